@@ -0,0 +1,53 @@
+package main
+
+import "hash/fnv"
+
+// ANSI escape codes the client colors its output with
+const (
+	bold        = "1"
+	italic      = "3"
+	systemColor = "90" // bright black, i.e. grey
+	pinnedColor = "93" // bright yellow
+	codeColor   = "36" // cyan, for code in messages
+
+	// Highlighted code
+	keywordColor = "35" // magenta
+	stringColor  = "32" // green
+	numberColor  = "33" // yellow
+)
+
+// senderColors are the foreground colors senders are told apart by. Black
+// and white are left out since they disappear on some backgrounds.
+var senderColors = []string{"31", "32", "33", "34", "35", "36", "91", "92", "93", "94", "95", "96"}
+
+// colorOf picks a color for a sender, the same one every time
+func colorOf(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return senderColors[h.Sum32()%uint32(len(senderColors))]
+}
+
+// paint wraps text in an ANSI escape, unless colors are turned off. Only
+// what code set is reset afterwards, so a colored name keeps the rest of a
+// bold line bold.
+func (s *session) paint(code, text string) string {
+	if s.noColor || text == "" {
+		return text
+	}
+	reset := "\033[39m"
+	switch code {
+	case bold:
+		reset = "\033[22m"
+	case italic:
+		reset = "\033[23m"
+	}
+	return "\033[" + code + "m" + text + reset
+}
+
+// sender shows a sender's name in their color, or in bold for our own
+func (s *session) sender(name string) string {
+	if name == s.name {
+		return s.paint(bold, name)
+	}
+	return s.paint(colorOf(name), name)
+}
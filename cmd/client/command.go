@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// errQuit is returned by a command that ends the session, like /quit
+var errQuit = errors.New("quit")
+
+// command is a slash command handled by the client itself rather than
+// sent as chat
+type command struct {
+	args string // arguments as shown in usage messages, e.g. "<user> <text>"
+	help string // one line for /help
+
+	// min and max bound the number of arguments; max < 0 means no limit.
+	// With text set the last argument is the rest of the line, spaces
+	// and all, so max is the number of arguments including it.
+	min, max int
+	text     bool
+
+	// feature is the chat.Feature the server must support for the
+	// command to work, empty if every server does
+	feature string
+
+	run func(s *session, args []string) error
+}
+
+// commands holds every command by name, including its '/'
+var commands = make(map[string]*command)
+
+// registerCommand adds a command. It panics if the name is taken, since
+// that can only be a mistake in the program.
+func registerCommand(name string, c *command) {
+	if _, ok := commands[name]; ok {
+		panic("command " + name + " registered twice")
+	}
+	commands[name] = c
+}
+
+// usage returns the error shown when a command is given the wrong arguments
+func (c *command) usage(name string) error {
+	if c.args == "" {
+		return errors.New("usage: " + name)
+	}
+	return errors.New("usage: " + name + " " + c.args)
+}
+
+// runCommand handles a line starting with '/'
+func (s *session) runCommand(line string) error {
+	name, rest, _ := strings.Cut(line, " ")
+	c, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %s, type /help for a list", name)
+	}
+
+	var args []string
+	if c.text {
+		args = splitArgs(rest, c.max)
+	} else {
+		args = strings.Fields(rest)
+	}
+	if len(args) < c.min || (c.max >= 0 && len(args) > c.max) {
+		return c.usage(name)
+	}
+	if c.feature != "" && !s.supports(c.feature) {
+		return fmt.Errorf("this server does not support %s", c.feature)
+	}
+	return c.run(s, args)
+}
+
+// splitArgs splits line into at most n arguments at spaces, the last one
+// being whatever is left of the line
+func splitArgs(line string, n int) []string {
+	var args []string
+	for len(args) < n-1 {
+		line = strings.TrimLeft(line, " \t")
+		i := strings.IndexAny(line, " \t")
+		if i < 0 {
+			break
+		}
+		args = append(args, line[:i])
+		line = line[i:]
+	}
+	if line = strings.TrimSpace(line); line != "" {
+		args = append(args, line)
+	}
+	return args
+}
+
+// showHelp lists every command, or explains the one named
+func (s *session) showHelp(args []string) error {
+	if len(args) == 1 {
+		name := "/" + strings.TrimPrefix(args[0], "/")
+		c, ok := commands[name]
+		if !ok {
+			return fmt.Errorf("unknown command %s", name)
+		}
+		fmt.Fprintf(s.out, "%s %s\n  %s\n", name, c.args, c.help)
+		return nil
+	}
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(s.out, "--- Commands ---")
+	for _, name := range names {
+		c := commands[name]
+		fmt.Fprintf(s.out, "  %-28s %s\n", strings.TrimSpace(name+" "+c.args), c.help)
+	}
+	fmt.Fprintln(s.out, "Anything else you type is sent to the current room.")
+	return nil
+}
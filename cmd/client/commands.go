@@ -0,0 +1,917 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+	"golang.org/x/term"
+)
+
+// historyPageSize is how many messages are shown when joining a room and
+// for every /more
+const historyPageSize = 20
+
+// auditPageSize is how many audit log entries /audit shows
+const auditPageSize = 20
+
+// listMentions prints the newest messages that mention the local user
+func (s *session) listMentions() error {
+	var reply chat.MentionsReply
+	err := s.call("GetMentions", &chat.MentionsArgs{Name: s.userName(), Token: s.sessionToken(), Limit: historyPageSize}, &reply)
+	if err != nil {
+		return err
+	}
+	if len(reply.Messages) == 0 {
+		fmt.Fprintln(s.out, "Nobody has mentioned you yet")
+		return nil
+	}
+	s.printFound("Mentions", reply.Messages)
+	return nil
+}
+
+// search prints the newest messages matching a /search command line:
+// words to look for, optionally with from:<user> and since:<duration>
+func (s *session) search(words []string) error {
+	args := &chat.SearchArgs{Name: s.userName(), Token: s.sessionToken(), Limit: historyPageSize}
+	var terms []string
+	for _, word := range words {
+		switch {
+		case strings.HasPrefix(word, "from:"):
+			args.Sender = strings.TrimPrefix(word, "from:")
+		case strings.HasPrefix(word, "since:"):
+			d, err := time.ParseDuration(strings.TrimPrefix(word, "since:"))
+			if err != nil {
+				return fmt.Errorf("invalid duration in %q, try e.g. since:2h", word)
+			}
+			args.After = time.Now().Add(-d)
+		default:
+			terms = append(terms, word)
+		}
+	}
+	args.Query = strings.Join(terms, " ")
+
+	var reply chat.SearchReply
+	if err := s.call("SearchHistory", args, &reply); err != nil {
+		return err
+	}
+	if len(reply.Messages) == 0 {
+		fmt.Fprintln(s.out, "No messages found")
+		return nil
+	}
+	s.printFound("Search results", reply.Messages)
+	return nil
+}
+
+// viewThread shows the thread containing message id in the current room
+// and sends what the user types next into it
+func (s *session) viewThread(id int64) error {
+	feed := s.currentFeed()
+	var reply chat.ThreadReply
+	if err := s.call("GetThread", &chat.ThreadArgs{Token: s.sessionToken(), Room: feed.room, ID: id}, &reply); err != nil {
+		return err
+	}
+	s.loadReceipts(reply.Messages)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	feed.thread = reply.Thread
+	fmt.Fprintf(s.out, "--- Thread #%d (%s) ---\n", reply.Thread, feed.room)
+	s.printHistory(reply.Messages, reply.Thread)
+	fmt.Fprintln(s.out, "(type /thread to go back to the room)")
+
+	return nil
+}
+
+// listPins prints the pinned messages of the current room
+func (s *session) listPins() error {
+	room := s.currentFeed().room
+	var reply chat.PinsReply
+	if err := s.call("GetPins", &chat.RoomArgs{Name: s.userName(), Token: s.sessionToken(), Room: room}, &reply); err != nil {
+		return err
+	}
+	if len(reply.Messages) == 0 {
+		fmt.Fprintf(s.out, "Nothing is pinned in %s\n", room)
+		return nil
+	}
+	s.printFound("Pinned in "+room, reply.Messages)
+	return nil
+}
+
+// closeThread goes back from viewing a thread to the whole current room
+func (s *session) closeThread() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	feed := s.feeds[s.current]
+	if feed.thread == 0 {
+		return errors.New("usage: /thread <id>")
+	}
+	feed.thread = 0
+	fmt.Fprintf(s.out, "Back in room %s\n", feed.room)
+	return nil
+}
+
+// listUsers prints everyone who is currently online
+func (s *session) listUsers() error {
+	var reply chat.UsersReply
+	err := s.call("ListOnlineUsers", &struct{}{}, &reply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(s.out, "--- Online (%d) ---\n", len(reply.Users))
+	for _, user := range reply.Users {
+		fmt.Fprintln(s.out, user)
+	}
+	return nil
+}
+
+// showStats prints the server statistics, with the most active users first
+func (s *session) showStats() error {
+	var reply chat.StatsReply
+	if err := s.call("GetStats", &struct{}{}, &reply); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(s.out, "--- Server stats ---")
+	fmt.Fprintf(s.out, "Uptime:   %v\n", reply.Uptime.Round(time.Second))
+	fmt.Fprintf(s.out, "Messages: %d\n", reply.Messages)
+	fmt.Fprintf(s.out, "Online:   %d users, %d connections\n", reply.Online, reply.Clients)
+	fmt.Fprintf(s.out, "Rooms:    %d\n", reply.Rooms)
+	fmt.Fprintf(s.out, "Memory:   %.1f MiB in use, %.1f MiB from the OS\n", float64(reply.MemAlloc)/(1<<20), float64(reply.MemSys)/(1<<20))
+
+	names := make([]string, 0, len(reply.PerUser))
+	for name := range reply.PerUser {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if reply.PerUser[names[i]] != reply.PerUser[names[j]] {
+			return reply.PerUser[names[i]] > reply.PerUser[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		fmt.Fprintf(s.out, "  %-16s %d\n", name, reply.PerUser[name])
+	}
+	return nil
+}
+
+// showMOTD prints the server's message of the day. Unless asked for it
+// explicitly, nothing is printed when there is none.
+func (s *session) showMOTD(explicit bool) error {
+	var reply chat.MOTDReply
+	if err := s.call("GetMOTD", &struct{}{}, &reply); err != nil {
+		return err
+	}
+	if reply.Text == "" {
+		if explicit {
+			fmt.Fprintln(s.out, "There is no message of the day")
+		}
+		return nil
+	}
+	fmt.Fprintln(s.out, "--- Message of the day ---")
+	fmt.Fprintln(s.out, reply.Text)
+	fmt.Fprintln(s.out, "--------------------------")
+	return nil
+}
+
+// showTopic prints the topic of the current room
+func (s *session) showTopic() error {
+	room := s.currentFeed().room
+	var reply chat.TopicReply
+	if err := s.call("GetTopic", &chat.RoomArgs{Room: room}, &reply); err != nil {
+		return err
+	}
+	if reply.Topic == "" {
+		fmt.Fprintf(s.out, "%s has no topic\n", room)
+		return nil
+	}
+	fmt.Fprintf(s.out, "Topic of %s: %s (set by %s %s)\n", room, reply.Topic, reply.SetBy, reply.SetAt.Local().Format("Jan 2 15:04"))
+	return nil
+}
+
+// showAudit prints audit log entries, one per line
+func (s *session) showAudit(entries []chat.AuditEntry) {
+	if len(entries) == 0 {
+		fmt.Fprintln(s.out, "The audit log is empty")
+		return
+	}
+	fmt.Fprintln(s.out, "--- Audit log ---")
+	for _, e := range entries {
+		actor := e.Actor
+		if actor == "" {
+			actor = "server"
+		}
+		line := fmt.Sprintf("%s %s %s", e.Time.Local().Format("2006-01-02 15:04"), actor, e.Action)
+		if e.Target != "" {
+			line += " " + e.Target
+		}
+		if e.Detail != "" {
+			line += " (" + e.Detail + ")"
+		}
+		if e.Reason != "" {
+			line += ": " + e.Reason
+		}
+		fmt.Fprintln(s.out, line)
+	}
+}
+
+// showReports prints the open reports with the messages they are about
+func (s *session) showReports(reports []chat.Report) {
+	if len(reports) == 0 {
+		fmt.Fprintln(s.out, "No reports are waiting")
+		return
+	}
+	fmt.Fprintln(s.out, "--- Reports ---")
+	for _, r := range reports {
+		fmt.Fprintf(s.out, "#%d from %s", r.ID, r.Reporter)
+		if r.Reason != "" {
+			fmt.Fprintf(s.out, " (%s)", r.Reason)
+		}
+		fmt.Fprintf(s.out, ": %s\n", r.Message.String())
+	}
+	fmt.Fprintln(s.out, "Resolve them with /resolve <report> delete|dismiss")
+}
+
+// showWebhooks prints the webhooks new messages are posted to
+func (s *session) showWebhooks(hooks []chat.Webhook) {
+	if len(hooks) == 0 {
+		fmt.Fprintln(s.out, "No webhooks are registered")
+		return
+	}
+	fmt.Fprintln(s.out, "--- Webhooks ---")
+	for _, h := range hooks {
+		room := "every public room"
+		if h.Room != "" {
+			room = h.Room
+		}
+		fmt.Fprintf(s.out, "#%d %s for %s, added by %s %s", h.ID, h.URL, room, h.Creator, h.Created.Local().Format("Jan 2 15:04"))
+		if h.Signed {
+			fmt.Fprint(s.out, " (signed)")
+		}
+		fmt.Fprintln(s.out)
+	}
+}
+
+// listRooms prints every room on the server, with how many messages we
+// have not read in those we joined
+func (s *session) listRooms() error {
+	var reply chat.RoomsReply
+	err := s.call("ListRooms", &struct{}{}, &reply)
+	if err != nil {
+		return err
+	}
+	var unread chat.UnreadReply
+	if s.supports(chat.FeatureUnread) {
+		s.call("GetUnreadCounts", &chat.UserArgs{Name: s.userName(), Token: s.sessionToken()}, &unread)
+	}
+	fmt.Fprintln(s.out, "--- Rooms ---")
+	for _, r := range reply.Rooms {
+		fmt.Fprint(s.out, r.Name)
+		if n := unread.Rooms[r.Name]; n > 0 {
+			fmt.Fprint(s.out, " "+s.paint(bold, fmt.Sprintf("(%d unread)", n)))
+		}
+		fmt.Fprintf(s.out, " (%d members, %d messages)", r.Members, r.Messages)
+		if r.Private {
+			fmt.Fprint(s.out, " [private]")
+		}
+		if r.Topic != "" {
+			fmt.Fprintf(s.out, ": %s", r.Topic)
+		}
+		fmt.Fprintln(s.out)
+	}
+	return nil
+}
+
+// readPassword prompts for a password, hiding what is typed when stdin is
+// a terminal
+func readPassword(reader *bufio.Reader, prompt string) (string, error) {
+	fmt.Print(prompt)
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		password, err := term.ReadPassword(fd)
+		fmt.Println()
+		return string(password), err
+	}
+	password, err := reader.ReadString('\n')
+	return strings.TrimRight(password, "\r\n"), err
+}
+
+// readPassword prompts for a password in whichever interface the session
+// is using
+func (s *session) readPassword(prompt string) (string, error) {
+	if s.ui != nil {
+		return s.ui.readPassword(prompt)
+	}
+	return readPassword(s.reader, prompt)
+}
+
+// register creates an account for the current name
+func (s *session) register() error {
+	password, err := s.readPassword("Choose a password: ")
+	if err != nil {
+		return err
+	}
+	err = s.call("Register", &chat.AccountArgs{Name: s.name, Password: password}, &struct{}{})
+	if err != nil {
+		return err
+	}
+	s.setLogin(s.name, password)
+	fmt.Fprintf(s.out, "Registered %s, log in with this password from now on.\n", s.name)
+	return nil
+}
+
+// rename changes our name on the server and in the prompt. The new name
+// has no account, so the password is dropped.
+func (s *session) rename(name string) error {
+	err := s.call("RenameUser", &chat.RenameArgs{Name: s.name, Token: s.sessionToken(), NewName: name}, &struct{}{})
+	if err != nil {
+		return err
+	}
+	s.setLogin(name, "")
+	return nil
+}
+
+// setLogin changes the name and password we log in with
+func (s *session) setLogin(name, password string) {
+	s.connMu.Lock()
+	s.mu.Lock()
+	s.name, s.password = name, password
+	if s.ui != nil {
+		s.ui.showName(name)
+	} else {
+		s.prompt = promptFor(name)
+	}
+	s.mu.Unlock()
+	s.connMu.Unlock()
+}
+
+// checkLength returns an error if text is too long to send
+func checkLength(text string) error {
+	if len(text) > chat.DefaultMaxLength {
+		return fmt.Errorf("message is %d bytes long, the limit is %d", len(text), chat.DefaultMaxLength)
+	}
+	return nil
+}
+
+// parseID parses a message ID as shown in front of messages, with or
+// without its '#'
+func parseID(s string) (int64, error) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(s, "#"), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid message ID %q", s)
+	}
+	return id, nil
+}
+
+// moderate calls one of the moderation RPCs, such as KickUser
+func (s *session) moderate(method string, args chat.ModerationArgs) error {
+	args.Name, args.Token = s.userName(), s.sessionToken()
+	return s.call(method, &args, &struct{}{})
+}
+
+// showHistory prints the newest messages of the current room again
+func (s *session) showHistory(limit int) error {
+	feed := s.currentFeed()
+	var history chat.HistoryPage
+	if err := s.call("GetHistory", &chat.HistoryArgs{Token: s.sessionToken(), Room: feed.room, Limit: limit}, &history); err != nil {
+		return err
+	}
+	s.loadReceipts(history.Messages)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.out, "--- History (%s) ---\n", feed.room)
+	s.printHistory(history.Messages, feed.thread)
+	fmt.Fprintln(s.out, "------------------")
+	return nil
+}
+
+// exportHistory saves all the current room's history the server keeps to
+// path
+func (s *session) exportHistory(path string) error {
+	feed := s.currentFeed()
+	var history chat.HistoryPage
+	if err := s.call("GetHistory", &chat.HistoryArgs{Token: s.sessionToken(), Room: feed.room}, &history); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := chat.WriteExport(f, chat.ExportFormat(path), history.Messages); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(s.out, "Saved %d messages from %s to %s\n", len(history.Messages), feed.room, path)
+	return nil
+}
+
+// messageCommand registers a command that takes a message ID, followed by
+// text if withText is set, and needs feature
+func messageCommand(name, help, feature string, withText bool, run func(s *session, id int64, text string) error) {
+	c := &command{args: "<id>", help: help, min: 1, max: 1, text: withText, feature: feature}
+	if withText {
+		c.args, c.min, c.max = "<id> <text>", 2, 2
+	}
+	c.run = func(s *session, args []string) error {
+		id, err := parseID(args[0])
+		if err != nil {
+			return err
+		}
+		var text string
+		if withText {
+			text = args[1]
+			if err := checkLength(text); err != nil {
+				return err
+			}
+		}
+		return run(s, id, text)
+	}
+	registerCommand(name, c)
+}
+
+// reactionCommand registers a command that calls method with a message ID
+// and a reaction
+func reactionCommand(name, help, method string) {
+	registerCommand(name, &command{args: "<id> <emoji>", help: help, min: 2, max: 2, feature: chat.FeatureReactions, run: func(s *session, args []string) error {
+		id, err := parseID(args[0])
+		if err != nil {
+			return err
+		}
+		// Shortcodes react with their emoji, like everyone else's
+		return s.call(method, &chat.ReactionArgs{Name: s.userName(), Token: s.sessionToken(), ID: id, Reaction: s.emojify(args[1])}, &struct{}{})
+	}})
+}
+
+// moderationCommand registers a moderation command that calls method on
+// its first argument, with the rest of the line as the reason
+func moderationCommand(name, target, help, method string, shadow bool) {
+	registerCommand(name, &command{args: target + " [reason]", help: help, min: 1, max: 2, text: true, run: func(s *session, args []string) error {
+		moderation := chat.ModerationArgs{Target: args[0], Shadow: shadow}
+		if len(args) == 2 {
+			moderation.Reason = args[1]
+		}
+		return s.moderate(method, moderation)
+	}})
+}
+
+func init() {
+	registerCommand("/help", &command{args: "[command]", help: "list the commands, or explain one", max: 1, run: (*session).showHelp})
+	registerCommand("/quit", &command{help: "leave the chat, like typing exit", run: func(s *session, args []string) error {
+		return errQuit
+	}})
+
+	// Rooms
+	registerCommand("/create", &command{args: "<room> [password]", help: "create a room and join it, needing the password to join if given (registered users)", min: 1, max: 2, feature: chat.FeatureRooms, run: func(s *session, args []string) error {
+		room := &chat.RoomArgs{Name: s.userName(), Token: s.sessionToken(), Room: args[0]}
+		if len(args) == 2 {
+			room.Password = args[1]
+		}
+		if err := s.call("CreateRoom", room, &struct{}{}); err != nil {
+			return err
+		}
+		return s.join(args[0], "")
+	}})
+	registerCommand("/private", &command{args: "<room>", help: "create an invite-only room and join it (registered users)", min: 1, max: 1, feature: chat.FeaturePrivateRooms, run: func(s *session, args []string) error {
+		room := &chat.RoomArgs{Name: s.userName(), Token: s.sessionToken(), Room: args[0], InviteOnly: true}
+		if err := s.call("CreateRoom", room, &struct{}{}); err != nil {
+			return err
+		}
+		return s.join(args[0], "")
+	}})
+	registerCommand("/join", &command{args: "<room> [password|invite]", help: "join a room and send to it from now on", min: 1, max: 2, feature: chat.FeatureRooms, run: func(s *session, args []string) error {
+		var key string
+		if len(args) == 2 {
+			key = args[1]
+		}
+		return s.join(args[0], key)
+	}})
+	registerCommand("/invite", &command{help: "make an invite to the current private room (its creator, and admins and up)", feature: chat.FeaturePrivateRooms, run: func(s *session, args []string) error {
+		room := s.currentFeed().room
+		var reply chat.InviteReply
+		if err := s.call("GenerateInvite", &chat.InviteArgs{Name: s.userName(), Token: s.sessionToken(), Room: room}, &reply); err != nil {
+			return err
+		}
+		fmt.Fprintf(s.out, "Others can now join with: /join %s %s\n", room, reply.Invite)
+		return nil
+	}})
+	registerCommand("/revoke", &command{args: "<invite>", help: "stop an invite to the current room from letting anyone else in", min: 1, max: 1, feature: chat.FeaturePrivateRooms, run: func(s *session, args []string) error {
+		revoke := &chat.InviteArgs{Name: s.userName(), Token: s.sessionToken(), Room: s.currentFeed().room, Invite: args[0]}
+		if err := s.call("RevokeInvite", revoke, &struct{}{}); err != nil {
+			return err
+		}
+		fmt.Fprintln(s.out, "Invite revoked")
+		return nil
+	}})
+	registerCommand("/leave", &command{help: "leave the current room", feature: chat.FeatureRooms, run: func(s *session, args []string) error {
+		return s.leave()
+	}})
+	registerCommand("/rooms", &command{help: "list the rooms", feature: chat.FeatureRooms, run: func(s *session, args []string) error {
+		return s.listRooms()
+	}})
+	registerCommand("/topic", &command{help: "show the topic of the current room", run: func(s *session, args []string) error {
+		return s.showTopic()
+	}})
+	registerCommand("/settopic", &command{args: "[text]", help: "change the topic of the current room, or remove it (its creator, and moderators and up)", max: 1, text: true, run: func(s *session, args []string) error {
+		var text string
+		if len(args) == 1 {
+			text = args[0]
+		}
+		return s.call("SetTopic", &chat.TopicArgs{Name: s.userName(), Token: s.sessionToken(), Room: s.currentFeed().room, Topic: text}, &struct{}{})
+	}})
+	registerCommand("/history", &command{args: "[count]", help: "show the newest messages of the current room again", max: 1, run: func(s *session, args []string) error {
+		limit := historyPageSize
+		if len(args) == 1 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n <= 0 {
+				return errors.New("usage: /history [count]")
+			}
+			limit = n
+		}
+		return s.showHistory(limit)
+	}})
+	registerCommand("/export", &command{args: "<file>", help: "save the current room's history to a file, as CSV if it ends in .csv and JSON otherwise", min: 1, max: 1, run: func(s *session, args []string) error {
+		return s.exportHistory(args[0])
+	}})
+	registerCommand("/more", &command{help: "show older messages of the current room", run: func(s *session, args []string) error {
+		return s.more()
+	}})
+
+	// Information
+	registerCommand("/who", &command{help: "list who is online", run: func(s *session, args []string) error {
+		return s.listUsers()
+	}})
+	registerCommand("/stats", &command{help: "show server statistics", run: func(s *session, args []string) error {
+		return s.showStats()
+	}})
+	registerCommand("/info", &command{help: "show the server's version and what it supports", run: func(s *session, args []string) error {
+		var info chat.ServerInfoReply
+		if err := s.call("GetServerInfo", &struct{}{}, &info); err != nil {
+			return err
+		}
+		fmt.Fprintf(s.out, "Server %s, protocol %d\n", info.Version, info.Protocol)
+		fmt.Fprintf(s.out, "Supports: %s\n", strings.Join(info.Features, ", "))
+		return nil
+	}})
+	registerCommand("/mentions", &command{help: "list the latest messages mentioning you", run: func(s *session, args []string) error {
+		return s.listMentions()
+	}})
+	registerCommand("/search", &command{args: "<words>", help: "search messages, narrowed with from:<user> and since:<duration>", min: 1, max: -1, feature: chat.FeatureSearch, run: (*session).search})
+
+	// Messages
+	registerCommand("/paste", &command{args: "[end]", help: "write a message over several lines, ended by a line with only . (or end); Alt-Enter does the same in the full-screen interface", max: 1, run: func(s *session, args []string) error {
+		end := pasteEnd
+		if len(args) == 1 {
+			end = args[0]
+		}
+		return s.paste(end)
+	}})
+	registerCommand("/emoji", &command{args: "<search>", help: "list the emoji shortcodes, like :smile:, containing a word", min: 1, max: 1, run: func(s *session, args []string) error {
+		return s.searchEmoji(args[0])
+	}})
+	registerCommand("/me", &command{args: "<action>", help: "describe what you are doing, e.g. /me waves", min: 1, max: 1, text: true, run: func(s *session, args []string) error {
+		if err := checkLength(args[0]); err != nil {
+			return err
+		}
+		out := s.typed(args[0])
+		out.emote = true
+		return s.sendOrQueue(out)
+	}})
+	registerCommand("/msg", &command{args: "<user> <text>", help: "send a private message", min: 2, max: 2, text: true, feature: chat.FeatureDirectMessages, run: func(s *session, args []string) error {
+		if err := checkLength(args[1]); err != nil {
+			return err
+		}
+		return s.sendOrQueue(outgoing{to: args[0], text: args[1]})
+	}})
+	messageCommand("/reply", "reply to a message in the current room", chat.FeatureThreads, true, func(s *session, id int64, text string) error {
+		return s.sendOrQueue(outgoing{room: s.currentFeed().room, text: text, replyTo: id})
+	})
+	registerCommand("/translate", &command{args: "<lang> <id>", help: "translate a message into a language, such as fr or de, posting the translation as a reply to it", min: 2, max: 2, feature: chat.FeatureTranslate, run: func(s *session, args []string) error {
+		id, err := parseID(args[1])
+		if err != nil {
+			return err
+		}
+		return s.call("Translate", &chat.TranslateArgs{Name: s.userName(), Token: s.sessionToken(), ID: id, Lang: args[0]}, &chat.TranslateReply{})
+	}})
+	registerCommand("/thread", &command{args: "[id]", help: "show a thread and post into it, or go back to the room", max: 1, feature: chat.FeatureThreads, run: func(s *session, args []string) error {
+		if len(args) == 0 {
+			return s.closeThread()
+		}
+		id, err := parseID(args[0])
+		if err != nil {
+			return err
+		}
+		return s.viewThread(id)
+	}})
+	messageCommand("/edit", "change one of your messages", chat.FeatureEdits, true, func(s *session, id int64, text string) error {
+		return s.call("EditMessage", &chat.EditArgs{Name: s.userName(), Token: s.sessionToken(), ID: id, Message: text}, &struct{}{})
+	})
+	registerCommand("/delete", &command{args: "<id> [reason]", help: "delete one of your messages, or as a moderator someone else's", min: 1, max: 2, text: true, feature: chat.FeatureEdits, run: func(s *session, args []string) error {
+		id, err := parseID(args[0])
+		if err != nil {
+			return err
+		}
+		del := &chat.DeleteArgs{Name: s.userName(), Token: s.sessionToken(), ID: id}
+		if len(args) == 2 {
+			del.Reason = args[1]
+		}
+		return s.call("DeleteMessage", del, &struct{}{})
+	}})
+	registerCommand("/report", &command{args: "<id> [reason]", help: "flag someone's message as abusive for the moderators", min: 1, max: 2, text: true, feature: chat.FeatureReports, run: func(s *session, args []string) error {
+		id, err := parseID(args[0])
+		if err != nil {
+			return err
+		}
+		report := &chat.ReportArgs{Name: s.userName(), Token: s.sessionToken(), ID: id}
+		if len(args) == 2 {
+			report.Reason = args[1]
+		}
+		var reply chat.ReportReply
+		if err := s.call("ReportMessage", report, &reply); err != nil {
+			return err
+		}
+		fmt.Fprintln(s.out, "Thanks, the moderators will look at it")
+		return nil
+	}})
+	messageCommand("/receipts", "show who has received and read one of your messages", chat.FeatureReceipts, false, func(s *session, id int64, _ string) error {
+		return s.showReceipts(id)
+	})
+	messageCommand("/pin", "pin a message to its room (moderators and up)", chat.FeaturePins, false, func(s *session, id int64, _ string) error {
+		return s.call("PinMessage", &chat.PinArgs{Name: s.userName(), Token: s.sessionToken(), ID: id}, &struct{}{})
+	})
+	messageCommand("/unpin", "unpin a message (moderators and up)", chat.FeaturePins, false, func(s *session, id int64, _ string) error {
+		return s.call("UnpinMessage", &chat.PinArgs{Name: s.userName(), Token: s.sessionToken(), ID: id}, &struct{}{})
+	})
+	registerCommand("/pins", &command{help: "list the pinned messages of the current room", feature: chat.FeaturePins, run: func(s *session, args []string) error {
+		return s.listPins()
+	}})
+	registerCommand("/poll", &command{args: "<question> | <option> | <option>..., vote <id> <option> or close <id>", help: "start a poll in the current room, vote in one or close yours", min: 1, max: 1, text: true, feature: chat.FeaturePolls, run: func(s *session, args []string) error {
+		return s.poll(args[0])
+	}})
+	registerCommand("/send-file", &command{args: "<path>", help: "share a file in the current room", min: 1, max: 1, text: true, feature: chat.FeatureFiles, run: func(s *session, args []string) error {
+		return s.sendFile(args[0], "")
+	}})
+	registerCommand("/get-file", &command{args: "<id> [path]", help: "save a file shared in a room, under its own name unless given a path", min: 1, max: 2, text: true, feature: chat.FeatureFiles, run: func(s *session, args []string) error {
+		id, err := parseID(args[0])
+		if err != nil {
+			return err
+		}
+		var path string
+		if len(args) == 2 {
+			path = args[1]
+		}
+		return s.getFile(id, path)
+	}})
+	registerCommand("/image", &command{args: "<path> [caption]", help: "share a PNG, JPEG or GIF image in the current room", min: 1, max: 2, text: true, feature: chat.FeatureImages, run: func(s *session, args []string) error {
+		var caption string
+		if len(args) == 2 {
+			caption = args[1]
+		}
+		return s.sendImage(args[0], caption)
+	}})
+	messageCommand("/view", "show a shared image, with -plain in a terminal that can draw them", chat.FeatureImages, false, func(s *session, id int64, _ string) error {
+		return s.viewImage(id)
+	})
+	registerCommand("/schedule", &command{args: "<when> <text>", help: "send a message to the current room later, after a delay like 10m or at a time like 18:30", min: 2, max: 2, text: true, feature: chat.FeatureScheduled, run: func(s *session, args []string) error {
+		return s.schedule(args[0], args[1])
+	}})
+	registerCommand("/remind", &command{args: "me in <delay>|at <time> <text>", help: "have the server send you a private reminder, e.g. /remind me in 10m stretch", min: 4, max: 4, text: true, feature: chat.FeatureReminders, run: func(s *session, args []string) error {
+		if args[0] != "me" || (args[1] != "in" && args[1] != "at") {
+			return errors.New("usage: /remind me in <delay>|at <time> <text>")
+		}
+		return s.remind(args[2], args[3])
+	}})
+	registerCommand("/scheduled", &command{help: "list your scheduled messages and reminders", feature: chat.FeatureScheduled, run: func(s *session, args []string) error {
+		return s.listScheduled()
+	}})
+	registerCommand("/unschedule", &command{args: "<id>", help: "cancel a scheduled message or reminder", min: 1, max: 1, feature: chat.FeatureScheduled, run: func(s *session, args []string) error {
+		id, err := parseID(args[0])
+		if err != nil {
+			return err
+		}
+		if err := s.call("CancelScheduled", &chat.CancelScheduledArgs{Name: s.userName(), Token: s.sessionToken(), ID: id}, &struct{}{}); err != nil {
+			return err
+		}
+		fmt.Fprintf(s.out, "Cancelled scheduled message %d\n", id)
+		return nil
+	}})
+	reactionCommand("/react", "react to a message", "ReactToMessage")
+	reactionCommand("/unreact", "take back a reaction", "RemoveReaction")
+
+	// Accounts and moderation
+	registerCommand("/nick", &command{args: "<name>", help: "change your name", min: 1, max: 1, run: func(s *session, args []string) error {
+		return s.rename(args[0])
+	}})
+	registerCommand("/register", &command{help: "protect your name with a password", run: func(s *session, args []string) error {
+		return s.register()
+	}})
+	registerCommand("/block", &command{args: "<user>", help: "stop seeing someone's messages, including private ones", min: 1, max: 1, feature: chat.FeatureBlocks, run: func(s *session, args []string) error {
+		if err := s.call("BlockUser", &chat.BlockArgs{Name: s.userName(), Token: s.sessionToken(), Target: args[0]}, &struct{}{}); err != nil {
+			return err
+		}
+		fmt.Fprintf(s.out, "You will no longer see messages from %s\n", args[0])
+		return nil
+	}})
+	registerCommand("/unblock", &command{args: "<user>", help: "see a blocked user's messages again", min: 1, max: 1, feature: chat.FeatureBlocks, run: func(s *session, args []string) error {
+		if err := s.call("UnblockUser", &chat.BlockArgs{Name: s.userName(), Token: s.sessionToken(), Target: args[0]}, &struct{}{}); err != nil {
+			return err
+		}
+		fmt.Fprintf(s.out, "You will see messages from %s again\n", args[0])
+		return nil
+	}})
+	registerCommand("/blocked", &command{help: "list the users you have blocked", feature: chat.FeatureBlocks, run: func(s *session, args []string) error {
+		var reply chat.UsersReply
+		if err := s.call("GetBlocked", &chat.UserArgs{Name: s.userName(), Token: s.sessionToken()}, &reply); err != nil {
+			return err
+		}
+		if len(reply.Users) == 0 {
+			fmt.Fprintln(s.out, "You have not blocked anyone")
+			return nil
+		}
+		fmt.Fprintf(s.out, "Blocked: %s\n", strings.Join(reply.Users, ", "))
+		return nil
+	}})
+	registerCommand("/motd", &command{help: "show the message of the day", run: func(s *session, args []string) error {
+		return s.showMOTD(true)
+	}})
+	registerCommand("/setmotd", &command{args: "[text]", help: "change the message of the day, or remove it (admins and up)", max: 1, text: true, run: func(s *session, args []string) error {
+		var text string
+		if len(args) == 1 {
+			text = args[0]
+		}
+		return s.call("SetMOTD", &chat.MOTDArgs{Name: s.userName(), Token: s.sessionToken(), Text: text}, &struct{}{})
+	}})
+	registerCommand("/reloadfilter", &command{help: "reread the server's word filter file (admins and up)", run: func(s *session, args []string) error {
+		var reply chat.FilterReply
+		if err := s.call("ReloadFilter", &chat.UserArgs{Name: s.userName(), Token: s.sessionToken()}, &reply); err != nil {
+			return err
+		}
+		fmt.Fprintf(s.out, "The word filter now has %d words\n", reply.Words)
+		return nil
+	}})
+	registerCommand("/reload", &command{help: "make the server reread its config file and apply the settings that can change while it runs (admins and up)", run: func(s *session, args []string) error {
+		if err := s.call("ReloadConfig", &chat.UserArgs{Name: s.userName(), Token: s.sessionToken()}, &struct{}{}); err != nil {
+			return err
+		}
+		fmt.Fprintln(s.out, "The server reloaded its settings")
+		return nil
+	}})
+	registerCommand("/prune", &command{help: "remove the messages the server's retention policy no longer keeps, now (admins and up)", run: func(s *session, args []string) error {
+		var reply chat.PruneReply
+		if err := s.call("PruneHistory", &chat.UserArgs{Name: s.userName(), Token: s.sessionToken()}, &reply); err != nil {
+			return err
+		}
+		fmt.Fprintf(s.out, "Removed %d expired messages\n", reply.Removed)
+		return nil
+	}})
+	registerCommand("/role", &command{args: "[user]", help: "show your role, or someone else's", max: 1, run: func(s *session, args []string) error {
+		target := s.userName()
+		if len(args) == 1 {
+			target = args[0]
+		}
+		var reply chat.RoleReply
+		if err := s.call("GetRole", &chat.RoleArgs{Target: target}, &reply); err != nil {
+			return err
+		}
+		fmt.Fprintf(s.out, "%s has the %s role\n", target, reply.Role)
+		return nil
+	}})
+	registerCommand("/setrole", &command{args: "<user> <role>", help: "make a registered user a user, moderator or admin (admins and up)", min: 2, max: 2, run: func(s *session, args []string) error {
+		role, err := chat.ParseRole(args[1])
+		if err != nil {
+			return err
+		}
+		return s.call("SetRole", &chat.RoleArgs{Name: s.userName(), Token: s.sessionToken(), Target: args[0], Role: role}, &struct{}{})
+	}})
+	moderationCommand("/kick", "<user>", "disconnect a user (moderators and up)", "KickUser", false)
+	moderationCommand("/ban", "<user or IP>", "ban a user or address (admins and up)", "BanUser", false)
+	moderationCommand("/unban", "<user or IP>", "lift a ban (admins and up)", "UnbanUser", false)
+	moderationCommand("/mute", "<user>", "stop a user from sending (moderators and up)", "MuteUser", false)
+	moderationCommand("/shadowmute", "<user>", "hide a user's messages without telling them (moderators and up)", "MuteUser", true)
+	registerCommand("/audit", &command{args: "[user]", help: "show the latest moderation actions and admin changes, or those by or against a user (admins and up)", max: 1, run: func(s *session, args []string) error {
+		audit := &chat.AuditArgs{Name: s.userName(), Token: s.sessionToken(), Limit: auditPageSize}
+		if len(args) == 1 {
+			audit.User = args[0]
+		}
+		var reply chat.AuditReply
+		if err := s.call("GetAuditLog", audit, &reply); err != nil {
+			return err
+		}
+		s.showAudit(reply.Entries)
+		return nil
+	}})
+	registerCommand("/reports", &command{help: "list reported messages waiting for a moderator (moderators and up)", feature: chat.FeatureReports, run: func(s *session, args []string) error {
+		var reply chat.ReportsReply
+		if err := s.call("GetReports", &chat.UserArgs{Name: s.userName(), Token: s.sessionToken()}, &reply); err != nil {
+			return err
+		}
+		s.showReports(reply.Reports)
+		return nil
+	}})
+	registerCommand("/resolve", &command{args: "<report> delete|dismiss [reason]", help: "delete a reported message or dismiss the report (moderators and up)", min: 2, max: 3, text: true, feature: chat.FeatureReports, run: func(s *session, args []string) error {
+		id, err := strconv.ParseInt(strings.TrimPrefix(args[0], "#"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid report number %q", args[0])
+		}
+		resolve := &chat.ResolveArgs{Name: s.userName(), Token: s.sessionToken(), Report: id}
+		switch args[1] {
+		case "delete":
+			resolve.Delete = true
+		case "dismiss":
+		default:
+			return errors.New("usage: /resolve <report> delete|dismiss [reason]")
+		}
+		if len(args) == 3 {
+			resolve.Reason = args[2]
+		}
+		return s.call("ResolveReport", resolve, &struct{}{})
+	}})
+	registerCommand("/purge", &command{args: "<user> [reason]", help: "erase a user's account and every message they sent or received (admins and up)", min: 1, max: 2, text: true, run: func(s *session, args []string) error {
+		purge := &chat.ModerationArgs{Name: s.userName(), Token: s.sessionToken(), Target: args[0]}
+		if len(args) == 2 {
+			purge.Reason = args[1]
+		}
+		var reply chat.PurgeReply
+		if err := s.call("PurgeUser", purge, &reply); err != nil {
+			return err
+		}
+		fmt.Fprintf(s.out, "Purged %s and %d of their messages\n", args[0], reply.Removed)
+		return nil
+	}})
+	moderationCommand("/unmute", "<user>", "let a muted user send again (moderators and up)", "UnmuteUser", false)
+	registerCommand("/webhook", &command{args: "<url> [room|*] [secret]", help: "post every new message in a room, or in every public room with * or no room, to a URL; a secret signs them (admins and up)", min: 1, max: 3, feature: chat.FeatureWebhooks, run: func(s *session, args []string) error {
+		hook := &chat.WebhookArgs{Name: s.userName(), Token: s.sessionToken(), URL: args[0]}
+		if len(args) >= 2 && args[1] != "*" {
+			hook.Room = args[1]
+		}
+		if len(args) == 3 {
+			hook.Secret = args[2]
+		}
+		var reply chat.WebhookReply
+		if err := s.call("AddWebhook", hook, &reply); err != nil {
+			return err
+		}
+		fmt.Fprintf(s.out, "Added webhook #%d\n", reply.ID)
+		return nil
+	}})
+	registerCommand("/webhooks", &command{help: "list the webhooks new messages are posted to (admins and up)", feature: chat.FeatureWebhooks, run: func(s *session, args []string) error {
+		var reply chat.WebhooksReply
+		if err := s.call("ListWebhooks", &chat.UserArgs{Name: s.userName(), Token: s.sessionToken()}, &reply); err != nil {
+			return err
+		}
+		s.showWebhooks(reply.Webhooks)
+		return nil
+	}})
+	registerCommand("/unwebhook", &command{args: "<id>", help: "stop posting messages to a webhook (admins and up)", min: 1, max: 1, feature: chat.FeatureWebhooks, run: func(s *session, args []string) error {
+		id, err := strconv.ParseInt(strings.TrimPrefix(args[0], "#"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid webhook number %q", args[0])
+		}
+		return s.call("RemoveWebhook", &chat.WebhookArgs{Name: s.userName(), Token: s.sessionToken(), ID: id}, &struct{}{})
+	}})
+	registerCommand("/addbot", &command{args: "<name> [room]", help: "add a bot that other systems post to a room as, through the server's REST API (admins and up)", min: 1, max: 2, feature: chat.FeatureBots, run: func(s *session, args []string) error {
+		bot := &chat.BotArgs{Name: s.userName(), Token: s.sessionToken(), Bot: args[0]}
+		if len(args) == 2 {
+			bot.Room = args[1]
+		}
+		var reply chat.BotReply
+		if err := s.call("AddBot", bot, &reply); err != nil {
+			return err
+		}
+		fmt.Fprintf(s.out, "Added bot #%d. Post as it with this token, which is not shown again:\n", reply.ID)
+		fmt.Fprintf(s.out, "  curl -H 'Authorization: Bearer %s' -d '{\"text\": \"hello\"}' http://<rest-addr>/hooks\n", reply.Token)
+		return nil
+	}})
+	registerCommand("/bots", &command{help: "list the bots (admins and up)", feature: chat.FeatureBots, run: func(s *session, args []string) error {
+		var reply chat.BotsReply
+		if err := s.call("ListBots", &chat.UserArgs{Name: s.userName(), Token: s.sessionToken()}, &reply); err != nil {
+			return err
+		}
+		if len(reply.Bots) == 0 {
+			fmt.Fprintln(s.out, "There are no bots")
+			return nil
+		}
+		fmt.Fprintln(s.out, "--- Bots ---")
+		for _, b := range reply.Bots {
+			fmt.Fprintf(s.out, "#%d %s posts to %s, added by %s %s\n", b.ID, s.sender(b.Name), b.Room, b.Creator, b.Created.Local().Format("Jan 2 15:04"))
+		}
+		return nil
+	}})
+	registerCommand("/removebot", &command{args: "<id>", help: "remove a bot, so that its token stops working (admins and up)", min: 1, max: 1, feature: chat.FeatureBots, run: func(s *session, args []string) error {
+		id, err := strconv.ParseInt(strings.TrimPrefix(args[0], "#"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid bot number %q", args[0])
+		}
+		return s.call("RemoveBot", &chat.BotArgs{Name: s.userName(), Token: s.sessionToken(), ID: id}, &struct{}{})
+	}})
+}
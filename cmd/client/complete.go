@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// refreshOnline fetches who is online for completing @names. It is called
+// at login and whenever the server announces someone joining, leaving or
+// changing their name.
+func (s *session) refreshOnline() {
+	var reply chat.UsersReply
+	if err := s.call("ListOnlineUsers", &struct{}{}, &reply); err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.online = reply.Users
+	s.mu.Unlock()
+}
+
+// complete is called when Tab is pressed with the cursor at byte pos of
+// line. It completes the word before the cursor: a command at the start
+// of the line, or a name after '@'. When the word could be completed in
+// several ways it is extended as far as they agree, and if that is no
+// further the choices are listed.
+func (s *session) complete(line string, pos int) (string, int, bool) {
+	start := strings.LastIndexAny(line[:pos], " \t") + 1
+	word := line[start:pos]
+
+	var choices []string
+	switch {
+	case start == 0 && strings.HasPrefix(word, "/"):
+		for name := range commands {
+			choices = append(choices, name)
+		}
+	case strings.HasPrefix(word, "@"):
+		s.mu.Lock()
+		for _, name := range s.online {
+			choices = append(choices, "@"+name)
+		}
+		s.mu.Unlock()
+	default:
+		return line, pos, false
+	}
+
+	var matches []string
+	for _, choice := range choices {
+		if strings.HasPrefix(choice, word) {
+			matches = append(matches, choice)
+		}
+	}
+	if len(matches) == 0 {
+		return line, pos, true
+	}
+
+	completion := matches[0]
+	if len(matches) == 1 {
+		completion += " "
+	} else {
+		for _, m := range matches[1:] {
+			completion = commonPrefix(completion, m)
+		}
+		if completion == word {
+			// The frontend is busy handling the key, so list them after
+			sort.Strings(matches)
+			go s.notice(strings.Join(matches, "  "))
+		}
+	}
+	return line[:start] + completion + line[pos:], start + len(completion), true
+}
+
+// commonPrefix returns the longest prefix a and b share, in whole runes
+func commonPrefix(a, b string) string {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	for n < len(a) && n > 0 && !utf8.RuneStart(a[n]) {
+		n--
+	}
+	return a[:n]
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// pasteEnd is the line that ends a message started with /paste, unless
+// another one is given
+const pasteEnd = "."
+
+// paste reads the lines typed or pasted after /paste until one holding
+// only end, and sends them to the current room or conversation as one
+// message, indentation and all. A line holding only /cancel throws the
+// message away.
+func (s *session) paste(end string) error {
+	fmt.Fprintf(s.out, "Type or paste your message, then a line with only %s to send it or /cancel to drop it\n", end)
+	var lines []string
+	for {
+		// Frontends draw their own prompt
+		if s.prompt != "" {
+			fmt.Fprint(s.out, "... ")
+		}
+		line, err := s.readLine()
+		// Leaving in the middle of a message leaves the chat
+		if errors.Is(err, io.EOF) {
+			return errQuit
+		}
+		if err != nil {
+			return err
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == end {
+			break
+		}
+		if trimmed == "/cancel" {
+			fmt.Fprintln(s.out, "Message dropped")
+			return nil
+		}
+		lines = append(lines, strings.TrimRight(line, " \t\r"))
+	}
+
+	// Blank lines around the message are left out, indentation is kept
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return errors.New("the message is empty")
+	}
+	text := strings.Join(lines, "\n")
+	if err := checkLength(text); err != nil {
+		return err
+	}
+	return s.sendOrQueue(s.typed(text))
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// display formats a message for the terminal, after the time it was sent.
+// Chat messages start with their ID so they can be referred to in commands
+// like /edit.
+func (s *session) display(m chat.Message) string {
+	return s.stamp(m) + s.describe(m)
+}
+
+// stamp returns the time msg was sent in local time, as "[15:04] " with
+// the default format, or nothing if timestamps are turned off
+func (s *session) stamp(m chat.Message) string {
+	if s.timeFormat == "" || m.Timestamp.IsZero() {
+		return ""
+	}
+	return s.paint(systemColor, "["+m.Timestamp.Local().Format(s.timeFormat)+"]") + " "
+}
+
+// describe formats a message without its time
+func (s *session) describe(m chat.Message) string {
+	if m.Kind == chat.KindSystem {
+		return s.paint(systemColor, "*** "+m.Body)
+	}
+	if m.Kind == chat.KindTopic {
+		return s.paint(systemColor, m.String())
+	}
+
+	sender := s.sender(m.Sender)
+	if m.Kind == chat.KindDelete {
+		return fmt.Sprintf("#%d (message deleted by %s)", m.Ref, sender)
+	}
+	if !m.Deleted.IsZero() {
+		return fmt.Sprintf("#%d (message deleted)", m.ID)
+	}
+	if m.Kind == chat.KindReact {
+		return fmt.Sprintf("#%d %s reacted %s", m.Ref, sender, m.Body)
+	}
+	if m.Kind == chat.KindUnreact {
+		return fmt.Sprintf("#%d %s removed their %s", m.Ref, sender, m.Body)
+	}
+	if m.Kind == chat.KindPin {
+		return s.paint(systemColor, fmt.Sprintf("#%d was pinned by %s", m.Ref, m.Sender))
+	}
+	if m.Kind == chat.KindUnpin {
+		return s.paint(systemColor, fmt.Sprintf("#%d was unpinned by %s", m.Ref, m.Sender))
+	}
+	if m.Kind == chat.KindVote {
+		return fmt.Sprintf("#%d %s voted for option %s", m.Ref, sender, m.Body)
+	}
+	if m.Kind == chat.KindClosePoll {
+		return s.paint(systemColor, fmt.Sprintf("#%d poll closed by %s", m.Ref, m.Sender))
+	}
+	if m.Kind == chat.KindPreview {
+		return fmt.Sprintf("#%d link preview:\n%s", m.Ref, s.formatPreview(chat.NewPreview(m.Body)))
+	}
+
+	id, suffix := m.ID, ""
+	if m.Kind == chat.KindEdit {
+		id, suffix = m.Ref, " (edited)"
+	} else if !m.Edited.IsZero() {
+		suffix = " (edited)"
+	}
+	suffix += formatReactions(m.Reactions)
+	if !m.Pinned.IsZero() {
+		suffix += " " + s.paint(pinnedColor, "[pinned]")
+	}
+	if m.To != "" {
+		return fmt.Sprintf("#%d [DM] %s -> %s: %s%s", id, sender, s.sender(m.To), s.markdown(m.Body), suffix)
+	}
+	if m.Kind == chat.KindEmote {
+		return fmt.Sprintf("#%d * %s %s%s", id, sender, s.paint(italic, s.markdown(m.Body)), suffix)
+	}
+	if m.Kind == chat.KindPoll {
+		return s.describePoll(m, sender, suffix)
+	}
+	if m.Kind == chat.KindFile {
+		file := m.File
+		if file == nil {
+			file = chat.NewFile(m.Body)
+		}
+		return s.describeFile(id, sender, file, suffix)
+	}
+	return fmt.Sprintf("#%d %s: %s%s", id, sender, s.markdown(m.Body), suffix)
+}
+
+// describeFile formats a shared file with its caption and how to get it
+func (s *session) describeFile(id int64, sender string, file *chat.File, suffix string) string {
+	what, get := "", fmt.Sprintf("/get-file %d to save it", id)
+	if file.IsImage() {
+		what, get = "an image ", fmt.Sprintf("/view %d to show it or %s", id, get)
+	}
+	var caption string
+	if file.Caption != "" {
+		caption = ": " + file.Caption
+	}
+	return fmt.Sprintf("#%d %s shared %s%s (%s)%s, %s%s", id, sender, what, s.paint(bold, file.Name), formatSize(file.Size), caption, get, suffix)
+}
+
+// describePoll formats a poll with its options and how many votes each
+// has, one per line
+func (s *session) describePoll(m chat.Message, sender, suffix string) string {
+	poll := m.Poll
+	if poll == nil {
+		poll = chat.NewPoll(m.Body)
+	}
+	if !poll.Closed.IsZero() {
+		suffix = " " + s.paint(systemColor, "(closed)") + suffix
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "#%d %s started a poll: %s%s", m.ID, sender, s.paint(bold, poll.Question), suffix)
+	for i, n := range poll.Tally() {
+		fmt.Fprintf(&b, "\n    %d. %s (%d)", i+1, poll.Options[i], n)
+	}
+	return b.String()
+}
+
+// formatTally renders a poll's votes on one line, like
+// "Lunch? Pizza 3, Tacos 1"
+func formatTally(poll *chat.Poll) string {
+	counts := poll.Tally()
+	parts := make([]string, len(counts))
+	for i, n := range counts {
+		parts[i] = fmt.Sprintf("%s %d", poll.Options[i], n)
+	}
+	return poll.Question + " " + strings.Join(parts, ", ")
+}
+
+// pending formats a message that is queued to be sent once we reconnect.
+// It has no ID yet, so it is marked as pending instead.
+func (s *session) pending(out outgoing) string {
+	tag := s.paint(systemColor, "(pending)")
+	if out.replyTo != 0 {
+		tag = s.paint(systemColor, fmt.Sprintf("(pending reply to #%d)", out.replyTo))
+	}
+	sender := s.sender(s.name)
+	if out.to != "" {
+		return fmt.Sprintf("%s [DM] %s -> %s: %s", tag, sender, s.sender(out.to), out.text)
+	}
+	if out.emote {
+		return fmt.Sprintf("%s * %s %s", tag, sender, s.paint(italic, out.text))
+	}
+	return fmt.Sprintf("%s %s: %s", tag, sender, out.text)
+}
+
+// formatPreview renders a link preview for the line under the message
+// with the link, as its title followed by the description
+func (s *session) formatPreview(p *chat.Preview) string {
+	text := s.paint(bold, p.Title)
+	if p.Description != "" {
+		text += " - " + p.Description
+	}
+	return "    " + s.paint(systemColor, "↳ ") + text
+}
+
+// formatReactions renders reaction counts like " [👍 2 🎉 1]", most
+// popular first
+func formatReactions(reactions map[string][]string) string {
+	if len(reactions) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(reactions))
+	for r := range reactions {
+		keys = append(keys, r)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(reactions[keys[i]]) != len(reactions[keys[j]]) {
+			return len(reactions[keys[i]]) > len(reactions[keys[j]])
+		}
+		return keys[i] < keys[j]
+	})
+
+	parts := make([]string, len(keys))
+	for i, r := range keys {
+		parts[i] = fmt.Sprintf("%s %d", r, len(reactions[r]))
+	}
+	return " [" + strings.Join(parts, " ") + "]"
+}
+
+// quoteLength is how much of a message is quoted above a reply
+const quoteLength = 40
+
+// quote formats the start of a message for display above a reply to it
+func quote(m chat.Message) string {
+	if !m.Deleted.IsZero() {
+		return "  > (message deleted)"
+	}
+	body := []rune(strings.ReplaceAll(m.Body, "\n", " "))
+	if m.Kind == chat.KindPoll {
+		body = []rune(chat.NewPoll(m.Body).Question)
+	}
+	if m.Kind == chat.KindFile {
+		body = []rune(chat.NewFile(m.Body).Name)
+	}
+	if len(body) > quoteLength {
+		body = append(body[:quoteLength], '…')
+	}
+	if m.Kind == chat.KindEmote {
+		return "  > * " + m.Sender + " " + string(body)
+	}
+	return "  > " + m.Sender + ": " + string(body)
+}
+
+// printMessages prints messages from a feed above a fresh prompt, tagging
+// them with the room name unless it is the current room. Messages that were
+// already printed are skipped.
+func (s *session) printMessages(feed *roomFeed, messages []chat.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.prompt != "" {
+		fmt.Fprint(s.out, "\r")
+	}
+	for _, msg := range messages {
+		if msg.ID <= feed.lastID {
+			continue
+		}
+		feed.lastID = msg.ID
+		s.remember(msg)
+		if msg.IsChat() && msg.Sender != s.name && (feed.direct || s.mentionsMe(msg)) {
+			s.alert(msg, feed.direct)
+		}
+		if !feed.direct && feed.room != s.current {
+			fmt.Fprintf(s.out, "[%s] ", feed.room)
+		}
+		s.printMessage(msg, feed.thread)
+	}
+	fmt.Fprint(s.out, s.prompt)
+}
+
+// printHistory prints a page of history. Events are left out since the
+// messages they changed already show their effect. The caller must hold
+// s.mu.
+func (s *session) printHistory(messages []chat.Message, thread int64) {
+	for _, msg := range messages {
+		s.remember(msg)
+	}
+	for _, msg := range messages {
+		if !msg.IsEvent() {
+			s.printMessage(msg, thread)
+		}
+	}
+}
+
+// printMessage prints msg as seen while viewing thread, or the whole room
+// if thread is 0. Replies in other threads only get a one-line note so
+// they do not clutter the room. The caller must hold s.mu.
+func (s *session) printMessage(msg chat.Message, thread int64) {
+	if msg.Thread != 0 && msg.Thread != thread {
+		fmt.Fprintf(s.out, "%s#%d %s replied in thread #%d\n", s.stamp(msg), msg.ID, msg.Sender, msg.Thread)
+		return
+	}
+	// Replies to the start of the thread being viewed need no quote,
+	// since it is shown at the top
+	if msg.InReplyTo != 0 && msg.InReplyTo != thread {
+		fmt.Fprintln(s.out, s.quoted(msg.InReplyTo))
+	}
+	if s.mentionsMe(msg) {
+		fmt.Fprintln(s.out, s.paint(bold, s.display(msg))+s.receiptMark(msg))
+		return
+	}
+	fmt.Fprintln(s.out, s.display(msg)+s.receiptMark(msg))
+
+	if msg.Preview != nil && msg.Deleted.IsZero() {
+		fmt.Fprintln(s.out, s.formatPreview(msg.Preview))
+	}
+	// Show the new tally under each vote
+	if msg.Kind == chat.KindVote || msg.Kind == chat.KindClosePoll {
+		if poll, ok := s.seen[msg.Ref]; ok && poll.Poll != nil {
+			fmt.Fprintln(s.out, "    "+formatTally(poll.Poll))
+		}
+	}
+}
+
+// mentionsMe reports whether msg mentions the local user
+func (s *session) mentionsMe(msg chat.Message) bool {
+	for _, name := range msg.Mentions {
+		if name == s.name {
+			return true
+		}
+	}
+	return false
+}
+
+// printFound prints messages from different rooms and conversations under
+// a title
+func (s *session) printFound(title string, messages []chat.Message) {
+	fmt.Fprintf(s.out, "--- %s ---\n", title)
+	for _, msg := range messages {
+		if msg.To == "" {
+			fmt.Fprintf(s.out, "[%s] ", msg.Room)
+		}
+		fmt.Fprintln(s.out, s.display(msg))
+	}
+	fmt.Fprintln(s.out, "------------------")
+}
+
+// remember keeps msg for quoting replies to it, or applies it to the
+// message it changes. The caller must hold s.mu.
+func (s *session) remember(msg chat.Message) {
+	switch msg.Kind {
+	case chat.KindChat, chat.KindEmote, chat.KindPoll, chat.KindFile:
+		s.seen[msg.ID] = msg
+	case chat.KindEdit, chat.KindDelete, chat.KindPin, chat.KindUnpin, chat.KindVote, chat.KindClosePoll, chat.KindPreview:
+		target, ok := s.seen[msg.Ref]
+		if !ok {
+			return
+		}
+		switch msg.Kind {
+		case chat.KindEdit:
+			target.Body = msg.Body
+			if target.Preview != nil && !strings.Contains(target.Body, target.Preview.URL) {
+				target.Preview = nil
+			}
+		case chat.KindDelete:
+			target.Deleted = msg.Timestamp
+		case chat.KindPin:
+			target.Pinned = msg.Timestamp
+		case chat.KindUnpin:
+			target.Pinned = time.Time{}
+		case chat.KindVote, chat.KindClosePoll:
+			target.Poll = target.Poll.Apply(msg)
+		case chat.KindPreview:
+			target.Preview = chat.NewPreview(msg.Body)
+		}
+		s.seen[msg.Ref] = target
+	}
+}
+
+// quoted returns the quote shown above a reply to message id. The caller
+// must hold s.mu.
+func (s *session) quoted(id int64) string {
+	if m, ok := s.seen[id]; ok {
+		return quote(m)
+	}
+	return fmt.Sprintf("  > (reply to #%d)", id)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// emoji maps the shortcodes the client understands, as used on GitHub and
+// Slack, to the emoji they stand for
+var emoji = map[string]string{
+	// Faces
+	"smile": "😄", "smiley": "😃", "grin": "😁", "grinning": "😀", "laughing": "😆", "joy": "😂",
+	"rofl": "🤣", "sweat_smile": "😅", "slightly_smiling_face": "🙂", "upside_down_face": "🙃",
+	"wink": "😉", "blush": "😊", "innocent": "😇", "heart_eyes": "😍", "star_struck": "🤩",
+	"kissing_heart": "😘", "yum": "😋", "stuck_out_tongue": "😛", "stuck_out_tongue_winking_eye": "😜",
+	"zany_face": "🤪", "hugs": "🤗", "thinking": "🤔", "shushing_face": "🤫", "zipper_mouth_face": "🤐",
+	"neutral_face": "😐", "expressionless": "😑", "no_mouth": "😶", "smirk": "😏", "unamused": "😒",
+	"roll_eyes": "🙄", "grimacing": "😬", "relieved": "😌", "pensive": "😔", "sleepy": "😪",
+	"sleeping": "😴", "mask": "😷", "nerd_face": "🤓", "sunglasses": "😎", "confused": "😕",
+	"worried": "😟", "frowning_face": "☹️", "open_mouth": "😮", "astonished": "😲", "flushed": "😳",
+	"pleading_face": "🥺", "cry": "😢", "sob": "😭", "scream": "😱", "confounded": "😖",
+	"disappointed": "😞", "sweat": "😓", "weary": "😩", "tired_face": "😫", "yawning_face": "🥱",
+	"triumph": "😤", "rage": "😡", "angry": "😠", "cursing_face": "🤬", "smiling_imp": "😈",
+	"skull": "💀", "poop": "💩", "clown_face": "🤡", "ghost": "👻", "alien": "👽", "robot": "🤖",
+	"partying_face": "🥳", "exploding_head": "🤯", "cold_face": "🥶", "hot_face": "🥵",
+	"face_with_monocle": "🧐", "see_no_evil": "🙈", "hear_no_evil": "🙉", "speak_no_evil": "🙊",
+
+	// Hands and people
+	"+1": "👍", "thumbsup": "👍", "-1": "👎", "thumbsdown": "👎", "ok_hand": "👌", "wave": "👋",
+	"clap": "👏", "raised_hands": "🙌", "pray": "🙏", "handshake": "🤝", "muscle": "💪",
+	"point_up": "☝️", "point_down": "👇", "point_left": "👈", "point_right": "👉", "fist": "✊",
+	"v": "✌️", "crossed_fingers": "🤞", "metal": "🤘", "call_me_hand": "🤙", "writing_hand": "✍️",
+	"eyes": "👀", "brain": "🧠", "facepalm": "🤦", "shrug": "🤷", "bow": "🙇", "dancer": "💃",
+	"runner": "🏃", "man_technologist": "👨‍💻", "woman_technologist": "👩‍💻",
+
+	// Hearts and symbols
+	"heart": "❤️", "orange_heart": "🧡", "yellow_heart": "💛", "green_heart": "💚", "blue_heart": "💙",
+	"purple_heart": "💜", "black_heart": "🖤", "broken_heart": "💔", "sparkling_heart": "💖",
+	"two_hearts": "💕", "100": "💯", "fire": "🔥", "sparkles": "✨", "star": "⭐", "boom": "💥",
+	"zap": "⚡", "tada": "🎉", "confetti_ball": "🎊", "balloon": "🎈", "gift": "🎁", "trophy": "🏆",
+	"medal_sports": "🏅", "dart": "🎯", "bulb": "💡", "bell": "🔔", "mega": "📣", "speech_balloon": "💬",
+	"thought_balloon": "💭", "zzz": "💤", "check": "✔️", "white_check_mark": "✅", "x": "❌",
+	"heavy_check_mark": "✔️", "warning": "⚠️", "no_entry": "⛔", "question": "❓", "exclamation": "❗",
+	"bangbang": "‼️", "interrobang": "⁉️", "recycle": "♻️", "arrow_up": "⬆️", "arrow_down": "⬇️",
+	"arrow_left": "⬅️", "arrow_right": "➡️", "new": "🆕", "free": "🆓", "cool": "🆒", "sos": "🆘",
+	"copyright": "©️", "registered": "®️", "tm": "™️", "infinity": "♾️", "heavy_plus_sign": "➕",
+	"heavy_minus_sign": "➖", "red_circle": "🔴", "green_circle": "🟢", "large_blue_circle": "🔵",
+
+	// Nature and weather
+	"sunny": "☀️", "cloud": "☁️", "umbrella": "☔", "snowflake": "❄️", "rainbow": "🌈",
+	"crescent_moon": "🌙", "earth_africa": "🌍", "earth_americas": "🌎", "earth_asia": "🌏",
+	"ocean": "🌊", "seedling": "🌱", "evergreen_tree": "🌲", "palm_tree": "🌴", "cactus": "🌵",
+	"four_leaf_clover": "🍀", "maple_leaf": "🍁", "rose": "🌹", "sunflower": "🌻", "cherry_blossom": "🌸",
+	"dog": "🐶", "cat": "🐱", "mouse": "🐭", "rabbit": "🐰", "fox_face": "🦊", "bear": "🐻",
+	"panda_face": "🐼", "koala": "🐨", "tiger": "🐯", "lion": "🦁", "cow": "🐮", "pig": "🐷",
+	"frog": "🐸", "monkey": "🐒", "chicken": "🐔", "penguin": "🐧", "bird": "🐦", "owl": "🦉",
+	"unicorn": "🦄", "bee": "🐝", "bug": "🐛", "butterfly": "🦋", "snail": "🐌", "turtle": "🐢",
+	"snake": "🐍", "octopus": "🐙", "fish": "🐟", "whale": "🐳", "dolphin": "🐬", "crab": "🦀",
+	"gopher": "🐹",
+
+	// Food and drink
+	"apple": "🍎", "banana": "🍌", "grapes": "🍇", "watermelon": "🍉", "strawberry": "🍓",
+	"peach": "🍑", "cherries": "🍒", "lemon": "🍋", "avocado": "🥑", "pizza": "🍕", "hamburger": "🍔",
+	"fries": "🍟", "hotdog": "🌭", "taco": "🌮", "burrito": "🌯", "sushi": "🍣", "ramen": "🍜",
+	"spaghetti": "🍝", "bread": "🍞", "cheese": "🧀", "egg": "🥚", "cake": "🍰", "birthday": "🎂",
+	"cookie": "🍪", "doughnut": "🍩", "chocolate_bar": "🍫", "candy": "🍬", "popcorn": "🍿",
+	"coffee": "☕", "tea": "🍵", "beer": "🍺", "beers": "🍻", "wine_glass": "🍷", "cocktail": "🍸",
+	"champagne": "🍾",
+
+	// Activities, travel and objects
+	"soccer": "⚽", "basketball": "🏀", "football": "🏈", "tennis": "🎾", "video_game": "🎮",
+	"game_die": "🎲", "guitar": "🎸", "musical_note": "🎵", "notes": "🎶", "headphones": "🎧",
+	"art": "🎨", "movie_camera": "🎥", "camera": "📷", "tv": "📺", "computer": "💻",
+	"keyboard": "⌨️", "desktop_computer": "🖥️", "iphone": "📱", "phone": "☎️", "email": "📧",
+	"mailbox": "📫", "package": "📦", "memo": "📝", "pencil2": "✏️", "book": "📖", "books": "📚",
+	"calendar": "📆", "chart_with_upwards_trend": "📈", "chart_with_downwards_trend": "📉",
+	"clipboard": "📋", "pushpin": "📌", "paperclip": "📎", "link": "🔗", "lock": "🔒", "unlock": "🔓",
+	"key": "🔑", "hammer": "🔨", "wrench": "🔧", "gear": "⚙️", "mag": "🔍", "hourglass": "⌛",
+	"alarm_clock": "⏰", "stopwatch": "⏱️", "moneybag": "💰", "dollar": "💵", "credit_card": "💳",
+	"gem": "💎", "rocket": "🚀", "airplane": "✈️", "car": "🚗", "bike": "🚲", "train": "🚆",
+	"ship": "🚢", "house": "🏠", "office": "🏢", "hospital": "🏥", "school": "🏫",
+	"construction": "🚧", "rotating_light": "🚨", "checkered_flag": "🏁", "triangular_flag_on_post": "🚩",
+	"crown": "👑", "ring": "💍", "lipstick": "💄", "eyeglasses": "👓", "tshirt": "👕", "jeans": "👖",
+	"shopping_cart": "🛒", "pill": "💊", "syringe": "💉", "dna": "🧬", "microscope": "🔬",
+	"telescope": "🔭", "satellite": "📡", "battery": "🔋", "electric_plug": "🔌", "flashlight": "🔦",
+	"candle": "🕯️", "bomb": "💣", "shield": "🛡️", "crystal_ball": "🔮", "magnet": "🧲",
+}
+
+// shortcodeAt returns the emoji for the shortcode starting with the colon
+// at text[i], and the index just past it, or "" and i if there is none
+// there
+func shortcodeAt(text []rune, i int) (string, int) {
+	for end := i + 1; end < len(text) && end-i <= 32; end++ {
+		if text[end] == ':' {
+			if e, ok := emoji[string(text[i+1:end])]; ok {
+				return e, end + 1
+			}
+			return "", i
+		}
+		if !isShortcodeRune(text[end]) {
+			break
+		}
+	}
+	return "", i
+}
+
+// isShortcodeRune reports whether r may appear in a shortcode
+func isShortcodeRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '+' || r == '-'
+}
+
+// emojify replaces the shortcodes in text, like :smile:, with their emoji,
+// unless that is turned off with -no-emoji
+func (s *session) emojify(text string) string {
+	if s.noEmoji || !strings.Contains(text, ":") {
+		return text
+	}
+	runes := []rune(text)
+	var b strings.Builder
+	for i := 0; i < len(runes); i++ {
+		if runes[i] == ':' {
+			if e, end := shortcodeAt(runes, i); e != "" {
+				b.WriteString(e)
+				i = end - 1
+				continue
+			}
+		}
+		b.WriteRune(runes[i])
+	}
+	return b.String()
+}
+
+// searchEmoji lists the shortcodes containing query, with their emoji
+func (s *session) searchEmoji(query string) error {
+	query = strings.ToLower(strings.Trim(query, ":"))
+	if query == "" {
+		return errors.New("give part of a shortcode to search for, e.g. /emoji heart")
+	}
+	var found []string
+	for code := range emoji {
+		if strings.Contains(code, query) {
+			found = append(found, code)
+		}
+	}
+	if len(found) == 0 {
+		fmt.Fprintf(s.out, "No emoji match %q\n", query)
+		return nil
+	}
+	sort.Strings(found)
+	for _, code := range found {
+		fmt.Fprintf(s.out, "%s :%s:\n", emoji[code], code)
+	}
+	return nil
+}
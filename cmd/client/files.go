@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// sendFile uploads the file at path in chunks and shares it in the
+// current room, with an optional caption
+func (s *session) sendFile(path, caption string) error {
+	feed := s.currentFeed()
+	if feed.direct {
+		return errors.New("switch to a room to share files in it")
+	}
+	if err := checkLength(caption); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	args := &chat.UploadArgs{
+		Name:     s.userName(),
+		Token:    s.sessionToken(),
+		Room:     feed.room,
+		FileName: filepath.Base(path),
+		Size:     int64(len(data)),
+		SHA256:   hex.EncodeToString(sum[:]),
+		Caption:  caption,
+	}
+
+	var reply chat.UploadReply
+	for offset := 0; ; {
+		end := min(offset+chat.FileChunkSize, len(data))
+		args.Offset, args.Data = int64(offset), data[offset:end]
+		reply = chat.UploadReply{}
+		if err := s.call("UploadFileChunk", args, &reply); err != nil {
+			return err
+		}
+		args.Upload = reply.Upload
+		if offset = end; offset == len(data) {
+			break
+		}
+	}
+	fmt.Fprintf(s.out, "Shared %s (%s) as #%d\n", args.FileName, formatSize(args.Size), reply.ID)
+	return nil
+}
+
+// download fetches the file shared by message id in chunks and checks it
+// against its hash
+func (s *session) download(id int64) ([]byte, chat.File, error) {
+	args := &chat.DownloadArgs{Name: s.userName(), Token: s.sessionToken(), ID: id}
+	var data []byte
+	var file chat.File
+	for {
+		var reply chat.DownloadReply
+		if err := s.call("DownloadFileChunk", args, &reply); err != nil {
+			return nil, file, err
+		}
+		file = reply.File
+		data = append(data, reply.Data...)
+		if int64(len(data)) >= file.Size || len(reply.Data) == 0 {
+			break
+		}
+		args.Offset = int64(len(data))
+	}
+	sum := sha256.Sum256(data)
+	if int64(len(data)) != file.Size || hex.EncodeToString(sum[:]) != file.SHA256 {
+		return nil, file, errors.New("the download does not match the file that was shared, please try again")
+	}
+	return data, file, nil
+}
+
+// getFile downloads the file shared by message id and saves it to path,
+// or under its own name in the current directory if path is empty. It
+// never overwrites a file.
+func (s *session) getFile(id int64, path string) error {
+	data, file, err := s.download(id)
+	if err != nil {
+		return err
+	}
+
+	if path == "" {
+		path = filepath.Base(file.Name)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%s already exists, give /get-file another path", path)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(s.out, "Saved %s (%s)\n", path, formatSize(file.Size))
+	return nil
+}
+
+// sendImage shares the image at path in the current room, refusing files
+// that clients could not show
+func (s *session) sendImage(path, caption string) error {
+	if chat.ImageType(path) == "" {
+		return errors.New("only PNG, JPEG and GIF images can be shared with /image, use /send-file for other files")
+	}
+	return s.sendFile(path, caption)
+}
+
+// viewImage downloads the image shared by message id and draws it in the
+// terminal
+func (s *session) viewImage(id int64) error {
+	if _, ok := s.ui.(*tui); ok {
+		return fmt.Errorf("images can only be shown with -plain, save it with /get-file %d instead", id)
+	}
+	if s.images == "" {
+		return fmt.Errorf("this terminal cannot show images (see -images), save it with /get-file %d instead", id)
+	}
+	data, file, err := s.download(id)
+	if err != nil {
+		return err
+	}
+	if !file.IsImage() {
+		return fmt.Errorf("#%d is not an image, save it with /get-file %d", id, id)
+	}
+	return showImage(s.out, s.images, data)
+}
+
+// formatSize renders a size in bytes the way people read it, e.g. "1.5 MB"
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// syntax is what the highlighter knows of a programming language. It works
+// a line at a time, so strings and comments spanning lines are only
+// highlighted on their first.
+type syntax struct {
+	keywords map[string]bool
+	comment  string // starts a comment that runs to the end of the line
+	quotes   string // characters strings start and end with
+	anyCase  bool   // keywords are also recognised in upper case
+}
+
+// newSyntax returns the syntax of a language with the given keywords
+func newSyntax(comment, quotes, keywords string) *syntax {
+	s := &syntax{keywords: make(map[string]bool), comment: comment, quotes: quotes}
+	for _, k := range strings.Fields(keywords) {
+		s.keywords[k] = true
+	}
+	return s
+}
+
+// caseless returns s with its keywords also recognised in upper case
+func caseless(s *syntax) *syntax {
+	s.anyCase = true
+	return s
+}
+
+var (
+	goSyntax = newSyntax("//", "\"'`", `break case chan const continue default defer else fallthrough
+		for func go goto if import interface map package range return select struct switch type var
+		nil true false iota`)
+	pythonSyntax = newSyntax("#", "\"'", `and as assert async await break class continue def del elif else
+		except finally for from global if import in is lambda nonlocal not or pass raise return try
+		while with yield None True False self`)
+	jsSyntax = newSyntax("//", "\"'`", `async await break case catch class const continue debugger default
+		delete do else export extends finally for function if import in instanceof let new of return
+		static super switch this throw try typeof var void while yield null undefined true false
+		interface type enum implements`)
+	cSyntax = newSyntax("//", "\"'", `auto break case char class const continue default delete do double
+		else enum extern float for goto if inline int long namespace new private protected public
+		return short signed sizeof static struct switch template this typedef union unsigned using
+		virtual void volatile while true false nullptr NULL bool`)
+	javaSyntax = newSyntax("//", "\"'", `abstract boolean break byte case catch char class continue default
+		do double else enum extends final finally float for if implements import instanceof int
+		interface long new package private protected public return short static super switch this
+		throw throws try void while var true false null`)
+	rustSyntax = newSyntax("//", "\"", `as async await break const continue crate else enum extern fn for
+		if impl in let loop match mod move mut pub ref return self Self static struct super trait
+		type unsafe use where while true false`)
+	shellSyntax = newSyntax("#", "\"'", `if then else elif fi for while until do done case esac in
+		function return local export echo exit`)
+	sqlSyntax = caseless(newSyntax("--", "'\"", `select from where and or not insert into values update set delete
+		create table drop alter index join left right inner outer on group by order having limit as
+		null is in like distinct union`))
+	jsonSyntax = newSyntax("", "\"", "true false null")
+)
+
+// syntaxes maps the language tags of code blocks to their syntax
+var syntaxes = map[string]*syntax{
+	"go": goSyntax, "golang": goSyntax,
+	"python": pythonSyntax, "py": pythonSyntax,
+	"javascript": jsSyntax, "js": jsSyntax, "typescript": jsSyntax, "ts": jsSyntax,
+	"c": cSyntax, "cpp": cSyntax, "c++": cSyntax, "h": cSyntax,
+	"java": javaSyntax, "kotlin": javaSyntax,
+	"rust": rustSyntax, "rs": rustSyntax,
+	"sh": shellSyntax, "bash": shellSyntax, "shell": shellSyntax, "zsh": shellSyntax,
+	"sql":  sqlSyntax,
+	"json": jsonSyntax,
+}
+
+// highlight colors the keywords, strings, numbers and comments in a line
+// of code
+func (s *session) highlight(lang *syntax, line string) string {
+	text := []rune(line)
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case lang.comment != "" && strings.HasPrefix(string(text[i:]), lang.comment):
+			b.WriteString(s.paint(systemColor, string(text[i:])))
+			return b.String()
+		case strings.ContainsRune(lang.quotes, c):
+			end := i + 1
+			for end < len(text) && text[end] != c {
+				if text[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end = min(end+1, len(text))
+			b.WriteString(s.paint(stringColor, string(text[i:end])))
+			i = end
+		case unicode.IsLetter(c) || c == '_':
+			end := i
+			for end < len(text) && (unicode.IsLetter(text[end]) || unicode.IsDigit(text[end]) || text[end] == '_') {
+				end++
+			}
+			word := string(text[i:end])
+			if lang.keywords[word] || lang.anyCase && lang.keywords[strings.ToLower(word)] {
+				word = s.paint(keywordColor, word)
+			}
+			b.WriteString(word)
+			i = end
+		case unicode.IsDigit(c):
+			end := i
+			for end < len(text) && (unicode.IsDigit(text[end]) || unicode.IsLetter(text[end]) || text[end] == '.') {
+				end++
+			}
+			b.WriteString(s.paint(numberColor, string(text[i:end])))
+			i = end
+		default:
+			b.WriteRune(c)
+			i++
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	_ "image/gif" // decoders for the images servers let through
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+)
+
+// Terminal graphics protocols images can be shown with
+const (
+	imagesKitty = "kitty"
+	imagesSixel = "sixel"
+)
+
+// maxImageWidth and maxImageHeight bound how large images are shown, in
+// pixels; larger ones are scaled down
+const (
+	maxImageWidth  = 480
+	maxImageHeight = 320
+)
+
+// kittyChunk is how much base64 data goes in each escape sequence of the
+// kitty graphics protocol, the most it allows
+const kittyChunk = 4096
+
+// imageProtocol returns the graphics protocol to show images with for the
+// -images flag: the one it names, or for "auto" the one the terminal is
+// known to support going by its environment. It returns "" for none.
+func imageProtocol(flag string) (string, error) {
+	switch flag {
+	case imagesKitty, imagesSixel:
+		return flag, nil
+	case "off":
+		return "", nil
+	case "auto":
+	default:
+		return "", fmt.Errorf("unknown -images %q (want auto, kitty, sixel or off)", flag)
+	}
+
+	term, program := os.Getenv("TERM"), os.Getenv("TERM_PROGRAM")
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "", term == "xterm-kitty", program == "WezTerm", program == "ghostty":
+		return imagesKitty, nil
+	case strings.Contains(term, "sixel"), term == "foot", term == "mlterm":
+		return imagesSixel, nil
+	}
+	return "", nil
+}
+
+// showImage draws an image in the terminal with protocol, scaled down to
+// fit maxImageWidth by maxImageHeight
+func showImage(w io.Writer, protocol string, data []byte) error {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("the image cannot be read: %w", err)
+	}
+	img = shrink(img, maxImageWidth, maxImageHeight)
+	if protocol == imagesKitty {
+		return writeKitty(w, img)
+	}
+	return writeSixel(w, img)
+}
+
+// shrink scales img down, keeping its aspect ratio, until it fits in
+// width by height pixels. Images that already fit are returned as they
+// are.
+func shrink(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+	scale := min(float64(width)/float64(b.Dx()), float64(height)/float64(b.Dy()))
+	if scale >= 1 {
+		return img
+	}
+	w, h := max(int(float64(b.Dx())*scale), 1), max(int(float64(b.Dy())*scale), 1)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(x, y, img.At(b.Min.X+int(float64(x)/scale), b.Min.Y+int(float64(y)/scale)))
+		}
+	}
+	return dst
+}
+
+// writeKitty draws img with the kitty graphics protocol, sending it as a
+// PNG split over as many escape sequences as it takes. q=2 keeps the
+// terminal from answering, which would end up in the input line.
+func writeKitty(w io.Writer, img image.Image) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	payload := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	var out strings.Builder
+	for first := true; ; first = false {
+		chunk := payload[:min(kittyChunk, len(payload))]
+		payload = payload[len(chunk):]
+		more := 0
+		if payload != "" {
+			more = 1
+		}
+		if first {
+			fmt.Fprintf(&out, "\x1b_Ga=T,f=100,q=2,m=%d;%s\x1b\\", more, chunk)
+		} else {
+			fmt.Fprintf(&out, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+		if more == 0 {
+			break
+		}
+	}
+	out.WriteString("\n")
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// writeSixel draws img as sixels, in the 216 web-safe colors. Each band of
+// six rows is drawn one color at a time, with runs of the same sixel
+// compressed.
+func writeSixel(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	pal := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), palette.WebSafe)
+	draw.FloydSteinberg.Draw(pal, pal.Bounds(), img, b.Min)
+	width, height := pal.Bounds().Dx(), pal.Bounds().Dy()
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "\x1bPq\"1;1;%d;%d", width, height)
+	for i, c := range pal.Palette {
+		r, g, bl, _ := c.RGBA()
+		fmt.Fprintf(&out, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, bl*100/0xffff)
+	}
+
+	row := make([]byte, width)
+	for top := 0; top < height; top += 6 {
+		// The colors in this band
+		used := make(map[uint8]bool)
+		for y := top; y < min(top+6, height); y++ {
+			for x := 0; x < width; x++ {
+				used[pal.ColorIndexAt(x, y)] = true
+			}
+		}
+		for c := range used {
+			for x := 0; x < width; x++ {
+				var bits byte
+				for dy := 0; dy < 6 && top+dy < height; dy++ {
+					if pal.ColorIndexAt(x, top+dy) == c {
+						bits |= 1 << dy
+					}
+				}
+				row[x] = '?' + bits
+			}
+			fmt.Fprintf(&out, "#%d", c)
+			writeSixelRow(&out, row)
+			out.WriteByte('$')
+		}
+		out.WriteByte('-')
+	}
+	out.WriteString("\x1b\\\n")
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// writeSixelRow writes a row of sixels, with runs of more than three of
+// the same one written as a repeat count
+func writeSixelRow(out *strings.Builder, row []byte) {
+	for i := 0; i < len(row); {
+		j := i
+		for j < len(row) && row[j] == row[i] {
+			j++
+		}
+		if n := j - i; n > 3 {
+			fmt.Fprintf(out, "!%d%c", n, row[i])
+		} else {
+			out.Write(row[i:j])
+		}
+		i = j
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"golang.org/x/term"
+)
+
+// lineEditor reads lines for the plain interface on a terminal, with
+// history on Up and Down and the usual editing keys such as Ctrl-A and
+// Ctrl-E. Messages printed while a line is being typed appear above it,
+// and the line is drawn again below them.
+type lineEditor struct {
+	term  *term.Terminal
+	fd    int
+	state *term.State // to restore when done
+	focus atomic.Bool
+}
+
+// startLineEditor puts the terminal in raw mode until stop is called.
+// Pressing Tab calls complete with the line and the cursor's byte offset.
+func startLineEditor(complete func(line string, pos int) (string, int, bool)) (*lineEditor, error) {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &lineEditor{fd: fd, state: state}
+	e.term = term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{&focusInput{File: os.Stdin, focused: &e.focus}, os.Stdout}, "")
+	t := e.term
+	if width, height, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+		t.SetSize(width, height)
+	}
+	t.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' {
+			return "", 0, false
+		}
+		return complete(line, pos)
+	}
+	os.Stdout.WriteString(focusReportingOn)
+	return e, nil
+}
+
+// Write prints p above the line being typed
+func (e *lineEditor) Write(p []byte) (int, error) {
+	return e.term.Write(p)
+}
+
+// readLine returns the next line typed, or io.EOF for Ctrl-C and Ctrl-D
+func (e *lineEditor) readLine() (string, error) {
+	line, err := e.term.ReadLine()
+	// Pasted lines are fine as they are
+	if errors.Is(err, term.ErrPasteIndicator) {
+		err = nil
+	}
+	return line, err
+}
+
+// readPassword reads a line without echoing it, after prompt
+func (e *lineEditor) readPassword(prompt string) (string, error) {
+	return e.term.ReadPassword(prompt)
+}
+
+// showName puts name in the prompt
+func (e *lineEditor) showName(name string) {
+	e.term.SetPrompt(promptFor(name))
+}
+
+// focused reports whether the terminal has told us it has focus
+func (e *lineEditor) focused() bool {
+	return e.focus.Load()
+}
+
+// stop takes the terminal out of raw mode
+func (e *lineEditor) stop() {
+	os.Stdout.WriteString(focusReportingOff)
+	term.Restore(e.fd, e.state)
+}
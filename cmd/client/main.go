@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/rpc"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+	"golang.org/x/term"
+)
+
+// promptFor returns the prompt shown while logged in as name
+func promptFor(name string) string {
+	return "[" + name + "] Enter message (or 'exit' to quit): "
+}
+
+// envOr returns the environment variable key, or fallback if it is unset
+func envOr(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// tlsConfig builds the client's TLS settings. Server certificates are
+// checked against the system roots, or against the PEM bundle in caFile.
+// A client certificate is presented when certFile and keyFile are given,
+// and its common name is returned as the name it identifies us by.
+func tlsConfig(caFile, certFile, keyFile string) (*tls.Config, string, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, "", err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, "", fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+
+	if certFile == "" && keyFile == "" {
+		return config, "", nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, "", err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, "", err
+	}
+	config.Certificates = []tls.Certificate{cert}
+	return config, leaf.Subject.CommonName, nil
+}
+
+// dial connects to the chat server at addr with connect. When that fails
+// it lets the user retry, possibly with a different address, until it
+// works or they type exit, in which case it returns nil. It also returns
+// the address that worked.
+func dial(reader *bufio.Reader, addr string, connect func(addr string) (*chat.Client, error)) (*chat.Client, string) {
+	for {
+		// Allow a bare host name and assume the default port
+		if _, _, err := net.SplitHostPort(addr); err != nil && !strings.HasPrefix(addr, chat.UnixScheme) {
+			addr = net.JoinHostPort(addr, chat.DefaultPort)
+		}
+
+		client, err := connect(addr)
+		if err == nil {
+			return client, addr
+		}
+		fmt.Printf("Could not connect to the chat server at %s: %v\n", addr, err)
+		fmt.Print("Press Enter to retry, type another address, or 'exit' to quit: ")
+
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, ""
+		}
+		switch line = strings.TrimSpace(line); line {
+		case "exit":
+			return nil, ""
+		case "":
+		default:
+			addr = line
+		}
+	}
+}
+
+// promptLogin asks for the user's name and logs in with it, asking again
+// while the server rejects it (e.g. because someone else is using it).
+// Registered users also give their password, which is returned as well
+// as the session token.
+func promptLogin(client *chat.Client, reader *bufio.Reader) (name, password, token string) {
+	for {
+		fmt.Print("Enter your name: ")
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			log.Fatal("Error reading name:", err)
+		}
+		name = strings.TrimSpace(line)
+
+		password, err = readPassword(reader, "Password (leave empty if you have no account): ")
+		if err != nil {
+			log.Fatal("Error reading password:", err)
+		}
+		if password != "" {
+			token, err = client.Authenticate(name, password)
+		} else {
+			token, err = client.Login(name)
+		}
+		if err == nil {
+			return name, password, token
+		}
+		if _, ok := err.(rpc.ServerError); !ok {
+			log.Fatal("RPC error:", err)
+		}
+		fmt.Println("Error:", err)
+	}
+}
+
+func main() {
+	serverAddr := flag.String("server", envOr("CHAT_SERVER", "localhost:"+chat.DefaultPort), "chat server address as host:port, or unix:///path/to.sock (env CHAT_SERVER)")
+	useTLS := flag.Bool("tls", false, "connect to the server over TLS")
+	tlsCA := flag.String("tls-ca", "", "PEM bundle of CAs to verify the server with instead of the system roots (implies -tls)")
+	tlsCert := flag.String("tls-cert", "", "PEM client certificate to log in with (implies -tls, requires -tls-key)")
+	tlsKey := flag.String("tls-key", "", "PEM private key for -tls-cert")
+	codec := flag.String("codec", chat.CodecGob, "codec the server speaks: gob or json")
+	useHTTP := flag.Bool("http", false, "connect with RPC over HTTP, to a server's -http-addr")
+	plain := flag.Bool("plain", false, "use the plain line-based interface even on a terminal")
+	timeFormat := flag.String("timefmt", "15:04", "Go time layout for when messages were sent, shown in local time; empty to hide it")
+	notify := flag.Bool("notify", false, "show a desktop notification for mentions and private messages while the terminal is in the background, with notify-send or osascript")
+	timeout := flag.Duration("timeout", 15*time.Second, "how long to wait for the server to answer a request before reconnecting; 0 to wait for ever")
+	images := flag.String("images", "auto", "how /view shows images: auto to go by the terminal, kitty, sixel or off")
+	noMarkdown := flag.Bool("no-markdown", false, "show messages as written instead of rendering their Markdown bold, italics and code")
+	noEmoji := flag.Bool("no-emoji", false, "show emoji shortcodes like :smile: as written instead of as emoji")
+	noColor := flag.Bool("no-color", os.Getenv("NO_COLOR") != "", "print without colors or bold text (env NO_COLOR)")
+	flag.Parse()
+
+	if *codec != chat.CodecGob && *codec != chat.CodecJSON {
+		log.Fatal("Unknown codec: ", *codec)
+	}
+
+	imageProto, err := imageProtocol(*images)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var config *tls.Config
+	var certName string
+	if *useTLS || *tlsCA != "" || *tlsCert != "" || *tlsKey != "" {
+		var err error
+		if config, certName, err = tlsConfig(*tlsCA, *tlsCert, *tlsKey); err != nil {
+			log.Fatal("TLS error:", err)
+		}
+	}
+
+	// Connect to the RPC server
+	reader := bufio.NewReader(os.Stdin)
+	connect := func(addr string) (*chat.Client, error) {
+		var client *chat.Client
+		var err error
+		if *useHTTP {
+			client, err = chat.DialHTTP(addr, rpc.DefaultRPCPath, *codec, config)
+		} else {
+			client, err = chat.DialCodec(addr, *codec, config)
+		}
+		if err != nil {
+			return nil, err
+		}
+		client.SetTimeout(*timeout)
+		return client, nil
+	}
+	client, addr := dial(reader, *serverAddr, connect)
+	if client == nil {
+		return
+	}
+
+	// A client certificate already tells the server who we are; otherwise
+	// ask for a name and password
+	var name, password, token string
+	if certName != "" {
+		name = certName
+		var err error
+		if token, err = client.Login(name); err != nil {
+			log.Fatal("Login error:", err)
+		}
+	} else {
+		name, password, token = promptLogin(client, reader)
+	}
+
+	// Keep our session alive while we are connected
+	s := &session{
+		name:       name,
+		password:   password,
+		reader:     reader,
+		dial:       func() (*chat.Client, error) { return connect(addr) },
+		out:        os.Stdout,
+		prompt:     promptFor(name),
+		noColor:    *noColor,
+		noMarkdown: *noMarkdown,
+		noEmoji:    *noEmoji,
+		timeFormat: *timeFormat,
+		notify:     *notify,
+		images:     imageProto,
+		timeout:    *timeout,
+		client:     client,
+		token:      token,
+		connected:  make(chan struct{}),
+		feeds:      make(map[string]*roomFeed),
+		seen:       make(map[int64]chat.Message),
+		receipts:   make(map[int64]chat.Receipt),
+	}
+	close(s.connected)
+	go s.sendHeartbeats()
+
+	// Take over the terminal, if there is one, before anything is printed
+	// that should end up in the frontend: with the full-screen interface,
+	// or else a line editor
+	if term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd())) {
+		if *plain {
+			if editor, err := startLineEditor(s.complete); err == nil {
+				s.ui = editor
+			}
+		} else {
+			s.ui = startTUI(s.complete)
+		}
+		if s.ui != nil {
+			s.out, s.prompt = s.ui, ""
+			s.ui.showName(name)
+		}
+	}
+
+	fmt.Fprintf(s.out, "Welcome, %s! You can start chatting.\n", name)
+	fmt.Fprintln(s.out, "Type /help for a list of commands.")
+	// Older servers without a message of the day answer with an error
+	s.showMOTD(false)
+	s.loadServerInfo()
+
+	// Show the default room's history and listen for new messages in the background
+	if err := s.join(chat.DefaultRoom, ""); err != nil {
+		s.fatal("RPC error:", err)
+	}
+	if err := s.followDirectMessages(); err != nil {
+		s.fatal("RPC error:", err)
+	}
+	s.refreshOnline()
+
+	// Main chat loop
+	for {
+		fmt.Fprint(s.out, s.prompt)
+		message, err := s.readLine()
+		// Ctrl-C, Ctrl-D or the end of the input leave like exit
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			s.fatal("Error reading message:", err)
+		}
+		message = strings.TrimSpace(message)
+
+		// Check if user wants to exit
+		if message == "exit" {
+			break
+		}
+		if message == "" {
+			continue
+		}
+
+		// Commands are handled locally instead of being sent as chat
+		if strings.HasPrefix(message, "/") {
+			err := s.runCommand(message)
+			if errors.Is(err, errQuit) {
+				break
+			}
+			if err != nil {
+				fmt.Fprintln(s.out, "Error:", err)
+			}
+			continue
+		}
+
+		if err := checkLength(message); err != nil {
+			fmt.Fprintln(s.out, "Error:", err)
+			continue
+		}
+
+		// Send the message to the server; it comes back to us through
+		// receiveMessages like everyone else's
+		if err := s.sendOrQueue(s.typed(message)); err != nil {
+			fmt.Fprintln(s.out, "Error:", err)
+		}
+	}
+
+	s.closing.Store(true)
+	s.call("UnregisterUser", &chat.UserArgs{Name: s.name, Token: s.sessionToken()}, &struct{}{})
+	if s.ui != nil {
+		s.ui.stop()
+	}
+	fmt.Println("Goodbye!")
+}
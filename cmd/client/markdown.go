@@ -0,0 +1,165 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// fence starts and ends a block of code in a message
+const fence = "```"
+
+// continuation indents the lines of a message after the first, so that
+// they stand out from the next message
+const continuation = "  "
+
+// markdown renders a message body for the terminal, with the lines after
+// the first indented. Only a safe subset of Markdown is understood:
+// **bold**, *italics* or _italics_, `code` and blocks of code between ```
+// lines, which are indented on lines of their own. Anything else, and
+// everything with -no-markdown, is shown as written. Emoji shortcodes
+// outside code are replaced too.
+func (s *session) markdown(body string) string {
+	if s.noMarkdown || !strings.ContainsAny(body, "*_`") {
+		return strings.ReplaceAll(s.emojify(body), "\n", "\n"+continuation)
+	}
+
+	var out []string
+	var code []string // lines of the block being read
+	inCode := false
+	lang := ""
+	for _, line := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case !inCode && strings.HasPrefix(trimmed, fence):
+			inCode, lang, code = true, strings.TrimSpace(trimmed[len(fence):]), nil
+		case inCode && trimmed == fence:
+			out = append(out, s.codeBlock(lang, code)...)
+			inCode = false
+		case inCode:
+			code = append(code, line)
+		case len(out) == 0:
+			out = append(out, s.inline(line))
+		default:
+			out = append(out, continuation+s.inline(line))
+		}
+	}
+	// A block that is never closed runs to the end of the message
+	if inCode {
+		out = append(out, s.codeBlock(lang, code)...)
+	}
+	// A message that starts with a block shows it under the sender's name
+	if strings.HasPrefix(strings.TrimSpace(body), fence) {
+		out = append([]string{""}, out...)
+	}
+	return strings.Join(out, "\n")
+}
+
+// codeBlock renders the lines of a block of code, indented under the
+// message with tabs expanded so that the indentation inside survives. Code
+// in a language the client knows, going by the block's tag, is
+// highlighted.
+func (s *session) codeBlock(lang string, lines []string) []string {
+	syntax := syntaxes[strings.ToLower(lang)]
+	rendered := make([]string, len(lines))
+	for i, line := range lines {
+		line = strings.ReplaceAll(line, "\t", "    ")
+		if syntax != nil {
+			rendered[i] = "    " + s.highlight(syntax, line)
+		} else {
+			rendered[i] = "    " + s.paint(codeColor, line)
+		}
+	}
+	return rendered
+}
+
+// inline renders the Markdown within a line, emphasis and code spans, and
+// the emoji shortcodes outside code spans. A backslash shows the character
+// after it as written.
+func (s *session) inline(line string) string {
+	text := []rune(line)
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.ContainsRune("\\*_`", text[i+1]):
+			b.WriteRune(text[i+1])
+			i++
+			continue
+		case c == ':' && !s.noEmoji:
+			if e, end := shortcodeAt(text, i); e != "" {
+				b.WriteString(e)
+				i = end - 1
+				continue
+			}
+		case c == '`':
+			if end := closing(text, i+1, "`"); end > i+1 {
+				b.WriteString(s.paint(codeColor, string(text[i+1:end])))
+				i = end
+				continue
+			}
+		case (c == '*' || c == '_') && i+1 < len(text) && text[i+1] == c:
+			if end := emphasis(text, i, string([]rune{c, c})); end > 0 {
+				b.WriteString(s.paint(bold, s.inline(string(text[i+2:end]))))
+				i = end + 1
+				continue
+			}
+		case c == '*' || c == '_':
+			if end := emphasis(text, i, string(c)); end > 0 {
+				b.WriteString(s.paint(italic, s.inline(string(text[i+1:end]))))
+				i = end
+				continue
+			}
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// emphasis returns where the emphasis opened by delim at text[start] ends,
+// or -1 if it is not emphasis. As in Markdown, the text inside may not
+// start or end with a space, and underscores inside words, as in
+// snake_case, are left alone.
+func emphasis(text []rune, start int, delim string) int {
+	from := start + len(delim)
+	if from >= len(text) || unicode.IsSpace(text[from]) {
+		return -1
+	}
+	if delim[0] == '_' && start > 0 && isWordRune(text[start-1]) {
+		return -1
+	}
+	end := closing(text, from, delim)
+	if end <= from || unicode.IsSpace(text[end-1]) {
+		return -1
+	}
+	if after := end + len(delim); delim[0] == '_' && after < len(text) && isWordRune(text[after]) {
+		return -1
+	}
+	return end
+}
+
+// closing returns the index of the first delim in text at or after from,
+// or -1 if there is none. A single * or _ does not match half of a double
+// one.
+func closing(text []rune, from int, delim string) int {
+	d := []rune(delim)
+	for i := from; i+len(d) <= len(text); i++ {
+		if text[i] == '\\' && d[0] != '`' {
+			i++
+			continue
+		}
+		if string(text[i:i+len(d)]) != delim {
+			continue
+		}
+		if len(d) == 1 && d[0] != '`' && i+1 < len(text) && text[i+1] == d[0] {
+			i++
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+// isWordRune reports whether r is part of a word for emphasis with _
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// Escape sequences that make the terminal report when its window gains or
+// loses focus, by typing focusIn or focusOut
+const (
+	focusReportingOn  = "\033[?1004h"
+	focusReportingOff = "\033[?1004l"
+	focusIn           = 'I' // after "\033["
+	focusOut          = 'O'
+)
+
+// focusInput reads from the terminal, taking out focus reports and
+// recording them in focused. Frontends read their keys through it.
+type focusInput struct {
+	*os.File
+	focused *atomic.Bool
+}
+
+func (f *focusInput) Read(p []byte) (int, error) {
+	for {
+		n, err := f.File.Read(p)
+		n = f.filter(p[:n])
+		// Reading nothing but a focus report is no reason to return
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+// filter removes focus reports from b and returns how much is left
+func (f *focusInput) filter(b []byte) int {
+	out := b[:0]
+	for i := 0; i < len(b); i++ {
+		if b[i] == '\033' && i+2 < len(b) && b[i+1] == '[' && (b[i+2] == focusIn || b[i+2] == focusOut) {
+			f.focused.Store(b[i+2] == focusIn)
+			i += 2
+			continue
+		}
+		out = append(out, b[i])
+	}
+	return len(out)
+}
+
+// alert tells the user about msg, which mentions them or was sent to them
+// privately, unless the terminal is known to have focus so they have seen
+// it already: it rings the bell and, with -notify, shows a desktop
+// notification. The caller must hold s.mu.
+func (s *session) alert(msg chat.Message, direct bool) {
+	if s.ui != nil && s.ui.focused() {
+		return
+	}
+	fmt.Fprint(s.out, "\a")
+	if !s.notify {
+		return
+	}
+	title := fmt.Sprintf("%s mentioned you in %s", msg.Sender, msg.Room)
+	if direct {
+		title = "Private message from " + msg.Sender
+	}
+	go notifyDesktop(title, msg.Body)
+}
+
+// notifyDesktop shows a desktop notification with notify-send, or with
+// osascript on macOS. Failures are ignored, since the bell has already
+// told the user.
+func notifyDesktop(title, body string) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(body), appleScriptString(title))
+		cmd = exec.Command("osascript", "-e", script)
+	} else {
+		cmd = exec.Command("notify-send", "--", title, body)
+	}
+	cmd.Run()
+}
+
+// appleScriptString quotes s as an AppleScript string literal
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// errPollUsage is returned when /poll cannot make sense of its arguments
+var errPollUsage = errors.New("usage: /poll <question> | <option> | <option>..., /poll vote <id> <option> or /poll close <id>")
+
+// poll handles /poll: starting a poll in the current room when line holds
+// a question and options separated by '|', otherwise voting or closing
+func (s *session) poll(line string) error {
+	if strings.Contains(line, "|") {
+		return s.startPoll(line)
+	}
+
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 3 && fields[0] == "vote":
+		id, err := parseID(fields[1])
+		if err != nil {
+			return err
+		}
+		option, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return fmt.Errorf("invalid option %q, give its number", fields[2])
+		}
+		return s.call("Vote", &chat.VoteArgs{Name: s.userName(), Token: s.sessionToken(), ID: id, Option: option}, &struct{}{})
+	case len(fields) == 2 && fields[0] == "close":
+		id, err := parseID(fields[1])
+		if err != nil {
+			return err
+		}
+		return s.call("ClosePoll", &chat.VoteArgs{Name: s.userName(), Token: s.sessionToken(), ID: id}, &struct{}{})
+	}
+	return errPollUsage
+}
+
+// startPoll starts a poll in the current room from a line like
+// "Lunch? | Pizza | Tacos"
+func (s *session) startPoll(line string) error {
+	feed := s.currentFeed()
+	if feed.direct {
+		return errors.New("switch to a room to start a poll in it")
+	}
+	parts := strings.Split(line, "|")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	args := &chat.PollArgs{Name: s.userName(), Token: s.sessionToken(), Room: feed.room, Question: parts[0], Options: parts[1:]}
+	var reply chat.PollReply
+	return s.call("CreatePoll", args, &reply)
+}
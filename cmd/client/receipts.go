@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// markRead tells the server we have seen messages a feed just printed.
+// Private messages and those of the current room count as read; other
+// rooms only get a tag in front of their messages, so they wait until we
+// switch to them.
+func (s *session) markRead(feed *roomFeed, messages []chat.Message) {
+	if !s.supports(chat.FeatureReceipts) {
+		return
+	}
+	s.mu.Lock()
+	name, current := s.name, feed.direct || feed.room == s.current
+	s.mu.Unlock()
+	if !current {
+		return
+	}
+
+	// The newest message from each conversation the messages are part of
+	newest := make(map[string]int64)
+	for _, m := range messages {
+		if !m.IsChat() || m.Sender == name {
+			continue
+		}
+		key := m.Room
+		if feed.direct {
+			key = m.Sender
+		}
+		newest[key] = max(newest[key], m.ID)
+	}
+	for key, id := range newest {
+		args := &chat.ReadArgs{Name: s.userName(), Token: s.sessionToken(), ID: id}
+		if feed.direct {
+			args.With = key
+		} else {
+			args.Room = key
+		}
+		s.call("MarkRead", args, &struct{}{})
+	}
+}
+
+// markRoomRead tells the server we have seen room up to message id, after
+// switching to it
+func (s *session) markRoomRead(room string, id int64) {
+	if id == 0 || !s.supports(chat.FeatureReceipts) {
+		return
+	}
+	s.call("MarkRead", &chat.ReadArgs{Name: s.userName(), Token: s.sessionToken(), Room: room, ID: id}, &struct{}{})
+}
+
+// loadReceipts fetches the receipts of our own messages among messages,
+// before they are printed with receiptMark
+func (s *session) loadReceipts(messages []chat.Message) {
+	if !s.supports(chat.FeatureReceipts) {
+		return
+	}
+	name := s.userName()
+	var ids []int64
+	for _, m := range messages {
+		if m.IsChat() && m.Sender == name && m.Deleted.IsZero() {
+			ids = append(ids, m.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	var reply chat.ReceiptsReply
+	if err := s.call("GetReceipts", &chat.ReceiptArgs{Name: name, Token: s.sessionToken(), IDs: ids}, &reply); err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range reply.Receipts {
+		s.receipts[r.ID] = r
+	}
+}
+
+// receiptMark returns " ✓✓" after one of our own messages once someone
+// has read it, " ✓" once it has reached someone, and nothing otherwise or
+// if we do not know. The caller must hold s.mu.
+func (s *session) receiptMark(m chat.Message) string {
+	r, ok := s.receipts[m.ID]
+	if !ok || m.Sender != s.name {
+		return ""
+	}
+	if len(r.Read) > 0 {
+		return " " + s.paint(systemColor, "✓✓")
+	}
+	if len(r.Delivered) > 0 {
+		return " " + s.paint(systemColor, "✓")
+	}
+	return ""
+}
+
+// showReceipts prints who has received and read one of our messages
+func (s *session) showReceipts(id int64) error {
+	var reply chat.ReceiptsReply
+	if err := s.call("GetReceipts", &chat.ReceiptArgs{Name: s.userName(), Token: s.sessionToken(), IDs: []int64{id}}, &reply); err != nil {
+		return err
+	}
+	r := reply.Receipts[0]
+	s.mu.Lock()
+	s.receipts[id] = r
+	s.mu.Unlock()
+
+	if len(r.Delivered) == 0 {
+		fmt.Fprintf(s.out, "#%d has not reached anyone yet\n", id)
+		return nil
+	}
+	fmt.Fprintf(s.out, "#%d delivered to: %s\n", id, strings.Join(r.Delivered, ", "))
+	if len(r.Read) > 0 {
+		fmt.Fprintf(s.out, "#%d read by: %s\n", id, strings.Join(r.Read, ", "))
+	}
+	return nil
+}
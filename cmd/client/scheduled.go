@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// parseWhen parses when to send a scheduled message: a delay such as 10m
+// or 1h30m, or a time of day such as 18:30, which means tomorrow once it
+// has passed today
+func parseWhen(when string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(when); err == nil {
+		if d <= 0 {
+			return time.Time{}, errors.New("the delay must be positive")
+		}
+		return now.Add(d), nil
+	}
+	clock, err := time.ParseInLocation("15:04", when, now.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, try e.g. 10m or 18:30", when)
+	}
+	at := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+	if !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+	return at, nil
+}
+
+// schedule asks the server to post text to the current room at the time
+// when stands for
+func (s *session) schedule(when, text string) error {
+	feed := s.currentFeed()
+	if feed.direct {
+		return errors.New("switch to a room to schedule messages for it")
+	}
+	if err := checkLength(text); err != nil {
+		return err
+	}
+	at, err := parseWhen(when, time.Now())
+	if err != nil {
+		return err
+	}
+	args := &chat.ScheduleArgs{Name: s.userName(), Token: s.sessionToken(), Room: feed.room, Message: text, At: at}
+	var reply chat.ScheduleReply
+	if err := s.call("ScheduleMessage", args, &reply); err != nil {
+		return err
+	}
+	fmt.Fprintf(s.out, "Message %d will be sent to %s at %s, /unschedule %d to cancel it\n", reply.ID, feed.room, at.Format(time.DateTime), reply.ID)
+	return nil
+}
+
+// remind asks the server to send text back to us at the time when stands
+// for
+func (s *session) remind(when, text string) error {
+	if err := checkLength(text); err != nil {
+		return err
+	}
+	at, err := parseWhen(when, time.Now())
+	if err != nil {
+		return err
+	}
+	args := &chat.ReminderArgs{Name: s.userName(), Token: s.sessionToken(), Message: text, At: at}
+	var reply chat.ScheduleReply
+	if err := s.call("SetReminder", args, &reply); err != nil {
+		return err
+	}
+	fmt.Fprintf(s.out, "Reminder %d set for %s, /unschedule %d to cancel it\n", reply.ID, at.Format(time.DateTime), reply.ID)
+	return nil
+}
+
+// listScheduled prints our scheduled messages and reminders that have not
+// been sent yet
+func (s *session) listScheduled() error {
+	var reply chat.ScheduledReply
+	if err := s.call("ListScheduled", &chat.UserArgs{Name: s.userName(), Token: s.sessionToken()}, &reply); err != nil {
+		return err
+	}
+	if len(reply.Messages) == 0 {
+		fmt.Fprintln(s.out, "You have no scheduled messages")
+		return nil
+	}
+	fmt.Fprintln(s.out, "--- Scheduled messages ---")
+	for _, m := range reply.Messages {
+		where := "in " + m.Room
+		if m.Reminder {
+			where = "reminder"
+		}
+		fmt.Fprintf(s.out, "#%d at %s %s: %s\n", m.ID, m.At.Local().Format(time.DateTime), where, m.Body)
+	}
+	fmt.Fprintln(s.out, "Cancel them with /unschedule <id>")
+	return nil
+}
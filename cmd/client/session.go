@@ -0,0 +1,629 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/rpc"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// minReconnectDelay and maxReconnectDelay bound the exponential backoff
+// between attempts to reconnect to the server
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+// heartbeatInterval is how often the client tells the server it is still
+// online, unless the server's presence timeout calls for more often
+const heartbeatInterval = 10 * time.Second
+
+// roomFeed follows the messages of one joined room, or the user's private
+// messages when direct is set
+type roomFeed struct {
+	room      string
+	direct    bool
+	lastIndex atomic.Int64
+	lastID    int64 // highest message ID printed, used to drop duplicates
+	oldestID  int64 // lowest message ID printed, where /more continues
+	more      bool  // older messages are available
+	thread    int64 // thread being viewed, 0 for the whole room
+	stopped   atomic.Bool
+}
+
+// errDisconnected is returned by calls made while the connection to the
+// server is down
+var errDisconnected = errors.New("not connected to the server")
+
+// outgoing is a message waiting to be sent, to a room or to a user
+type outgoing struct {
+	room    string
+	to      string
+	text    string
+	replyTo int64  // message being replied to, 0 if none
+	emote   bool   // an action, sent with /me
+	id      string // ClientID, kept when the message is sent again
+}
+
+// frontend is an interface that takes over the terminal: the TUI, or the
+// line editor for the plain interface. It is also where the session prints.
+type frontend interface {
+	io.Writer
+	readLine() (string, error) // io.EOF when the user wants to quit
+	readPassword(prompt string) (string, error)
+	showName(name string) // puts the name we are logged in as in the prompt
+	focused() bool        // whether the terminal is known to have focus
+	stop()                // gives the terminal back
+}
+
+// session holds the connection and the rooms the user has joined
+type session struct {
+	// name and password log us in again after reconnecting; password is
+	// empty without an account. Only the main loop changes them, holding
+	// both connMu and mu, so other goroutines may read them with either.
+	name     string
+	password string
+	reader   *bufio.Reader
+	dial     func() (*chat.Client, error) // opens a new connection to the server
+
+	// Everything is printed to out, followed by prompt after incoming
+	// messages so the user can carry on typing. A frontend draws its own
+	// prompt and leaves this one empty.
+	out     io.Writer
+	prompt  string
+	ui      frontend // nil when reading lines from reader
+	noColor bool     // print no ANSI escapes, for terminals without them
+	// noMarkdown shows message bodies as written, without rendering
+	// Markdown, and noEmoji leaves emoji shortcodes like :smile: alone
+	noMarkdown bool
+	noEmoji    bool
+
+	timeFormat string // layout for the time in front of messages, empty for none
+	notify     bool   // show desktop notifications as well as ringing the bell
+	images     string // graphics protocol /view draws images with, empty for none
+
+	timeout time.Duration // how long the server may take to answer, 0 for ever
+
+	closing atomic.Bool // set once the user exits
+
+	connMu    sync.Mutex
+	client    *chat.Client  // nil while reconnecting
+	token     string        // identifies us to the server after Login
+	connected chan struct{} // closed while client is usable
+	queue     []outgoing    // messages waiting to be sent, oldest first
+
+	mu       sync.Mutex
+	online   []string // who is online, for completing @names
+	current  string   // room that typed messages are sent to
+	feeds    map[string]*roomFeed
+	seen     map[int64]chat.Message // messages printed so far, for quoting replies
+	receipts map[int64]chat.Receipt // who got our own messages, as last fetched
+
+	info *chat.ServerInfoReply // the server's version and features, nil if it cannot say
+}
+
+// supports reports whether the server supports feature, assuming it does
+// if the server is too old to say
+func (s *session) supports(feature string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.info == nil || s.info.Supports(feature)
+}
+
+// loadServerInfo asks the server what it supports. Older servers cannot
+// say, which leaves every command available.
+func (s *session) loadServerInfo() {
+	var info chat.ServerInfoReply
+	if err := s.call("GetServerInfo", &struct{}{}, &info); err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.info = &info
+	s.mu.Unlock()
+}
+
+// call makes an RPC to the server. If the connection turns out to be
+// broken it starts reconnecting in the background and returns
+// errDisconnected.
+func (s *session) call(method string, args, reply any) error {
+	s.connMu.Lock()
+	client := s.client
+	s.connMu.Unlock()
+	if client == nil {
+		return errDisconnected
+	}
+
+	err := client.Call(method, args, reply)
+	if _, ok := err.(rpc.ServerError); err != nil && !ok {
+		s.lost(client)
+		return errDisconnected
+	}
+	return err
+}
+
+// userName returns the name we are logged in with
+func (s *session) userName() string {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	return s.name
+}
+
+// sessionToken returns the token of our current login
+func (s *session) sessionToken() string {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	return s.token
+}
+
+// waitConnected blocks until the session has a working connection
+func (s *session) waitConnected() {
+	s.connMu.Lock()
+	connected := s.connected
+	s.connMu.Unlock()
+	<-connected
+}
+
+// lost drops a broken connection and starts reconnecting, unless another
+// call noticed first or the user is exiting
+func (s *session) lost(client *chat.Client) {
+	s.connMu.Lock()
+	if s.client != client || s.closing.Load() {
+		s.connMu.Unlock()
+		return
+	}
+	client.Close()
+	s.client = nil
+	s.connected = make(chan struct{})
+	s.connMu.Unlock()
+
+	s.notice("Lost the connection to the server, reconnecting...")
+	go s.reconnect()
+}
+
+// reconnect connects and logs in again, waiting longer after every failed
+// attempt, then rejoins our rooms and sends the messages typed meanwhile.
+// It gives up if the server refuses to let us back in.
+func (s *session) reconnect() {
+	delay := minReconnectDelay
+	for !s.closing.Load() {
+		time.Sleep(delay)
+
+		client, token, err := s.relogin()
+		if err == nil {
+			s.resume(client, token)
+			return
+		}
+		// Our old session may still hold the name until the server
+		// notices it is gone, or the server may be full for now; anything
+		// else means we are not welcome
+		if _, ok := err.(rpc.ServerError); ok && !strings.Contains(err.Error(), "already taken") && !strings.Contains(err.Error(), "try again later") {
+			s.fatal("Could not log in again: ", err)
+		}
+
+		delay = min(delay*2, maxReconnectDelay)
+		s.notice(fmt.Sprintf("Could not reconnect (%v), retrying in %v", err, delay))
+	}
+}
+
+// relogin opens a new connection and logs in on it the way we did at first
+func (s *session) relogin() (*chat.Client, string, error) {
+	client, err := s.dial()
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.connMu.Lock()
+	name, password := s.name, s.password
+	s.connMu.Unlock()
+
+	var token string
+	if password != "" {
+		token, err = client.Authenticate(name, password)
+	} else {
+		token, err = client.Login(name)
+	}
+	if err != nil {
+		client.Close()
+		return nil, "", err
+	}
+	return client, token, nil
+}
+
+// resume switches the session to a new connection. The feeds pick up from
+// where they were, which fetches anything we missed.
+func (s *session) resume(client *chat.Client, token string) {
+	s.connMu.Lock()
+	s.client, s.token = client, token
+	close(s.connected)
+	s.connMu.Unlock()
+
+	s.notice("Reconnected")
+
+	// A restarted server may have forgotten which rooms we were in
+	s.mu.Lock()
+	var rooms []string
+	for room, feed := range s.feeds {
+		if !feed.direct && room != chat.DefaultRoom {
+			rooms = append(rooms, room)
+		}
+	}
+	s.mu.Unlock()
+	for _, room := range rooms {
+		err := s.call("JoinRoom", &chat.RoomArgs{Name: s.userName(), Token: token, Room: room}, &struct{}{})
+		if err != nil {
+			s.notice(fmt.Sprintf("Could not rejoin %s: %v", room, err))
+			s.mu.Lock()
+			s.dropFeed(room)
+			s.mu.Unlock()
+		}
+	}
+
+	s.flush()
+}
+
+// flush sends the messages queued while we were disconnected, in the order
+// they were typed. Each stays queued until it has reached the server, so
+// anything typed meanwhile waits behind it. Room messages in a row go
+// together in one SendMessages call where the server has it.
+func (s *session) flush() {
+	single := !s.supports(chat.FeatureBatch)
+	for {
+		s.connMu.Lock()
+		if len(s.queue) == 0 {
+			s.connMu.Unlock()
+			return
+		}
+		n := 1
+		for !single && n < len(s.queue) && n < chat.MaxBatch && s.queue[0].to == "" && s.queue[n].to == "" {
+			n++
+		}
+		batch := slices.Clone(s.queue[:n])
+		s.connMu.Unlock()
+
+		var err error
+		if n > 1 {
+			err = s.sendBatch(batch)
+			var serverErr rpc.ServerError
+			if errors.As(err, &serverErr) {
+				// One bad message fails the lot; send them one at a time
+				// so that only the bad ones are lost
+				single = true
+				continue
+			}
+		} else {
+			err = s.send(batch[0])
+			if err != nil && !errors.Is(err, errDisconnected) {
+				s.notice(fmt.Sprintf("Could not send %q: %v", batch[0].text, err))
+			}
+		}
+		// Still queued, for the next reconnect to carry on with
+		if errors.Is(err, errDisconnected) {
+			return
+		}
+		s.connMu.Lock()
+		s.queue = s.queue[n:]
+		s.connMu.Unlock()
+	}
+}
+
+// sendBatch sends room messages with a single SendMessages call
+func (s *session) sendBatch(batch []outgoing) error {
+	args := &chat.BatchArgs{Name: s.userName(), Token: s.sessionToken(), Timeout: s.timeout}
+	for _, out := range batch {
+		args.Messages = append(args.Messages, chat.BatchMessage{Room: out.room, Message: out.text, InReplyTo: out.replyTo, Emote: out.emote, ClientID: out.id})
+	}
+	return s.call("SendMessages", args, &chat.BatchReply{})
+}
+
+// send sends a message
+func (s *session) send(out outgoing) error {
+	if out.to != "" {
+		args := &chat.DirectMessageArgs{Name: s.userName(), Token: s.sessionToken(), To: out.to, Message: out.text, ClientID: out.id}
+		return s.call("SendDirectMessage", args, &struct{}{})
+	}
+
+	// Passing our position keeps the server from echoing history we have
+	// already printed
+	lastIndex := 0
+	s.mu.Lock()
+	if feed, ok := s.feeds[out.room]; ok {
+		lastIndex = int(feed.lastIndex.Load())
+	}
+	s.mu.Unlock()
+	args := &chat.MessageArgs{
+		Name:      s.userName(),
+		Token:     s.sessionToken(),
+		Message:   out.text,
+		Room:      out.room,
+		LastIndex: lastIndex,
+		InReplyTo: out.replyTo,
+		Emote:     out.emote,
+		Timeout:   s.timeout,
+		ClientID:  out.id,
+	}
+	var reply chat.HistoryReply
+	return s.call("SendMessage", args, &reply)
+}
+
+// sendOrQueue sends a message now, or queues it to be sent once we have
+// reconnected, after any messages queued before it. The message gets a
+// ClientID first, so that if the connection broke after the server got
+// it, sending it again after reconnecting does not post it twice.
+func (s *session) sendOrQueue(out outgoing) error {
+	out.id = chat.NewClientID()
+	s.connMu.Lock()
+	waiting := len(s.queue) > 0
+	if waiting {
+		s.queue = append(s.queue, out)
+	}
+	s.connMu.Unlock()
+
+	if !waiting {
+		err := s.send(out)
+		if !errors.Is(err, errDisconnected) {
+			return err
+		}
+		s.connMu.Lock()
+		s.queue = append(s.queue, out)
+		s.connMu.Unlock()
+	}
+	fmt.Fprintln(s.out, s.pending(out))
+	return nil
+}
+
+// readLine returns the next line the user typed
+func (s *session) readLine() (string, error) {
+	if s.ui != nil {
+		return s.ui.readLine()
+	}
+	line, err := s.reader.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// fatal gives the terminal back before exiting with an error, which
+// log.Fatal alone would leave in the frontend's state
+func (s *session) fatal(v ...any) {
+	if s.ui != nil {
+		s.ui.stop()
+	}
+	log.Fatal(v...)
+}
+
+// notice prints a line from the client itself above a fresh prompt
+func (s *session) notice(text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.out, "\r%s\n%s", text, s.prompt)
+}
+
+// currentFeed returns the feed of the room messages are sent to
+func (s *session) currentFeed() *roomFeed {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.feeds[s.current]
+}
+
+// receiveMessages long-polls the server for new messages in the feed and
+// prints them as soon as they arrive, until the feed is stopped
+func (s *session) receiveMessages(feed *roomFeed) {
+	for !feed.stopped.Load() {
+		var reply chat.MessagesReply
+		var err error
+		lastIndex := int(feed.lastIndex.Load())
+		if feed.direct {
+			args := &chat.DirectSinceArgs{Name: s.userName(), Token: s.sessionToken(), LastIndex: lastIndex}
+			err = s.call("WaitForDirectMessages", args, &reply)
+		} else {
+			args := &chat.SinceArgs{Token: s.sessionToken(), Room: feed.room, LastIndex: lastIndex}
+			err = s.call("WaitForMessages", args, &reply)
+		}
+		if err != nil {
+			// Our session was closed because we are exiting
+			if s.closing.Load() {
+				return
+			}
+			// Carry on from the same position once we are back
+			if errors.Is(err, errDisconnected) {
+				s.waitConnected()
+				continue
+			}
+			s.fatal("RPC error:", err)
+		}
+		if feed.stopped.Load() {
+			return
+		}
+		// A lower position means the server lost its history, e.g. it
+		// restarted without persistence, so message IDs start over too
+		if reply.LastIndex < lastIndex {
+			s.mu.Lock()
+			feed.lastID = 0
+			s.mu.Unlock()
+		}
+		feed.lastIndex.Store(int64(reply.LastIndex))
+		if len(reply.Messages) > 0 {
+			s.printMessages(feed, reply.Messages)
+			s.markRead(feed, reply.Messages)
+		}
+		for _, msg := range reply.Messages {
+			if msg.Kind == chat.KindSystem {
+				s.refreshOnline()
+				break
+			}
+		}
+	}
+}
+
+// join joins a room, prints its history, follows it and makes it current.
+// key is the password of or an invite to a private room, if needed.
+func (s *session) join(room, key string) error {
+	args := &chat.RoomArgs{Name: s.userName(), Token: s.sessionToken(), Room: room, Password: key, Invite: key}
+	err := s.call("JoinRoom", args, &struct{}{})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	feed, ok := s.feeds[room]
+	s.current = room
+	s.mu.Unlock()
+	if ok {
+		fmt.Fprintf(s.out, "Switched to room %s\n", room)
+		s.mu.Lock()
+		lastID := feed.lastID
+		s.mu.Unlock()
+		s.markRoomRead(room, lastID)
+		return nil
+	}
+
+	var history chat.HistoryPage
+	err = s.call("GetHistory", &chat.HistoryArgs{Token: s.sessionToken(), Room: room, Limit: historyPageSize}, &history)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(s.out, "\n--- Chat History (%s) ---\n", room)
+	// Older servers have no topics and answer with an error
+	var topic chat.TopicReply
+	if s.call("GetTopic", &chat.RoomArgs{Room: room}, &topic) == nil && topic.Topic != "" {
+		fmt.Fprintf(s.out, "Topic: %s (set by %s)\n", topic.Topic, topic.SetBy)
+	}
+	if history.More {
+		fmt.Fprintln(s.out, "(type /more for older messages)")
+	}
+	s.loadReceipts(history.Messages)
+	s.mu.Lock()
+	s.printHistory(history.Messages, 0)
+	s.mu.Unlock()
+	fmt.Fprintln(s.out, "------------------")
+
+	feed = &roomFeed{room: room, more: history.More}
+	feed.lastIndex.Store(int64(history.LastIndex))
+	if n := len(history.Messages); n > 0 {
+		feed.oldestID = history.Messages[0].ID
+		feed.lastID = history.Messages[n-1].ID
+	}
+	s.mu.Lock()
+	s.feeds[room] = feed
+	s.mu.Unlock()
+	go s.receiveMessages(feed)
+	s.markRoomRead(room, feed.lastID)
+
+	return nil
+}
+
+// typed returns the outgoing message for text typed at the prompt, which
+// replies to the thread being viewed if there is one
+func (s *session) typed(text string) outgoing {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	feed := s.feeds[s.current]
+	return outgoing{room: feed.room, text: text, replyTo: feed.thread}
+}
+
+// more prints the page of history before the oldest message shown in the
+// current room
+func (s *session) more() error {
+	feed := s.currentFeed()
+	s.mu.Lock()
+	args := &chat.HistoryArgs{Token: s.sessionToken(), Room: feed.room, Before: feed.oldestID, Limit: historyPageSize}
+	more := feed.more
+	s.mu.Unlock()
+	if !more {
+		return errors.New("no older messages")
+	}
+
+	var history chat.HistoryPage
+	if err := s.call("GetHistory", args, &history); err != nil {
+		return err
+	}
+	s.loadReceipts(history.Messages)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.out, "--- Older messages (%s) ---\n", feed.room)
+	s.printHistory(history.Messages, feed.thread)
+	fmt.Fprintln(s.out, "------------------")
+	if len(history.Messages) > 0 {
+		feed.oldestID = history.Messages[0].ID
+	}
+	feed.more = history.More
+
+	return nil
+}
+
+// leave leaves the current room and falls back to the default room
+func (s *session) leave() error {
+	s.mu.Lock()
+	room := s.current
+	s.mu.Unlock()
+	if room == chat.DefaultRoom {
+		return fmt.Errorf("you cannot leave %s", chat.DefaultRoom)
+	}
+
+	err := s.call("LeaveRoom", &chat.RoomArgs{Name: s.userName(), Token: s.sessionToken(), Room: room}, &struct{}{})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.dropFeed(room)
+	s.mu.Unlock()
+	fmt.Fprintf(s.out, "Left room %s, back in %s\n", room, chat.DefaultRoom)
+
+	return nil
+}
+
+// dropFeed stops following a room, switching back to the default room if
+// it was the current one. The caller must hold s.mu.
+func (s *session) dropFeed(room string) {
+	if feed, ok := s.feeds[room]; ok {
+		feed.stopped.Store(true)
+		delete(s.feeds, room)
+	}
+	if s.current == room {
+		s.current = chat.DefaultRoom
+	}
+}
+
+// followDirectMessages starts receiving private messages sent after login
+func (s *session) followDirectMessages() error {
+	var reply chat.MessagesReply
+	err := s.call("GetDirectMessages", &chat.DirectSinceArgs{Name: s.userName(), Token: s.sessionToken()}, &reply)
+	if err != nil {
+		return err
+	}
+
+	feed := &roomFeed{direct: true}
+	feed.lastIndex.Store(int64(reply.LastIndex))
+	go s.receiveMessages(feed)
+
+	return nil
+}
+
+// sendHeartbeats keeps the user marked online until they exit. A failed
+// heartbeat is also how an idle client notices the connection is gone.
+func (s *session) sendHeartbeats() {
+	// Servers without Ping use the default timeout
+	interval := heartbeatInterval
+	var reply chat.PingReply
+	if s.call("Ping", &chat.UserArgs{Name: s.userName(), Token: s.sessionToken()}, &reply) == nil && reply.HeartbeatInterval() > 0 {
+		interval = min(interval, reply.HeartbeatInterval())
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if s.closing.Load() {
+			return
+		}
+		s.call("Heartbeat", &chat.UserArgs{Name: s.userName(), Token: s.sessionToken()}, &struct{}{})
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// tui is the full-screen terminal interface: a scrollable pane with
+// everything the session prints above a line to type in. What is typed
+// there is passed on to lines, one message at a time, which may span
+// several lines.
+type tui struct {
+	program *tea.Program
+	lines   <-chan string // closed once the program has exited
+	done    chan struct{} // closed once the program has exited
+	focus   atomic.Bool
+}
+
+// outputMsg carries text the session printed to the TUI
+type outputMsg string
+
+// passwordMsg asks the TUI to hide the next line typed, after prompt
+type passwordMsg string
+
+// promptMsg changes the prompt in front of the input line
+type promptMsg string
+
+// startTUI takes over the terminal until stop is called. Pressing Tab
+// calls complete with the line and the cursor's byte offset.
+func startTUI(complete func(line string, pos int) (string, int, bool)) *tui {
+	typed := make(chan string, 64)
+	ui := &tui{lines: typed, done: make(chan struct{})}
+	input := &focusInput{File: os.Stdin, focused: &ui.focus}
+	ui.program = tea.NewProgram(newTUIModel(typed, complete), tea.WithAltScreen(), tea.WithMouseCellMotion(), tea.WithInput(input))
+	go func() {
+		defer close(ui.done)
+		os.Stdout.WriteString(focusReportingOn)
+		ui.program.Run()
+		os.Stdout.WriteString(focusReportingOff)
+		// Unblock anything still waiting for input
+		close(typed)
+	}()
+	return ui
+}
+
+// Write shows p in the message pane. Carriage returns meant for the plain
+// interface are dropped since they would garble the screen, and bells are
+// rung on the terminal directly.
+func (ui *tui) Write(p []byte) (int, error) {
+	text := string(p)
+	if strings.Contains(text, "\a") {
+		os.Stdout.WriteString("\a")
+	}
+	text = strings.NewReplacer("\r", "", "\a", "").Replace(text)
+	ui.program.Send(outputMsg(text))
+	return len(p), nil
+}
+
+// focused reports whether the terminal has told us it has focus
+func (ui *tui) focused() bool {
+	return ui.focus.Load()
+}
+
+// readLine returns the next message typed, which may span several lines
+func (ui *tui) readLine() (string, error) {
+	line, ok := <-ui.lines
+	if !ok {
+		return "", io.EOF
+	}
+	return line, nil
+}
+
+// readPassword reads the next line typed without showing it
+func (ui *tui) readPassword(prompt string) (string, error) {
+	ui.program.Send(passwordMsg(prompt))
+	return ui.readLine()
+}
+
+// showName puts name in front of the input line
+func (ui *tui) showName(name string) {
+	ui.program.Send(promptMsg(name + "> "))
+}
+
+// stop gives the terminal back, waiting until it has been restored
+func (ui *tui) stop() {
+	ui.program.Quit()
+	<-ui.done
+}
+
+// tuiModel is the bubbletea model behind tui
+type tuiModel struct {
+	pane    viewport.Model
+	input   textinput.Model
+	content strings.Builder
+	typed   chan<- string // where lines typed are sent
+	prompt  string        // shown unless a password is being typed
+
+	complete func(line string, pos int) (string, int, bool)
+
+	// history holds the lines typed so far, oldest first, for Up and Down
+	// to bring back. back counts how far back the input line is, and
+	// pending is what was typed before going back.
+	history []string
+	back    int
+	pending string
+
+	// draft holds the lines of a message being written over several,
+	// ended with Alt-Enter or Ctrl-J, shown above the input line
+	draft []string
+
+	height int  // of the window
+	ready  bool // the pane has been sized to the window
+}
+
+func newTUIModel(typed chan<- string, complete func(line string, pos int) (string, int, bool)) *tuiModel {
+	input := textinput.New()
+	input.Prompt = "> "
+	input.CharLimit = 0
+	input.Focus()
+
+	// Only page keys scroll, so the arrow keys and letters keep editing
+	// the input line
+	pane := viewport.New(0, 0)
+	pane.KeyMap = viewport.KeyMap{
+		PageDown: key.NewBinding(key.WithKeys("pgdown")),
+		PageUp:   key.NewBinding(key.WithKeys("pgup")),
+	}
+
+	return &tuiModel{pane: pane, input: input, typed: typed, prompt: input.Prompt, complete: complete}
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.height = msg.Height
+		m.pane.Width = msg.Width
+		m.resize()
+		m.input.Width = msg.Width - len(m.prompt) - 1
+		m.ready = true
+		m.pane.SetContent(m.content.String())
+		m.pane.GotoBottom()
+		return m, nil
+
+	case outputMsg:
+		// Follow new output unless the user has scrolled back to read
+		follow := m.pane.AtBottom()
+		m.content.WriteString(string(msg))
+		m.pane.SetContent(strings.TrimSuffix(m.content.String(), "\n"))
+		if follow {
+			m.pane.GotoBottom()
+		}
+		return m, nil
+
+	case promptMsg:
+		m.prompt = string(msg)
+		m.input.Width = m.pane.Width - len(m.prompt) - 1
+		if m.input.EchoMode == textinput.EchoNormal {
+			m.input.Prompt = m.prompt
+		}
+		return m, nil
+
+	case passwordMsg:
+		m.input.Prompt = string(msg)
+		m.input.EchoMode = textinput.EchoPassword
+		return m, nil
+
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyCtrlC, tea.KeyCtrlD:
+			m.submit("exit")
+			return m, nil
+		case tea.KeyTab:
+			if m.input.EchoMode == textinput.EchoNormal {
+				// The input counts in runes, completion in bytes
+				line := m.input.Value()
+				pos := len(string([]rune(line)[:m.input.Position()]))
+				if line, pos, ok := m.complete(line, pos); ok {
+					m.input.SetValue(line)
+					m.input.SetCursor(utf8.RuneCountInString(line[:pos]))
+				}
+			}
+			return m, nil
+		case tea.KeyUp, tea.KeyDown:
+			m.browse(msg.Type == tea.KeyUp)
+			return m, nil
+		case tea.KeyCtrlJ:
+			m.newline()
+			return m, nil
+		case tea.KeyEsc:
+			// Throw away the message being written
+			if len(m.draft) > 0 {
+				m.draft = nil
+				m.input.Reset()
+				m.input.Prompt = m.prompt
+				m.resize()
+			}
+			return m, nil
+		case tea.KeyEnter:
+			if msg.Alt {
+				m.newline()
+				return m, nil
+			}
+			line := m.input.Value()
+			if len(m.draft) > 0 {
+				line = strings.Join(append(m.draft, line), "\n")
+				m.draft = nil
+				m.resize()
+			}
+			// Messages over several lines would not fit back on the input line
+			if m.input.EchoMode == textinput.EchoNormal && line != "" && !strings.Contains(line, "\n") &&
+				(len(m.history) == 0 || m.history[len(m.history)-1] != line) {
+				m.history = append(m.history, line)
+			}
+			m.back = 0
+			m.submit(line)
+			m.input.Reset()
+			m.input.Prompt = m.prompt
+			m.input.EchoMode = textinput.EchoNormal
+			m.pane.GotoBottom()
+			return m, nil
+		case tea.KeyPgUp, tea.KeyPgDown:
+			var cmd tea.Cmd
+			m.pane, cmd = m.pane.Update(msg)
+			return m, cmd
+		}
+
+	case tea.MouseMsg:
+		var cmd tea.Cmd
+		m.pane, cmd = m.pane.Update(msg)
+		return m, cmd
+	}
+
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	return m, cmd
+}
+
+// newline starts another line of the message being typed, keeping the one
+// before it in the draft
+func (m *tuiModel) newline() {
+	if m.input.EchoMode != textinput.EchoNormal {
+		return
+	}
+	m.draft = append(m.draft, m.input.Value())
+	m.input.Reset()
+	m.input.Prompt = strings.Repeat(" ", utf8.RuneCountInString(m.prompt))
+	m.back = 0
+	m.resize()
+}
+
+// resize fits the pane in what the window leaves above the draft and the
+// input line
+func (m *tuiModel) resize() {
+	follow := m.pane.AtBottom()
+	m.pane.Height = max(m.height-1-len(m.draft), 1)
+	if follow {
+		m.pane.GotoBottom()
+	}
+}
+
+// browse replaces the input line with the previous line typed, or the next
+// one if up is false
+func (m *tuiModel) browse(up bool) {
+	if m.input.EchoMode != textinput.EchoNormal {
+		return
+	}
+	switch {
+	case up && m.back < len(m.history):
+		if m.back == 0 {
+			m.pending = m.input.Value()
+		}
+		m.back++
+	case !up && m.back > 0:
+		m.back--
+	default:
+		return
+	}
+	if m.back == 0 {
+		m.input.SetValue(m.pending)
+	} else {
+		m.input.SetValue(m.history[len(m.history)-m.back])
+	}
+	m.input.CursorEnd()
+}
+
+// submit hands a line to whoever reads from the TUI. It does not wait for
+// them, so the screen keeps updating while a command runs.
+func (m *tuiModel) submit(line string) {
+	m.typed <- line
+}
+
+func (m *tuiModel) View() string {
+	if !m.ready {
+		return ""
+	}
+	var draft strings.Builder
+	for i, line := range m.draft {
+		prompt := m.prompt
+		if i > 0 {
+			prompt = strings.Repeat(" ", utf8.RuneCountInString(m.prompt))
+		}
+		draft.WriteString(prompt + line + "\n")
+	}
+	return m.pane.View() + "\n" + draft.String() + m.input.View()
+}
@@ -0,0 +1,70 @@
+// Command echobot is an example bot built with package chatbot. It joins
+// rooms and answers !echo with whatever follows it, and !ping with pong.
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chatbot"
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chatclient"
+)
+
+// fatal logs msg as an error and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// envOr returns the environment variable key, or fallback if it is unset
+func envOr(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+func main() {
+	serverAddr := flag.String("server", envOr("CHAT_SERVER", "localhost:"+chat.DefaultPort), "chat server address as host:port (env CHAT_SERVER)")
+	name := flag.String("name", "echobot", "name to log in as")
+	password := flag.String("password", os.Getenv("CHAT_PASSWORD"), "password of the bot's account, if it has one (env CHAT_PASSWORD)")
+	rooms := flag.String("rooms", chat.DefaultRoom, "comma-separated rooms to answer in")
+	codec := flag.String("codec", chat.CodecGob, "codec the server speaks: gob or json")
+	flag.Parse()
+
+	c, err := chatclient.Connect(*serverAddr, *name, &chatclient.Options{Password: *password, Codec: *codec})
+	if err != nil {
+		fatal("Could not log in", "server", *serverAddr, "name", *name, "err", err)
+	}
+	defer c.Close()
+
+	bot := chatbot.New(c)
+	bot.Command("!echo", "<text>", "say the text back")
+	bot.Command("!ping", "", "check that the bot is there")
+	invocations, err := bot.Run(strings.Split(*rooms, ",")...)
+	if err != nil {
+		fatal("Could not start", "err", err)
+	}
+	slog.Info("Answering commands", "name", *name, "rooms", *rooms)
+
+	for inv := range invocations {
+		var err error
+		switch inv.Command {
+		case "!echo":
+			if inv.Text == "" {
+				err = inv.Reply("Usage: !echo <text>")
+			} else {
+				err = inv.Reply(inv.Text)
+			}
+		case "!ping":
+			err = inv.Reply("pong")
+		}
+		if err != nil {
+			slog.Warn("Could not answer", "command", inv.Command, "from", inv.Message.Sender, "err", err)
+		}
+	}
+	fatal("Disconnected", "err", c.Err())
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfig applies the settings in a YAML config file to the flags not
+// given on the command line, so that flags override the file, after
+// setting them back to their defaults so that reloading it takes out
+// settings removed from the file. Settings are
+// named like the flags, and nested sections are joined to their settings
+// with dashes, so
+//
+//	tls:
+//	  cert: server.pem
+//
+// sets -tls-cert. Lists, such as admins, become comma-separated values.
+func loadConfig(path string, given map[string]bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var settings map[string]any
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return err
+	}
+	values := make(map[string]string)
+	if err := flatten("", settings, values); err != nil {
+		return err
+	}
+
+	flag.VisitAll(func(f *flag.Flag) {
+		if !given[f.Name] {
+			f.Value.Set(f.DefValue)
+		}
+	})
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if flag.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("unknown setting %q", name)
+		}
+		if given[name] {
+			continue
+		}
+		if err := flag.Set(name, values[name]); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %v", values[name], name, err)
+		}
+	}
+	return nil
+}
+
+// givenFlags returns the names of the flags given on the command line
+func givenFlags() map[string]bool {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	return given
+}
+
+// flatten adds the settings in a section of the config file to values as
+// flag values, by flag name
+func flatten(prefix string, settings map[string]any, values map[string]string) error {
+	for key, value := range settings {
+		name := key
+		if prefix != "" {
+			name = prefix + "-" + key
+		}
+		switch v := value.(type) {
+		case map[string]any:
+			if err := flatten(name, v, values); err != nil {
+				return err
+			}
+		case []any:
+			parts := make([]string, len(v))
+			for i, item := range v {
+				switch item.(type) {
+				case map[string]any, []any:
+					return fmt.Errorf("%s: lists may only hold plain values", name)
+				}
+				parts[i] = fmt.Sprint(item)
+			}
+			values[name] = strings.Join(parts, ",")
+		case nil:
+			values[name] = ""
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/rpc"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/ircbridge"
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/server"
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/webhookbridge"
+)
+
+// fatal logs msg at the error level and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// envOr returns the environment variable key, or fallback if it is unset
+func envOr(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// exportHistory writes everything in store to path for archiving
+func exportHistory(store server.MessageStore, path string) error {
+	messages, err := store.Load()
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := chat.WriteExport(f, chat.ExportFormat(path), messages); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	slog.Info("Exported history", "messages", len(messages), "path", path)
+	return nil
+}
+
+// translator returns the translator at url, or nil if url is empty
+func translator(url, key string) server.Translator {
+	if url == "" {
+		return nil
+	}
+	return &server.LibreTranslate{URL: url, Key: key}
+}
+
+// pairs parses a comma-separated list of key=value pairs
+func pairs(list string) (map[string]string, error) {
+	parsed := make(map[string]string)
+	for _, pair := range strings.Split(list, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("%q is not a key=value pair", pair)
+		}
+		parsed[key] = value
+	}
+	return parsed, nil
+}
+
+func main() {
+	configPath := flag.String("config", envOr("CHAT_CONFIG", ""), "YAML file of settings named like these flags, which flags on the command line override (env CHAT_CONFIG)")
+	addr := flag.String("addr", envOr("CHAT_ADDR", ""), "address to listen on as host:port, overrides -host and -port (env CHAT_ADDR)")
+	host := flag.String("host", envOr("CHAT_HOST", ""), "interface to listen on, empty for all (env CHAT_HOST)")
+	port := flag.String("port", envOr("CHAT_PORT", chat.DefaultPort), "port to listen on (env CHAT_PORT)")
+	listenUnix := flag.String("listen-unix", "", "Unix domain socket to also accept connections on, e.g. /run/chat.sock")
+	unixMode := flag.String("unix-mode", "0660", "permissions of the -listen-unix socket, which decide who may connect")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate to serve TLS with (requires -tls-key)")
+	tlsKey := flag.String("tls-key", "", "PEM private key for -tls-cert")
+	tlsClientCA := flag.String("tls-client-ca", "", "PEM bundle of CAs that sign client certificates; clients must then log in with a certificate whose common name is their chat name")
+	storeKind := flag.String("store", "file", "where chat history is persisted: memory, file or sqlite")
+	historyPath := flag.String("history-file", "chat_history.jsonl", "JSON Lines history file used by -store=file")
+	dbPath := flag.String("db", "chat.db", "SQLite database used by -store=sqlite")
+	motd := flag.String("motd", "", "message of the day shown to clients after connecting")
+	motdFile := flag.String("motd-file", "", "file to read the message of the day from instead of -motd, and to save changes to")
+	filterFile := flag.String("filter-file", "", "file of words, one per line, that messages may not contain (empty disables the filter)")
+	filterMode := flag.String("filter-mode", server.FilterMask, "what to do with words from -filter-file: mask them or reject the message")
+	accountsPath := flag.String("accounts-file", "accounts.json", "file registered accounts are saved to (empty keeps them in memory)")
+	roomsPath := flag.String("rooms-file", "rooms.json", "file room settings such as passwords and invites are saved to (empty keeps them in memory)")
+	reportsPath := flag.String("reports-file", "reports.json", "file reported messages waiting for a moderator are saved to (empty keeps them in memory)")
+	webhooksPath := flag.String("webhooks-file", "webhooks.json", "file the webhooks admins register are saved to (empty keeps them in memory)")
+	botsPath := flag.String("bots-file", "bots.json", "file the bots admins add, which post through the REST API's /hooks, are saved to (empty keeps them in memory)")
+	scheduledPath := flag.String("scheduled-file", "scheduled.json", "file messages scheduled for later are saved to (empty keeps them in memory)")
+	filesDir := flag.String("files-dir", "files", "directory files users share are saved in (empty keeps them in memory)")
+	maxFileSize := flag.Int64("max-file-size", chat.DefaultMaxFileSize, "largest file users may share, in bytes (negative turns file sharing off)")
+	maxImageSize := flag.Int64("max-image-size", chat.DefaultMaxImageSize, "largest image users may share for clients to show inline, in bytes")
+	previewHosts := flag.String("preview-hosts", "", "comma-separated sites, such as github.com, whose pages are fetched to preview links to them and their subdomains (empty turns previews off)")
+	assistantURL := flag.String("assistant-url", "", "OpenAI-compatible chat completions URL, e.g. https://api.openai.com/v1/chat/completions, for a bot that answers messages mentioning it (empty disables it)")
+	assistantKey := flag.String("assistant-key", os.Getenv("CHAT_ASSISTANT_KEY"), "API key for -assistant-url (env CHAT_ASSISTANT_KEY, which keeps it out of the process list)")
+	assistantModel := flag.String("assistant-model", "", "model the assistant asks for, e.g. gpt-4o-mini")
+	assistantName := flag.String("assistant-name", "assistant", "name the assistant answers to, as @name, and posts under")
+	assistantMaxTokens := flag.Int("assistant-max-tokens", 512, "most tokens in each of the assistant's answers")
+	assistantRate := flag.Float64("assistant-rate", 5, "questions each user may ask the assistant a minute")
+	translateURL := flag.String("translate-url", "", "LibreTranslate-compatible translate URL, e.g. https://libretranslate.com/translate, for /translate (empty disables it)")
+	translateKey := flag.String("translate-key", os.Getenv("CHAT_TRANSLATE_KEY"), "API key for -translate-url, if it needs one (env CHAT_TRANSLATE_KEY)")
+	ircServer := flag.String("irc-server", "", "IRC server as host:port, e.g. irc.libera.chat:6697, to bridge rooms to (empty disables the bridge)")
+	ircTLS := flag.Bool("irc-tls", false, "connect to -irc-server with TLS")
+	ircNick := flag.String("irc-nick", ircbridge.DefaultNick, "nick the IRC bridge uses")
+	ircPassword := flag.String("irc-password", os.Getenv("CHAT_IRC_PASSWORD"), "password of -irc-server, or on most networks of the bridge's nick (env CHAT_IRC_PASSWORD)")
+	ircChannels := flag.String("irc-channels", "", "comma-separated room=#channel pairs of the rooms to bridge to IRC, e.g. general=#mychat")
+	ircNicks := flag.String("irc-nicks", "", "comma-separated nick=name pairs naming IRC nicks in the chat; other nicks get -irc-suffix")
+	ircSuffix := flag.String("irc-suffix", ircbridge.DefaultSuffix, "added to IRC nicks to name them in the chat")
+	bridgeWebhooks := flag.String("bridge-webhooks", "", "comma-separated room=URL pairs of Slack or Discord incoming webhooks to mirror rooms to; the URLs are secret, so better set in the config file")
+	bridgeBots := flag.String("bridge-bots", "", "comma-separated room=bot pairs of the bots posting the Slack or Discord channels' messages to -bridge-webhooks rooms, whose messages are not mirrored back")
+	auditPath := flag.String("audit-file", "audit.jsonl", "file moderation actions and admin changes are logged to (empty keeps them in memory)")
+	admins := flag.String("admins", "", "comma-separated accounts that are admins (default: the first account registered is the owner)")
+	rateLimit := flag.Float64("rate", 5, "messages per second each client may send (0 for no limit)")
+	rateBurst := flag.Int("burst", 10, "messages a client may send at once before -rate applies")
+	botRate := flag.Float64("bot-rate", 1, "messages per second each bot may post through the REST API's /hooks (0 for no limit)")
+	botBurst := flag.Int("bot-burst", 5, "messages a bot may post at once before -bot-rate applies")
+	spamRepeats := flag.Int("spam-repeats", 3, "times in a row the same message may be sent within -spam-window before the sender is muted (0 for no limit)")
+	spamBurst := flag.Int("spam-burst", 15, "messages a client may send within -spam-window before being muted (0 for no limit)")
+	spamWindow := flag.Duration("spam-window", 10*time.Second, "period -spam-repeats and -spam-burst are counted over")
+	spamMute := flag.Duration("spam-mute", 30*time.Second, "how long the first automatic mute for spamming lasts; it doubles for repeat offenders")
+	maxLength := flag.Int("max-length", chat.DefaultMaxLength, "longest message accepted, in bytes")
+	historyLimit := flag.Int("history-limit", 1000, "messages kept in memory per room and per user's direct messages (0 for no limit)")
+	retentionAge := flag.Duration("retention-age", 0, "remove messages older than this from memory and the store (0 keeps them)")
+	retentionCount := flag.Int("retention-count", 0, "keep only this many of the newest messages of each room and private conversation, in memory and the store (0 for no limit)")
+	export := flag.String("export", "", "write the stored history to this file, as CSV if it ends in .csv and JSON otherwise, then exit")
+	importPath := flag.String("import", "", "history file written by -export to load into the server and its store at startup, e.g. to move to a new host")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 5*time.Second, "how long to wait for clients to disconnect when shutting down")
+	maxClients := flag.Int("max-clients", 0, "most connections served at once (0 for no limit)")
+	maxConnsPerIP := flag.Int("max-conns-per-ip", 0, "most connections served at once from one address (0 for no limit)")
+	presenceTimeout := flag.Duration("presence-timeout", 30*time.Second, "how long a client may go without a heartbeat before it is marked offline")
+	idleTimeout := flag.Duration("idle-timeout", 2*time.Minute, "close RPC connections that send nothing, not even heartbeats, for this long (0 for never)")
+	editWindow := flag.Duration("edit-window", 15*time.Minute, "how long after sending a message can be edited (0 for no limit)")
+	metricsAddr := flag.String("metrics-addr", "", "address to serve Prometheus metrics on at /metrics, and health and readiness probes at /healthz and /readyz, e.g. :9090 (empty disables it)")
+	allowLegacy := flag.Bool("allow-legacy", false, "accept messages from clients that do not log in, as long as their name is not in use")
+	grpcAddr := flag.String("grpc-addr", "", "address to also serve the chat over gRPC on, e.g. :50051 (empty disables it)")
+	webAddr := flag.String("web-addr", "", "address to serve the browser client and its WebSocket gateway on, e.g. :8080 (empty disables it)")
+	pushWorkers := flag.Int("push-workers", 8, "goroutines writing new messages to browsers and gRPC streams")
+	pushQueue := flag.Int("push-queue", 256, "messages a browser or gRPC stream may fall behind by before it is disconnected")
+	httpAddr := flag.String("http-addr", "", "address to also serve RPC over HTTP on, at "+rpc.DefaultRPCPath+" (empty disables it)")
+	restAddr := flag.String("rest-addr", "", "address to serve the JSON REST API on, e.g. :8081 (empty disables it)")
+	codec := flag.String("codec", chat.CodecGob, "codec connections are served with: gob, or json for JSON-RPC clients in other languages")
+	logLevel := flag.String("log-level", "info", "least severe log messages shown: debug, info, warn or error")
+	flag.Parse()
+
+	given := givenFlags()
+	if *configPath != "" {
+		if err := loadConfig(*configPath, given); err != nil {
+			fatal("Invalid -config", "path", *configPath, "err", err)
+		}
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		fatal("Invalid -log-level", "err", err)
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
+	// gRPC, browser and REST clients log in with a name, so they would
+	// bypass certificate names
+	if (*grpcAddr != "" || *webAddr != "" || *restAddr != "") && *tlsClientCA != "" {
+		fatal("-grpc-addr, -web-addr and -rest-addr cannot be combined with -tls-client-ca")
+	}
+
+	// Open the message store; New reloads the saved history from it
+	store, err := server.OpenStore(*storeKind, *historyPath, *dbPath)
+	if err != nil {
+		fatal("Store error", "err", err)
+	}
+	defer store.Close()
+
+	if *export != "" {
+		if err := exportHistory(store, *export); err != nil {
+			fatal("Export error", "err", err)
+		}
+		return
+	}
+
+	// settings gathers the server's settings from the flags, which Reload
+	// updates from the config file
+	var settings func() server.Config
+	settings = func() server.Config {
+		return server.Config{
+			Store:           store,
+			AccountsFile:    *accountsPath,
+			RoomsFile:       *roomsPath,
+			ReportsFile:     *reportsPath,
+			WebhooksFile:    *webhooksPath,
+			BotsFile:        *botsPath,
+			ScheduledFile:   *scheduledPath,
+			FilesDir:        *filesDir,
+			MaxFileSize:     *maxFileSize,
+			MaxImageSize:    *maxImageSize,
+			PreviewHosts:    strings.Split(*previewHosts, ","),
+			AuditFile:       *auditPath,
+			ImportFile:      *importPath,
+			MOTD:            *motd,
+			MOTDFile:        *motdFile,
+			FilterFile:      *filterFile,
+			FilterMode:      *filterMode,
+			Admins:          strings.Split(*admins, ","),
+			HistoryLimit:    *historyLimit,
+			RetentionAge:    *retentionAge,
+			RetentionCount:  *retentionCount,
+			RateLimit:       *rateLimit,
+			RateBurst:       *rateBurst,
+			BotRate:         *botRate,
+			BotBurst:        *botBurst,
+			SpamRepeats:     *spamRepeats,
+			SpamBurst:       *spamBurst,
+			SpamWindow:      *spamWindow,
+			SpamMute:        *spamMute,
+			MaxLength:       *maxLength,
+			EditWindow:      *editWindow,
+			AllowLegacy:     *allowLegacy,
+			Codec:           *codec,
+			MaxClients:      *maxClients,
+			MaxConnsPerIP:   *maxConnsPerIP,
+			PresenceTimeout: *presenceTimeout,
+			IdleTimeout:     *idleTimeout,
+			PushWorkers:     *pushWorkers,
+			PushQueue:       *pushQueue,
+
+			AssistantURL:       *assistantURL,
+			AssistantKey:       *assistantKey,
+			AssistantModel:     *assistantModel,
+			AssistantName:      *assistantName,
+			AssistantMaxTokens: *assistantMaxTokens,
+			AssistantRate:      *assistantRate,
+
+			Translator: translator(*translateURL, *translateKey),
+			Reload: func() (server.Config, error) {
+				if *configPath != "" {
+					if err := loadConfig(*configPath, given); err != nil {
+						return server.Config{}, err
+					}
+				}
+				return settings(), nil
+			},
+		}
+	}
+
+	// Create the chat server
+	srv, err := server.New(settings())
+	if err != nil {
+		fatal("Error starting the server", "err", err)
+	}
+
+	// Listen for incoming connections
+	if *addr == "" {
+		*addr = net.JoinHostPort(*host, *port)
+	}
+	listener, err := server.Listen(*addr, *tlsCert, *tlsKey, *tlsClientCA)
+	if err != nil {
+		fatal("Listen error", "err", err)
+	}
+
+	slog.Info("Chat server running", "addr", listener.Addr().String(), "store", *storeKind, "codec", *codec)
+
+	if *listenUnix != "" {
+		mode, err := strconv.ParseUint(*unixMode, 8, 32)
+		if err != nil {
+			fatal("Invalid -unix-mode", "err", err)
+		}
+		unixListener, err := server.ListenUnix(*listenUnix, os.FileMode(mode))
+		if err != nil {
+			fatal("Unix socket error", "err", err)
+		}
+		go func() {
+			if err := srv.Serve(unixListener); !errors.Is(err, server.ErrServerClosed) {
+				fatal("Serve error", "err", err)
+			}
+		}()
+		slog.Info("Listening on Unix socket", "path", *listenUnix, "mode", *unixMode)
+	}
+
+	if *grpcAddr != "" {
+		grpcListener, err := server.Listen(*grpcAddr, *tlsCert, *tlsKey, "")
+		if err != nil {
+			fatal("gRPC listen error", "err", err)
+		}
+		go func() {
+			if err := srv.ServeGRPC(grpcListener); !errors.Is(err, server.ErrServerClosed) {
+				fatal("gRPC serve error", "err", err)
+			}
+		}()
+		slog.Info("Serving gRPC", "addr", grpcListener.Addr().String())
+	}
+
+	if *webAddr != "" {
+		webListener, err := server.Listen(*webAddr, *tlsCert, *tlsKey, "")
+		if err != nil {
+			fatal("Web listen error", "err", err)
+		}
+		web := &http.Server{Handler: srv.WebHandler()}
+		go func() {
+			if err := web.Serve(webListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fatal("Web error", "err", err)
+			}
+		}()
+		defer web.Close()
+		slog.Info("Serving the web client", "addr", webListener.Addr().String())
+	}
+
+	if *httpAddr != "" {
+		httpListener, err := server.Listen(*httpAddr, *tlsCert, *tlsKey, *tlsClientCA)
+		if err != nil {
+			fatal("HTTP listen error", "err", err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle(rpc.DefaultRPCPath, srv.RPCHandler())
+		rpcHTTP := &http.Server{Handler: mux}
+		go func() {
+			if err := rpcHTTP.Serve(httpListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fatal("HTTP error", "err", err)
+			}
+		}()
+		defer rpcHTTP.Close()
+		slog.Info("Serving RPC over HTTP", "addr", httpListener.Addr().String(), "path", rpc.DefaultRPCPath)
+	}
+
+	if *restAddr != "" {
+		restListener, err := server.Listen(*restAddr, *tlsCert, *tlsKey, "")
+		if err != nil {
+			fatal("REST listen error", "err", err)
+		}
+		rest := &http.Server{Handler: srv.RESTHandler()}
+		go func() {
+			if err := rest.Serve(restListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fatal("REST error", "err", err)
+			}
+		}()
+		defer rest.Close()
+		slog.Info("Serving the REST API", "addr", restListener.Addr().String())
+	}
+
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", srv.MetricsHandler())
+		mux.Handle("/healthz", srv.HealthHandler())
+		mux.Handle("/readyz", srv.ReadyHandler())
+		metrics := &http.Server{Addr: *metricsAddr, Handler: mux}
+		go func() {
+			if err := metrics.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fatal("Metrics error", "err", err)
+			}
+		}()
+		defer metrics.Close()
+		slog.Info("Serving metrics", "url", *metricsAddr+"/metrics")
+	}
+
+	if *ircServer != "" {
+		channels, err := pairs(*ircChannels)
+		if err != nil {
+			fatal("Invalid -irc-channels", "err", err)
+		}
+		nicks, err := pairs(*ircNicks)
+		if err != nil {
+			fatal("Invalid -irc-nicks", "err", err)
+		}
+		bridge, err := ircbridge.New(srv, ircbridge.Config{
+			Server:   *ircServer,
+			TLS:      *ircTLS,
+			Nick:     *ircNick,
+			Password: *ircPassword,
+			Channels: channels,
+			Nicks:    nicks,
+			Suffix:   *ircSuffix,
+		})
+		if err != nil {
+			fatal("IRC bridge error", "err", err)
+		}
+		srv.AddHook(bridge)
+		go bridge.Run()
+		defer bridge.Close()
+		slog.Info("Bridging rooms to IRC", "server", *ircServer, "channels", *ircChannels)
+	}
+
+	if *bridgeWebhooks != "" {
+		webhooks, err := pairs(*bridgeWebhooks)
+		if err != nil {
+			fatal("Invalid -bridge-webhooks", "err", err)
+		}
+		bots, err := pairs(*bridgeBots)
+		if err != nil {
+			fatal("Invalid -bridge-bots", "err", err)
+		}
+		targets := make(map[string]webhookbridge.Target)
+		for room, url := range webhooks {
+			targets[room] = webhookbridge.Target{URL: url, Bot: bots[room]}
+		}
+		bridge, err := webhookbridge.New(webhookbridge.Config{Targets: targets})
+		if err != nil {
+			fatal("Webhook bridge error", "err", err)
+		}
+		srv.AddHook(bridge)
+		go bridge.Run()
+		defer bridge.Close()
+		slog.Info("Mirroring rooms to webhooks", "rooms", len(targets))
+	}
+
+	// Shut down on SIGINT or SIGTERM: disconnect everyone, then let the
+	// deferred Close flush the store
+	stopped := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	go func() {
+		for range reloads {
+			if err := srv.Reload(); err != nil {
+				slog.Error("Error reloading the settings, keeping the old ones", "err", err)
+			}
+		}
+	}()
+	go func() {
+		defer close(stopped)
+		sig := <-signals
+		slog.Info("Shutting down", "signal", sig.String())
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			slog.Warn("Gave up waiting for connections to close", "timeout", *shutdownTimeout)
+		}
+	}()
+
+	if err := srv.Serve(listener); !errors.Is(err, server.ErrServerClosed) {
+		fatal("Serve error", "err", err)
+	}
+	<-stopped
+	slog.Info("Chat server stopped")
+}
@@ -0,0 +1,310 @@
+package chat
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"strings"
+	"time"
+)
+
+// Client is a connection to a chat server. Its methods are safe to call
+// from several goroutines at once, as net/rpc multiplexes calls over the
+// connection. Errors returned by the server are rpc.ServerError values;
+// any other error means the connection is broken.
+type Client struct {
+	rpc      *rpc.Client
+	protocol int           // the protocol version agreed on with the server
+	timeout  time.Duration // how long calls wait for an answer, 0 for ever
+}
+
+// ErrTimeout is returned by calls the server does not answer within the
+// client's timeout. The connection may be stuck, so it is best closed.
+var ErrTimeout = errors.New("the server did not answer in time")
+
+// helloTimeout bounds how long the Dial functions wait for the server to
+// answer Hello, so that a server that accepts connections but is stuck
+// does not hang them
+const helloTimeout = 10 * time.Second
+
+// NewClientID returns a random version 4 UUID to send as the ClientID of a
+// message, so it can be sent again after a network error without being
+// posted twice
+func NewClientID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// Dial connects to the chat server at addr, over TLS if config is set. An
+// addr starting with UnixScheme connects to a Unix domain socket.
+func Dial(addr string, config *tls.Config) (*Client, error) {
+	return DialCodec(addr, CodecGob, config)
+}
+
+// DialCodec is Dial for a server started with the given codec
+func DialCodec(addr, codec string, config *tls.Config) (*Client, error) {
+	conn, err := dial(addr, codec, config)
+	if err != nil {
+		return nil, err
+	}
+	return hello(NewCodecClient(conn, codec))
+}
+
+// DialHTTP is DialCodec for a server that serves RPC over HTTP at path,
+// usually rpc.DefaultRPCPath
+func DialHTTP(addr, path, codec string, config *tls.Config) (*Client, error) {
+	conn, err := dial(addr, codec, config)
+	if err != nil {
+		return nil, err
+	}
+	io.WriteString(conn, "CONNECT "+path+" HTTP/1.0\n\n")
+
+	// Require a successful HTTP response before switching to RPC
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err == nil && resp.Status != HTTPConnected {
+		err = fmt.Errorf("unexpected HTTP response: %s", resp.Status)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return hello(NewCodecClient(conn, codec))
+}
+
+// hello agrees on a protocol version with the server for the Dial
+// functions, closing c if they cannot agree. Servers older than Hello
+// cannot answer, which means version 1.
+func hello(c *Client) (*Client, error) {
+	var reply HelloReply
+	err := c.call(helloTimeout, "Hello", &HelloArgs{Protocol: ProtocolVersion, MinProtocol: MinProtocolVersion}, &reply)
+	var serverErr rpc.ServerError
+	if errors.As(err, &serverErr) && strings.HasPrefix(string(serverErr), "rpc: can't find method") {
+		return c, nil
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.protocol = reply.Protocol
+	return c, nil
+}
+
+// dial opens the connection for DialCodec and DialHTTP
+func dial(addr, codec string, config *tls.Config) (net.Conn, error) {
+	if codec != CodecGob && codec != CodecJSON {
+		return nil, fmt.Errorf("unknown codec %q", codec)
+	}
+	network := "tcp"
+	if path, ok := strings.CutPrefix(addr, UnixScheme); ok {
+		network, addr = "unix", path
+	}
+	if config == nil {
+		return net.Dial(network, addr)
+	}
+	return tls.Dial(network, addr, config)
+}
+
+// NewClient returns a client that talks to the server over conn
+func NewClient(conn io.ReadWriteCloser) *Client {
+	return &Client{rpc: rpc.NewClient(conn), protocol: MinProtocolVersion}
+}
+
+// NewCodecClient is NewClient for a server started with the given codec,
+// which must be CodecGob or CodecJSON
+func NewCodecClient(conn io.ReadWriteCloser, codec string) *Client {
+	if codec == CodecJSON {
+		return &Client{rpc: jsonrpc.NewClient(conn), protocol: MinProtocolVersion}
+	}
+	return NewClient(conn)
+}
+
+// Protocol returns the protocol version agreed on with the server, which
+// the Dial functions do when connecting. Clients made with NewClient speak
+// version 1.
+func (c *Client) Protocol() int {
+	return c.protocol
+}
+
+// SetTimeout bounds how long each call waits for the server to answer,
+// after which it returns ErrTimeout; 0, the default, waits for ever.
+// Long-polling calls, those starting with "Wait", get WaitTimeout more, as
+// the server holds them back on purpose. Set it before making calls.
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
+// Call calls the server method with the given name, e.g. "SendMessage".
+// It covers the methods that have no typed wrapper below. If the call
+// times out, reply may still be filled in later and should be dropped.
+func (c *Client) Call(method string, args, reply any) error {
+	timeout := c.timeout
+	if timeout > 0 && strings.HasPrefix(method, "Wait") {
+		timeout += WaitTimeout
+	}
+	return c.call(timeout, method, args, reply)
+}
+
+// call makes a call that waits at most timeout for the answer, unless
+// timeout is 0
+func (c *Client) call(timeout time.Duration, method string, args, reply any) error {
+	if timeout <= 0 {
+		return c.rpc.Call(ServiceName+"."+method, args, reply)
+	}
+	call := c.rpc.Go(ServiceName+"."+method, args, reply, make(chan *rpc.Call, 1))
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-timer.C:
+		return ErrTimeout
+	}
+}
+
+// Close closes the connection, which also ends any session on it
+func (c *Client) Close() error {
+	return c.rpc.Close()
+}
+
+// Login claims name for an unregistered user and returns the session token
+// to pass to later calls
+func (c *Client) Login(name string) (string, error) {
+	var reply LoginReply
+	err := c.Call("Login", &UserArgs{Name: name}, &reply)
+	return reply.Token, err
+}
+
+// Authenticate logs in to a registered account and returns the session
+// token to pass to later calls
+func (c *Client) Authenticate(name, password string) (string, error) {
+	var reply LoginReply
+	err := c.Call("Authenticate", &AccountArgs{Name: name, Password: password}, &reply)
+	return reply.Token, err
+}
+
+// Logout ends the session
+func (c *Client) Logout(token string) error {
+	return c.Call("UnregisterUser", &UserArgs{Token: token}, &struct{}{})
+}
+
+// Heartbeat keeps the session online; it must be called more often than
+// the server's presence timeout, which Ping reports
+func (c *Client) Heartbeat(token string) error {
+	return c.Call("Heartbeat", &UserArgs{Token: token}, &struct{}{})
+}
+
+// Ping keeps the session online like Heartbeat and returns the server's
+// timeouts, which say how often to call it. An empty token just checks the server is answering.
+func (c *Client) Ping(token string) (PingReply, error) {
+	var reply PingReply
+	err := c.Call("Ping", &UserArgs{Token: token}, &reply)
+	return reply, err
+}
+
+// GetServerInfo returns the server's version and the features it supports
+func (c *Client) GetServerInfo() (*ServerInfoReply, error) {
+	var reply ServerInfoReply
+	if err := c.Call("GetServerInfo", &struct{}{}, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// SendMessage posts text to a room, an empty room meaning DefaultRoom
+func (c *Client) SendMessage(token, room, text string) error {
+	return c.Call("SendMessage", &MessageArgs{Token: token, Room: room, Message: text}, &HistoryReply{})
+}
+
+// SendMessages posts up to MaxBatch messages in one call, all of them or
+// none, and returns the IDs they were given
+func (c *Client) SendMessages(token string, messages []BatchMessage) ([]int64, error) {
+	var reply BatchReply
+	if err := c.Call("SendMessages", &BatchArgs{Token: token, Messages: messages}, &reply); err != nil {
+		return nil, err
+	}
+	return reply.IDs, nil
+}
+
+// SendDirectMessage sends text privately to another user
+func (c *Client) SendDirectMessage(token, to, text string) error {
+	return c.Call("SendDirectMessage", &DirectMessageArgs{Token: token, To: to, Message: text}, &struct{}{})
+}
+
+// ScheduleMessage holds text until at, then posts it to a room, and
+// returns the ID to cancel it with
+func (c *Client) ScheduleMessage(token, room, text string, at time.Time) (int64, error) {
+	var reply ScheduleReply
+	if err := c.Call("ScheduleMessage", &ScheduleArgs{Token: token, Room: room, Message: text, At: at}, &reply); err != nil {
+		return 0, err
+	}
+	return reply.ID, nil
+}
+
+// MarkRead tells the server the user has seen a room up to message id
+func (c *Client) MarkRead(token, room string, id int64) error {
+	return c.Call("MarkRead", &ReadArgs{Token: token, Room: room, ID: id}, &struct{}{})
+}
+
+// GetUnreadCounts returns how many unread messages each of the user's
+// rooms has, leaving out those with none
+func (c *Client) GetUnreadCounts(token string) (map[string]int, error) {
+	var reply UnreadReply
+	if err := c.Call("GetUnreadCounts", &UserArgs{Token: token}, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Rooms, nil
+}
+
+// GetReceipts returns who has received and read each of the user's own
+// messages with the given IDs
+func (c *Client) GetReceipts(token string, ids []int64) ([]Receipt, error) {
+	var reply ReceiptsReply
+	if err := c.Call("GetReceipts", &ReceiptArgs{Token: token, IDs: ids}, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Receipts, nil
+}
+
+// JoinRoom makes the user a member of a room so they can post to it
+func (c *Client) JoinRoom(token, room string) error {
+	return c.Call("JoinRoom", &RoomArgs{Token: token, Room: room}, &struct{}{})
+}
+
+// LeaveRoom gives up membership of a room
+func (c *Client) LeaveRoom(token, room string) error {
+	return c.Call("LeaveRoom", &RoomArgs{Token: token, Room: room}, &struct{}{})
+}
+
+// GetHistory returns up to limit of a room's newest messages before the
+// message with ID before, or the newest ones if before is 0
+func (c *Client) GetHistory(token, room string, before int64, limit int) (*HistoryPage, error) {
+	var reply HistoryPage
+	err := c.Call("GetHistory", &HistoryArgs{Token: token, Room: room, Before: before, Limit: limit}, &reply)
+	return &reply, err
+}
+
+// WaitForMessages blocks until a room has messages after position
+// lastIndex and returns them, or returns none after a while so that the
+// caller can simply call again
+func (c *Client) WaitForMessages(token, room string, lastIndex int) (*MessagesReply, error) {
+	var reply MessagesReply
+	err := c.Call("WaitForMessages", &SinceArgs{Token: token, Room: room, LastIndex: lastIndex}, &reply)
+	return &reply, err
+}
+
+// WaitForDirectMessages is WaitForMessages for the user's private messages
+func (c *Client) WaitForDirectMessages(token string, lastIndex int) (*MessagesReply, error) {
+	var reply MessagesReply
+	err := c.Call("WaitForDirectMessages", &DirectSinceArgs{Token: token, LastIndex: lastIndex}, &reply)
+	return &reply, err
+}
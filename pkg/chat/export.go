@@ -0,0 +1,193 @@
+package chat
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats history can be exported in
+const (
+	ExportJSON = "json"
+	ExportCSV  = "csv"
+)
+
+// exportColumns is the header row of a CSV export
+var exportColumns = []string{"id", "kind", "room", "sender", "to", "body", "time", "ref", "in_reply_to", "thread", "mentions", "edited", "deleted"}
+
+// kindNames names each MessageKind in CSV exports
+var kindNames = []string{"chat", "system", "edit", "delete", "react", "unreact", "emote", "topic"}
+
+// ExportFormat picks the export format for a file name from its extension:
+// CSV for .csv files and JSON for anything else
+func ExportFormat(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return ExportCSV
+	}
+	return ExportJSON
+}
+
+// WriteExport writes messages to w in format, either as a JSON array of
+// messages or as CSV with a header row
+func WriteExport(w io.Writer, format string, messages []Message) error {
+	switch format {
+	case ExportJSON, "":
+		if messages == nil {
+			messages = []Message{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(messages)
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		cw.Write(exportColumns)
+		for _, m := range messages {
+			kind := strconv.Itoa(int(m.Kind))
+			if int(m.Kind) < len(kindNames) {
+				kind = kindNames[m.Kind]
+			}
+			cw.Write([]string{
+				strconv.FormatInt(m.ID, 10),
+				kind,
+				m.Room,
+				m.Sender,
+				m.To,
+				m.Body,
+				exportTime(m.Timestamp),
+				exportID(m.Ref),
+				exportID(m.InReplyTo),
+				exportID(m.Thread),
+				strings.Join(m.Mentions, " "),
+				exportTime(m.Edited),
+				exportTime(m.Deleted),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown export format %q, use %s or %s", format, ExportJSON, ExportCSV)
+	}
+}
+
+// ReadExport reads messages written by WriteExport in format
+func ReadExport(r io.Reader, format string) ([]Message, error) {
+	switch format {
+	case ExportJSON, "":
+		var messages []Message
+		if err := json.NewDecoder(r).Decode(&messages); err != nil {
+			return nil, err
+		}
+		return messages, nil
+	case ExportCSV:
+		cr := csv.NewReader(r)
+		header, err := cr.Read()
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Equal(header, exportColumns) {
+			return nil, errors.New("not a chat history export: unexpected CSV header")
+		}
+		var messages []Message
+		for {
+			record, err := cr.Read()
+			if err == io.EOF {
+				return messages, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			m, err := parseExportRecord(record)
+			if err != nil {
+				line, _ := cr.FieldPos(0)
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			messages = append(messages, m)
+		}
+	default:
+		return nil, fmt.Errorf("unknown export format %q, use %s or %s", format, ExportJSON, ExportCSV)
+	}
+}
+
+// parseExportRecord parses one row of a CSV export
+func parseExportRecord(record []string) (Message, error) {
+	m := Message{Room: record[2], Sender: record[3], To: record[4], Body: record[5]}
+	kind := slices.Index(kindNames, record[1])
+	if kind < 0 {
+		return m, fmt.Errorf("unknown message kind %q", record[1])
+	}
+	m.Kind = MessageKind(kind)
+	if record[10] != "" {
+		m.Mentions = strings.Split(record[10], " ")
+	}
+
+	var err error
+	if m.ID, err = strconv.ParseInt(record[0], 10, 64); err != nil {
+		return m, fmt.Errorf("invalid message ID %q", record[0])
+	}
+	if m.Ref, err = parseExportID(record[7]); err != nil {
+		return m, err
+	}
+	if m.InReplyTo, err = parseExportID(record[8]); err != nil {
+		return m, err
+	}
+	if m.Thread, err = parseExportID(record[9]); err != nil {
+		return m, err
+	}
+	if m.Timestamp, err = parseExportTime(record[6]); err != nil {
+		return m, err
+	}
+	if m.Edited, err = parseExportTime(record[11]); err != nil {
+		return m, err
+	}
+	if m.Deleted, err = parseExportTime(record[12]); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+// exportTime formats t for a CSV export, empty if it is zero
+func exportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// exportID formats a message ID for a CSV export, empty if it is 0
+func exportID(id int64) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.FormatInt(id, 10)
+}
+
+// parseExportTime parses a time formatted by exportTime
+func parseExportTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return t, fmt.Errorf("invalid time %q", s)
+	}
+	return t, nil
+}
+
+// parseExportID parses a message ID formatted by exportID
+func parseExportID(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid message ID %q", s)
+	}
+	return id, nil
+}
@@ -0,0 +1,1146 @@
+// Package chat defines the protocol spoken between the chat server and its
+// clients: the RPC argument and reply types, messages, and the constants both
+// sides agree on. It also provides Client, a thin typed wrapper around the
+// RPC connection that other programs can use to talk to the server.
+package chat
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServiceName is the name the server registers its RPC methods under
+const ServiceName = "ChatServer"
+
+// DefaultPort is the port the server listens on unless told otherwise
+const DefaultPort = "1234"
+
+// DefaultRoom is the room used when a request does not name one. It always
+// exists and anyone may post to it, so clients that predate rooms keep working.
+const DefaultRoom = "general"
+
+// WaitTimeout is the longest WaitForMessages and the other long-polling
+// calls block before answering with no messages, after which clients call
+// again
+const WaitTimeout = 30 * time.Second
+
+// DefaultMaxLength is the default limit on the size of a message in bytes
+const DefaultMaxLength = 1024
+
+// DefaultMaxFileSize is the default limit on the size of a shared file in
+// bytes
+const DefaultMaxFileSize = 10 << 20
+
+// DefaultMaxImageSize is the default limit on the size of a shared image,
+// which clients may show inline, in bytes
+const DefaultMaxImageSize = 1 << 20
+
+// FileChunkSize is the most data UploadFileChunk takes and
+// DownloadFileChunk returns in one call
+const FileChunkSize = 256 << 10
+
+// UnixScheme prefixes the path of a Unix domain socket where a host:port
+// address is expected, e.g. unix:///run/chat.sock
+const UnixScheme = "unix://"
+
+// HTTPConnected is the status the server answers a CONNECT request for RPC
+// over HTTP with, the same as net/rpc's
+const HTTPConnected = "200 Connected to Go RPC"
+
+// ProtocolVersion is the newest version of the protocol this package
+// defines. It goes up when requests or replies change shape in ways older
+// peers cannot decode. Clients and servers agree on a version with Hello
+// when connecting:
+//
+//  1. The original protocol, spoken by clients that do not call Hello.
+//     History comes both as Messages and as strings for clients that
+//     predate Messages.
+//  2. Hello. History only comes as Messages.
+const ProtocolVersion = 2
+
+// MinProtocolVersion is the oldest protocol version this package still
+// speaks
+const MinProtocolVersion = 1
+
+// Features a server can list in GetServerInfo. Clients should only use
+// what a server lists, and may assume everything of an older server that
+// cannot answer GetServerInfo.
+const (
+	FeatureRooms          = "rooms"           // CreateRoom, JoinRoom and the like
+	FeaturePrivateRooms   = "private-rooms"   // rooms with passwords or invites
+	FeatureDirectMessages = "direct-messages" // SendDirectMessage
+	FeatureEdits          = "edits"           // EditMessage and DeleteMessage
+	FeatureReactions      = "reactions"       // ReactToMessage
+	FeatureThreads        = "threads"         // replies and GetThread
+	FeatureSearch         = "search"          // SearchHistory
+	FeatureStreaming      = "streaming"       // WaitForMessages long polling
+	FeatureBlocks         = "blocks"          // BlockUser and UnblockUser
+	FeatureReports        = "reports"         // ReportMessage and moderator review
+	FeatureExport         = "export"          // ExportHistory
+	FeatureWordFilter     = "word-filter"     // messages are checked for banned words
+	FeatureRetention      = "retention"       // old messages are pruned
+	FeatureGRPC           = "grpc"            // the chat is also served over gRPC
+	FeatureBatch          = "batch"           // SendMessages
+	FeatureReceipts       = "receipts"        // MarkRead and GetReceipts
+	FeatureUnread         = "unread"          // GetUnreadCounts
+	FeaturePins           = "pins"            // PinMessage, UnpinMessage and GetPins
+	FeatureScheduled      = "scheduled"       // ScheduleMessage, ListScheduled and CancelScheduled
+	FeatureReminders      = "reminders"       // SetReminder
+	FeaturePolls          = "polls"           // CreatePoll, Vote and ClosePoll
+	FeatureFiles          = "files"           // UploadFileChunk and DownloadFileChunk
+	FeatureImages         = "images"          // files that are images, shown inline
+	FeaturePreviews       = "previews"        // KindPreview events for links in messages
+	FeatureWebhooks       = "webhooks"        // AddWebhook, RemoveWebhook and ListWebhooks
+	FeatureBots           = "bots"            // AddBot, RemoveBot and ListBots, for POST /hooks on the REST API
+	FeatureAssistant      = "assistant"       // a bot answers questions mentioning @assistant
+	FeatureTranslate      = "translate"       // Translate
+)
+
+// Codecs the server can speak. Gob is the default and what Go clients use;
+// JSON is JSON-RPC 1.0 as implemented by net/rpc/jsonrpc, for clients in
+// other languages.
+const (
+	CodecGob  = "gob"
+	CodecJSON = "json"
+)
+
+// MessageArgs represents the arguments for sending a message
+type MessageArgs struct {
+	Name    string
+	Token   string // session token from Login
+	Message string
+	Room    string // empty means DefaultRoom
+
+	// LastIndex is the number of messages the client has already seen.
+	// SendMessage only returns history after it; older clients leave it
+	// at zero and keep receiving the full history.
+	LastIndex int
+
+	InReplyTo int64 // ID of the message this one replies to, 0 if none
+
+	// Emote sends Message as an action of the sender's, like "/me waves",
+	// which is shown as "* alice waves"
+	Emote bool
+
+	// Timeout, if set, is how long the server may take to get round to
+	// posting the message after receiving it. Past that it gives up with
+	// an error, as a client with a timeout as long will have given up
+	// waiting and may send the message again.
+	Timeout time.Duration
+
+	// ClientID, if set, identifies this message among those the sender
+	// sent lately, e.g. a UUID from NewClientID. The server posts a
+	// message only once however many times it is sent with the same
+	// ClientID, so a client that did not get an answer can simply send
+	// it again.
+	ClientID string
+}
+
+// DedupWindow is how long the server remembers the ClientIDs of messages,
+// and so how long a client may retry a send without posting it twice
+const DedupWindow = 10 * time.Minute
+
+// HistoryReply represents the response containing chat history in the
+// original "Name: Message" string format
+type HistoryReply struct {
+	History []string
+}
+
+// MaxBatch is the most messages a single SendMessages call may carry
+const MaxBatch = 100
+
+// BatchMessage is one of the messages sent together with SendMessages
+type BatchMessage struct {
+	Room      string // defaults to DefaultRoom
+	Message   string
+	InReplyTo int64 // as in MessageArgs
+	Emote     bool
+	ClientID  string // as in MessageArgs; the reply has the ID of the message first sent with it
+}
+
+// BatchArgs represents the arguments for SendMessages, which posts up to
+// MaxBatch messages in one call, all of them or none
+type BatchArgs struct {
+	Name     string
+	Token    string
+	Messages []BatchMessage
+	Timeout  time.Duration // as in MessageArgs
+}
+
+// BatchReply holds the IDs given to the messages of a SendMessages call,
+// in the order they were sent
+type BatchReply struct {
+	IDs []int64
+}
+
+// HistoryArgs represents the arguments for fetching a page of history.
+// Older clients send no arguments and get the whole default room.
+type HistoryArgs struct {
+	Name   string
+	Token  string // identifies the caller, needed for private rooms only
+	Room   string // empty means DefaultRoom
+	Before int64  // only messages with lower IDs, 0 for the newest
+	Limit  int    // at most this many messages, 0 for no limit
+}
+
+// HistoryPage represents the response to GetHistory. History holds the
+// page in the original string format for older clients.
+type HistoryPage struct {
+	History   []string
+	Messages  []Message
+	More      bool // older messages are available before this page
+	LastIndex int  // position to wait for new messages from
+}
+
+// MessageKind distinguishes regular chat from other kinds of messages
+type MessageKind int
+
+const (
+	KindChat      MessageKind = iota // written by a user
+	KindSystem                       // generated by the server, e.g. join/leave
+	KindEdit                         // replaces the body of message Ref
+	KindDelete                       // replaces message Ref with a tombstone
+	KindReact                        // adds the reaction in Body to message Ref
+	KindUnreact                      // removes the reaction in Body from message Ref
+	KindEmote                        // written by a user, an action like "/me waves"
+	KindTopic                        // sets the topic of Room to Body, empty to clear it
+	KindPin                          // pins message Ref to its room
+	KindUnpin                        // unpins message Ref
+	KindPoll                         // a poll, see NewPoll for its Body
+	KindVote                         // votes for option Body, counting from 1, in poll Ref
+	KindClosePoll                    // ends the voting in poll Ref
+	KindFile                         // a shared file, see NewFile for its Body
+	KindPreview                      // attaches the link preview in Body, see NewPreview, to message Ref
+)
+
+// Message represents a single chat message. Changes to earlier messages,
+// such as edits, are messages of their own that refer to the message they
+// change, so they reach clients and the store like any other message.
+type Message struct {
+	ID        int64 // unique and increasing across the whole server
+	Kind      MessageKind
+	Room      string
+	Sender    string // empty for system messages
+	To        string // recipient of a direct message, empty otherwise
+	Body      string
+	Timestamp time.Time
+	Ref       int64     // message changed by an edit, deletion or reaction
+	InReplyTo int64     // message this one replies to, 0 if none
+	Thread    int64     // first message of the reply chain, 0 if not a reply
+	Mentions  []string  // users mentioned with @name in Body
+	ClientID  string    // the sender's MessageArgs.ClientID, if any; not shown to others
+	Edited    time.Time // when the message was last edited, zero if never
+	Deleted   time.Time // when the message was deleted, zero if it was not
+	Pinned    time.Time // when the message was pinned, zero if it is not
+
+	// Poll holds the options and votes of a KindPoll message. Like
+	// Reactions it is replaced rather than modified.
+	Poll *Poll
+
+	File *File // the file a KindFile message shares
+
+	// Preview describes the first link in the message, once the server
+	// has fetched it. It is nil until then, and for messages without
+	// links.
+	Preview *Preview
+
+	// Reactions lists the users who reacted to the message, by reaction.
+	// It is replaced rather than modified, so replies can share it.
+	Reactions map[string][]string
+}
+
+// IsChat reports whether the message was written by a user, as regular
+// chat, an emote, a poll or a shared file
+func (m Message) IsChat() bool {
+	return m.Kind == KindChat || m.Kind == KindEmote || m.Kind == KindPoll || m.Kind == KindFile
+}
+
+// IsEvent reports whether the message changes an earlier message, such as
+// an edit or a reaction, rather than standing on its own
+func (m Message) IsEvent() bool {
+	return m.Ref != 0
+}
+
+// String formats the message the way the original protocol did
+func (m Message) String() string {
+	if m.Kind == KindSystem {
+		return "*** " + m.Body
+	}
+	if m.Kind == KindEdit {
+		return m.Sender + " (edited): " + m.Body
+	}
+	if m.Kind == KindDelete {
+		return "*** " + m.Sender + " deleted a message"
+	}
+	if m.Kind == KindReact {
+		return "*** " + m.Sender + " reacted " + m.Body + " to a message"
+	}
+	if m.Kind == KindUnreact {
+		return "*** " + m.Sender + " removed a " + m.Body + " reaction"
+	}
+	if m.Kind == KindPin {
+		return "*** " + m.Sender + " pinned a message"
+	}
+	if m.Kind == KindUnpin {
+		return "*** " + m.Sender + " unpinned a message"
+	}
+	if m.Kind == KindVote {
+		return "*** " + m.Sender + " voted in a poll"
+	}
+	if m.Kind == KindClosePoll {
+		return "*** " + m.Sender + " closed a poll"
+	}
+	if m.Kind == KindPreview {
+		return "*** Link preview: " + NewPreview(m.Body).Title
+	}
+	if m.Kind == KindTopic && m.Body == "" {
+		return "*** " + m.Sender + " cleared the topic"
+	}
+	if m.Kind == KindTopic {
+		return "*** " + m.Sender + " set the topic: " + m.Body
+	}
+	if !m.Deleted.IsZero() {
+		return m.Sender + ": (message deleted)"
+	}
+	if m.To != "" {
+		return "[DM] " + m.Sender + " -> " + m.To + ": " + m.Body
+	}
+	if m.Kind == KindEmote {
+		return "* " + m.Sender + " " + m.Body
+	}
+	if m.Kind == KindPoll {
+		return "*** " + m.Sender + " started a poll: " + NewPoll(m.Body).Question
+	}
+	if m.Kind == KindFile {
+		f := NewFile(m.Body)
+		if f.Caption != "" {
+			return "*** " + m.Sender + " shared a file: " + f.Name + ": " + f.Caption
+		}
+		return "*** " + m.Sender + " shared a file: " + f.Name
+	}
+	return m.Sender + ": " + m.Body
+}
+
+// File describes a file shared with a KindFile message
+type File struct {
+	Name    string // the file's base name
+	Size    int64  // in bytes
+	SHA256  string // hex-encoded hash of the contents
+	Caption string // text sent along with the file, if any
+}
+
+// NewFile returns the file the body of a KindFile message describes: its
+// hash, size and name, separated by spaces, and on the next line its
+// caption if it has one
+func NewFile(body string) *File {
+	f := &File{}
+	line, caption, _ := strings.Cut(body, "\n")
+	sum, rest, _ := strings.Cut(line, " ")
+	size, name, _ := strings.Cut(rest, " ")
+	f.SHA256, f.Name, f.Caption = sum, name, caption
+	f.Size, _ = strconv.ParseInt(size, 10, 64)
+	return f
+}
+
+// Body returns the body of a KindFile message sharing f
+func (f *File) Body() string {
+	body := fmt.Sprintf("%s %d %s", f.SHA256, f.Size, f.Name)
+	if f.Caption != "" {
+		body += "\n" + f.Caption
+	}
+	return body
+}
+
+// IsImage reports whether the file is an image that clients may show
+// inline, going by its name. Servers only accept such files if they are
+// images in that format, no larger than their image limit.
+func (f *File) IsImage() bool {
+	return ImageType(f.Name) != ""
+}
+
+// ImageType returns the MIME type of an image clients may show inline,
+// PNG, JPEG or GIF, going by the extension of its file name, or "" if
+// the name is not one of those
+func ImageType(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".png":
+		return "image/png"
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".gif":
+		return "image/gif"
+	}
+	return ""
+}
+
+// Preview describes a web page linked to in a message
+type Preview struct {
+	URL         string
+	Title       string
+	Description string // a snippet of the page, if it has one
+}
+
+// NewPreview returns the preview the body of a KindPreview message
+// describes: the URL, the title and the description, one per line
+func NewPreview(body string) *Preview {
+	url, rest, _ := strings.Cut(body, "\n")
+	title, description, _ := strings.Cut(rest, "\n")
+	return &Preview{URL: url, Title: title, Description: description}
+}
+
+// Body returns the body of a KindPreview message attaching p
+func (p *Preview) Body() string {
+	return p.URL + "\n" + p.Title + "\n" + p.Description
+}
+
+// Limits on polls
+const (
+	MinPollOptions = 2
+	MaxPollOptions = 10
+)
+
+// Poll is the state of a KindPoll message
+type Poll struct {
+	Question string
+	Options  []string
+	Votes    map[string]int // the option each user voted for, counting from 1
+	Closed   time.Time      // when voting ended, zero while it is open
+}
+
+// NewPoll returns the poll, without votes, that the body of a KindPoll
+// message describes: the question on its first line, then one option on
+// each line after it
+func NewPoll(body string) *Poll {
+	question, options, _ := strings.Cut(body, "\n")
+	p := &Poll{Question: question}
+	if options != "" {
+		p.Options = strings.Split(options, "\n")
+	}
+	return p
+}
+
+// PollBody returns the body of a KindPoll message asking question
+func PollBody(question string, options []string) string {
+	return strings.Join(append([]string{question}, options...), "\n")
+}
+
+// Apply returns a copy of the poll with a KindVote or KindClosePoll event
+// that refers to it applied. Votes that do not name an option, and
+// events for a nil poll, change nothing.
+func (p *Poll) Apply(event Message) *Poll {
+	if p == nil {
+		return nil
+	}
+	updated := *p
+	switch event.Kind {
+	case KindVote:
+		option, err := strconv.Atoi(event.Body)
+		if err != nil || option < 1 || option > len(p.Options) {
+			return p
+		}
+		updated.Votes = make(map[string]int, len(p.Votes)+1)
+		for name, o := range p.Votes {
+			updated.Votes[name] = o
+		}
+		updated.Votes[event.Sender] = option
+	case KindClosePoll:
+		updated.Closed = event.Timestamp
+	}
+	return &updated
+}
+
+// Tally returns how many votes each option has, in the order of Options
+func (p *Poll) Tally() []int {
+	counts := make([]int, len(p.Options))
+	for _, option := range p.Votes {
+		if option >= 1 && option <= len(counts) {
+			counts[option-1]++
+		}
+	}
+	return counts
+}
+
+// SinceArgs represents the arguments for fetching messages after a position
+type SinceArgs struct {
+	Name      string
+	Token     string // identifies the caller, needed for private rooms only
+	Room      string
+	LastIndex int // number of messages the client has already seen
+}
+
+// MessagesReply represents the response containing new messages only
+type MessagesReply struct {
+	Messages  []Message
+	LastIndex int // position to pass on the next call
+}
+
+// ThreadArgs represents the arguments for fetching a thread
+type ThreadArgs struct {
+	Name  string
+	Token string // identifies the caller, needed for private rooms only
+	Room  string // empty means DefaultRoom
+	ID    int64  // any message in the thread
+}
+
+// ThreadReply represents the response to GetThread
+type ThreadReply struct {
+	Thread   int64     // ID of the message that started the thread
+	Messages []Message // the thread's messages still kept, oldest first
+}
+
+// MentionsArgs represents the arguments for fetching the messages that
+// mention the caller
+type MentionsArgs struct {
+	Name  string
+	Token string
+	Limit int // at most this many of the newest mentions, 0 for no limit
+}
+
+// MentionsReply represents the response to GetMentions
+type MentionsReply struct {
+	Messages []Message // oldest first
+}
+
+// SearchArgs represents the arguments for searching the history
+type SearchArgs struct {
+	Name   string
+	Token  string
+	Query  string    // words that must all appear, ignoring case
+	Sender string    // only messages from this user, empty for anyone
+	After  time.Time // only messages sent after this time, zero for any
+	Before time.Time // only messages sent before this time, zero for any
+	Limit  int       // at most this many of the newest matches, 0 for no limit
+}
+
+// SearchReply represents the response to SearchHistory
+type SearchReply struct {
+	Messages []Message // oldest first
+}
+
+// StatsReply represents the response to GetStats
+type StatsReply struct {
+	Messages int            // chat messages ever posted
+	PerUser  map[string]int // the same by sender
+	Clients  int            // open connections
+	Online   int            // logged in users
+	Rooms    int
+	Uptime   time.Duration
+	MemAlloc uint64 // bytes of heap in use
+	MemSys   uint64 // bytes obtained from the operating system
+}
+
+// RoomArgs represents the arguments for creating, joining or leaving a room.
+// A room created with a Password or InviteOnly is private: joining it takes
+// the password or an invite from GenerateInvite.
+type RoomArgs struct {
+	Name       string
+	Token      string
+	Room       string
+	Password   string // join password, set by CreateRoom and given to JoinRoom
+	Invite     string // invite for JoinRoom
+	InviteOnly bool   // for CreateRoom, only let in users with an invite
+}
+
+// RoomInfo describes a single room in a ListRooms reply
+type RoomInfo struct {
+	Name     string
+	Members  int
+	Messages int
+	Topic    string
+	Private  bool // joining takes a password or an invite
+}
+
+// InviteArgs represents the arguments for generating or revoking an invite
+// to a private room
+type InviteArgs struct {
+	Name   string
+	Token  string
+	Room   string
+	Invite string // the invite to revoke
+}
+
+// InviteReply represents the response to GenerateInvite
+type InviteReply struct {
+	Invite string
+}
+
+// TopicArgs represents the arguments for setting a room's topic
+type TopicArgs struct {
+	Name  string
+	Token string
+	Room  string
+	Topic string // empty to clear it
+}
+
+// TopicReply represents the response to GetTopic
+type TopicReply struct {
+	Topic string    // empty if the room has none
+	SetBy string    // who set it
+	SetAt time.Time // when it was set
+}
+
+// RoomsReply represents the response containing all rooms
+type RoomsReply struct {
+	Rooms []RoomInfo
+}
+
+// DirectMessageArgs represents the arguments for sending a private message
+type DirectMessageArgs struct {
+	Name     string
+	Token    string
+	To       string
+	Message  string
+	ClientID string // as in MessageArgs
+}
+
+// ReadArgs represents the arguments for MarkRead, which marks a room or
+// the private messages with another user as read up to a message
+type ReadArgs struct {
+	Name  string
+	Token string
+	Room  string // empty means DefaultRoom
+	With  string // the other user of a private conversation, instead of Room
+	ID    int64  // the newest message read
+}
+
+// ReceiptArgs represents the arguments for GetReceipts
+type ReceiptArgs struct {
+	Name  string
+	Token string
+	IDs   []int64 // messages the caller sent
+}
+
+// Receipt lists who a message has reached. A user counts once their
+// client has fetched the message, and has read it once the client marked
+// it read with MarkRead.
+type Receipt struct {
+	ID        int64
+	Delivered []string // sorted by name, including those who read it
+	Read      []string // sorted by name
+}
+
+// ReceiptsReply represents the response to GetReceipts
+type ReceiptsReply struct {
+	Receipts []Receipt // in the order of ReceiptArgs.IDs
+}
+
+// UnreadReply represents the response to GetUnreadCounts
+type UnreadReply struct {
+	// Rooms maps each room the caller has joined, and DefaultRoom, to the
+	// number of messages from others posted after the last one they marked
+	// read. Rooms without unread messages are left out.
+	Rooms map[string]int
+}
+
+// DirectSinceArgs represents the arguments for fetching a user's private
+// messages after a position
+type DirectSinceArgs struct {
+	Name      string
+	Token     string
+	LastIndex int
+}
+
+// UserArgs represents the arguments for login and presence calls
+type UserArgs struct {
+	Name  string
+	Token string // session token, for calls made after Login
+}
+
+// RenameArgs represents the arguments for changing the caller's name
+type RenameArgs struct {
+	Name    string
+	Token   string
+	NewName string
+}
+
+// AccountArgs represents the arguments for registering or authenticating
+// an account
+type AccountArgs struct {
+	Name     string
+	Password string
+}
+
+// LoginReply represents the response to a successful login
+type LoginReply struct {
+	Token string
+}
+
+// ModerationArgs represents the arguments for kicking, banning, muting and
+// lifting those. Target is a user name, or an IP address for BanUser and
+// UnbanUser.
+type ModerationArgs struct {
+	Name   string
+	Token  string
+	Target string
+	Shadow bool   // MuteUser only: hide messages without telling the user
+	Reason string // optional, kept in the audit log
+}
+
+// ReportArgs represents the arguments for reporting a message
+type ReportArgs struct {
+	Name   string
+	Token  string
+	ID     int64 // the message reported
+	Reason string
+}
+
+// ReportReply represents the response to ReportMessage
+type ReportReply struct {
+	ID int64 // the new report
+}
+
+// Report is a message a user flagged for moderators to look at
+type Report struct {
+	ID       int64
+	Message  Message // as it was when reported
+	Reporter string
+	Reason   string
+	Time     time.Time
+}
+
+// ReportsReply represents the response to GetReports
+type ReportsReply struct {
+	Reports []Report // oldest first
+}
+
+// ResolveArgs represents the arguments for resolving a report
+type ResolveArgs struct {
+	Name   string
+	Token  string
+	Report int64
+	Delete bool   // delete the message rather than dismiss the report
+	Reason string // optional, kept in the audit log
+}
+
+// AuditEntry is a moderation action or admin change in the audit log
+type AuditEntry struct {
+	Time   time.Time
+	Actor  string // who did it, empty for the server itself
+	Action string // e.g. "kick", "ban", "mute" or "set-role"
+	Target string // the user or address acted on, if any
+	Detail string // what changed, e.g. the new role
+	Reason string
+}
+
+// AuditArgs represents the arguments for reading the audit log
+type AuditArgs struct {
+	Name  string
+	Token string
+	User  string // only entries with this actor or target, empty for all
+	Limit int    // at most this many of the newest entries, 0 for all
+}
+
+// AuditReply represents the response to GetAuditLog
+type AuditReply struct {
+	Entries []AuditEntry // oldest first
+}
+
+// FilterReply represents the response to ReloadFilter
+type FilterReply struct {
+	Words int // number of banned words now in the filter
+}
+
+// PruneReply represents the response to PruneHistory
+type PruneReply struct {
+	Removed int // number of messages that had expired
+}
+
+// PurgeReply represents the response to PurgeUser
+type PurgeReply struct {
+	Removed int // number of the user's messages removed
+}
+
+// ExportArgs represents the arguments for ExportHistory
+type ExportArgs struct {
+	Name   string
+	Token  string
+	Format string // ExportJSON or ExportCSV, empty for JSON
+}
+
+// ExportReply represents the response to ExportHistory
+type ExportReply struct {
+	Data string // the whole history in the format asked for
+}
+
+// BlockArgs represents the arguments for blocking or unblocking a user
+type BlockArgs struct {
+	Name   string
+	Token  string
+	Target string
+}
+
+// EditArgs represents the arguments for editing a message
+type EditArgs struct {
+	Name    string
+	Token   string
+	ID      int64
+	Message string // new body
+}
+
+// DeleteArgs represents the arguments for deleting a message
+type DeleteArgs struct {
+	Name   string
+	Token  string
+	ID     int64
+	Reason string // optional, kept in the audit log when deleting others' messages
+}
+
+// ReactionArgs represents the arguments for adding or removing a reaction
+type ReactionArgs struct {
+	Name     string
+	Token    string
+	ID       int64
+	Reaction string // usually a single emoji
+}
+
+// PinArgs represents the arguments for pinning or unpinning a message
+type PinArgs struct {
+	Name  string
+	Token string
+	ID    int64
+}
+
+// PinsReply represents the response to GetPins
+type PinsReply struct {
+	Messages []Message // the pinned messages still kept, oldest first
+}
+
+// TranslateArgs represents the arguments for translating a message
+type TranslateArgs struct {
+	Name  string
+	Token string
+	ID    int64  // of the message to translate
+	Lang  string // code of the language to translate it into, e.g. "fr"
+}
+
+// TranslateReply represents the response to Translate
+type TranslateReply struct {
+	ID   int64  // of the reply the translation was posted as
+	Text string // the reply's text
+}
+
+// PollArgs represents the arguments for starting a poll
+type PollArgs struct {
+	Name     string
+	Token    string
+	Room     string // empty means DefaultRoom
+	Question string
+	Options  []string // MinPollOptions to MaxPollOptions of them
+}
+
+// PollReply represents the response to CreatePoll
+type PollReply struct {
+	ID int64 // the poll's message
+}
+
+// VoteArgs represents the arguments for voting in a poll and closing it
+type VoteArgs struct {
+	Name   string
+	Token  string
+	ID     int64 // the poll's message
+	Option int   // counting from 1, unused by ClosePoll
+}
+
+// UploadArgs represents the arguments for uploading part of a file. The
+// first call of an upload leaves Upload empty and describes the file; the
+// rest give the Upload ID it returned. The file is shared in Room once all
+// of it has arrived.
+type UploadArgs struct {
+	Name   string
+	Token  string
+	Upload string // from the first call's reply, empty to start an upload
+	Offset int64  // where Data goes in the file, which must be where the last chunk ended
+	Data   []byte // at most FileChunkSize bytes
+
+	// The first call only
+	Room     string // empty means DefaultRoom
+	FileName string
+	Size     int64
+	SHA256   string // hex-encoded, checked once the whole file has arrived
+	Caption  string // optional text to send along with the file
+}
+
+// UploadReply represents the response to UploadFileChunk
+type UploadReply struct {
+	Upload string // the ID to send the rest of the file with
+	ID     int64  // the message sharing the file, once the last chunk arrived
+}
+
+// DownloadArgs represents the arguments for downloading part of a shared
+// file
+type DownloadArgs struct {
+	Name   string
+	Token  string
+	ID     int64 // the message sharing the file
+	Offset int64
+}
+
+// DownloadReply represents the response to DownloadFileChunk
+type DownloadReply struct {
+	File File
+	Data []byte // up to FileChunkSize bytes from Offset, empty at the end
+}
+
+// ScheduleArgs represents the arguments for scheduling a message
+type ScheduleArgs struct {
+	Name    string
+	Token   string
+	Room    string // empty means DefaultRoom
+	Message string
+	At      time.Time // when to post it
+}
+
+// ScheduleReply represents the response to ScheduleMessage and
+// SetReminder
+type ScheduleReply struct {
+	ID int64 // the scheduled message, for CancelScheduled
+}
+
+// ReminderArgs represents the arguments for setting a reminder
+type ReminderArgs struct {
+	Name    string
+	Token   string
+	Message string
+	At      time.Time // when to send it
+}
+
+// ScheduledMessage is a message the server holds until it is time to post
+// it to a room, or a reminder it sends back to its sender then
+type ScheduledMessage struct {
+	ID       int64
+	Sender   string
+	Room     string // empty for reminders
+	Body     string
+	At       time.Time // when it is sent
+	Created  time.Time // when it was scheduled
+	Reminder bool      // sent privately to Sender rather than posted to Room
+}
+
+// ScheduledReply represents the response to ListScheduled
+type ScheduledReply struct {
+	Messages []ScheduledMessage // soonest first
+}
+
+// CancelScheduledArgs represents the arguments for cancelling a scheduled
+// message or reminder
+type CancelScheduledArgs struct {
+	Name  string
+	Token string
+	ID    int64
+}
+
+// WebhookArgs represents the arguments for registering a webhook, or for
+// removing one by ID
+type WebhookArgs struct {
+	Name   string
+	Token  string
+	URL    string // AddWebhook only: where messages are posted, http or https
+	Room   string // AddWebhook only: the room to post messages of, empty for every room
+	Secret string // AddWebhook only: signs each payload, see WebhookPayload; optional
+	ID     int64  // RemoveWebhook only
+}
+
+// WebhookReply represents the response to AddWebhook
+type WebhookReply struct {
+	ID int64 // the new webhook, for RemoveWebhook
+}
+
+// Webhook is a URL the server posts each new room message to, as a
+// WebhookPayload
+type Webhook struct {
+	ID      int64
+	URL     string
+	Room    string // empty for every public room
+	Secret  string // never sent to clients; ListWebhooks only says whether there is one
+	Signed  bool   // whether payloads are signed with Secret
+	Creator string
+	Created time.Time
+}
+
+// WebhooksReply represents the response to ListWebhooks
+type WebhooksReply struct {
+	Webhooks []Webhook // oldest first
+}
+
+// WebhookPayload is the JSON body the server posts to a webhook for each
+// new chat message in its rooms. With a secret, the request carries a
+// WebhookSignatureHeader of "sha256=" and the hex HMAC-SHA256 of the body
+// keyed with the secret, so that the receiver can check where it came
+// from.
+type WebhookPayload struct {
+	Webhook int64 // the ID of the webhook it was sent to
+	Message Message
+}
+
+// WebhookSignatureHeader carries the signature of a WebhookPayload
+const WebhookSignatureHeader = "X-Chat-Signature"
+
+// BotArgs represents the arguments for adding a bot, or for removing one
+// by ID
+type BotArgs struct {
+	Name  string
+	Token string
+	Bot   string // AddBot only: the name the bot's messages are sent under
+	Room  string // AddBot only: the room it posts to, empty means DefaultRoom
+	ID    int64  // RemoveBot only
+}
+
+// BotReply represents the response to AddBot
+type BotReply struct {
+	ID int64 // the new bot, for RemoveBot
+
+	// Token is what external systems authenticate as the bot with, in an
+	// "Authorization: Bearer" header. The server only keeps its hash, so
+	// it cannot be shown again.
+	Token string
+}
+
+// Bot is an identity that external systems, such as CI or monitoring,
+// post messages to a room under, through the REST API's POST /hooks
+type Bot struct {
+	ID      int64
+	Name    string
+	Room    string
+	Creator string
+	Created time.Time
+}
+
+// BotsReply represents the response to ListBots
+type BotsReply struct {
+	Bots []Bot // oldest first
+}
+
+// Role decides what a user may do on the server. Roles are ordered, and
+// each may do everything the roles below it may.
+type Role int
+
+const (
+	RoleGuest     Role = iota // logged in without an account
+	RoleUser                  // logged in to a registered account
+	RoleModerator             // may kick and mute users and delete their messages
+	RoleAdmin                 // may also ban users and give out roles below admin
+	RoleOwner                 // runs the server and may make admins
+)
+
+var roleNames = []string{"guest", "user", "moderator", "admin", "owner"}
+
+func (r Role) String() string {
+	if r < 0 || int(r) >= len(roleNames) {
+		return fmt.Sprintf("Role(%d)", int(r))
+	}
+	return roleNames[r]
+}
+
+// ParseRole returns the role with the given name, such as "moderator"
+func ParseRole(name string) (Role, error) {
+	for i, n := range roleNames {
+		if strings.EqualFold(name, n) {
+			return Role(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown role %q, expected one of %s", name, strings.Join(roleNames, ", "))
+}
+
+// MarshalText makes roles readable in JSON, such as the accounts file
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Role) UnmarshalText(text []byte) error {
+	role, err := ParseRole(string(text))
+	if err != nil {
+		return err
+	}
+	*r = role
+	return nil
+}
+
+// RoleArgs represents the arguments for looking up or changing the role of
+// the user named Target. Role is only used by SetRole.
+type RoleArgs struct {
+	Name   string
+	Token  string
+	Target string
+	Role   Role
+}
+
+// RoleReply represents the response to GetRole
+type RoleReply struct {
+	Role Role
+}
+
+// MOTDReply represents the response to GetMOTD
+type MOTDReply struct {
+	Text string // empty if there is no message of the day
+}
+
+// MOTDArgs represents the arguments for changing the message of the day
+type MOTDArgs struct {
+	Name  string
+	Token string
+	Text  string // empty to remove it
+}
+
+// PingReply represents the response to Ping
+type PingReply struct {
+	Time            time.Time     // the server's clock
+	PresenceTimeout time.Duration // how long a session lasts without a ping or heartbeat
+	IdleTimeout     time.Duration // how long a connection lasts without any call, 0 for ever
+}
+
+// HelloArgs represents the arguments for Hello, which a client calls when
+// connecting to agree on a protocol version
+type HelloArgs struct {
+	Protocol    int // the newest version the client speaks
+	MinProtocol int // the oldest version the client speaks
+}
+
+// HelloReply represents the response to Hello
+type HelloReply struct {
+	Protocol int // the version used on this connection from now on
+}
+
+// ServerInfoReply represents the response to GetServerInfo
+type ServerInfoReply struct {
+	Version  string   // the server's release, "dev" for development builds
+	Protocol int      // the ProtocolVersion the server speaks
+	Features []string // Feature constants the server supports
+}
+
+// Supports reports whether the server lists feature
+func (r *ServerInfoReply) Supports(feature string) bool {
+	for _, f := range r.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// HealthReply represents the response to Health and Ready
+type HealthReply struct {
+	Healthy   bool   // the server works
+	Ready     bool   // the server works and can take clients
+	Store     string // "ok", or why the store cannot be reached
+	Listeners int    // listeners accepting connections
+	Stopping  bool   // the server is shutting down
+}
+
+// HeartbeatInterval returns how often a client should send heartbeats to
+// stay online and connected: a third of the shorter timeout, or 0 if the
+// server did not say
+func (r PingReply) HeartbeatInterval() time.Duration {
+	timeout := r.PresenceTimeout
+	if r.IdleTimeout > 0 && (timeout == 0 || r.IdleTimeout < timeout) {
+		timeout = r.IdleTimeout
+	}
+	return timeout / 3
+}
+
+// UsersReply represents the response containing online users
+type UsersReply struct {
+	Users []string
+}
@@ -0,0 +1,119 @@
+package chat
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPollRoundTrip(t *testing.T) {
+	tests := []struct {
+		question string
+		options  []string
+	}{
+		{"Lunch?", []string{"pizza", "sushi"}},
+		{"Which day works?", []string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday"}},
+		{"Spaces kept", []string{" a b ", "c"}},
+		{"", []string{"", "empty"}},
+	}
+	for _, tt := range tests {
+		got := NewPoll(PollBody(tt.question, tt.options))
+		want := &Poll{Question: tt.question, Options: tt.options}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("NewPoll(PollBody(%q, %q)) = %+v, want %+v", tt.question, tt.options, got, want)
+		}
+	}
+	if got := NewPoll("Question only"); got.Question != "Question only" || got.Options != nil {
+		t.Errorf("NewPoll without options = %+v", got)
+	}
+}
+
+func TestPollApply(t *testing.T) {
+	closed := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	poll := NewPoll(PollBody("Lunch?", []string{"pizza", "sushi"}))
+	tests := []struct {
+		name   string
+		events []Message
+		tally  []int
+		closed time.Time
+	}{
+		{"no votes", nil, []int{0, 0}, time.Time{}},
+		{"votes", []Message{{Kind: KindVote, Sender: "alice", Body: "1"}, {Kind: KindVote, Sender: "bob", Body: "2"}, {Kind: KindVote, Sender: "carol", Body: "2"}}, []int{1, 2}, time.Time{}},
+		{"changed vote", []Message{{Kind: KindVote, Sender: "alice", Body: "1"}, {Kind: KindVote, Sender: "alice", Body: "2"}}, []int{0, 1}, time.Time{}},
+		{"not an option", []Message{{Kind: KindVote, Sender: "alice", Body: "3"}, {Kind: KindVote, Sender: "bob", Body: "0"}, {Kind: KindVote, Sender: "carol", Body: "x"}}, []int{0, 0}, time.Time{}},
+		{"closed", []Message{{Kind: KindVote, Sender: "alice", Body: "1"}, {Kind: KindClosePoll, Timestamp: closed}}, []int{1, 0}, closed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := poll
+			for _, event := range tt.events {
+				p = p.Apply(event)
+			}
+			if got := p.Tally(); !reflect.DeepEqual(got, tt.tally) {
+				t.Errorf("tally = %v, want %v", got, tt.tally)
+			}
+			if !p.Closed.Equal(tt.closed) {
+				t.Errorf("closed = %v, want %v", p.Closed, tt.closed)
+			}
+		})
+	}
+	if poll.Votes != nil {
+		t.Errorf("Apply changed the poll it was called on: %v", poll.Votes)
+	}
+	if (*Poll)(nil).Apply(Message{Kind: KindVote, Body: "1"}) != nil {
+		t.Error("Apply on a nil poll returned one")
+	}
+}
+
+func TestFileRoundTrip(t *testing.T) {
+	const sum = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	tests := []*File{
+		{Name: "notes.txt", Size: 1234, SHA256: sum},
+		{Name: "my holiday photo.jpg", Size: 5 << 20, SHA256: sum, Caption: "the beach"},
+		{Name: "empty", Size: 0, SHA256: sum, Caption: "two\nlines"},
+	}
+	for _, want := range tests {
+		if got := NewFile(want.Body()); !reflect.DeepEqual(got, want) {
+			t.Errorf("NewFile(%q) = %+v, want %+v", want.Body(), got, want)
+		}
+	}
+}
+
+func TestFileIsImage(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"cat.png", "image/png"},
+		{"CAT.JPG", "image/jpeg"},
+		{"cat.jpeg", "image/jpeg"},
+		{"cat.gif", "image/gif"},
+		{"cat.svg", ""},
+		{"cat.png.exe", ""},
+		{"png", ""},
+	}
+	for _, tt := range tests {
+		if got := ImageType(tt.name); got != tt.want {
+			t.Errorf("ImageType(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+		if got := (&File{Name: tt.name}).IsImage(); got != (tt.want != "") {
+			t.Errorf("IsImage of %q = %v", tt.name, got)
+		}
+	}
+}
+
+func TestPreviewRoundTrip(t *testing.T) {
+	tests := []*Preview{
+		{URL: "https://example.com/", Title: "Example Domain", Description: "This domain is for use in examples."},
+		{URL: "https://example.com/a?b=c#d", Title: "No description"},
+		{URL: "https://example.com/", Title: "Ünïcödé", Description: "with: colons, commas\tand tabs"},
+	}
+	for _, want := range tests {
+		if got := NewPreview(want.Body()); !reflect.DeepEqual(got, want) {
+			t.Errorf("NewPreview(%q) = %+v, want %+v", want.Body(), got, want)
+		}
+	}
+	if got := NewPreview("https://example.com/"); !reflect.DeepEqual(got, &Preview{URL: "https://example.com/"}) {
+		t.Errorf("NewPreview of a URL alone = %+v", got)
+	}
+}
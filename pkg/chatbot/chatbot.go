@@ -0,0 +1,209 @@
+// Package chatbot is a small framework for bots that answer commands,
+// such as "!weather London", in chat rooms. A bot registers the commands
+// it understands and receives each use of them, already parsed, over a
+// channel:
+//
+//	c, err := chatclient.Connect("localhost:1234", "weatherbot", nil)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer c.Close()
+//	bot := chatbot.New(c)
+//	bot.Command("!weather", "<city>", "the forecast for a city")
+//	invocations, err := bot.Run(chat.DefaultRoom)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	for inv := range invocations {
+//		inv.Reply(forecast(inv.Text))
+//	}
+//
+// The bot answers HelpCommand itself, with the commands it has, unless it
+// registers a command of that name.
+package chatbot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chatclient"
+)
+
+// HelpCommand lists the commands a bot has
+const HelpCommand = "!help"
+
+// ErrRunning is returned by Command once the bot is running
+var ErrRunning = errors.New("chatbot: commands must be registered before Run")
+
+// Bot answers the commands it registered in the rooms it runs in. Its
+// methods are safe to call from several goroutines at once.
+type Bot struct {
+	client *chatclient.Client
+
+	mu       sync.Mutex
+	commands map[string]command // by name in lower case
+	order    []string           // the same names, in the order registered
+	running  bool
+}
+
+// command is a command a bot registered
+type command struct {
+	name string // as registered
+	args string // e.g. "<city>", empty if it takes none
+	help string
+}
+
+// Invocation is a use of one of a bot's commands
+type Invocation struct {
+	Command string       // the command as registered, e.g. "!weather"
+	Text    string       // everything after the command, trimmed
+	Args    []string     // Text split into words
+	Message chat.Message // the message the command was in
+
+	bot *Bot
+}
+
+// New returns a bot that takes part in the chat as client, without any
+// commands yet
+func New(client *chatclient.Client) *Bot {
+	return &Bot{client: client, commands: make(map[string]command)}
+}
+
+// Client returns the client the bot takes part in the chat as, for calls
+// the bot makes besides answering
+func (b *Bot) Client() *chatclient.Client {
+	return b.client
+}
+
+// Command registers a command, a single word such as "!weather", which is
+// matched regardless of case at the start of messages. args and help
+// describe its arguments, e.g. "<city>", and what it does, for
+// HelpCommand.
+func (b *Bot) Command(name, args, help string) error {
+	if name == "" || strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("chatbot: command %q must be a single word", name)
+	}
+	key := strings.ToLower(name)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.running {
+		return ErrRunning
+	}
+	if _, ok := b.commands[key]; ok {
+		return fmt.Errorf("chatbot: command %q is already registered", name)
+	}
+	b.commands[key] = command{name: name, args: args, help: help}
+	b.order = append(b.order, key)
+	return nil
+}
+
+// Run joins rooms, chat.DefaultRoom if none are given, and returns a
+// channel that receives every use of the bot's commands in them from now
+// on. Messages the bot sent itself are left out. The channel is closed
+// once the client is closed or its connection fails; the client's Err
+// tells which.
+func (b *Bot) Run(rooms ...string) (<-chan Invocation, error) {
+	if len(rooms) == 0 {
+		rooms = []string{chat.DefaultRoom}
+	}
+	b.mu.Lock()
+	b.running = true
+	b.mu.Unlock()
+
+	var feeds []<-chan chat.Message
+	for _, room := range rooms {
+		messages, err := b.client.Subscribe(room)
+		if err != nil {
+			return nil, fmt.Errorf("chatbot: joining %s: %w", room, err)
+		}
+		feeds = append(feeds, messages)
+	}
+
+	out := make(chan Invocation)
+	var wg sync.WaitGroup
+	for _, messages := range feeds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range messages {
+				if inv, ok := b.parse(m); ok {
+					out <- inv
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out, nil
+}
+
+// parse returns the invocation in m, if it uses one of the bot's
+// commands. HelpCommand is answered here unless the bot registered it.
+func (b *Bot) parse(m chat.Message) (Invocation, bool) {
+	if m.Kind != chat.KindChat || m.IsEvent() || !m.Deleted.IsZero() || m.Sender == b.client.Name() {
+		return Invocation{}, false
+	}
+	word, text := strings.TrimSpace(m.Body), ""
+	if i := strings.IndexFunc(word, unicode.IsSpace); i >= 0 {
+		word, text = word[:i], word[i:]
+	}
+	key := strings.ToLower(word)
+
+	b.mu.Lock()
+	cmd, ok := b.commands[key]
+	b.mu.Unlock()
+	if !ok {
+		if key == HelpCommand {
+			b.client.Reply(m.Room, m.ID, b.help())
+		}
+		return Invocation{}, false
+	}
+
+	text = strings.TrimSpace(text)
+	inv := Invocation{
+		Command: cmd.name,
+		Text:    text,
+		Args:    strings.Fields(text),
+		Message: m,
+		bot:     b,
+	}
+	return inv, true
+}
+
+// help lists the bot's commands, one per line
+func (b *Bot) help() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.order) == 0 {
+		return "I have no commands"
+	}
+	lines := []string{"My commands:"}
+	for _, key := range b.order {
+		cmd := b.commands[key]
+		line := strings.TrimSpace(cmd.name + " " + cmd.args)
+		if cmd.help != "" {
+			line += ": " + cmd.help
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Reply answers the invocation with a reply to the message it was in
+func (inv Invocation) Reply(text string) error {
+	return inv.bot.client.Reply(inv.Message.Room, inv.Message.ID, text)
+}
+
+// Send posts text to the room the invocation was in, not as a reply
+func (inv Invocation) Send(text string) error {
+	return inv.bot.client.Send(inv.Message.Room, text)
+}
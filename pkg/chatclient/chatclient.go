@@ -0,0 +1,221 @@
+// Package chatclient is a small Go API for bots and other frontends that
+// want to take part in a chat without dealing with sessions, heartbeats
+// and long polling themselves:
+//
+//	c, err := chatclient.Connect("localhost:1234", "bot", nil)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer c.Close()
+//	messages, err := c.Subscribe(chat.DefaultRoom)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	for m := range messages {
+//		if m.Sender != "bot" && m.Body == "ping" {
+//			c.Send(m.Room, "pong")
+//		}
+//	}
+//
+// Use chat.Client directly for calls that are not covered here.
+package chatclient
+
+import (
+	"crypto/tls"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// heartbeatInterval is how often the client tells the server it is still
+// online, unless the server's presence timeout calls for more often
+const heartbeatInterval = 10 * time.Second
+
+// ErrClosed is returned by calls made after Close
+var ErrClosed = errors.New("chatclient: client closed")
+
+// Options holds the optional settings for Connect
+type Options struct {
+	// Password logs in to a registered account instead of claiming an
+	// unregistered name
+	Password string
+	// TLS connects over TLS with this configuration
+	TLS *tls.Config
+	// Codec is the codec the server was started with, chat.CodecGob if
+	// empty
+	Codec string
+}
+
+// Client is a logged in chat user. Its methods are safe to call from
+// several goroutines at once.
+type Client struct {
+	conn  *chat.Client
+	name  string
+	token string
+
+	mu     sync.Mutex
+	err    error // why the client stopped, once it has
+	done   chan struct{}
+	closed bool
+}
+
+// Connect connects to the chat server at addr and logs in as name. A nil
+// opts uses the defaults: a plain connection and no password.
+func Connect(addr, name string, opts *Options) (*Client, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	codec := opts.Codec
+	if codec == "" {
+		codec = chat.CodecGob
+	}
+	conn, err := chat.DialCodec(addr, codec, opts.TLS)
+	if err != nil {
+		return nil, err
+	}
+	var token string
+	if opts.Password != "" {
+		token, err = conn.Authenticate(name, opts.Password)
+	} else {
+		token, err = conn.Login(name)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := &Client{conn: conn, name: name, token: token, done: make(chan struct{})}
+	go c.sendHeartbeats()
+	return c, nil
+}
+
+// Name returns the name the client is logged in as
+func (c *Client) Name() string {
+	return c.name
+}
+
+// Send posts text to a room, an empty room meaning chat.DefaultRoom. The
+// user must have joined the room, which Subscribe does.
+func (c *Client) Send(room, text string) error {
+	if err := c.Err(); err != nil {
+		return err
+	}
+	return c.conn.SendMessage(c.token, room, text)
+}
+
+// Reply posts text to a room as a reply to message id, starting or
+// continuing its thread
+func (c *Client) Reply(room string, id int64, text string) error {
+	if err := c.Err(); err != nil {
+		return err
+	}
+	return c.conn.Call("SendMessage", &chat.MessageArgs{Token: c.token, Room: room, Message: text, InReplyTo: id}, &chat.HistoryReply{})
+}
+
+// Subscribe joins a room and returns a channel that receives every
+// message posted to it from now on, including events such as edits and
+// reactions (see chat.Message.IsEvent). The channel is closed when the
+// client is closed or the connection fails; Err tells which.
+func (c *Client) Subscribe(room string) (<-chan chat.Message, error) {
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+	if err := c.conn.JoinRoom(c.token, room); err != nil {
+		return nil, err
+	}
+	page, err := c.conn.GetHistory(c.token, room, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make(chan chat.Message)
+	go c.receive(room, page.LastIndex, messages)
+	return messages, nil
+}
+
+// Err returns nil while the client is usable, ErrClosed after Close, or
+// the error that broke the connection
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close logs out and closes the connection, closing every channel
+// returned by Subscribe
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	if c.err == nil {
+		c.err = ErrClosed
+	}
+	close(c.done)
+	c.mu.Unlock()
+
+	c.conn.Logout(c.token)
+	return c.conn.Close()
+}
+
+// fail records err as the reason the client stopped, unless it already has
+// one
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// receive long-polls a room from position lastIndex and hands its messages
+// to out until the client stops
+func (c *Client) receive(room string, lastIndex int, out chan<- chat.Message) {
+	defer close(out)
+	for {
+		reply, err := c.conn.WaitForMessages(c.token, room, lastIndex)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		lastIndex = reply.LastIndex
+		for _, m := range reply.Messages {
+			select {
+			case out <- m:
+			case <-c.done:
+				return
+			}
+		}
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+	}
+}
+
+// sendHeartbeats keeps the user online until the client stops
+func (c *Client) sendHeartbeats() {
+	// Servers without Ping use the default timeout
+	interval := heartbeatInterval
+	if reply, err := c.conn.Ping(c.token); err == nil && reply.HeartbeatInterval() > 0 {
+		interval = min(interval, reply.HeartbeatInterval())
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.conn.Heartbeat(c.token); err != nil {
+				c.fail(err)
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
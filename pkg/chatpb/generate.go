@@ -0,0 +1,6 @@
+// Package chatpb is the gRPC version of the chat protocol, generated from
+// chat.proto. Regenerate it after changing chat.proto with go generate,
+// which needs protoc, protoc-gen-go and protoc-gen-go-grpc.
+package chatpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative chat.proto
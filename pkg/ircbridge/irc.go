@@ -0,0 +1,367 @@
+package ircbridge
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Limits on what the bridge says on IRC, whose lines are at most 512
+// bytes long with the sender and channel
+const (
+	maxLineLength = 8 << 10 // bytes in a line read, allowing for IRCv3 tags
+	maxLineText   = 400     // bytes of a message in each line sent
+	maxLines      = 4       // lines sent for a chat message, the last summing up the rest
+)
+
+// ircConn is a connection to IRC and what the bridge knows over it
+type ircConn struct {
+	bridge *Bridge
+	conn   net.Conn
+
+	writeMu sync.Mutex // held while writing a line
+
+	// Only the goroutine reading from the connection uses these
+	nick       string                     // the bridge's own
+	registered bool                       // once the server welcomed the bridge
+	members    map[string]map[string]bool // nicks in each channel, all in lower case
+}
+
+// ircMessage is a line from the IRC server, e.g.
+// ":bob!b@example.com PRIVMSG #chat :hello"
+type ircMessage struct {
+	prefix  string // who it is from, e.g. "bob!b@example.com"
+	command string // in upper case, e.g. "PRIVMSG" or "001"
+	params  []string
+}
+
+// parseMessage parses a line from the IRC server
+func parseMessage(line string) ircMessage {
+	var m ircMessage
+	line = strings.TrimRight(line, "\r\n")
+	// IRCv3 message tags
+	if strings.HasPrefix(line, "@") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	if strings.HasPrefix(line, ":") {
+		m.prefix, line, _ = strings.Cut(line[1:], " ")
+	}
+	for {
+		line = strings.TrimLeft(line, " ")
+		if line == "" {
+			break
+		}
+		if m.command != "" && strings.HasPrefix(line, ":") {
+			m.params = append(m.params, line[1:])
+			break
+		}
+		var word string
+		word, line, _ = strings.Cut(line, " ")
+		if m.command == "" {
+			m.command = strings.ToUpper(word)
+		} else {
+			m.params = append(m.params, word)
+		}
+	}
+	return m
+}
+
+// nick returns the nick the message is from
+func (m ircMessage) nick() string {
+	nick, _, _ := strings.Cut(m.prefix, "!")
+	return nick
+}
+
+// param returns the ith parameter, or "" if there are fewer
+func (m ircMessage) param(i int) string {
+	if i < len(m.params) {
+		return m.params[i]
+	}
+	return ""
+}
+
+// run registers with the server and relays messages until the connection
+// fails
+func (c *ircConn) run() error {
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		c.conn.Close()
+		close(stop)
+		wg.Wait()
+	}()
+
+	if password := c.bridge.config.Password; password != "" {
+		if err := c.write("PASS " + password); err != nil {
+			return err
+		}
+	}
+	if err := c.write("NICK " + c.nick); err != nil {
+		return err
+	}
+	if err := c.write("USER " + c.nick + " 0 * :Chat room bridge"); err != nil {
+		return err
+	}
+
+	r := bufio.NewReaderSize(c.conn, maxLineLength)
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		m := parseMessage(line)
+		if m.command == "001" && !c.registered {
+			c.registered = true
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.relay(stop)
+			}()
+		}
+		if err := c.handle(m); err != nil {
+			return err
+		}
+	}
+}
+
+// write sends a line to the server
+func (c *ircConn) write(line string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := io.WriteString(c.conn, line+"\r\n")
+	return err
+}
+
+// relay sends the lines the bridge queues, slowly enough not to be taken
+// for a flooder, and pings the server now and then, until stop is closed
+func (c *ircConn) relay(stop <-chan struct{}) {
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+
+	for {
+		var line string
+		select {
+		case <-stop:
+			return
+		case <-ping.C:
+			line = "PING :" + c.nick
+		case line = <-c.bridge.lines:
+		}
+		if err := c.write(line); err != nil {
+			// The reader notices too and reconnects
+			c.conn.Close()
+			return
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(floodDelay):
+		}
+	}
+}
+
+// handle acts on a message from the server
+func (c *ircConn) handle(m ircMessage) error {
+	b := c.bridge
+	nick := m.nick()
+	self := strings.EqualFold(nick, c.nick)
+
+	switch m.command {
+	case "PING":
+		return c.write("PONG :" + m.param(0))
+
+	case "001": // welcome
+		c.nick = m.param(0)
+		slog.Info("Connected to IRC", "server", b.config.Server, "nick", c.nick)
+		for _, channel := range b.channels {
+			if err := c.write("JOIN " + channel); err != nil {
+				return err
+			}
+		}
+
+	case "433": // the nick is taken
+		if !c.registered {
+			c.nick += "_"
+			return c.write("NICK " + c.nick)
+		}
+
+	case "353": // the nicks in a channel
+		for _, name := range strings.Fields(m.param(3)) {
+			c.addMember(m.param(2), strings.TrimLeft(name, "~&@%+"))
+		}
+
+	case "JOIN":
+		channel := m.param(0)
+		room, ok := b.room(channel)
+		if !ok {
+			break
+		}
+		if self {
+			slog.Info("Joined IRC channel", "channel", channel, "room", room)
+			break
+		}
+		c.addMember(channel, nick)
+		b.announce(room, "%s joined %s on IRC", b.chatName(nick), channel)
+
+	case "PART":
+		channel := m.param(0)
+		room, ok := b.room(channel)
+		if !ok || self {
+			break
+		}
+		c.removeMember(channel, nick)
+		b.announce(room, "%s left %s on IRC%s", b.chatName(nick), channel, because(m.param(1)))
+
+	case "KICK":
+		channel, victim := m.param(0), m.param(1)
+		room, ok := b.room(channel)
+		if !ok {
+			break
+		}
+		if strings.EqualFold(victim, c.nick) {
+			slog.Warn("Kicked from IRC channel, joining again", "channel", channel, "by", nick, "reason", m.param(2))
+			return c.write("JOIN " + channel)
+		}
+		c.removeMember(channel, victim)
+		b.announce(room, "%s was kicked from %s on IRC by %s%s", b.chatName(victim), channel, nick, because(m.param(2)))
+
+	case "QUIT":
+		for channel := range c.members {
+			if room, ok := b.room(channel); ok && c.removeMember(channel, nick) {
+				b.announce(room, "%s left IRC%s", b.chatName(nick), because(m.param(0)))
+			}
+		}
+
+	case "NICK":
+		newNick := m.param(0)
+		if self {
+			c.nick = newNick
+			break
+		}
+		for channel := range c.members {
+			if room, ok := b.room(channel); ok && c.removeMember(channel, nick) {
+				c.addMember(channel, newNick)
+				b.announce(room, "%s is now known as %s", b.chatName(nick), b.chatName(newNick))
+			}
+		}
+
+	case "PRIVMSG":
+		room, ok := b.room(m.param(0))
+		// Private messages to the bridge are not relayed
+		if !ok || self {
+			break
+		}
+		text := m.param(1)
+		if action, ok := strings.CutPrefix(text, "\x01ACTION "); ok {
+			b.post(room, nick, strings.TrimSuffix(action, "\x01"), true)
+		} else if !strings.HasPrefix(text, "\x01") {
+			b.post(room, nick, text, false)
+		}
+
+	case "ERROR":
+		return fmt.Errorf("closed by the server: %s", m.param(0))
+	}
+	return nil
+}
+
+// addMember notes that nick is in channel
+func (c *ircConn) addMember(channel, nick string) {
+	channel = strings.ToLower(channel)
+	if c.members[channel] == nil {
+		c.members[channel] = make(map[string]bool)
+	}
+	c.members[channel][strings.ToLower(nick)] = true
+}
+
+// removeMember notes that nick left channel, reporting whether it was in
+// it
+func (c *ircConn) removeMember(channel, nick string) bool {
+	channel, nick = strings.ToLower(channel), strings.ToLower(nick)
+	if !c.members[channel][nick] {
+		return false
+	}
+	delete(c.members[channel], nick)
+	return true
+}
+
+// because returns the reason given for leaving, for an announcement
+func because(reason string) string {
+	if reason = strings.TrimSpace(stripFormatting(reason)); reason == "" {
+		return ""
+	}
+	return " (" + reason + ")"
+}
+
+// stripFormatting removes the control codes IRC clients use for bold,
+// colours and the like from text
+func stripFormatting(text string) string {
+	var sb strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case 0x02, 0x0f, 0x11, 0x16, 0x1d, 0x1e, 0x1f:
+			// bold, reset, monospace, reverse, italic, strikethrough and underline
+		case 0x03:
+			// A colour, with up to two digits for the text and optionally
+			// a comma and two more for the background
+			i += digits(text[i+1:])
+			if i+2 < len(text) && text[i+1] == ',' && digits(text[i+2:]) > 0 {
+				i += 1 + digits(text[i+2:])
+			}
+		default:
+			sb.WriteByte(text[i])
+		}
+	}
+	return sb.String()
+}
+
+// digits returns how many of the first two bytes of s are digits
+func digits(s string) int {
+	n := 0
+	for n < 2 && n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	return n
+}
+
+// ircLines splits a chat message into lines short enough for IRC, summing
+// up those past maxLines
+func ircLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r ")
+		for len(line) > maxLineText {
+			cut := cutPoint(line, maxLineText)
+			lines = append(lines, line[:cut])
+			line = strings.TrimLeft(line[cut:], " ")
+		}
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > maxLines {
+		more := len(lines) - maxLines + 1
+		lines = append(lines[:maxLines-1], fmt.Sprintf("(%d more lines)", more))
+	}
+	return lines
+}
+
+// cutPoint returns where to cut s, longer than n bytes, to make it at
+// most n bytes long: at a space in its second half, or else between runes
+func cutPoint(s string, n int) int {
+	if i := strings.LastIndexByte(s[:n], ' '); i > n/2 {
+		return i
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return n
+}
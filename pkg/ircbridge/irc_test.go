@@ -0,0 +1,107 @@
+package ircbridge
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMessage(t *testing.T) {
+	tests := []struct {
+		line string
+		want ircMessage
+	}{
+		{":bob!b@example.com PRIVMSG #chat :hello there\r\n",
+			ircMessage{prefix: "bob!b@example.com", command: "PRIVMSG", params: []string{"#chat", "hello there"}}},
+		{"PING :irc.example.com",
+			ircMessage{command: "PING", params: []string{"irc.example.com"}}},
+		{"@time=2024-01-01T00:00:00Z;account=bob :bob!b@h PRIVMSG #chat :hi",
+			ircMessage{prefix: "bob!b@h", command: "PRIVMSG", params: []string{"#chat", "hi"}}},
+		{":irc.example.com 353 me = #chat :@alice +bob carol",
+			ircMessage{prefix: "irc.example.com", command: "353", params: []string{"me", "=", "#chat", "@alice +bob carol"}}},
+		{":irc.example.com 001 me :Welcome :)",
+			ircMessage{prefix: "irc.example.com", command: "001", params: []string{"me", "Welcome :)"}}},
+		{":bob!b@h PRIVMSG #chat ::-)",
+			ircMessage{prefix: "bob!b@h", command: "PRIVMSG", params: []string{"#chat", ":-)"}}},
+		{"join  #chat   #other",
+			ircMessage{command: "JOIN", params: []string{"#chat", "#other"}}},
+		{":bob!b@h QUIT",
+			ircMessage{prefix: "bob!b@h", command: "QUIT"}},
+		{"", ircMessage{}},
+	}
+	for _, tt := range tests {
+		if got := parseMessage(tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseMessage(%q) = %#v, want %#v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestMessageNick(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"bob!b@example.com", "bob"},
+		{"irc.example.com", "irc.example.com"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := (ircMessage{prefix: tt.prefix}).nick(); got != tt.want {
+			t.Errorf("nick of %q = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestStripFormatting(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"plain text", "plain text"},
+		{"\x02bold\x02 and \x1ditalic\x0f", "bold and italic"},
+		{"\x1funder\x1f\x1estrike\x1e\x11mono\x11\x16rev\x16", "understrikemonorev"},
+		{"\x0304red\x03 text", "red text"},
+		{"\x034,12on blue", "on blue"},
+		{"\x0312,not a background", ",not a background"},
+		{"100\x03%", "100%"},
+		{"ends with a colour\x03", "ends with a colour"},
+		{"\x03123", "3"},
+		{"héllo wörld", "héllo wörld"},
+	}
+	for _, tt := range tests {
+		if got := stripFormatting(tt.text); got != tt.want {
+			t.Errorf("stripFormatting(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestIRCLines(t *testing.T) {
+	words := func(n int) string { return strings.TrimSpace(strings.Repeat("word ", n)) }
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"one line", "hello", []string{"hello"}},
+		{"blank lines and trailing spaces", "a\nb\r\n\n  \nc  ", []string{"a", "b", "c"}},
+		{"nothing", "  \n ", nil},
+		{"cut at a space", words(100), []string{words(80), words(20)}},
+		{"cut without spaces", strings.Repeat("x", 500), []string{strings.Repeat("x", 400), strings.Repeat("x", 100)}},
+		{"cut between runes", "a" + strings.Repeat("é", 250), []string{"a" + strings.Repeat("é", 199), strings.Repeat("é", 51)}},
+		{"as many lines as allowed", "1\n2\n3\n4", []string{"1", "2", "3", "4"}},
+		{"too many lines", "1\n2\n3\n4\n5\n6", []string{"1", "2", "3", "(3 more lines)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ircLines(tt.text)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ircLines(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+			for _, line := range got {
+				if len(line) > maxLineText {
+					t.Errorf("line of %d bytes, longer than %d", len(line), maxLineText)
+				}
+			}
+		})
+	}
+}
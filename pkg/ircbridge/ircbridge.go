@@ -0,0 +1,323 @@
+// Package ircbridge relays messages between chat rooms and IRC channels.
+// A Bridge is a server.MessageHook of the chat server it bridges: it posts
+// what is said in its channels to their rooms under the speakers' nicks,
+// e.g. as "bob[irc]", and says what is written in the rooms on IRC as
+// "<alice> hello". Joins, parts, quits and nick changes on IRC are
+// announced in the rooms, and users joining and leaving the rooms are
+// noted in the channels.
+//
+//	bridge, err := ircbridge.New(srv, ircbridge.Config{
+//		Server:   "irc.libera.chat:6697",
+//		TLS:      true,
+//		Channels: map[string]string{"general": "#mychat"},
+//	})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	srv.AddHook(bridge)
+//	go bridge.Run()
+//	defer bridge.Close()
+package ircbridge
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/server"
+)
+
+// Defaults for Config
+const (
+	DefaultNick   = "chatbridge"
+	DefaultSuffix = "[irc]"
+)
+
+// Limits on the connection to IRC
+const (
+	dialTimeout   = 30 * time.Second
+	writeTimeout  = 30 * time.Second
+	pingInterval  = 2 * time.Minute // how often the bridge checks the server is still there
+	readTimeout   = 5 * time.Minute // without hearing from the server, before reconnecting
+	minRetryDelay = time.Second
+	maxRetryDelay = 5 * time.Minute
+	floodDelay    = 500 * time.Millisecond // between lines sent, so that the server does not take the bridge for a flooder
+	queueSize     = 256                    // lines waiting to be sent; more are dropped
+)
+
+// ErrClosed is returned by Run once the bridge is closed
+var ErrClosed = errors.New("ircbridge: bridge closed")
+
+// Config holds the settings of a Bridge
+type Config struct {
+	Server   string // host:port of the IRC server
+	TLS      bool   // connect with TLS, usually to port 6697
+	Nick     string // the bridge's nick, DefaultNick if empty
+	Password string // the server's password, or on most networks the nick's
+
+	// Channels maps each bridged room to its IRC channel, e.g.
+	// "general" to "#mychat"
+	Channels map[string]string
+
+	// Nicks maps IRC nicks to the names they are given in the chat, such
+	// as a user's own. Other nicks are given with Suffix added,
+	// DefaultSuffix if empty, which New reserves so that chat users
+	// cannot take such names.
+	Nicks  map[string]string
+	Suffix string
+}
+
+// Bridge relays messages between rooms of a chat server and IRC channels
+type Bridge struct {
+	server.BaseHook
+
+	srv      *server.ChatServer
+	config   Config
+	channels map[string]string // config.Channels
+	rooms    map[string]string // the other way round, by channel in lower case
+	nicks    map[string]string // config.Nicks by nick in lower case
+	lines    chan string       // waiting to be sent to IRC
+	done     chan struct{}     // closed by Close
+	close    sync.Once
+
+	// mu guards the connection, and is held while posting messages from
+	// IRC until they are in posted, so that OnAfterSend knows not to send
+	// them back
+	mu     sync.Mutex
+	conn   net.Conn       // to IRC, nil while there is none
+	posted map[int64]bool // messages from IRC OnAfterSend has not seen yet
+}
+
+// New returns a bridge between the rooms of srv and the IRC channels
+// config names. It is not connected until Run is called, and only sees
+// the messages in the rooms once added with srv.AddHook.
+func New(srv *server.ChatServer, config Config) (*Bridge, error) {
+	if config.Server == "" {
+		return nil, errors.New("ircbridge: the IRC server is required")
+	}
+	if len(config.Channels) == 0 {
+		return nil, errors.New("ircbridge: no rooms to bridge")
+	}
+	if config.Nick == "" {
+		config.Nick = DefaultNick
+	}
+	if config.Suffix == "" {
+		config.Suffix = DefaultSuffix
+	}
+	b := &Bridge{
+		srv:      srv,
+		config:   config,
+		channels: make(map[string]string),
+		rooms:    make(map[string]string),
+		nicks:    make(map[string]string),
+		lines:    make(chan string, queueSize),
+		done:     make(chan struct{}),
+		posted:   make(map[int64]bool),
+	}
+	for room, channel := range config.Channels {
+		if room == "" {
+			room = chat.DefaultRoom
+		}
+		if (!strings.HasPrefix(channel, "#") && !strings.HasPrefix(channel, "&")) || strings.ContainsAny(channel, " ,\x07") {
+			return nil, fmt.Errorf("ircbridge: %q is not an IRC channel", channel)
+		}
+		key := strings.ToLower(channel)
+		if other, ok := b.rooms[key]; ok {
+			return nil, fmt.Errorf("ircbridge: rooms %q and %q are both bridged to %s", other, room, channel)
+		}
+		b.channels[room] = channel
+		b.rooms[key] = room
+	}
+	for nick, name := range config.Nicks {
+		b.nicks[strings.ToLower(nick)] = name
+	}
+	// Keep chat users from passing for someone on IRC
+	srv.ReserveSuffix(config.Suffix)
+	return b, nil
+}
+
+// Run connects to IRC and relays messages until the bridge is closed,
+// reconnecting whenever the connection fails. It always returns
+// ErrClosed.
+func (b *Bridge) Run() error {
+	delay := minRetryDelay
+	for {
+		start := time.Now()
+		err := b.connect()
+		select {
+		case <-b.done:
+			return ErrClosed
+		default:
+		}
+		// Start over after a connection that lasted
+		if time.Since(start) > maxRetryDelay {
+			delay = minRetryDelay
+		}
+		slog.Warn("Lost the connection to IRC, reconnecting", "server", b.config.Server, "in", delay, "err", err)
+		select {
+		case <-b.done:
+			return ErrClosed
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
+
+// Close leaves IRC and stops Run
+func (b *Bridge) Close() error {
+	b.close.Do(func() { close(b.done) })
+
+	b.mu.Lock()
+	conn := b.conn
+	b.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	io.WriteString(conn, "QUIT :Chat bridge shutting down\r\n")
+	return conn.Close()
+}
+
+// connect makes a connection to IRC and relays messages over it until it
+// fails
+func (b *Bridge) connect() error {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	var conn net.Conn
+	var err error
+	if b.config.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", b.config.Server, nil)
+	} else {
+		conn, err = dialer.Dial("tcp", b.config.Server)
+	}
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	select {
+	case <-b.done:
+		b.mu.Unlock()
+		conn.Close()
+		return ErrClosed
+	default:
+	}
+	b.conn = conn
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.conn = nil
+		b.mu.Unlock()
+	}()
+
+	c := &ircConn{
+		bridge:  b,
+		conn:    conn,
+		nick:    b.config.Nick,
+		members: make(map[string]map[string]bool),
+	}
+	return c.run()
+}
+
+// room returns the room bridged to an IRC channel
+func (b *Bridge) room(channel string) (string, bool) {
+	room, ok := b.rooms[strings.ToLower(channel)]
+	return room, ok
+}
+
+// chatName returns the name an IRC nick is given in the chat
+func (b *Bridge) chatName(nick string) string {
+	if name, ok := b.nicks[strings.ToLower(nick)]; ok {
+		return name
+	}
+	return nick + b.config.Suffix
+}
+
+// send queues a line for IRC. Line breaks cannot be smuggled into it, and
+// it is dropped if too many are waiting already.
+func (b *Bridge) send(format string, args ...any) {
+	line := strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == 0 {
+			return ' '
+		}
+		return r
+	}, fmt.Sprintf(format, args...))
+	select {
+	case b.lines <- line:
+	default:
+		slog.Warn("Dropped a line for IRC, too many are waiting", "line", line)
+	}
+}
+
+// post posts what nick said on IRC to room, as an action if emote is set
+func (b *Bridge) post(room, nick, text string, emote bool) {
+	text = strings.TrimSpace(stripFormatting(text))
+	if text == "" {
+		return
+	}
+	post := b.srv.Post
+	if emote {
+		post = b.srv.PostEmote
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	msg, err := post(room, b.chatName(nick), text)
+	if err != nil {
+		slog.Warn("Could not relay a message from IRC", "room", room, "nick", nick, "err", err)
+		return
+	}
+	b.posted[msg.ID] = true
+}
+
+// announce tells room about something that happened on IRC
+func (b *Bridge) announce(room, format string, args ...any) {
+	if err := b.srv.Announce(room, fmt.Sprintf(format, args...)); err != nil {
+		slog.Warn("Could not announce IRC news", "room", room, "err", err)
+	}
+}
+
+// OnAfterSend relays messages written in the bridged rooms to IRC, leaving
+// out those that came from IRC
+func (b *Bridge) OnAfterSend(msg chat.Message) {
+	b.mu.Lock()
+	fromIRC := b.posted[msg.ID]
+	delete(b.posted, msg.ID)
+	b.mu.Unlock()
+
+	channel, ok := b.channels[msg.Room]
+	if fromIRC || !ok || msg.To != "" {
+		return
+	}
+	switch msg.Kind {
+	case chat.KindChat:
+		for _, line := range ircLines(msg.Body) {
+			b.send("PRIVMSG %s :<%s> %s", channel, msg.Sender, line)
+		}
+	case chat.KindEmote:
+		for _, line := range ircLines(msg.Body) {
+			b.send("PRIVMSG %s :* %s %s", channel, msg.Sender, line)
+		}
+	}
+}
+
+// OnUserJoin notes users joining a bridged room on IRC
+func (b *Bridge) OnUserJoin(name, room string) {
+	if channel, ok := b.channels[room]; ok {
+		b.send("NOTICE %s :%s joined %s", channel, name, room)
+	}
+}
+
+// OnUserLeave notes users leaving a bridged room on IRC
+func (b *Bridge) OnUserLeave(name, room string) {
+	if channel, ok := b.channels[room]; ok {
+		b.send("NOTICE %s :%s left %s", channel, name, room)
+	}
+}
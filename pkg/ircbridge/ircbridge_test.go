@@ -0,0 +1,85 @@
+package ircbridge
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/server"
+)
+
+// newTestServer returns a chat server keeping everything in memory, shut
+// down when the test ends
+func newTestServer(t *testing.T) *server.ChatServer {
+	t.Helper()
+	srv, err := server.New(server.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		srv.Shutdown(ctx)
+	})
+	return srv
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"bridges", Config{Server: "irc.example.com:6667", Channels: map[string]string{"general": "#chat"}}, false},
+		{"local channel", Config{Server: "irc.example.com:6667", Channels: map[string]string{"general": "&chat"}}, false},
+		{"no server", Config{Channels: map[string]string{"general": "#chat"}}, true},
+		{"no channels", Config{Server: "irc.example.com:6667"}, true},
+		{"not a channel", Config{Server: "irc.example.com:6667", Channels: map[string]string{"general": "chat"}}, true},
+		{"space in the channel", Config{Server: "irc.example.com:6667", Channels: map[string]string{"general": "#my chat"}}, true},
+		{"comma in the channel", Config{Server: "irc.example.com:6667", Channels: map[string]string{"general": "#a,#b"}}, true},
+		{"channel bridged twice", Config{Server: "irc.example.com:6667", Channels: map[string]string{"general": "#chat", "other": "#CHAT"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(newTestServer(t), tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChatName(t *testing.T) {
+	srv := newTestServer(t)
+	b, err := New(srv, Config{
+		Server:   "irc.example.com:6667",
+		Channels: map[string]string{"general": "#chat"},
+		Nicks:    map[string]string{"Alice": "alice"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom, err := New(srv, Config{
+		Server:   "irc.example.com:6667",
+		Channels: map[string]string{"general": "#chat"},
+		Suffix:   "@libera",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		bridge *Bridge
+		nick   string
+		want   string
+	}{
+		{b, "Alice", "alice"},
+		{b, "ALICE", "alice"},
+		{b, "bob", "bob" + DefaultSuffix},
+		{custom, "Alice", "Alice@libera"},
+	}
+	for _, tt := range tests {
+		if got := tt.bridge.chatName(tt.nick); got != tt.want {
+			t.Errorf("chatName(%q) = %q, want %q", tt.nick, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// minPasswordLength is the shortest password Register accepts
+const minPasswordLength = 8
+
+// account is a registered user whose name is protected by a password
+type account struct {
+	Name         string
+	PasswordHash []byte // bcrypt hash
+	Created      time.Time
+	Role         chat.Role // RoleUser or above
+	Blocked      []string  `json:",omitempty"` // users whose messages are hidden from this one
+
+	// Admin is how older versions marked the first account, which is the
+	// owner's. Loading turns it into Role, as accounts from before roles
+	// have none.
+	Admin bool `json:",omitempty"`
+}
+
+// loadAccounts reads the accounts file, if it exists
+func (s *ChatServer) loadAccounts(path string) error {
+	s.accountsPath = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var accounts []*account
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for _, a := range accounts {
+		a.Role = max(a.Role, chat.RoleUser)
+		if a.Admin {
+			a.Role, a.Admin = chat.RoleOwner, false
+		}
+		s.accounts[a.Name] = a
+		if len(a.Blocked) > 0 {
+			s.blocks[a.Name] = make(map[string]bool)
+			for _, name := range a.Blocked {
+				s.blocks[a.Name][name] = true
+			}
+		}
+	}
+	return nil
+}
+
+// saveAccounts rewrites the accounts file. It writes a temporary file and
+// renames it so a crash never leaves a truncated file behind.
+// The caller must hold s.mu.
+func (s *ChatServer) saveAccounts() error {
+	if s.accountsPath == "" {
+		return nil
+	}
+
+	accounts := make([]*account, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		accounts = append(accounts, a)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Name < accounts[j].Name })
+
+	data, err := json.MarshalIndent(accounts, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.accountsPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.accountsPath)
+}
+
+// Register creates an account so that nobody else can use its name, even
+// while its owner is offline. The name must not belong to someone else who
+// is currently logged in.
+func (c *chatConn) Register(args *chat.AccountArgs, _ *struct{}) error {
+	name := strings.TrimSpace(args.Name)
+	if name == "" {
+		return errors.New("name is required")
+	}
+	if len(args.Password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+
+	// Hashing is deliberately slow, so do it before taking the lock
+	hash, err := bcrypt.GenerateFromPassword([]byte(args.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.accounts[name]; ok {
+		return fmt.Errorf("name %q is already registered", name)
+	}
+	if sess, ok := c.online[name]; ok && sess.conn != c {
+		return fmt.Errorf("name %q is in use by someone else", name)
+	}
+	if c.isBot(name) {
+		return fmt.Errorf("name %q belongs to a bot", name)
+	}
+	if c.bannedNames[name] {
+		return errors.New("you are banned from this server")
+	}
+
+	// Without admins on the command line, the first account runs the server
+	acct := &account{Name: name, PasswordHash: hash, Created: time.Now(), Role: chat.RoleUser}
+	if len(c.accounts) == 0 && len(c.admins) == 0 {
+		acct.Role = chat.RoleOwner
+	}
+	c.accounts[name] = acct
+	if err := c.saveAccounts(); err != nil {
+		delete(c.accounts, name)
+		slog.Error("Error saving accounts", "err", err)
+		return errors.New("could not save account")
+	}
+	slog.Info("Registered account", "name", name)
+	if acct.Role == chat.RoleOwner {
+		slog.Info("First account has been made the owner", "name", name)
+	}
+
+	return nil
+}
+
+// checkPassword verifies the password of a registered account. The caller
+// must not hold c.mu, as checking takes a while on purpose.
+func (c *chatConn) checkPassword(name, password string) error {
+	c.mu.Lock()
+	acct, ok := c.accounts[name]
+	c.mu.Unlock()
+	if !ok {
+		return errors.New("invalid name or password")
+	}
+	if bcrypt.CompareHashAndPassword(acct.PasswordHash, []byte(password)) != nil {
+		slog.Warn("Failed login", "name", name, "ip", c.ip)
+		return errors.New("invalid name or password")
+	}
+	return nil
+}
+
+// Authenticate logs in to a registered account with its password and
+// returns a session token, like Login does for unregistered names
+func (c *chatConn) Authenticate(args *chat.AccountArgs, reply *chat.LoginReply) error {
+	name := strings.TrimSpace(args.Name)
+	if err := c.checkPassword(name, args.Password); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sess, err := c.login(name)
+	if err != nil {
+		return err
+	}
+	reply.Token = sess.token
+
+	return nil
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// Defaults for the assistant's Config settings, and limits on asking it
+const (
+	defaultAssistantName      = "assistant"
+	defaultAssistantMaxTokens = 512
+	defaultAssistantRate      = 5                // questions a minute per user
+	assistantTimeout          = 60 * time.Second // for each answer
+	maxAssistantAnswer        = 64 << 10         // bytes of the API's response read
+	maxAssistantAsks          = 4                // questions being answered at once; more are turned away
+)
+
+// assistant is the built-in bot that answers questions with a large
+// language model. It is a MessageHook, so it sees messages as they are
+// posted.
+type assistant struct {
+	BaseHook
+
+	server    *ChatServer
+	name      string
+	url       string
+	key       string
+	model     string
+	maxTokens int
+	rate      float64 // questions a minute per user
+	client    *http.Client
+	slots     chan struct{} // holds a value for each question being answered
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket // each user's questions
+}
+
+// completionRequest is the body of a request to an OpenAI-compatible chat
+// completions API
+type completionRequest struct {
+	Model     string              `json:"model,omitempty"`
+	Messages  []completionMessage `json:"messages"`
+	MaxTokens int                 `json:"max_tokens"`
+}
+
+type completionMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// completionResponse is the part of the API's answer the assistant uses
+type completionResponse struct {
+	Choices []struct {
+		Message completionMessage `json:"message"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// newAssistant returns the assistant config asks for, or nil if it has no
+// API URL
+func newAssistant(s *ChatServer, config Config) *assistant {
+	if config.AssistantURL == "" {
+		return nil
+	}
+	a := &assistant{
+		server:    s,
+		name:      defaultAssistantName,
+		url:       config.AssistantURL,
+		key:       config.AssistantKey,
+		model:     config.AssistantModel,
+		maxTokens: defaultAssistantMaxTokens,
+		rate:      defaultAssistantRate,
+		client:    &http.Client{Timeout: assistantTimeout},
+		slots:     make(chan struct{}, maxAssistantAsks),
+		buckets:   make(map[string]*tokenBucket),
+	}
+	if name := strings.TrimSpace(config.AssistantName); name != "" {
+		a.name = name
+	}
+	if config.AssistantMaxTokens > 0 {
+		a.maxTokens = config.AssistantMaxTokens
+	}
+	if config.AssistantRate > 0 {
+		a.rate = config.AssistantRate
+	}
+	return a
+}
+
+// question returns what msg asks the assistant, and whether it asks it
+// anything: direct messages to it, and chat in rooms that mentions it
+func (a *assistant) question(msg chat.Message) (string, bool) {
+	if msg.Kind != chat.KindChat || msg.Sender == a.name || msg.Sender == "" {
+		return "", false
+	}
+	if msg.To != "" {
+		return strings.TrimSpace(msg.Body), msg.To == a.name
+	}
+	mention := "@" + a.name
+	asked := false
+	var words []string
+	for _, word := range strings.Fields(msg.Body) {
+		if strings.TrimRight(word, ".,:;!?)'\"") == mention {
+			asked = true
+			continue
+		}
+		words = append(words, word)
+	}
+	return strings.Join(words, " "), asked
+}
+
+// OnAfterSend answers the questions asked of the assistant, each from a
+// goroutine of its own so that other hooks do not wait for the API
+func (a *assistant) OnAfterSend(msg chat.Message) {
+	question, asked := a.question(msg)
+	if !asked {
+		return
+	}
+	if question == "" {
+		a.answer(msg, "Ask me something after @"+a.name+", e.g. @"+a.name+" what is a goroutine?")
+		return
+	}
+	if !a.allow(msg.Sender) {
+		a.answer(msg, fmt.Sprintf("You can ask me %g questions a minute, please wait a little", a.rate))
+		return
+	}
+	select {
+	case a.slots <- struct{}{}:
+	default:
+		a.answer(msg, "I am busy answering others, please ask again in a moment")
+		return
+	}
+	go func() {
+		defer func() { <-a.slots }()
+		text, err := a.complete(msg.Sender, question)
+		if err != nil {
+			slog.Error("Error asking the assistant", "id", msg.ID, "from", msg.Sender, "err", err)
+			text = "Sorry, I cannot answer right now"
+		}
+		a.answer(msg, text)
+	}()
+}
+
+// allow reports whether name may ask another question now
+func (a *assistant) allow(name string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	burst := max(int(a.rate), 1)
+	now := time.Now()
+	for n, b := range a.buckets {
+		if b.idle(a.rate/60, burst, now) {
+			delete(a.buckets, n)
+		}
+	}
+	b, ok := a.buckets[name]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		a.buckets[name] = b
+	}
+	return b.take(1, a.rate/60, burst, now)
+}
+
+// complete asks the API for an answer to question from the user called
+// from
+func (a *assistant) complete(from, question string) (string, error) {
+	body, err := json.Marshal(completionRequest{
+		Model: a.model,
+		Messages: []completionMessage{
+			{Role: "system", Content: fmt.Sprintf("You are %s, a helpful assistant in a chat room. Answer in a few sentences of plain text or Markdown.", a.name)},
+			{Role: "user", Content: from + " asks: " + question},
+		},
+		MaxTokens: a.maxTokens,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := a.server.requestContext(assistantTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "chatroom-assistant/"+Version)
+	if a.key != "" {
+		req.Header.Set("Authorization", "Bearer "+a.key)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var reply completionResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxAssistantAnswer)).Decode(&reply); err != nil && resp.StatusCode == http.StatusOK {
+		return "", fmt.Errorf("decoding the answer: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if reply.Error != nil {
+			return "", fmt.Errorf("status %s: %s", resp.Status, reply.Error.Message)
+		}
+		return "", fmt.Errorf("status %s", resp.Status)
+	}
+	if len(reply.Choices) == 0 || strings.TrimSpace(reply.Choices[0].Message.Content) == "" {
+		return "", errors.New("the answer is empty")
+	}
+	return strings.TrimSpace(reply.Choices[0].Message.Content), nil
+}
+
+// answer posts text as the assistant's reply to msg: privately to a
+// direct message, or as a reply in its room. Answers are filtered like
+// users' messages and cut to the longest message allowed.
+func (a *assistant) answer(msg chat.Message, text string) {
+	s := a.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopping {
+		return
+	}
+	text, err := s.filterText(text)
+	if err != nil {
+		text = "Sorry, I cannot answer that here"
+	}
+	text = truncateText(text, s.maxLength)
+
+	var reply chat.Message
+	if msg.To != "" {
+		reply = s.newMessage("", a.name, msg.Sender, text)
+	} else {
+		r, ok := s.rooms[msg.Room]
+		if !ok {
+			return
+		}
+		reply = s.newMessage(msg.Room, a.name, "", text)
+		reply.InReplyTo = msg.ID
+		if reply.Thread, err = threadOf(r, msg.Room, msg.ID); err != nil {
+			// The question was deleted meanwhile
+			return
+		}
+	}
+	reply.Mentions = s.mentions(text)
+	if _, err := s.post(&reply); err != nil {
+		slog.Error("Error posting the assistant's answer", "id", msg.ID, "err", err)
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// Audited actions
+const (
+	auditKick          = "kick"
+	auditBan           = "ban"
+	auditUnban         = "unban"
+	auditMute          = "mute"
+	auditShadowMute    = "shadow-mute"
+	auditUnmute        = "unmute"
+	auditAutoMute      = "auto-mute"
+	auditDelete        = "delete"
+	auditSetRole       = "set-role"
+	auditSetMOTD       = "set-motd"
+	auditReloadFilter  = "reload-filter"
+	auditResolveReport = "resolve-report"
+	auditPrune         = "prune"
+	auditPurge         = "purge"
+	auditReloadConfig  = "reload-config"
+	auditAddWebhook    = "add-webhook"
+	auditRemoveWebhook = "remove-webhook"
+	auditAddBot        = "add-bot"
+	auditRemoveBot     = "remove-bot"
+)
+
+// loadAudit reads the audit log, a JSON Lines file with one entry per
+// line, if it exists, and remembers the path to append new entries to
+func (s *ChatServer) loadAudit(path string) error {
+	s.auditPath = path
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var entry chat.AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// Most likely a write cut short by a crash; skip it
+			slog.Warn("Skipping corrupt audit line", "line", line, "path", path, "err", err)
+			continue
+		}
+		s.audit = append(s.audit, entry)
+	}
+	return scanner.Err()
+}
+
+// record adds an entry to the audit log. actor is empty for actions the
+// server takes by itself. Failing to save it is logged but does not undo
+// the action. The caller must hold s.mu, for reading at least.
+func (s *ChatServer) record(actor, action, target, detail, reason string) {
+	entry := chat.AuditEntry{
+		Time:   time.Now(),
+		Actor:  actor,
+		Action: action,
+		Target: target,
+		Detail: detail,
+		Reason: reason,
+	}
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+	s.audit = append(s.audit, entry)
+	if s.auditPath == "" {
+		return
+	}
+
+	f, err := os.OpenFile(s.auditPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err == nil {
+		err = json.NewEncoder(f).Encode(entry)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		slog.Error("Error saving audit entry", "action", action, "target", target, "err", err)
+	}
+}
+
+// GetAuditLog returns the newest entries of the audit log, oldest first,
+// optionally only those where args.User is the actor or the target.
+// Only admins can read it.
+func (c *chatConn) GetAuditLog(args *chat.AuditArgs, reply *chat.AuditReply) error {
+	if args.Limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if _, err := c.authorize(args.Token, args.Name, permViewAudit); err != nil {
+		return err
+	}
+	c.auditMu.Lock()
+	defer c.auditMu.Unlock()
+	for i := len(c.audit) - 1; i >= 0 && (args.Limit == 0 || len(reply.Entries) < args.Limit); i-- {
+		if e := c.audit[i]; args.User == "" || e.Actor == args.User || e.Target == args.User {
+			reply.Entries = append(reply.Entries, e)
+		}
+	}
+	slices.Reverse(reply.Entries)
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// SendMessages posts several messages in one call, such as those a client
+// queued while it was disconnected. Every message is checked before any is
+// posted, so either all of them are posted, one after the other, or none
+// is. The reply holds the IDs they were given.
+func (c *chatConn) SendMessages(args *chat.BatchArgs, reply *chat.BatchReply) error {
+	received := time.Now()
+	if len(args.Messages) == 0 {
+		return errors.New("no messages to send")
+	}
+	if len(args.Messages) > chat.MaxBatch {
+		return fmt.Errorf("at most %d messages can be sent at once", chat.MaxBatch)
+	}
+	for i, m := range args.Messages {
+		if err := c.checkLength(m.Message); err != nil {
+			return fmt.Errorf("message %d: %w", i+1, err)
+		}
+	}
+
+	return c.posting(c.mu.RLocker(), func() (*storeJob, error) {
+		if args.Timeout > 0 && time.Since(received) > args.Timeout {
+			return nil, errors.New("the server is too busy, the messages were not sent")
+		}
+
+		from, err := c.sender(args.Token, args.Name)
+		if err != nil {
+			return nil, err
+		}
+		shadow, err := c.checkMuted(from)
+		if err != nil {
+			return nil, err
+		}
+		// Messages sent before, in an earlier call or earlier in this one,
+		// are answered with the ID they were given instead of being posted
+		reply.IDs = make([]int64, len(args.Messages))
+		var messages []chat.Message
+		var fresh []int               // index in args.Messages of each of messages
+		repeats := make(map[int]int)  // index of a repeat to that of the first
+		first := make(map[string]int) // ClientID to the index it first came at
+		for i, m := range args.Messages {
+			if id, ok := c.sentBefore(from, m.ClientID); ok {
+				reply.IDs[i] = id
+				continue
+			}
+			if m.ClientID != "" {
+				if j, ok := first[m.ClientID]; ok {
+					repeats[i] = j
+					continue
+				}
+				first[m.ClientID] = i
+			}
+			msg, err := c.batchMessage(from, m)
+			if err != nil {
+				return nil, fmt.Errorf("message %d: %w", i+1, err)
+			}
+			messages = append(messages, msg)
+			fresh = append(fresh, i)
+		}
+		if len(messages) == 0 {
+			return nil, nil
+		}
+
+		// Only count the messages against the limits once they all passed
+		if err := c.checkBatchRate(len(messages)); err != nil {
+			return nil, err
+		}
+		for _, i := range fresh {
+			if err := c.checkSpam(from, args.Messages[i].Message); err != nil {
+				return nil, err
+			}
+		}
+
+		var job *storeJob
+		if shadow {
+			for k := range messages {
+				c.echo(&messages[k])
+			}
+		} else if job, err = c.postAll(messages); err != nil {
+			return nil, err
+		}
+		for k, msg := range messages {
+			reply.IDs[fresh[k]] = msg.ID
+		}
+		for i, j := range repeats {
+			reply.IDs[i] = reply.IDs[j]
+		}
+
+		slog.Debug("Received messages", "from", from, "count", len(messages), "first", messages[0].ID, "shadow", shadow)
+		return job, nil
+	})
+}
+
+// batchMessage checks that from may post m and returns it as a message
+// that has yet to be stamped. The caller must hold c.mu, for reading at
+// least.
+func (c *chatConn) batchMessage(from string, m chat.BatchMessage) (chat.Message, error) {
+	name := roomName(m.Room)
+	r, err := c.joinedRoom(from, name)
+	if err != nil {
+		return chat.Message{}, err
+	}
+	thread, err := threadOf(r, name, m.InReplyTo)
+	if err != nil {
+		return chat.Message{}, err
+	}
+	text, err := c.filterText(m.Message)
+	if err != nil {
+		return chat.Message{}, err
+	}
+	kind := chat.KindChat
+	if m.Emote {
+		kind = chat.KindEmote
+	}
+	return chat.Message{
+		Kind:      kind,
+		Room:      name,
+		Sender:    from,
+		Body:      text,
+		InReplyTo: m.InReplyTo,
+		Thread:    thread,
+		Mentions:  c.mentions(text),
+		ClientID:  m.ClientID,
+	}, nil
+}
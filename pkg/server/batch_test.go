@@ -0,0 +1,107 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// failingStore is a store that cannot save anything
+type failingStore struct{ memoryStore }
+
+func (failingStore) Append(chat.Message) error      { return errors.New("disk full") }
+func (failingStore) AppendAll([]chat.Message) error { return errors.New("disk full") }
+
+func TestSendMessages(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config // with MaxLength 100
+		messages []chat.BatchMessage
+		wantErr  bool
+		posted   []string // bodies in the default room afterwards
+	}{
+		{
+			name:     "all posted",
+			messages: []chat.BatchMessage{{Message: "one"}, {Message: "two", Emote: true}, {Message: "three"}},
+			posted:   []string{"one", "two", "three"},
+		},
+		{
+			name:     "repeated ClientID",
+			messages: []chat.BatchMessage{{Message: "one", ClientID: "a"}, {Message: "one", ClientID: "a"}, {Message: "two", ClientID: "b"}},
+			posted:   []string{"one", "two"},
+		},
+		{
+			name:     "nothing",
+			messages: nil,
+			wantErr:  true,
+		},
+		{
+			name:     "too many",
+			messages: make([]chat.BatchMessage, chat.MaxBatch+1),
+			wantErr:  true,
+		},
+		{
+			name:     "one too long",
+			messages: []chat.BatchMessage{{Message: "one"}, {Message: strings.Repeat("a", 101)}, {Message: "three"}},
+			wantErr:  true,
+		},
+		{
+			name:     "one to a room not joined",
+			messages: []chat.BatchMessage{{Message: "one"}, {Room: "nowhere", Message: "two"}},
+			wantErr:  true,
+		},
+		{
+			name:     "one replying to nothing",
+			messages: []chat.BatchMessage{{Message: "one"}, {Message: "two", InReplyTo: 12345}},
+			wantErr:  true,
+		},
+		{
+			name:     "over the rate limit",
+			config:   Config{RateLimit: 1, RateBurst: 2},
+			messages: []chat.BatchMessage{{Message: "one"}, {Message: "two"}, {Message: "three"}},
+			wantErr:  true,
+		},
+		{
+			name:     "not saved",
+			config:   Config{Store: failingStore{}},
+			messages: []chat.BatchMessage{{Message: "one"}, {Message: "two"}},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.MaxLength = 100
+			s := newTestServer(t, tt.config)
+			c := &chatConn{ChatServer: s}
+			c.mu.Lock()
+			sess, err := c.login("alice")
+			c.mu.Unlock()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var reply chat.BatchReply
+			err = c.SendMessages(&chat.BatchArgs{Name: "alice", Token: sess.token, Messages: tt.messages}, &reply)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SendMessages() error = %v, want an error: %v", err, tt.wantErr)
+			}
+
+			c.mu.RLock()
+			var posted []string
+			for _, m := range s.rooms[chat.DefaultRoom].history.since(0) {
+				if m.Sender == "alice" {
+					posted = append(posted, m.Body)
+				}
+			}
+			c.mu.RUnlock()
+			if strings.Join(posted, "|") != strings.Join(tt.posted, "|") {
+				t.Errorf("posted %q, want %q", posted, tt.posted)
+			}
+			if err == nil && len(reply.IDs) != len(tt.messages) {
+				t.Errorf("got %d IDs for %d messages", len(reply.IDs), len(tt.messages))
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"strings"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// blockedBy returns a filter reporting the messages name should not see
+// because they blocked the sender, or nil if they have blocked nobody.
+// The filter works on a copy of the set, so it may be used after s.mu is
+// released. The caller must hold s.mu, for reading at least.
+func (s *ChatServer) blockedBy(name string) func(chat.Message) bool {
+	blocked := maps.Clone(s.blocks[name])
+	if len(blocked) == 0 {
+		return nil
+	}
+	return func(m chat.Message) bool {
+		return blocked[m.Sender]
+	}
+}
+
+// visible removes the messages name has blocked the senders of, reusing
+// the slice. The caller must hold s.mu.
+func (s *ChatServer) visible(name string, messages []chat.Message) []chat.Message {
+	return without(messages, s.blockedBy(name))
+}
+
+// without removes the messages hide reports true for, reusing the slice.
+// A nil hide, as blockedBy returns, removes nothing.
+func without(messages []chat.Message, hide func(chat.Message) bool) []chat.Message {
+	if hide == nil {
+		return messages
+	}
+	kept := messages[:0]
+	for _, m := range messages {
+		if !hide(m) {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+// reader returns the name of the user with the session token, or an empty
+// name if there is no such session. The caller must hold s.mu.
+func (s *ChatServer) reader(token string) string {
+	if sess, ok := s.tokens[token]; ok {
+		return sess.name
+	}
+	return ""
+}
+
+// setBlocked adds target to or removes it from the users name has
+// blocked, saving the change with their account if they have one.
+// The caller must hold s.mu.
+func (s *ChatServer) setBlocked(name, target string, block bool) error {
+	blocked := s.blocks[name]
+	if blocked == nil {
+		blocked = make(map[string]bool)
+		s.blocks[name] = blocked
+	}
+	was := blocked[target]
+	if block {
+		blocked[target] = true
+	} else {
+		delete(blocked, target)
+	}
+
+	acct, ok := s.accounts[name]
+	if !ok {
+		return nil
+	}
+	old := acct.Blocked
+	acct.Blocked = sortedNames(blocked)
+	if err := s.saveAccounts(); err != nil {
+		acct.Blocked = old
+		if was {
+			blocked[target] = true
+		} else {
+			delete(blocked, target)
+		}
+		slog.Error("Error saving accounts", "err", err)
+		return errors.New("could not save your blocked users")
+	}
+	return nil
+}
+
+// BlockUser hides every message and private message from a user from the
+// caller, including the ones already sent. The blocked user is not told.
+func (c *chatConn) BlockUser(args *chat.BlockArgs, _ *struct{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name, err := c.sender(args.Token, args.Name)
+	if err != nil {
+		return err
+	}
+	target := strings.TrimSpace(args.Target)
+	if target == "" {
+		return errors.New("a name to block is required")
+	}
+	if target == name {
+		return errors.New("you cannot block yourself")
+	}
+	if c.blocks[name][target] {
+		return fmt.Errorf("you have already blocked %s", target)
+	}
+	if err := c.setBlocked(name, target, true); err != nil {
+		return err
+	}
+	slog.Info("Blocked user", "name", target, "by", name)
+
+	return nil
+}
+
+// UnblockUser shows a blocked user's messages to the caller again
+func (c *chatConn) UnblockUser(args *chat.BlockArgs, _ *struct{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name, err := c.sender(args.Token, args.Name)
+	if err != nil {
+		return err
+	}
+	target := strings.TrimSpace(args.Target)
+	if !c.blocks[name][target] {
+		return fmt.Errorf("you have not blocked %s", target)
+	}
+	if err := c.setBlocked(name, target, false); err != nil {
+		return err
+	}
+	slog.Info("Unblocked user", "name", target, "by", name)
+
+	return nil
+}
+
+// GetBlocked returns the users the caller has blocked, sorted by name
+func (c *chatConn) GetBlocked(args *chat.UserArgs, reply *chat.UsersReply) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	name, err := c.sender(args.Token, args.Name)
+	if err != nil {
+		return err
+	}
+	reply.Users = sortedNames(c.blocks[name])
+
+	return nil
+}
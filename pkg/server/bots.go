@@ -0,0 +1,369 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// bot is how a bot is kept in the bots file. Only the hash of its token
+// is kept, like the hash of an account's password.
+type bot struct {
+	chat.Bot
+	TokenHash string
+}
+
+// restHookPost is the body of POST /hooks. It has the fields of what
+// Slack's and Discord's webhooks take, so that messages from them can be
+// forwarded as they are.
+type restHookPost struct {
+	Text     string `json:"text"`
+	Content  string `json:"content"`  // Discord's name for Text
+	Username string `json:"username"` // who on the other side wrote it, if anyone
+	Emote    bool   `json:"emote"`    // post text as an action, like /me
+}
+
+// maxBotUsername limits the length of a username bots post under
+const maxBotUsername = 64
+
+// hashBotToken returns the hash of a bot token that is kept in its place
+func hashBotToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadBots reads the bots, if the file exists, and remembers the path to
+// save changes to
+func (s *ChatServer) loadBots(path string) error {
+	s.botsPath = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &s.bots); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for _, b := range s.bots {
+		s.nextBotID = max(s.nextBotID, b.ID)
+	}
+	return nil
+}
+
+// saveBots rewrites the bots file the same way saveAccounts does.
+// The caller must hold s.mu.
+func (s *ChatServer) saveBots() error {
+	if s.botsPath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.bots, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.botsPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.botsPath)
+}
+
+// isBot reports whether name belongs to a bot, or to the assistant, which
+// users may not log in as. So do the names bots post others' messages
+// under, and those ending in a suffix reserved with ReserveSuffix.
+// The caller must hold s.mu.
+func (s *ChatServer) isBot(name string) bool {
+	if s.assistant != nil && name == s.assistant.name {
+		return true
+	}
+	for _, suffix := range s.reservedSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	for _, b := range s.bots {
+		if b.Name == name || strings.HasSuffix(name, "["+b.Name+"]") {
+			return true
+		}
+	}
+	return false
+}
+
+// AddBot lets an admin create a bot that posts to a room, returning the
+// token external systems post its messages with
+func (c *chatConn) AddBot(args *chat.BotArgs, reply *chat.BotReply) error {
+	name := strings.TrimSpace(args.Bot)
+	if name == "" {
+		return errors.New("the bot needs a name")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	admin, err := c.authorize(args.Token, args.Name, permBots)
+	if err != nil {
+		return err
+	}
+	room := roomName(args.Room)
+	if _, err := c.findRoom(room); err != nil {
+		return err
+	}
+	if _, ok := c.accounts[name]; ok {
+		return fmt.Errorf("name %q is registered", name)
+	}
+	if _, ok := c.online[name]; ok || c.isBot(name) {
+		return fmt.Errorf("name %q is already taken", name)
+	}
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	c.nextBotID++
+	b := bot{
+		Bot: chat.Bot{
+			ID:      c.nextBotID,
+			Name:    name,
+			Room:    room,
+			Creator: admin,
+			Created: time.Now(),
+		},
+		TokenHash: hashBotToken(token),
+	}
+	c.bots = append(c.bots, b)
+	if err := c.saveBots(); err != nil {
+		c.bots = c.bots[:len(c.bots)-1]
+		slog.Error("Error saving bots", "err", err)
+		return errors.New("could not save the bot")
+	}
+	reply.ID = b.ID
+	reply.Token = token
+
+	slog.Info("Added bot", "bot", b.ID, "name", name, "room", room, "by", admin)
+	c.record(admin, auditAddBot, name, "posts to "+room, "")
+	return nil
+}
+
+// RemoveBot lets an admin delete a bot, after which its token no longer
+// works. The messages it sent stay.
+func (c *chatConn) RemoveBot(args *chat.BotArgs, _ *struct{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	admin, err := c.authorize(args.Token, args.Name, permBots)
+	if err != nil {
+		return err
+	}
+	for i, b := range c.bots {
+		if b.ID != args.ID {
+			continue
+		}
+		old := c.bots
+		c.bots = append(c.bots[:i:i], c.bots[i+1:]...)
+		if err := c.saveBots(); err != nil {
+			c.bots = old
+			slog.Error("Error saving bots", "err", err)
+			return errors.New("could not remove the bot")
+		}
+		c.limitsMu.Lock()
+		delete(c.botBuckets, b.ID)
+		c.limitsMu.Unlock()
+		slog.Info("Removed bot", "bot", b.ID, "name", b.Name, "by", admin)
+		c.record(admin, auditRemoveBot, b.Name, "", "")
+		return nil
+	}
+	return fmt.Errorf("bot %d not found", args.ID)
+}
+
+// ListBots returns the bots, oldest first
+func (c *chatConn) ListBots(args *chat.UserArgs, reply *chat.BotsReply) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if _, err := c.authorize(args.Token, args.Name, permBots); err != nil {
+		return err
+	}
+	for _, b := range c.bots {
+		reply.Bots = append(reply.Bots, b.Bot)
+	}
+	return nil
+}
+
+// botByToken returns the bot a token belongs to. The caller must hold
+// s.mu.
+func (s *ChatServer) botByToken(token string) (bot, bool) {
+	hash := hashBotToken(token)
+	for _, b := range s.bots {
+		if subtle.ConstantTimeCompare([]byte(b.TokenHash), []byte(hash)) == 1 {
+			return b, true
+		}
+	}
+	return bot{}, false
+}
+
+// checkBotRate returns an error if a bot is posting faster than the limit
+// each bot token has, which is separate from the users' one.
+// The caller must hold s.mu, for reading at least.
+func (s *ChatServer) checkBotRate(b bot) error {
+	if s.botRate <= 0 {
+		return nil
+	}
+	s.limitsMu.Lock()
+	defer s.limitsMu.Unlock()
+	bucket, ok := s.botBuckets[b.ID]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(s.botBurst), last: time.Now()}
+		s.botBuckets[b.ID] = bucket
+	}
+	if !bucket.take(1, s.botRate, s.botBurst, time.Now()) {
+		slog.Warn("Rate limited bot", "bot", b.ID, "name", b.Name)
+		return fmt.Errorf("rate limit exceeded: at most %g messages per second, please slow down", s.botRate)
+	}
+	return nil
+}
+
+// botSender returns the name a bot posts a message from username under:
+// its own, or with a username "username[bot]", e.g. "bob[slack]", so that
+// bots cannot pass for users
+func botSender(b bot, username string) string {
+	username = strings.Join(strings.Fields(username), " ")
+	if username == "" {
+		return b.Name
+	}
+	return truncateText(username, maxBotUsername) + "[" + b.Name + "]"
+}
+
+// postAsBot posts text to a bot's room under the name sender, its own or
+// one from botSender, and returns the message and the job saving it. Bots
+// are filtered, muted and banned like users. The caller must hold s.mu,
+// for reading at least.
+func (s *ChatServer) postAsBot(b bot, sender, text string, kind chat.MessageKind) (chat.Message, *storeJob, error) {
+	if _, err := s.findRoom(b.Room); err != nil {
+		return chat.Message{}, nil, err
+	}
+	if s.bannedNames[b.Name] {
+		return chat.Message{}, nil, errors.New("the bot is banned from this server")
+	}
+	shadow, err := s.checkMuted(b.Name)
+	if err != nil {
+		return chat.Message{}, nil, err
+	}
+	if text, err = s.filterText(text); err != nil {
+		return chat.Message{}, nil, err
+	}
+
+	msg := s.newMessage(b.Room, sender, "", text)
+	msg.Kind = kind
+	msg.Mentions = s.mentions(text)
+	if shadow {
+		s.stamp(&msg)
+		slog.Debug("Dropped message from shadow-muted bot", "id", msg.ID, "name", b.Name)
+		return msg, nil, nil
+	}
+	job, err := s.post(&msg)
+	if err != nil {
+		return chat.Message{}, nil, err
+	}
+	slog.Debug("Received message from bot", "id", msg.ID, "bot", b.ID, "room", b.Room)
+	return msg, job, nil
+}
+
+func (s *ChatServer) restPostHook(w http.ResponseWriter, r *http.Request) {
+	// Turn strangers away before reading what they send
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="chat"`)
+		writeJSON(w, http.StatusUnauthorized, restError{"give the bot's token in an Authorization: Bearer header or as ?token="})
+		return
+	}
+	s.mu.RLock()
+	_, known := s.botByToken(token)
+	s.mu.RUnlock()
+	if !known {
+		writeJSON(w, http.StatusUnauthorized, restError{"unknown bot token"})
+		return
+	}
+
+	var post restHookPost
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+		// What Slack's outgoing webhooks send
+		s.limitBody(w, r)
+		if err := r.ParseForm(); err != nil {
+			if tooLarge := new(http.MaxBytesError); errors.As(err, &tooLarge) {
+				writeJSON(w, http.StatusRequestEntityTooLarge, restError{"request body is too large"})
+			} else {
+				writeJSON(w, http.StatusBadRequest, restError{"invalid form: " + err.Error()})
+			}
+			return
+		}
+		post.Text, post.Username = r.PostForm.Get("text"), r.PostForm.Get("user_name")
+	} else if !s.readJSON(w, r, &post) {
+		return
+	}
+
+	text := strings.TrimSpace(post.Text)
+	if text == "" {
+		text = strings.TrimSpace(post.Content)
+	}
+	if text == "" {
+		writeJSON(w, http.StatusBadRequest, restError{"text is required"})
+		return
+	}
+	if err := s.checkLength(text); err != nil {
+		writeJSON(w, http.StatusBadRequest, restError{err.Error()})
+		return
+	}
+	kind := chat.KindChat
+	if post.Emote {
+		kind = chat.KindEmote
+	}
+
+	// Looked up again, in case the bot was removed while its post was read
+	s.mu.RLock()
+	b, known := s.botByToken(token)
+	var msg chat.Message
+	var job *storeJob
+	var status int
+	var err error
+	switch {
+	case !known:
+		status, err = http.StatusUnauthorized, errors.New("unknown bot token")
+	default:
+		if err = s.checkBotRate(b); err != nil {
+			status = http.StatusTooManyRequests
+		} else if msg, job, err = s.postAsBot(b, botSender(b, post.Username), text, kind); err != nil {
+			status = http.StatusBadRequest
+		}
+	}
+	s.mu.RUnlock()
+	if err == nil {
+		if err = job.wait(); err != nil {
+			status = http.StatusInternalServerError
+		}
+	}
+
+	if status == http.StatusTooManyRequests {
+		w.Header().Set("Retry-After", "1")
+	}
+	if err != nil {
+		writeJSON(w, status, restError{err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusCreated, msg)
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+func TestRESTPostHook(t *testing.T) {
+	s := newTestServer(t, Config{MaxLength: 100})
+	s.bots = append(s.bots, bot{
+		Bot:       chat.Bot{ID: 1, Name: "slack", Room: chat.DefaultRoom},
+		TokenHash: hashBotToken("secret"),
+	})
+	handler := s.RESTHandler()
+
+	const form = "application/x-www-form-urlencoded"
+	tests := []struct {
+		name        string
+		target      string
+		auth        string // Authorization header
+		contentType string
+		body        string
+		status      int
+		sender      string // of the message posted
+		text        string
+	}{
+		{"no token", "/hooks", "", "", `{"text": "hi"}`, http.StatusUnauthorized, "", ""},
+		{"unknown token", "/hooks", "Bearer wrong", "", `{"text": "hi"}`, http.StatusUnauthorized, "", ""},
+		{"basic auth is no token", "/hooks", "Basic c2xhY2s6c2VjcmV0", "", `{"text": "hi"}`, http.StatusUnauthorized, "", ""},
+		{"bearer token", "/hooks", "Bearer secret", "", `{"text": "hi"}`, http.StatusCreated, "slack", "hi"},
+		{"token in the query", "/hooks?token=secret", "", "", `{"text": "hi"}`, http.StatusCreated, "slack", "hi"},
+		{"username", "/hooks", "Bearer secret", "", `{"text": "hi", "username": "bob"}`, http.StatusCreated, "bob[slack]", "hi"},
+		{"Discord content", "/hooks", "Bearer secret", "", `{"content": "hi", "username": "bob"}`, http.StatusCreated, "bob[slack]", "hi"},
+		{"JSON with a charset", "/hooks", "Bearer secret", "application/json; charset=utf-8", `{"text": "hi"}`, http.StatusCreated, "slack", "hi"},
+		{"form", "/hooks?token=secret", "", form, "text=hi+there&user_name=bob", http.StatusCreated, "bob[slack]", "hi there"},
+		{"form without a token", "/hooks", "", form, "text=hi&token=secret", http.StatusUnauthorized, "", ""},
+		{"invalid JSON", "/hooks", "Bearer secret", "", `{"text": `, http.StatusBadRequest, "", ""},
+		{"JSON as a form", "/hooks", "Bearer secret", form, `{"text": "hi"}`, http.StatusBadRequest, "", ""},
+		{"no text", "/hooks", "Bearer secret", "", `{"username": "bob"}`, http.StatusBadRequest, "", ""},
+		{"too long", "/hooks", "Bearer secret", "", `{"text": "` + strings.Repeat("a", 101) + `"}`, http.StatusBadRequest, "", ""},
+		{"JSON too large", "/hooks", "Bearer secret", "", `{"text": "` + strings.Repeat("a", 1<<20) + `"}`, http.StatusRequestEntityTooLarge, "", ""},
+		{"form too large", "/hooks", "Bearer secret", form, "text=" + strings.Repeat("a", 1<<20), http.StatusRequestEntityTooLarge, "", ""},
+		{"unknown token with a large body", "/hooks", "Bearer wrong", "", `{"text": "` + strings.Repeat("a", 1<<20) + `"}`, http.StatusUnauthorized, "", ""},
+		{"unknown token with invalid JSON", "/hooks", "Bearer wrong", "", `{"text": `, http.StatusUnauthorized, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusCreated {
+				return
+			}
+			var msg chat.Message
+			if err := json.Unmarshal(w.Body.Bytes(), &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Sender != tt.sender || msg.Body != tt.text || msg.Room != chat.DefaultRoom {
+				t.Errorf("posted %q from %q to %q, want %q from %q to %q", msg.Body, msg.Sender, msg.Room, tt.text, tt.sender, chat.DefaultRoom)
+			}
+		})
+	}
+}
+
+func TestBotSender(t *testing.T) {
+	b := bot{Bot: chat.Bot{Name: "slack"}}
+	tests := []struct {
+		username string
+		want     string
+	}{
+		{"", "slack"},
+		{"   ", "slack"},
+		{"bob", "bob[slack]"},
+		{" bob \n smith ", "bob smith[slack]"},
+		{strings.Repeat("x", maxBotUsername+10), strings.Repeat("x", maxBotUsername) + "[slack]"},
+	}
+	for _, tt := range tests {
+		if got := botSender(b, tt.username); got != tt.want {
+			t.Errorf("botSender(%q) = %q, want %q", tt.username, got, tt.want)
+		}
+	}
+}
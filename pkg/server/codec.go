@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"log/slog"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sync"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// newServerCodec returns the codec serveConn speaks over conn
+func (s *ChatServer) newServerCodec(conn net.Conn) rpc.ServerCodec {
+	var codec rpc.ServerCodec
+	if s.codec == chat.CodecJSON {
+		codec = jsonrpc.NewServerCodec(conn)
+	} else {
+		buf := bufio.NewWriter(conn)
+		codec = &gobServerCodec{rwc: conn, dec: gob.NewDecoder(conn), enc: gob.NewEncoder(buf), encBuf: buf}
+	}
+	return &loggingCodec{
+		ServerCodec: codec,
+		s:           s,
+		addr:        conn.RemoteAddr().String(),
+		started:     make(map[uint64]time.Time),
+	}
+}
+
+// loggingCodec wraps the codec of a connection to count and log the
+// requests it answers
+type loggingCodec struct {
+	rpc.ServerCodec
+	s    *ChatServer
+	addr string // remote address, for logging
+
+	mu      sync.Mutex           // guards started; requests are read and answered concurrently
+	started map[uint64]time.Time // when each pending request was read, by sequence number
+}
+
+func (c *loggingCodec) ReadRequestHeader(r *rpc.Request) error {
+	if err := c.ServerCodec.ReadRequestHeader(r); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.started[r.Seq] = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *loggingCodec) WriteResponse(r *rpc.Response, body any) error {
+	c.s.rpcCalls.Add(1)
+	if r.Error != "" {
+		c.s.rpcErrors.Add(1)
+	}
+	c.mu.Lock()
+	start := c.started[r.Seq]
+	delete(c.started, r.Seq)
+	c.mu.Unlock()
+	attrs := []any{"addr", c.addr, "method", r.ServiceMethod, "latency", time.Since(start)}
+	if r.Error != "" {
+		attrs = append(attrs, "err", r.Error)
+	}
+	slog.Debug("Handled request", attrs...)
+
+	return c.ServerCodec.WriteResponse(r, body)
+}
+
+// gobServerCodec is the gob codec net/rpc uses by default, which it does not
+// export
+type gobServerCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+}
+
+func (c *gobServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *gobServerCodec) ReadRequestBody(body any) error {
+	return c.dec.Decode(body)
+}
+
+func (c *gobServerCodec) WriteResponse(r *rpc.Response, body any) error {
+	if err := c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			// Gob couldn't encode the header. Should not happen, so if it
+			// does, shut down the connection to signal that it did.
+			slog.Error("rpc: gob error encoding response", "err", err)
+			c.Close()
+		}
+		return err
+	}
+	if err := c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			// Was a gob problem encoding the body but the header has been
+			// written. Shut down the connection to signal that it did.
+			slog.Error("rpc: gob error encoding body", "err", err)
+			c.Close()
+		}
+		return err
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *gobServerCodec) Close() error {
+	if c.closed {
+		// Only call c.rwc.Close once; otherwise the semantics are undefined.
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
@@ -0,0 +1,377 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/rpc"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// handshakeTimeout is how long a TLS client has to complete its handshake
+const handshakeTimeout = 10 * time.Second
+
+// writeTimeout is how long a client may take to read a reply before it is
+// taken to be hung and disconnected
+const writeTimeout = 10 * time.Second
+
+// shutdownGrace is how long clients get to receive the shutdown notice
+// before they are disconnected
+const shutdownGrace = time.Second
+
+// ErrServerClosed is returned by Serve after Shutdown
+var ErrServerClosed = errors.New("chat server closed")
+
+// Errors given to clients turned away by Config.MaxClients and
+// Config.MaxConnsPerIP
+var (
+	errServerFull     = errors.New("the server is full, try again later")
+	errTooManyFromYou = errors.New("too many connections from your address, try again later")
+)
+
+// chatConn is the RPC receiver for a single client connection. It embeds
+// the shared ChatServer, so every method is still served as
+// "ChatServer.<Method>", and adds the calls that need to know which
+// connection they came from.
+type chatConn struct {
+	*ChatServer
+	name   string        // name claimed by this connection, guarded by ChatServer.mu
+	ip     string        // remote address without the port, used for bans
+	cert   string        // common name of the client certificate, if any
+	conn   io.Closer     // closed to kick the client
+	closed chan struct{} // closed once the client has gone away
+
+	// protocol is the version agreed on with Hello, guarded by
+	// ChatServer.mu; 0 until then, which means version 1
+	protocol int
+}
+
+// watchedConn closes done as soon as reading from the connection fails.
+// net/rpc only notices a disconnect after every in-flight call has
+// returned, so long-polling calls use done to give up early. With idle
+// set, reading fails once the client has sent nothing for that long.
+type watchedConn struct {
+	net.Conn
+	idle  time.Duration
+	idled atomic.Bool // set if the connection was dropped for being idle
+	once  sync.Once
+	done  chan struct{}
+}
+
+// Write gives up on clients that stop reading for writeTimeout, closing
+// the connection, so that a hung client cannot hold a goroutine for ever
+func (w *watchedConn) Write(p []byte) (int, error) {
+	w.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	n, err := w.Conn.Write(p)
+	if err != nil {
+		w.Conn.Close()
+	}
+	return n, err
+}
+
+func (w *watchedConn) Read(p []byte) (int, error) {
+	if w.idle > 0 {
+		w.Conn.SetReadDeadline(time.Now().Add(w.idle))
+	}
+	n, err := w.Conn.Read(p)
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			w.idled.Store(true)
+		}
+		w.once.Do(func() { close(w.done) })
+	}
+	return n, err
+}
+
+// serveConn serves RPCs for one client connection and releases the name it
+// claimed once the client disconnects
+func (s *ChatServer) serveConn(conn net.Conn) {
+	ip, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		ip = conn.RemoteAddr().String()
+	}
+	// Unix socket clients have no address, so they all count as one for bans
+	if conn.RemoteAddr().Network() == "unix" {
+		ip = "unix"
+	}
+
+	// With client certificates the name is decided by the certificate, so
+	// finish the handshake up front to learn it
+	var cert string
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			slog.Warn("TLS handshake failed", "ip", ip, "err", err)
+			conn.Close()
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+		if peers := tlsConn.ConnectionState().PeerCertificates; len(peers) > 0 {
+			cert = peers[0].Subject.CommonName
+		}
+	}
+
+	watched := &watchedConn{Conn: conn, idle: s.idleTimeout, done: make(chan struct{})}
+	c := &chatConn{ChatServer: s, ip: ip, cert: cert, conn: conn, closed: watched.done}
+
+	s.mu.Lock()
+	if s.stopping {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	if err := s.admit(ip); err != nil {
+		s.mu.Unlock()
+		slog.Warn("Turned away connection", "ip", ip, "err", err)
+		s.refuse(conn, err)
+		return
+	}
+	s.conns[c] = true
+	s.connsWG.Add(1)
+	s.mu.Unlock()
+	defer s.connsWG.Done()
+
+	srv := rpc.NewServer()
+	if err := srv.RegisterName(chat.ServiceName, c); err != nil {
+		slog.Error("Register error", "err", err)
+		conn.Close()
+		return
+	}
+	srv.ServeCodec(s.newServerCodec(watched))
+
+	reason := "disconnected"
+	if watched.idled.Load() {
+		reason = "idle"
+		slog.Info("Closed idle connection", "ip", ip, "timeout", s.idleTimeout)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, c)
+	if sess, ok := c.session(); ok {
+		s.markOffline(sess, reason)
+	}
+}
+
+// admit checks whether another connection from ip stays within the
+// connection limits. The caller must hold s.mu.
+func (s *ChatServer) admit(ip string) error {
+	if s.maxClients > 0 && len(s.conns) >= s.maxClients {
+		return errServerFull
+	}
+	if s.maxConnsPerIP > 0 {
+		n := 0
+		for c := range s.conns {
+			if c.ip == ip {
+				n++
+			}
+		}
+		if n >= s.maxConnsPerIP {
+			return errTooManyFromYou
+		}
+	}
+	return nil
+}
+
+// refuse answers every call on a connection that was not admitted with
+// err, so the client learns why instead of seeing the connection drop,
+// then closes it
+func (s *ChatServer) refuse(conn net.Conn, err error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	codec := s.newServerCodec(conn)
+	defer codec.Close()
+	for {
+		var req rpc.Request
+		if codec.ReadRequestHeader(&req) != nil {
+			return
+		}
+		codec.ReadRequestBody(nil)
+		resp := rpc.Response{ServiceMethod: req.ServiceMethod, Seq: req.Seq, Error: err.Error()}
+		if codec.WriteResponse(&resp, struct{}{}) != nil {
+			return
+		}
+	}
+}
+
+// Serve accepts connections on l and serves each in its own goroutine,
+// until Shutdown is called or l fails. After Shutdown it returns
+// ErrServerClosed. Serve may be called with several listeners at once.
+func (s *ChatServer) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.stopping {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[l] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			stopping := s.stopping
+			s.mu.Unlock()
+			if stopping {
+				return ErrServerClosed
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			slog.Error("Accept error", "err", err)
+			continue
+		}
+
+		go s.serveConn(conn)
+	}
+}
+
+// RPCHandler serves the chat over HTTP, for clients that connect with
+// chat.DialHTTP or rpc.DialHTTPPath, so it can pass HTTP-only proxies and
+// share a port with other handlers. Clients send a CONNECT request, after
+// which the connection carries RPC just like a plain one.
+func (s *ChatServer) RPCHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			io.WriteString(w, "405 must CONNECT\n")
+			return
+		}
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			slog.Warn("RPC over HTTP failed", "addr", r.RemoteAddr, "err", err)
+			return
+		}
+		io.WriteString(conn, "HTTP/1.0 "+chat.HTTPConnected+"\n\n")
+		s.serveConn(conn)
+	})
+}
+
+// Shutdown stops accepting connections and tells everyone the server is
+// going away. It gives clients a moment to receive that, then disconnects
+// them and waits for their connections to finish or ctx to be done,
+// whichever comes first, and for the messages posted to be saved. It does
+// not close the message store.
+func (s *ChatServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.stopping {
+		s.stopping = true
+		close(s.done)
+		s.cancel()
+		s.push.stop()
+		s.hookCalls.stop()
+		for l := range s.listeners {
+			l.Close()
+		}
+		s.announce("The server is shutting down")
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-time.After(shutdownGrace):
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	for c := range s.conns {
+		c.conn.Close()
+	}
+	for gs := range s.grpcServers {
+		go gs.Stop()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.connsWG.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	// The store is closed next, so save what is still waiting
+	s.writer.stop()
+	return err
+}
+
+// Listen opens the server's TCP listener, wrapped in TLS when a
+// certificate and key are given. With a client CA bundle as well, clients
+// must present a certificate signed by one of those CAs.
+func Listen(addr, certFile, keyFile, clientCAFile string) (net.Listener, error) {
+	if certFile == "" && keyFile == "" {
+		if clientCAFile != "" {
+			return nil, errors.New("requiring client certificates needs a server certificate and key")
+		}
+		return net.Listen("tcp", addr)
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("a TLS certificate and key must be given together")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2"}, // gRPC clients insist on negotiating HTTP/2
+	}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", clientCAFile)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		slog.Info("Requiring client certificates", "ca", clientCAFile)
+	}
+	slog.Info("Serving TLS", "cert", certFile)
+	return tls.Listen("tcp", addr, config)
+}
+
+// ListenUnix opens a Unix domain socket at path, replacing a socket left
+// behind by a server that did not shut down cleanly. The socket file gets
+// the permissions in mode, which decide who may connect, and is removed
+// again when the listener is closed.
+func ListenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another server", path)
+		}
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// sentMessage is what the server remembers of a message sent with a
+// ClientID
+type sentMessage struct {
+	id int64
+	at time.Time
+}
+
+// sentKey is the key of a sender's ClientID in ChatServer.sent. ClientIDs
+// only need to be unique per sender.
+func sentKey(sender, clientID string) string {
+	return sender + "\x00" + clientID
+}
+
+// sentBefore returns the ID of the message sender already sent with
+// clientID within chat.DedupWindow, if there is one
+func (s *ChatServer) sentBefore(sender, clientID string) (int64, bool) {
+	if clientID == "" {
+		return 0, false
+	}
+	s.sentMu.Lock()
+	defer s.sentMu.Unlock()
+	sent, ok := s.sent[sentKey(sender, clientID)]
+	if !ok || time.Since(sent.at) > chat.DedupWindow {
+		return 0, false
+	}
+	return sent.id, true
+}
+
+// remember notes the ClientID of msg, if it has one and was sent within
+// chat.DedupWindow, so that sending it again does not post it twice
+func (s *ChatServer) remember(msg chat.Message) {
+	if msg.ClientID != "" && time.Since(msg.Timestamp) <= chat.DedupWindow {
+		s.sentMu.Lock()
+		defer s.sentMu.Unlock()
+		s.sent[sentKey(msg.Sender, msg.ClientID)] = sentMessage{id: msg.ID, at: msg.Timestamp}
+	}
+}
+
+// forgetSent drops the ClientIDs of messages sent longer than
+// chat.DedupWindow ago
+func (s *ChatServer) forgetSent() {
+	s.sentMu.Lock()
+	defer s.sentMu.Unlock()
+	for key, sent := range s.sent {
+		if time.Since(sent.at) > chat.DedupWindow {
+			delete(s.sent, key)
+		}
+	}
+}
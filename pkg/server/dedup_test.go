@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+func TestSentBefore(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		sent     chat.Message // remembered
+		sender   string
+		clientID string
+		wantID   int64 // 0 if not sent before
+	}{
+		{"same", chat.Message{ID: 1, Sender: "alice", ClientID: "a", Timestamp: now}, "alice", "a", 1},
+		{"other ClientID", chat.Message{ID: 1, Sender: "alice", ClientID: "a", Timestamp: now}, "alice", "b", 0},
+		{"other sender", chat.Message{ID: 1, Sender: "alice", ClientID: "a", Timestamp: now}, "bob", "a", 0},
+		{"no ClientID", chat.Message{ID: 1, Sender: "alice", Timestamp: now}, "alice", "", 0},
+		{"just within the window", chat.Message{ID: 1, Sender: "alice", ClientID: "a", Timestamp: now.Add(-chat.DedupWindow + time.Minute)}, "alice", "a", 1},
+		{"before the window", chat.Message{ID: 1, Sender: "alice", ClientID: "a", Timestamp: now.Add(-chat.DedupWindow - time.Second)}, "alice", "a", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newChatServer(memoryStore{})
+			s.remember(tt.sent)
+			id, ok := s.sentBefore(tt.sender, tt.clientID)
+			if ok != (tt.wantID != 0) || id != tt.wantID {
+				t.Errorf("sentBefore = %d, %v; want %d", id, ok, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestForgetSent(t *testing.T) {
+	s := newChatServer(memoryStore{})
+	s.remember(chat.Message{ID: 1, Sender: "alice", ClientID: "new", Timestamp: time.Now()})
+	// Remembered earlier, and now out of the window
+	s.sent[sentKey("alice", "old")] = sentMessage{id: 2, at: time.Now().Add(-chat.DedupWindow - time.Second)}
+
+	s.forgetSent()
+	if _, ok := s.sent[sentKey("alice", "new")]; !ok {
+		t.Error("forgot a message within the window")
+	}
+	if _, ok := s.sent[sentKey("alice", "old")]; ok {
+		t.Error("kept a message out of the window")
+	}
+	if _, ok := s.sentBefore("alice", "old"); ok {
+		t.Error("sentBefore found a message out of the window")
+	}
+}
+
+func TestPostTwice(t *testing.T) {
+	s := newTestServer(t, Config{})
+	register(t, s, "alice", "password1")
+	handler := s.RESTHandler()
+
+	post := func(body string) (int, chat.Message) {
+		r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(body))
+		r.SetBasicAuth("alice", "password1")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		var msg chat.Message
+		json.Unmarshal(w.Body.Bytes(), &msg)
+		return w.Code, msg
+	}
+	status, first := post(`{"text": "hello", "clientId": "secret-id"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("first post: status %d", status)
+	}
+	status, again := post(`{"text": "hello", "clientId": "secret-id"}`)
+	if status != http.StatusOK || again.ID != first.ID {
+		t.Errorf("posting again: status %d, message %d; want %d, message %d", status, again.ID, http.StatusOK, first.ID)
+	}
+	status, other := post(`{"text": "hello", "clientId": "other-id"}`)
+	if status != http.StatusCreated || other.ID == first.ID {
+		t.Errorf("posting with another ClientID: status %d, message %d; want %d and a new message", status, other.ID, http.StatusCreated)
+	}
+
+	// Other users see the messages without their ClientIDs
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/messages", nil))
+	var reply restMessages
+	if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil {
+		t.Fatal(err)
+	}
+	hellos := 0
+	for _, m := range reply.Messages {
+		if m.Body == "hello" {
+			hellos++
+		}
+		if m.ClientID != "" {
+			t.Errorf("message %d was read with its ClientID %q", m.ID, m.ClientID)
+		}
+	}
+	if hellos != 2 {
+		t.Errorf("read %d messages, want 2", hellos)
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"bytes"
+	"log/slog"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// allMessages returns the whole history: everything in the store, or what
+// is kept in memory if the store keeps nothing. Either is read once the
+// writer has saved and delivered what was posted before, without holding
+// s.mu meanwhile, so the caller must not hold it either.
+func (s *ChatServer) allMessages() ([]chat.Message, error) {
+	var messages []chat.Message
+	err := s.writer.do(func() error {
+		if _, ok := s.store.(memoryStore); ok {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			messages = s.keptMessages()
+			return nil
+		}
+		var err error
+		messages, err = s.store.Load()
+		return err
+	})
+	return messages, err
+}
+
+// ExportHistory lets an admin download the whole history, every room and
+// private conversation with all metadata, as JSON or CSV for archiving
+func (c *chatConn) ExportHistory(args *chat.ExportArgs, reply *chat.ExportReply) error {
+	c.mu.RLock()
+	admin, err := c.authorize(args.Token, args.Name, permExport)
+	c.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	messages, err := c.allMessages()
+	if err != nil {
+		slog.Error("Error loading history to export", "err", err)
+		return err
+	}
+	var buf bytes.Buffer
+	if err := chat.WriteExport(&buf, args.Format, messages); err != nil {
+		return err
+	}
+	reply.Data = buf.String()
+
+	slog.Info("Exported history", "messages", len(messages), "format", args.Format, "by", admin)
+	return nil
+}
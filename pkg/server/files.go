@@ -0,0 +1,298 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // image.DecodeConfig reads the image types clients show
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// Limits on uploads in progress, so that abandoned ones do not pile up in
+// memory
+const (
+	maxUploads    = 3                // per user at once
+	uploadTimeout = 10 * time.Minute // since the last chunk arrived
+)
+
+// maxFileName limits the length of a shared file's name in bytes
+const maxFileName = 255
+
+// maxImagePixels limits the width and height of shared images, so that
+// small files cannot decompress into huge images in clients
+const maxImagePixels = 4096
+
+// upload is a file being uploaded with UploadFileChunk
+type upload struct {
+	owner string
+	room  string
+	file  chat.File
+	data  []byte // what has arrived so far
+	last  time.Time
+}
+
+// UploadFileChunk receives part of a file to share in a room. The first
+// call describes the file and starts an upload; later calls add to it in
+// order. Once all of it has arrived and its hash matches, the file is
+// kept and a chat.KindFile message sharing it is posted to the room.
+func (c *chatConn) UploadFileChunk(args *chat.UploadArgs, reply *chat.UploadReply) error {
+	if len(args.Data) > chat.FileChunkSize {
+		return fmt.Errorf("chunks can be at most %d bytes", chat.FileChunkSize)
+	}
+
+	return c.posting(&c.mu, func() (*storeJob, error) {
+		if c.maxFileSize <= 0 {
+			return nil, errors.New("file sharing is turned off on this server")
+		}
+		from, err := c.sender(args.Token, args.Name)
+		if err != nil {
+			return nil, err
+		}
+		c.dropStaleUploads()
+
+		id := args.Upload
+		if id == "" {
+			if id, err = c.startUpload(from, args); err != nil {
+				return nil, err
+			}
+		}
+		up, ok := c.uploads[id]
+		if !ok || up.owner != from {
+			return nil, fmt.Errorf("upload %q not found, it may have timed out", id)
+		}
+		reply.Upload = id
+		if args.Offset != int64(len(up.data)) {
+			return nil, fmt.Errorf("expected the chunk at offset %d, not %d", len(up.data), args.Offset)
+		}
+		if int64(len(up.data)+len(args.Data)) > up.file.Size {
+			delete(c.uploads, id)
+			return nil, fmt.Errorf("the file is longer than the %d bytes announced", up.file.Size)
+		}
+		up.data = append(up.data, args.Data...)
+		up.last = time.Now()
+		if int64(len(up.data)) < up.file.Size {
+			return nil, nil
+		}
+
+		// All of it is here
+		delete(c.uploads, id)
+		sum := sha256.Sum256(up.data)
+		if hex.EncodeToString(sum[:]) != up.file.SHA256 {
+			return nil, errors.New("the file does not match its hash, please send it again")
+		}
+		if up.file.IsImage() {
+			if err := checkImage(up.file.Name, up.data); err != nil {
+				return nil, err
+			}
+		}
+		return c.shareFile(up, reply)
+	})
+}
+
+// checkImage returns an error unless data is an image of the type its
+// name says, small enough for clients to show
+func checkImage(name string, data []byte) error {
+	want := chat.ImageType(name)
+	if http.DetectContentType(data) != want {
+		return fmt.Errorf("%s is not a %s image", name, strings.TrimPrefix(want, "image/"))
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s cannot be read as an image", name)
+	}
+	if config.Width > maxImagePixels || config.Height > maxImagePixels {
+		return fmt.Errorf("images can be at most %dx%d pixels", maxImagePixels, maxImagePixels)
+	}
+	return nil
+}
+
+// startUpload checks the description of a file in the first call of an
+// upload and starts it, returning its ID. The caller must hold c.mu.
+func (c *chatConn) startUpload(from string, args *chat.UploadArgs) (string, error) {
+	name := strings.TrimSpace(args.FileName)
+	if name == "" || strings.ContainsAny(name, "/\\\n") || name == "." || name == ".." {
+		return "", errors.New("a file name without slashes is required")
+	}
+	if len(name) > maxFileName {
+		return "", fmt.Errorf("file names can be at most %d bytes long", maxFileName)
+	}
+	if args.Size <= 0 {
+		return "", errors.New("empty files cannot be shared")
+	}
+	if args.Size > c.maxFileSize {
+		return "", fmt.Errorf("the file is %d bytes, the limit is %d", args.Size, c.maxFileSize)
+	}
+	if chat.ImageType(name) != "" && args.Size > c.maxImageSize {
+		return "", fmt.Errorf("the image is %d bytes, the limit for images is %d", args.Size, c.maxImageSize)
+	}
+	caption := strings.TrimSpace(args.Caption)
+	if err := c.checkLength(caption); err != nil {
+		return "", err
+	}
+	if _, err := c.filterText(caption); err != nil {
+		return "", err
+	}
+	sum := strings.ToLower(args.SHA256)
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+		return "", errors.New("a hex-encoded SHA-256 hash of the file is required")
+	}
+	room := roomName(args.Room)
+	if _, err := c.joinedRoom(from, room); err != nil {
+		return "", err
+	}
+	if _, err := c.checkMuted(from); err != nil {
+		return "", err
+	}
+	waiting := 0
+	for _, up := range c.uploads {
+		if up.owner == from {
+			waiting++
+		}
+	}
+	if waiting >= maxUploads {
+		return "", fmt.Errorf("you can only upload %d files at once", maxUploads)
+	}
+	if err := c.checkRate(); err != nil {
+		return "", err
+	}
+
+	id, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	c.uploads[id] = &upload{
+		owner: from,
+		room:  room,
+		file:  chat.File{Name: name, Size: args.Size, SHA256: sum, Caption: caption},
+		last:  time.Now(),
+	}
+	return id, nil
+}
+
+// shareFile keeps a file that finished uploading and posts the message
+// sharing it, returning the job saving that. The caller must hold c.mu.
+func (c *chatConn) shareFile(up *upload, reply *chat.UploadReply) (*storeJob, error) {
+	// The sender may have been muted or left the room meanwhile
+	if _, err := c.joinedRoom(up.owner, up.room); err != nil {
+		return nil, err
+	}
+	shadow, err := c.checkMuted(up.owner)
+	if err != nil {
+		return nil, err
+	}
+	file := up.file
+	if file.Caption, err = c.filterText(file.Caption); err != nil {
+		return nil, err
+	}
+	if err := c.saveFile(file.SHA256, up.data); err != nil {
+		slog.Error("Error saving file", "name", file.Name, "err", err)
+		return nil, errors.New("could not save the file")
+	}
+
+	msg := c.newMessage(up.room, up.owner, "", file.Body())
+	msg.Kind = chat.KindFile
+	msg.File = &file
+	msg.Mentions = c.mentions(file.Caption)
+	if shadow {
+		c.echo(&msg)
+		reply.ID = msg.ID
+		return nil, nil
+	}
+	job, err := c.post(&msg)
+	if err != nil {
+		return nil, err
+	}
+	reply.ID = msg.ID
+	slog.Info("Shared file", "id", msg.ID, "name", file.Name, "size", file.Size, "room", up.room, "from", up.owner)
+
+	return job, nil
+}
+
+// dropStaleUploads forgets uploads that have not made progress for
+// uploadTimeout. The caller must hold s.mu.
+func (s *ChatServer) dropStaleUploads() {
+	for id, up := range s.uploads {
+		if time.Since(up.last) > uploadTimeout {
+			delete(s.uploads, id)
+		}
+	}
+}
+
+// saveFile keeps the contents of a shared file under its hash, in
+// filesDir or in memory. Identical files are only kept once.
+// The caller must hold s.mu.
+func (s *ChatServer) saveFile(sum string, data []byte) error {
+	if s.filesDir == "" {
+		s.files[sum] = data
+		return nil
+	}
+	path := filepath.Join(s.filesDir, sum)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// DownloadFileChunk returns part of a file shared in a room the caller can
+// read, starting at args.Offset
+func (s *ChatServer) DownloadFileChunk(args *chat.DownloadArgs, reply *chat.DownloadReply) error {
+	s.mu.RLock()
+	m, ok := s.findMessage(args.ID)
+	if ok {
+		_, err := s.readableRoom(m.Room, args.Token)
+		ok = err == nil
+	}
+	if !ok || m.Kind != chat.KindFile || !m.Deleted.IsZero() || m.File == nil {
+		s.mu.RUnlock()
+		return fmt.Errorf("file %d not found", args.ID)
+	}
+	data, inMemory := s.files[m.File.SHA256]
+	dir := s.filesDir
+	s.mu.RUnlock()
+
+	file := *m.File
+	if args.Offset < 0 || args.Offset > file.Size {
+		return fmt.Errorf("offset %d is outside the file", args.Offset)
+	}
+	reply.File = file
+	n := min(file.Size-args.Offset, chat.FileChunkSize)
+	if dir == "" {
+		// Files kept in memory are lost when the server restarts
+		if !inMemory {
+			return fmt.Errorf("file %d is no longer available", args.ID)
+		}
+		reply.Data = data[args.Offset : args.Offset+n]
+		return nil
+	}
+
+	f, err := os.Open(filepath.Join(dir, file.SHA256))
+	if err != nil {
+		slog.Error("Error opening shared file", "id", args.ID, "err", err)
+		return fmt.Errorf("file %d is no longer available", args.ID)
+	}
+	defer f.Close()
+	reply.Data = make([]byte, n)
+	if _, err := f.ReadAt(reply.Data, args.Offset); err != nil && err != io.EOF {
+		slog.Error("Error reading shared file", "id", args.ID, "err", err)
+		return fmt.Errorf("file %d is no longer available", args.ID)
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// Word filter modes
+const (
+	FilterMask   = "mask"   // replace banned words with asterisks
+	FilterReject = "reject" // refuse messages containing banned words
+)
+
+// wordFilter finds banned words in messages. Words match whole, ignoring
+// case.
+type wordFilter struct {
+	words   int
+	pattern *regexp.Regexp
+}
+
+// loadFilter reads a word list with one word or phrase per line. Blank
+// lines and lines starting with # are skipped.
+func loadFilter(path string) (*wordFilter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var quoted []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		quoted = append(quoted, regexp.QuoteMeta(word))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	filter := &wordFilter{words: len(quoted)}
+	if len(quoted) > 0 {
+		filter.pattern = regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	}
+	return filter, nil
+}
+
+// codeFence starts and ends a block of code in a message, as in Markdown
+const codeFence = "```"
+
+// filterText applies the word filter, if there is one, to text a user
+// sends: it returns the text with banned words masked, or an error if the
+// filter rejects them. Blocks of code between ``` lines are left alone,
+// since identifiers in them would otherwise get mangled or rejected. The
+// caller must hold s.mu.
+func (s *ChatServer) filterText(text string) (string, error) {
+	if s.filter == nil || s.filter.pattern == nil || !s.filter.pattern.MatchString(text) {
+		return text, nil
+	}
+	if !strings.Contains(text, codeFence) {
+		return s.filterWords(text)
+	}
+
+	lines := strings.Split(text, "\n")
+	inCode := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case !inCode && strings.HasPrefix(trimmed, codeFence):
+			inCode = true
+		case inCode && trimmed == codeFence:
+			inCode = false
+		case !inCode:
+			filtered, err := s.filterWords(line)
+			if err != nil {
+				return "", err
+			}
+			lines[i] = filtered
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// filterWords applies the word filter to text outside code. The caller
+// must hold s.mu.
+func (s *ChatServer) filterWords(text string) (string, error) {
+	if !s.filter.pattern.MatchString(text) {
+		return text, nil
+	}
+	if s.filterMode == FilterReject {
+		return "", errors.New("your message contains a word that is not allowed here")
+	}
+	return s.filter.pattern.ReplaceAllStringFunc(text, func(word string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(word))
+	}), nil
+}
+
+// ReloadFilter lets an admin reread the word filter's file after editing
+// it, without restarting the server
+func (c *chatConn) ReloadFilter(args *chat.UserArgs, reply *chat.FilterReply) error {
+	c.mu.Lock()
+	admin, err := c.authorize(args.Token, args.Name, permReloadFilter)
+	path := c.filterPath
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		return errors.New("the server has no word filter file")
+	}
+
+	filter, err := loadFilter(path)
+	if err != nil {
+		slog.Error("Error loading the word filter", "path", path, "err", err)
+		return errors.New("could not load the word filter, keeping the old one")
+	}
+
+	c.mu.Lock()
+	c.filter = filter
+	c.record(admin, auditReloadFilter, "", fmt.Sprintf("%d words", filter.words), "")
+	c.mu.Unlock()
+	reply.Words = filter.words
+
+	slog.Info("Reloaded the word filter", "words", filter.words, "by", admin)
+
+	return nil
+}
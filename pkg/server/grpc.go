@@ -0,0 +1,267 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chatpb"
+)
+
+// grpcService serves the chat over gRPC. gRPC shares a connection between
+// calls and even clients, so each login gets a chatConn of its own, which
+// later calls find through their session token.
+type grpcService struct {
+	chatpb.UnimplementedChatServer
+	s *ChatServer
+}
+
+// grpcConn stands in for the network connection of a gRPC session.
+// Closing it, e.g. to kick the user, ends the session's Subscribe streams.
+type grpcConn struct {
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (g *grpcConn) Close() error {
+	g.once.Do(func() { close(g.closed) })
+	return nil
+}
+
+// ServeGRPC serves the gRPC version of the chat, defined in package chatpb,
+// on l until Shutdown is called. After Shutdown it returns ErrServerClosed.
+func (s *ChatServer) ServeGRPC(l net.Listener) error {
+	gs := grpc.NewServer(grpc.UnaryInterceptor(s.logUnary), grpc.StreamInterceptor(s.logStream))
+	chatpb.RegisterChatServer(gs, &grpcService{s: s})
+
+	s.mu.Lock()
+	if s.stopping {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.grpcServers[gs] = true
+	s.mu.Unlock()
+
+	err := gs.Serve(l)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.grpcServers, gs)
+	if s.stopping {
+		return ErrServerClosed
+	}
+	return err
+}
+
+// logUnary counts and logs gRPC calls like loggingCodec does for net/rpc
+func (s *ChatServer) logUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	s.logGRPC(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+// logStream is logUnary for streaming calls, logged once they end
+func (s *ChatServer) logStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	s.logGRPC(ss.Context(), info.FullMethod, start, err)
+	return err
+}
+
+func (s *ChatServer) logGRPC(ctx context.Context, method string, start time.Time, err error) {
+	s.rpcCalls.Add(1)
+	if err != nil {
+		s.rpcErrors.Add(1)
+	}
+	attrs := []any{"method", method, "latency", time.Since(start)}
+	if p, ok := peer.FromContext(ctx); ok {
+		attrs = append([]any{"addr", p.Addr.String()}, attrs...)
+	}
+	if err != nil {
+		attrs = append(attrs, "err", err)
+	}
+	slog.Debug("Handled request", attrs...)
+}
+
+// conn returns the chatConn of the session with the given token and counts
+// the call as a sign of life, like a heartbeat
+func (g *grpcService) conn(token string) (*chatConn, error) {
+	g.s.mu.Lock()
+	defer g.s.mu.Unlock()
+
+	sess, ok := g.s.tokens[token]
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid or expired session, please log in again")
+	}
+	sess.lastSeen = time.Now()
+	return sess.conn, nil
+}
+
+func (g *grpcService) Login(ctx context.Context, req *chatpb.LoginRequest) (*chatpb.LoginResponse, error) {
+	g.s.mu.RLock()
+	stopping := g.s.stopping
+	g.s.mu.RUnlock()
+	if stopping {
+		return nil, status.Error(codes.Unavailable, "the server is shutting down")
+	}
+
+	var ip string
+	if p, ok := peer.FromContext(ctx); ok {
+		ip = p.Addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+	}
+	gc := &grpcConn{closed: make(chan struct{})}
+	c := &chatConn{ChatServer: g.s, ip: ip, conn: gc, closed: gc.closed}
+
+	var reply chat.LoginReply
+	var err error
+	if req.Password != "" {
+		err = c.Authenticate(&chat.AccountArgs{Name: req.Name, Password: req.Password}, &reply)
+	} else {
+		err = c.Login(&chat.UserArgs{Name: req.Name}, &reply)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &chatpb.LoginResponse{Token: reply.Token}, nil
+}
+
+func (g *grpcService) Logout(_ context.Context, req *chatpb.LogoutRequest) (*chatpb.LogoutResponse, error) {
+	c, err := g.conn(req.Token)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.UnregisterUser(&chat.UserArgs{Token: req.Token}, &struct{}{}); err != nil {
+		return nil, err
+	}
+	if gc, ok := c.conn.(*grpcConn); ok {
+		gc.Close()
+	}
+	return &chatpb.LogoutResponse{}, nil
+}
+
+func (g *grpcService) SendMessage(_ context.Context, req *chatpb.SendMessageRequest) (*chatpb.SendMessageResponse, error) {
+	c, err := g.conn(req.Token)
+	if err != nil {
+		return nil, err
+	}
+	// A LastIndex past any history skips the history SendMessage returns
+	args := &chat.MessageArgs{Token: req.Token, Room: req.Room, Message: req.Text, InReplyTo: req.InReplyTo, Emote: req.Emote, ClientID: req.ClientId, LastIndex: math.MaxInt}
+	if err := c.SendMessage(args, &chat.HistoryReply{}); err != nil {
+		return nil, err
+	}
+	return &chatpb.SendMessageResponse{}, nil
+}
+
+func (g *grpcService) GetHistory(_ context.Context, req *chatpb.GetHistoryRequest) (*chatpb.GetHistoryResponse, error) {
+	var page chat.HistoryPage
+	if err := g.s.GetHistory(&chat.HistoryArgs{Token: req.Token, Room: req.Room, Before: req.Before, Limit: int(req.Limit)}, &page); err != nil {
+		return nil, err
+	}
+	resp := &chatpb.GetHistoryResponse{More: page.More}
+	for _, m := range page.Messages {
+		resp.Messages = append(resp.Messages, toProto(m))
+	}
+	return resp, nil
+}
+
+func (g *grpcService) Subscribe(req *chatpb.SubscribeRequest, stream chatpb.Chat_SubscribeServer) error {
+	c, err := g.conn(req.Token)
+	if err != nil {
+		return err
+	}
+	if err := c.JoinRoom(&chat.RoomArgs{Token: req.Token, Room: req.Room, Password: req.Password, Invite: req.Invite}, &struct{}{}); err != nil {
+		return err
+	}
+	var page chat.HistoryPage
+	if err := g.s.GetHistory(&chat.HistoryArgs{Token: req.Token, Room: req.Room, Limit: 1}, &page); err != nil {
+		return err
+	}
+
+	// Keep the session online while the stream is open, and end the stream
+	// once either goes away
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(g.s.presenceTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := g.conn(req.Token); err != nil {
+					return
+				}
+			case <-stream.Context().Done():
+				return
+			case <-c.closed:
+				return
+			}
+		}
+	}()
+
+	c.mu.Lock()
+	sub, err := c.subscribe(roomName(req.Room), c.reader(req.Token), page.LastIndex, func(m chat.Message) error {
+		return stream.Send(toProto(m))
+	})
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	defer c.unsubscribe(sub)
+
+	select {
+	case <-sub.done:
+		if sub.err == errFellBehind {
+			return status.Error(codes.ResourceExhausted, sub.err.Error())
+		}
+		return sub.err
+	case <-done:
+		if err := stream.Context().Err(); err != nil {
+			return err
+		}
+		return status.Error(codes.Unauthenticated, "session ended")
+	}
+}
+
+// toProto converts a message to its gRPC form
+func toProto(m chat.Message) *chatpb.Message {
+	pm := &chatpb.Message{
+		Id:        m.ID,
+		Kind:      chatpb.MessageKind(m.Kind),
+		Room:      m.Room,
+		Sender:    m.Sender,
+		To:        m.To,
+		Body:      m.Body,
+		Timestamp: timestamppb.New(m.Timestamp),
+		Ref:       m.Ref,
+		InReplyTo: m.InReplyTo,
+		Thread:    m.Thread,
+		Mentions:  m.Mentions,
+	}
+	if !m.Edited.IsZero() {
+		pm.Edited = timestamppb.New(m.Edited)
+	}
+	if !m.Deleted.IsZero() {
+		pm.Deleted = timestamppb.New(m.Deleted)
+	}
+	if len(m.Reactions) > 0 {
+		pm.Reactions = make(map[string]*chatpb.Users, len(m.Reactions))
+		for emoji, names := range m.Reactions {
+			pm.Reactions[emoji] = &chatpb.Users{Names: names}
+		}
+	}
+	return pm
+}
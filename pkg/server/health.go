@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// pinger is a MessageStore that can check that it still works, such as a
+// database that can lose its connection
+type pinger interface {
+	Ping() error
+}
+
+// Ping checks that the file can still be written to
+func (fs *fileStore) Ping() error {
+	_, err := fs.f.Stat()
+	return err
+}
+
+// Ping checks that the database still answers
+func (ss *sqliteStore) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	return ss.db.PingContext(ctx)
+}
+
+// health fills reply with the state of the store and of the accept loops.
+// The caller must hold s.mu.
+func (s *ChatServer) health(reply *chat.HealthReply) {
+	reply.Store = "ok"
+	if p, ok := s.store.(pinger); ok {
+		if err := p.Ping(); err != nil {
+			reply.Store = err.Error()
+		}
+	}
+	if err := s.writer.err(); err != nil && reply.Store == "ok" {
+		reply.Store = "saving messages: " + err.Error()
+	}
+	reply.Listeners = len(s.listeners) + len(s.grpcServers)
+	reply.Stopping = s.stopping
+	reply.Healthy = reply.Store == "ok"
+	reply.Ready = reply.Healthy && reply.Listeners > 0 && !s.stopping
+}
+
+// Health reports whether the server works: whether it can still reach its
+// store. Orchestration systems restart servers that are not healthy.
+func (s *ChatServer) Health(_ *struct{}, reply *chat.HealthReply) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	s.health(reply)
+	return nil
+}
+
+// Ready reports whether the server can take clients: it is healthy,
+// accepting connections and not shutting down. Orchestration systems only
+// send clients to servers that are ready.
+func (s *ChatServer) Ready(_ *struct{}, reply *chat.HealthReply) error {
+	return s.Health(nil, reply)
+}
+
+// HealthHandler returns an HTTP handler for probes, to be mounted at e.g.
+// /healthz, answering 200 if the server is healthy and 503 if not
+func (s *ChatServer) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		var reply chat.HealthReply
+		s.Health(nil, &reply)
+		probe(w, reply.Healthy, reply)
+	})
+}
+
+// ReadyHandler returns an HTTP handler for probes, to be mounted at e.g.
+// /readyz, answering 200 if the server is ready and 503 if not
+func (s *ChatServer) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		var reply chat.HealthReply
+		s.Ready(nil, &reply)
+		probe(w, reply.Ready, reply)
+	})
+}
+
+// probe writes the answer to a health or readiness probe
+func probe(w http.ResponseWriter, ok bool, reply chat.HealthReply) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	fmt.Fprintf(w, "store: %s\nlisteners: %d\nstopping: %t\n", reply.Store, reply.Listeners, reply.Stopping)
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// room holds the state of a single conversation
+type room struct {
+	history    messageLog
+	members    map[string]bool
+	operators  map[string]bool // who may change the topic, besides moderators
+	topic      chat.Message    // the latest KindTopic message, if any
+	password   []byte          // bcrypt hash of the join password, nil for none
+	inviteOnly bool
+	invites    map[string]bool // invites that still let users join
+}
+
+func newRoom() *room {
+	return &room{members: make(map[string]bool), operators: make(map[string]bool), invites: make(map[string]bool)}
+}
+
+// private reports whether joining the room takes a password or an invite
+func (r *room) private() bool {
+	return r.password != nil || r.inviteOnly
+}
+
+// messageLog is a history of messages, oldest first. Once it holds its
+// limit each new message evicts the oldest one. Positions count every
+// message ever added, which keeps the positions clients hold valid after
+// eviction. Evicted messages stay in the array behind buf until it is
+// copied, which happens once as many have been evicted as are kept, so a
+// log never holds on to more than about twice its limit.
+//
+// Each log has a lock of its own, so that messages can be added to
+// different rooms at once while s.mu is only held for reading. The log is
+// copy on write: messages are never changed in place while a snapshot may
+// share them, so a reader can take a snapshot and page through or search
+// it after letting go of both locks.
+type messageLog struct {
+	mu sync.Mutex // guards the fields below
+	logSnapshot
+	shared bool // set by snapshot, until buf is next copied
+}
+
+// logSnapshot is the state of a messageLog at one point in time. It is
+// never changed, so it can be read without holding any lock.
+type logSnapshot struct {
+	buf   []chat.Message // the messages still kept, oldest first
+	total int            // number of messages ever added
+}
+
+// snapshot returns the log as it is now
+func (l *messageLog) snapshot() logSnapshot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.shared = true
+	return l.logSnapshot
+}
+
+// add appends msg, evicting the oldest message if the log already holds
+// limit messages. A limit of 0 keeps everything.
+func (l *messageLog) add(msg chat.Message, limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total++
+	if limit > 0 && len(l.buf) >= limit {
+		kept := l.buf[len(l.buf)-limit+1:]
+		if cap(kept) > len(kept) {
+			l.buf = kept
+		} else {
+			// Full: copy to a new array, letting the evicted messages go
+			l.buf = make([]chat.Message, len(kept), 2*limit)
+			copy(l.buf, kept)
+			l.shared = false
+		}
+	}
+	// Snapshots end where buf ends, so append only writes past them
+	l.buf = append(l.buf, msg)
+}
+
+// trim drops every message up to the newest one drop reports true for.
+// Only whole prefixes can go, so that positions stay valid.
+func (l *messageLog) trim(drop func(chat.Message) bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for i, m := range l.buf {
+		if drop(m) {
+			n = i + 1
+		}
+	}
+	if n > 0 {
+		// Copied, so that the dropped messages can be freed
+		l.buf = slices.Clone(l.buf[n:])
+		l.shared = false
+	}
+}
+
+// update calls fn on every kept message, letting it change them
+func (l *messageLog) update(fn func(*chat.Message)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.own()
+	for i := range l.buf {
+		fn(&l.buf[i])
+	}
+}
+
+// change calls fn on the kept message with the given ID, if there is one,
+// letting it change the message
+func (l *messageLog) change(id int64, fn func(*chat.Message)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if i, ok := l.index(id); ok {
+		l.own()
+		fn(&l.buf[i])
+	}
+}
+
+// own copies buf if a snapshot may share it, so that it can be changed.
+// The caller must hold l.mu.
+func (l *messageLog) own() {
+	if l.shared {
+		l.buf = slices.Clone(l.buf)
+		l.shared = false
+	}
+}
+
+// kept returns how many messages the log holds in memory
+func (l *messageLog) kept() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buf)
+}
+
+// tail returns the messages after position since, as since does, and the
+// position after the newest message, as len does, both at the same point
+// in time
+func (l *messageLog) tail(since int) ([]chat.Message, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.logSnapshot.since(since), l.total
+}
+
+// len, since and find are those of logSnapshot, for a log that may be
+// changing meanwhile
+
+func (l *messageLog) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.logSnapshot.len()
+}
+
+func (l *messageLog) since(since int) []chat.Message {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.logSnapshot.since(since)
+}
+
+func (l *messageLog) find(id int64) (chat.Message, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.logSnapshot.find(id)
+}
+
+// len returns the position after the newest message
+func (l *logSnapshot) len() int {
+	return l.total
+}
+
+// since returns a copy of the messages after position since that are still
+// kept, oldest first
+func (l *logSnapshot) since(since int) []chat.Message {
+	oldest := l.total - len(l.buf)
+	if since < oldest {
+		since = oldest
+	}
+	if since >= l.total {
+		return nil
+	}
+	return slices.Clone(l.buf[since-oldest:])
+}
+
+// index returns where in buf the message with the given ID is
+func (l *logSnapshot) index(id int64) (int, bool) {
+	i := sort.Search(len(l.buf), func(i int) bool { return l.buf[i].ID >= id })
+	return i, i < len(l.buf) && l.buf[i].ID == id
+}
+
+// find returns the kept message with the given ID
+func (l *logSnapshot) find(id int64) (chat.Message, bool) {
+	if i, ok := l.index(id); ok {
+		return l.buf[i], true
+	}
+	return chat.Message{}, false
+}
+
+// page returns up to limit of the newest messages with IDs below before
+// that hide does not report true for, oldest first, and whether older ones
+// are kept too. A before of 0 starts from the newest message, a limit of 0
+// returns everything and a nil hide hides nothing.
+func (l *logSnapshot) page(before int64, limit int, hide func(chat.Message) bool) ([]chat.Message, bool) {
+	end := len(l.buf)
+	if before > 0 {
+		end = sort.Search(len(l.buf), func(i int) bool { return l.buf[i].ID >= before })
+	}
+
+	// Walk back from the end, then put the page in order
+	messages := []chat.Message{}
+	start := end
+	for start > 0 && (limit <= 0 || len(messages) < limit) {
+		start--
+		if m := l.buf[start]; hide == nil || !hide(m) {
+			messages = append(messages, m)
+		}
+	}
+	slices.Reverse(messages)
+	return messages, start > 0
+}
+
+// formatMessages converts messages to the string format used by
+// SendMessage and GetHistory, which older clients still rely on
+func formatMessages(messages []chat.Message) []string {
+	formatted := make([]string, len(messages))
+	for i, m := range messages {
+		formatted[i] = m.String()
+	}
+	return formatted
+}
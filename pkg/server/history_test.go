@@ -0,0 +1,99 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// ids returns the IDs of messages
+func ids(messages []chat.Message) []int64 {
+	var ids []int64
+	for _, m := range messages {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func TestMessageLogAdd(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		added int
+		kept  int64 // ID of the oldest message kept, counting from 1
+	}{
+		{"no limit", 0, 50, 1},
+		{"under the limit", 10, 5, 1},
+		{"at the limit", 10, 10, 1},
+		{"over the limit", 10, 11, 2},
+		{"far over the limit", 10, 1000, 991},
+		{"limit of one", 1, 5, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l messageLog
+			var snapshots []logSnapshot
+			for id := int64(1); id <= int64(tt.added); id++ {
+				l.add(chat.Message{ID: id}, tt.limit)
+				if tt.limit > 0 && cap(l.buf) > 2*tt.limit {
+					t.Fatalf("after %d messages the log holds on to %d, more than twice its limit", id, cap(l.buf))
+				}
+				snapshots = append(snapshots, l.snapshot())
+			}
+
+			got := ids(l.since(0))
+			if len(got) == 0 || got[0] != tt.kept || got[len(got)-1] != int64(tt.added) || len(got) != tt.added-int(tt.kept)+1 {
+				t.Errorf("kept %v, want %d to %d", got, tt.kept, tt.added)
+			}
+			if l.len() != tt.added {
+				t.Errorf("len() = %d, want %d", l.len(), tt.added)
+			}
+			// Snapshots are not changed by what was added after them
+			for i, snap := range snapshots {
+				got := snap.since(0)
+				if len(got) == 0 || got[len(got)-1].ID != int64(i+1) {
+					t.Fatalf("snapshot %d ends with %v", i+1, ids(got))
+				}
+			}
+		})
+	}
+}
+
+func TestMessageLogTrim(t *testing.T) {
+	tests := []struct {
+		name string
+		drop func(chat.Message) bool
+		want []int64
+	}{
+		{"nothing", func(chat.Message) bool { return false }, []int64{1, 2, 3, 4, 5}},
+		{"oldest", func(m chat.Message) bool { return m.ID <= 2 }, []int64{3, 4, 5}},
+		{"up to the newest dropped", func(m chat.Message) bool { return m.ID == 3 }, []int64{4, 5}},
+		{"everything", func(chat.Message) bool { return true }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l messageLog
+			for id := int64(1); id <= 5; id++ {
+				l.add(chat.Message{ID: id}, 0)
+			}
+			before := l.snapshot()
+			l.trim(tt.drop)
+
+			got := ids(l.since(0))
+			if len(got) != len(tt.want) {
+				t.Fatalf("kept %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("kept %v, want %v", got, tt.want)
+				}
+			}
+			if l.len() != 5 {
+				t.Errorf("len() = %d, want positions kept at 5", l.len())
+			}
+			if n := len(before.since(0)); n != 5 {
+				t.Errorf("trimming changed an earlier snapshot, which has %d messages", n)
+			}
+		})
+	}
+}
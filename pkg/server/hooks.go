@@ -0,0 +1,286 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// MessageHook lets programs that embed the server add features such as
+// filters, loggers and auto-responders without changing it. Embed
+// BaseHook to implement only the methods a hook needs.
+type MessageHook interface {
+	// OnBeforeSend is called for every message a user writes, chat and
+	// emotes, polls, shared files, direct messages and edits, before it
+	// is saved and given its ID. It may change msg.Body, or return an
+	// error to refuse the message, which the sender is shown. It is called
+	// for one message at a time, with the server locked, so it must be
+	// quick and must not call the server.
+	OnBeforeSend(msg *chat.Message) error
+
+	// OnAfterSend is called for the same messages once they are posted.
+	// Like OnUserJoin and OnUserLeave, it is called in the order things
+	// happened, from a goroutine of its own, so it may take its time and
+	// call the server, e.g. to answer with Post. Hooks that fall too far
+	// behind miss calls rather than holding up the server.
+	OnAfterSend(msg chat.Message)
+
+	// OnUserJoin is called when a user joins a room. Everyone is in
+	// chat.DefaultRoom, so logging in counts as joining it.
+	OnUserJoin(name, room string)
+
+	// OnUserLeave is called when a user leaves a room, going offline
+	// counting as leaving chat.DefaultRoom
+	OnUserLeave(name, room string)
+}
+
+// BaseHook implements MessageHook doing nothing, for hooks to embed
+type BaseHook struct{}
+
+func (BaseHook) OnBeforeSend(*chat.Message) error { return nil }
+func (BaseHook) OnAfterSend(chat.Message)         {}
+func (BaseHook) OnUserJoin(name, room string)     {}
+func (BaseHook) OnUserLeave(name, room string)    {}
+
+// hookQueueSize is how many hook calls may wait; more are dropped
+const hookQueueSize = 1024
+
+// hookQueue calls hooks one at a time, in order, without holding up
+// whoever queued the call
+type hookQueue struct {
+	mu      sync.Mutex
+	wake    *sync.Cond // signalled when calls grows or the queue stops
+	calls   []func()
+	stopped bool
+}
+
+// newHookQueue starts a goroutine making queued calls
+func newHookQueue() *hookQueue {
+	q := &hookQueue{}
+	q.wake = sync.NewCond(&q.mu)
+	go q.run()
+	return q
+}
+
+// add queues a call, unless too many are waiting already
+func (q *hookQueue) add(call func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.stopped {
+		return
+	}
+	if len(q.calls) >= hookQueueSize {
+		slog.Warn("Dropped a hook call, too many waiting")
+		return
+	}
+	q.calls = append(q.calls, call)
+	q.wake.Signal()
+}
+
+// run makes the queued calls until the queue is stopped and empty. A hook
+// that panics is logged and skipped rather than taking the server down.
+func (q *hookQueue) run() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		for len(q.calls) == 0 && !q.stopped {
+			q.wake.Wait()
+		}
+		if len(q.calls) == 0 {
+			return
+		}
+		calls := q.calls
+		q.calls = nil
+		q.mu.Unlock()
+
+		for _, call := range calls {
+			func() {
+				defer func() {
+					if err := recover(); err != nil {
+						slog.Error("Message hook panicked", "err", err)
+					}
+				}()
+				call()
+			}()
+		}
+
+		q.mu.Lock()
+	}
+}
+
+// stop makes the calls already queued and no more
+func (q *hookQueue) stop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.stopped = true
+	q.wake.Broadcast()
+}
+
+// AddHook registers a hook, which sees the messages and joins from then
+// on. Hooks are called in the order they were added.
+func (s *ChatServer) AddHook(hook MessageHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hooks = append(s.hooks, hook)
+}
+
+// ReserveSuffix keeps names ending in suffix, such as "[irc]", for hooks
+// that post others' messages under them, like bots do: users can no
+// longer log in, register or be renamed with such a name.
+func (s *ChatServer) ReserveSuffix(suffix string) {
+	if suffix == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reservedSuffixes = append(s.reservedSuffixes, suffix)
+}
+
+// hooked reports whether msg is written by a user, so hooks see it
+func hooked(msg chat.Message) bool {
+	return msg.IsChat() || msg.Kind == chat.KindEdit
+}
+
+// beforeSend runs the OnBeforeSend hooks on a message about to be posted,
+// finding its mentions again if they changed its body.
+// The caller must hold s.mu, for reading at least.
+func (s *ChatServer) beforeSend(msg *chat.Message) error {
+	if len(s.hooks) == 0 || !hooked(*msg) {
+		return nil
+	}
+	s.sending.Lock()
+	defer s.sending.Unlock()
+	body := msg.Body
+	for _, hook := range s.hooks {
+		if err := hook.OnBeforeSend(msg); err != nil {
+			return err
+		}
+	}
+	switch msg.Kind {
+	case chat.KindChat, chat.KindEmote, chat.KindEdit:
+		if msg.Body != body {
+			msg.Mentions = s.mentions(msg.Body)
+		}
+	}
+	return nil
+}
+
+// afterSend queues the OnAfterSend hooks for a message that was just
+// posted. The caller must hold s.mu.
+func (s *ChatServer) afterSend(msg chat.Message) {
+	if len(s.hooks) == 0 || !hooked(msg) {
+		return
+	}
+	hooks := s.hooks
+	s.hookCalls.add(func() {
+		for _, hook := range hooks {
+			hook.OnAfterSend(msg)
+		}
+	})
+}
+
+// userJoined queues the OnUserJoin hooks for a user who joined a room.
+// The caller must hold s.mu.
+func (s *ChatServer) userJoined(name, room string) {
+	if len(s.hooks) == 0 {
+		return
+	}
+	hooks := s.hooks
+	s.hookCalls.add(func() {
+		for _, hook := range hooks {
+			hook.OnUserJoin(name, room)
+		}
+	})
+}
+
+// userLeft queues the OnUserLeave hooks for a user who left a room.
+// The caller must hold s.mu.
+func (s *ChatServer) userLeft(name, room string) {
+	if len(s.hooks) == 0 {
+		return
+	}
+	hooks := s.hooks
+	s.hookCalls.add(func() {
+		for _, hook := range hooks {
+			hook.OnUserLeave(name, room)
+		}
+	})
+}
+
+// Post posts text to a room under the name sender, who need not be
+// online, for hooks and programs embedding the server to answer users
+// with. The text goes through the word filter and the OnBeforeSend hooks
+// like any other message.
+func (s *ChatServer) Post(room, sender, text string) (chat.Message, error) {
+	return s.postText(room, sender, text, chat.KindChat)
+}
+
+// PostEmote is Post for actions, like those users post with /me
+func (s *ChatServer) PostEmote(room, sender, text string) (chat.Message, error) {
+	return s.postText(room, sender, text, chat.KindEmote)
+}
+
+// postText implements Post and PostEmote
+func (s *ChatServer) postText(room, sender, text string, kind chat.MessageKind) (chat.Message, error) {
+	sender = strings.TrimSpace(sender)
+	if sender == "" {
+		return chat.Message{}, errors.New("sender is required")
+	}
+	if err := s.checkLength(text); err != nil {
+		return chat.Message{}, err
+	}
+
+	var msg chat.Message
+	err := s.posting(s.mu.RLocker(), func() (*storeJob, error) {
+		if s.stopping {
+			return nil, errors.New("the server is shutting down")
+		}
+		name := roomName(room)
+		if _, err := s.findRoom(name); err != nil {
+			return nil, err
+		}
+		text, err := s.filterText(text)
+		if err != nil {
+			return nil, err
+		}
+		msg = s.newMessage(name, sender, "", text)
+		msg.Kind = kind
+		msg.Mentions = s.mentions(text)
+		return s.post(&msg)
+	})
+	if err != nil {
+		return chat.Message{}, err
+	}
+	// As posted, which hooks may have changed on the way
+	return msg, nil
+}
+
+// Announce posts text to a room as a notice from the server, like the
+// ones telling users who joined, for hooks and programs embedding the
+// server. Hooks do not see it.
+func (s *ChatServer) Announce(room, text string) error {
+	if err := s.checkLength(text); err != nil {
+		return err
+	}
+
+	return s.posting(s.mu.RLocker(), func() (*storeJob, error) {
+		if s.stopping {
+			return nil, errors.New("the server is shutting down")
+		}
+		name := roomName(room)
+		if _, err := s.findRoom(name); err != nil {
+			return nil, err
+		}
+		msg := s.newMessage(name, "", "", text)
+		msg.Kind = chat.KindSystem
+		return s.post(&msg)
+	})
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+func TestHookQueue(t *testing.T) {
+	q := newHookQueue()
+	started, block := make(chan struct{}), make(chan struct{})
+	q.add(func() {
+		close(started)
+		<-block
+	})
+	// The first call is under way, so the rest wait
+	<-started
+
+	var order []int
+	for i := range hookQueueSize - 1 {
+		q.add(func() { order = append(order, i) })
+	}
+	finished := make(chan struct{})
+	q.add(func() { close(finished) })
+	// The queue is full
+	for range 10 {
+		q.add(func() { order = append(order, -1) })
+	}
+	close(block)
+	<-finished
+
+	if len(order) != hookQueueSize-1 {
+		t.Fatalf("made %d calls, want %d", len(order), hookQueueSize-1)
+	}
+	for i, got := range order {
+		if got != i {
+			t.Fatalf("call %d was call %d, want them in order", i, got)
+		}
+	}
+
+	q.stop()
+	q.add(func() { t.Error("a call added after stop was made") })
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.calls) != 0 {
+		t.Errorf("%d calls queued after stop", len(q.calls))
+	}
+}
+
+func TestReserveSuffix(t *testing.T) {
+	s := newTestServer(t, Config{})
+	s.ReserveSuffix("[irc]")
+	s.ReserveSuffix("")
+
+	tests := []struct {
+		name     string
+		reserved bool
+	}{
+		{"bob[irc]", true},
+		{"[irc]", true},
+		{"bob[irc]x", false},
+		{"bob", false},
+		{"bob[IRC]", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &chatConn{ChatServer: s}
+			err := c.Register(&chat.AccountArgs{Name: tt.name, Password: "password1"}, nil)
+			if (err != nil) != tt.reserved {
+				t.Errorf("Register(%q) = %v, want an error: %v", tt.name, err, tt.reserved)
+			}
+			c.mu.Lock()
+			_, err = c.login(tt.name)
+			c.mu.Unlock()
+			if (err != nil) != tt.reserved {
+				t.Errorf("login(%q) = %v, want an error: %v", tt.name, err, tt.reserved)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"log/slog"
+	"os"
+	"sort"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// importHistory replays a history file written by ExportHistory or the
+// -export flag into the server and its store, keeping the messages' IDs
+// and timestamps. IDs only increase, so messages with IDs the server has
+// already used are skipped; importing the same file again does nothing.
+// It must be called before the server starts serving.
+func (s *ChatServer) importHistory(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	messages, err := chat.ReadExport(f, chat.ExportFormat(path))
+	if err != nil {
+		return 0, err
+	}
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+
+	imported := 0
+	for _, msg := range messages {
+		if msg.ID <= s.nextID.Load() {
+			continue
+		}
+		// Reactions are rebuilt from the events as they are delivered
+		msg.Reactions = nil
+		if err := s.store.Append(msg); err != nil {
+			return imported, err
+		}
+		s.deliver(msg)
+		s.nextID.Store(msg.ID)
+		imported++
+	}
+	if skipped := len(messages) - imported; skipped > 0 {
+		slog.Warn("Skipped imported messages with IDs already in use", "count", skipped)
+	}
+	return imported, nil
+}
@@ -0,0 +1,63 @@
+package server
+
+import "github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+
+// Version is the server's release, reported by GetServerInfo. Release
+// builds set it with
+// -ldflags "-X github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/server.Version=v1.2.0".
+var Version = "dev"
+
+// GetServerInfo tells clients which version of the server and protocol
+// they are talking to and what it supports, so they can leave out what it
+// does not
+func (s *ChatServer) GetServerInfo(_ *struct{}, reply *chat.ServerInfoReply) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	reply.Version = Version
+	reply.Protocol = chat.ProtocolVersion
+	reply.Features = []string{
+		chat.FeatureRooms,
+		chat.FeaturePrivateRooms,
+		chat.FeatureDirectMessages,
+		chat.FeatureEdits,
+		chat.FeatureReactions,
+		chat.FeatureThreads,
+		chat.FeatureSearch,
+		chat.FeatureStreaming,
+		chat.FeatureBlocks,
+		chat.FeatureReports,
+		chat.FeatureExport,
+		chat.FeatureBatch,
+		chat.FeatureReceipts,
+		chat.FeatureUnread,
+		chat.FeaturePins,
+		chat.FeatureScheduled,
+		chat.FeatureReminders,
+		chat.FeaturePolls,
+		chat.FeatureWebhooks,
+		chat.FeatureBots,
+	}
+	if s.filter != nil {
+		reply.Features = append(reply.Features, chat.FeatureWordFilter)
+	}
+	if s.retentionAge > 0 || s.retentionCount > 0 {
+		reply.Features = append(reply.Features, chat.FeatureRetention)
+	}
+	if len(s.grpcServers) > 0 {
+		reply.Features = append(reply.Features, chat.FeatureGRPC)
+	}
+	if s.assistant != nil {
+		reply.Features = append(reply.Features, chat.FeatureAssistant)
+	}
+	if s.translator != nil {
+		reply.Features = append(reply.Features, chat.FeatureTranslate)
+	}
+	if len(s.previewHosts) > 0 {
+		reply.Features = append(reply.Features, chat.FeaturePreviews)
+	}
+	if s.maxFileSize > 0 {
+		reply.Features = append(reply.Features, chat.FeatureFiles, chat.FeatureImages)
+	}
+	return nil
+}
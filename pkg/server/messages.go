@@ -0,0 +1,685 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// waitTimeout bounds how long WaitForMessages blocks before returning an
+// empty reply, so clients periodically re-issue the call
+const waitTimeout = chat.WaitTimeout
+
+// maxReactionLength limits the size of a reaction in bytes
+const maxReactionLength = 32
+
+// sender resolves who is making a call, preferring its session token and
+// then the name this connection logged in with. Calls with neither come
+// from clients that predate Login; they are only accepted when allowLegacy
+// is set, and never for a name someone is logged in with.
+// The caller must hold c.mu.
+func (c *chatConn) sender(token, name string) (string, error) {
+	if token != "" {
+		sess, ok := c.tokens[token]
+		if !ok {
+			return "", errors.New("invalid or expired session, please log in again")
+		}
+		return sess.name, nil
+	}
+	if sess, ok := c.session(); ok {
+		return sess.name, nil
+	}
+
+	name = strings.TrimSpace(name)
+	if !c.allowLegacy {
+		return "", errors.New("login required")
+	}
+	if name == "" {
+		return "", errors.New("name is required")
+	}
+	if _, ok := c.online[name]; ok {
+		return "", fmt.Errorf("name %q is in use by a logged in user", name)
+	}
+	if _, ok := c.accounts[name]; ok {
+		return "", fmt.Errorf("name %q is registered, authenticate with its password", name)
+	}
+	if c.cert != "" && name != c.cert {
+		return "", fmt.Errorf("your certificate identifies you as %q", c.cert)
+	}
+	if c.banned(name) {
+		return "", errors.New("you are banned from this server")
+	}
+	return name, nil
+}
+
+// SendMessage handles new messages and returns the history the client
+// has not seen yet
+func (c *chatConn) SendMessage(args *chat.MessageArgs, reply *chat.HistoryReply) error {
+	received := time.Now()
+	if err := c.checkLength(args.Message); err != nil {
+		return err
+	}
+
+	var r *room // the room posted to, nil if nothing was posted
+	var msg chat.Message
+	var shadow bool
+	err := c.posting(c.mu.RLocker(), func() (*storeJob, error) {
+		if args.Timeout > 0 && time.Since(received) > args.Timeout {
+			return nil, errors.New("the server is too busy, the message was not sent")
+		}
+
+		from, err := c.sender(args.Token, args.Name)
+		if err != nil {
+			return nil, err
+		}
+		// A retry of a message that was posted after all
+		if id, ok := c.sentBefore(from, args.ClientID); ok {
+			slog.Debug("Ignored message sent again", "id", id, "from", from)
+			return nil, nil
+		}
+		kind := chat.KindChat
+		if args.Emote {
+			kind = chat.KindEmote
+		}
+		var job *storeJob
+		r, msg, job, shadow, err = c.sendMessage(from, args.Room, args.Message, kind, args.InReplyTo, args.ClientID)
+		return job, err
+	})
+	if err != nil || r == nil {
+		return err
+	}
+
+	// Set reply with the unseen part of the history, which clients that
+	// said Hello get from WaitForMessages instead
+	c.mu.RLock()
+	protocol, hide := c.protocol, c.blockedBy(msg.Sender)
+	c.mu.RUnlock()
+	if protocol >= 2 {
+		return nil
+	}
+	history, _ := r.history.tail(args.LastIndex)
+	reply.History = formatMessages(without(history, hide))
+	if shadow {
+		reply.History = append(reply.History, msg.String())
+	}
+
+	return nil
+}
+
+// sendMessage posts text of the given kind, chat or emote, from the user
+// from to a room, in reply to message inReplyTo unless it is 0, with the
+// sender's ClientID if any, and returns the room, the new message and the
+// job saving it. A shadow-muted message is only shown to its sender and
+// not kept in the room's history. The caller must hold c.mu, for reading
+// at least, and have checked the length.
+func (c *chatConn) sendMessage(from, room, text string, kind chat.MessageKind, inReplyTo int64, clientID string) (r *room, msg chat.Message, job *storeJob, shadow bool, err error) {
+	name := roomName(room)
+	r, err = c.joinedRoom(from, name)
+	if err != nil {
+		return nil, msg, nil, false, err
+	}
+	thread, err := threadOf(r, name, inReplyTo)
+	if err != nil {
+		return nil, msg, nil, false, err
+	}
+	if err := c.checkRate(); err != nil {
+		return nil, msg, nil, false, err
+	}
+	shadow, err = c.checkMuted(from)
+	if err != nil {
+		return nil, msg, nil, false, err
+	}
+	if err := c.checkSpam(from, text); err != nil {
+		return nil, msg, nil, false, err
+	}
+	if text, err = c.filterText(text); err != nil {
+		return nil, msg, nil, false, err
+	}
+
+	msg = c.newMessage(name, from, "", text)
+	msg.Kind = kind
+	msg.InReplyTo = inReplyTo
+	msg.Thread = thread
+	msg.Mentions = c.mentions(text)
+	msg.ClientID = clientID
+	if shadow {
+		c.echo(&msg)
+		return r, msg, nil, true, nil
+	}
+
+	// Save and broadcast the new message
+	if job, err = c.post(&msg); err != nil {
+		return nil, msg, nil, false, err
+	}
+
+	slog.Debug("Received message", "id", msg.ID, "from", from, "room", name, "message", text)
+
+	return r, msg, job, false, nil
+}
+
+// joinedRoom looks up a room for from to post to, which they must have
+// joined unless it is the default room. The caller must hold s.mu.
+func (s *ChatServer) joinedRoom(from, name string) (*room, error) {
+	r, err := s.findRoom(name)
+	if err != nil {
+		return nil, err
+	}
+	if name != chat.DefaultRoom && !r.members[from] {
+		return nil, fmt.Errorf("you have not joined room %q", name)
+	}
+	return r, nil
+}
+
+// threadOf returns the thread a reply to message inReplyTo in room r,
+// called name, belongs to, or 0 if inReplyTo is 0. Replies must stay in
+// the room of the message they answer. The caller must hold s.mu.
+func threadOf(r *room, name string, inReplyTo int64) (int64, error) {
+	if inReplyTo == 0 {
+		return 0, nil
+	}
+	target, ok := r.history.find(inReplyTo)
+	if !ok || !target.IsChat() || !target.Deleted.IsZero() {
+		return 0, fmt.Errorf("message %d not found in %s", inReplyTo, name)
+	}
+	if target.Thread != 0 {
+		return target.Thread, nil
+	}
+	return target.ID, nil
+}
+
+// GetHistory returns a page of a room's history: the newest messages, or
+// those before args.Before to page backwards. Without a limit it returns
+// all the history still kept in memory.
+func (s *ChatServer) GetHistory(args *chat.HistoryArgs, reply *chat.HistoryPage) error {
+	if args.Limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+
+	s.mu.RLock()
+	r, err := s.readableRoom(roomName(args.Room), args.Token)
+	if err != nil {
+		s.mu.RUnlock()
+		return err
+	}
+	name := s.reader(args.Token)
+	history, hide := r.history.snapshot(), s.blockedBy(name)
+	s.mu.RUnlock()
+
+	reply.Messages, reply.More = history.page(args.Before, args.Limit, hide)
+	reply.LastIndex = history.len()
+	s.receipts.deliver(name, reply.Messages)
+
+	return nil
+}
+
+// GetThread returns the message that started the thread containing
+// args.ID followed by every reply in it, as far as they are still kept
+func (s *ChatServer) GetThread(args *chat.ThreadArgs, reply *chat.ThreadReply) error {
+	s.mu.RLock()
+	name := roomName(args.Room)
+	r, err := s.readableRoom(name, args.Token)
+	if err != nil {
+		s.mu.RUnlock()
+		return err
+	}
+	history, hide := r.history.snapshot(), s.blockedBy(s.reader(args.Token))
+	s.mu.RUnlock()
+
+	m, ok := history.find(args.ID)
+	if !ok || !m.IsChat() {
+		return fmt.Errorf("message %d not found in %s", args.ID, name)
+	}
+	reply.Thread = m.Thread
+	if reply.Thread == 0 {
+		reply.Thread = m.ID
+	}
+
+	for _, m := range history.buf {
+		if m.IsChat() && (m.ID == reply.Thread || m.Thread == reply.Thread) && (hide == nil || !hide(m)) {
+			reply.Messages = append(reply.Messages, m)
+		}
+	}
+
+	return nil
+}
+
+// GetMentions returns the messages in rooms and private conversations that
+// mention the caller
+func (c *chatConn) GetMentions(args *chat.MentionsArgs, reply *chat.MentionsReply) error {
+	if args.Limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+
+	c.mu.RLock()
+	name, err := c.sender(args.Token, args.Name)
+	if err != nil {
+		c.mu.RUnlock()
+		return err
+	}
+	logs, hide := c.readableLogs(name), c.blockedBy(name)
+	c.mu.RUnlock()
+
+	reply.Messages = collect(logs, hide, args.Limit, func(m chat.Message) bool {
+		return mentioned(m, name)
+	})
+
+	return nil
+}
+
+// SearchHistory returns the messages in rooms and the caller's private
+// conversations that contain every word of args.Query and match the other
+// filters. Only the history still kept in memory is searched.
+func (c *chatConn) SearchHistory(args *chat.SearchArgs, reply *chat.SearchReply) error {
+	terms := strings.Fields(strings.ToLower(args.Query))
+	sender := strings.TrimSpace(args.Sender)
+	if len(terms) == 0 && sender == "" {
+		return errors.New("search for some words or a sender")
+	}
+	if args.Limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+
+	c.mu.RLock()
+	name, err := c.sender(args.Token, args.Name)
+	if err != nil {
+		c.mu.RUnlock()
+		return err
+	}
+	logs, hide := c.readableLogs(name), c.blockedBy(name)
+	c.mu.RUnlock()
+
+	reply.Messages = collect(logs, hide, args.Limit, func(m chat.Message) bool {
+		if sender != "" && m.Sender != sender {
+			return false
+		}
+		if !args.After.IsZero() && !m.Timestamp.After(args.After) {
+			return false
+		}
+		if !args.Before.IsZero() && !m.Timestamp.Before(args.Before) {
+			return false
+		}
+		body := strings.ToLower(m.Body)
+		for _, term := range terms {
+			if !strings.Contains(body, term) {
+				return false
+			}
+		}
+		return true
+	})
+
+	return nil
+}
+
+// readableLogs returns snapshots of name's private history and of every
+// room they may read. The caller must hold s.mu, for reading at least.
+func (s *ChatServer) readableLogs(name string) []logSnapshot {
+	logs := []logSnapshot{s.directHistory(name).snapshot()}
+	for _, r := range s.rooms {
+		if s.canRead(r, name) {
+			logs = append(logs, r.history.snapshot())
+		}
+	}
+	return logs
+}
+
+// collect returns the newest limit chat messages in logs that match
+// reports true for, oldest first, leaving out those hide reports true for.
+// A limit of 0 returns them all and a nil hide hides nothing.
+func collect(logs []logSnapshot, hide func(chat.Message) bool, limit int, match func(chat.Message) bool) []chat.Message {
+	var messages []chat.Message
+	for _, l := range logs {
+		for _, m := range l.buf {
+			if m.IsChat() && m.Deleted.IsZero() && (hide == nil || !hide(m)) && match(m) {
+				messages = append(messages, m)
+			}
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].ID < messages[j].ID
+	})
+	if n := len(messages); limit > 0 && n > limit {
+		messages = messages[n-limit:]
+	}
+	return messages
+}
+
+// GetMessagesSince returns only the messages newer than args.LastIndex
+func (s *ChatServer) GetMessagesSince(args *chat.SinceArgs, reply *chat.MessagesReply) error {
+	s.mu.RLock()
+	r, err := s.readableRoom(roomName(args.Room), args.Token)
+	if err != nil {
+		s.mu.RUnlock()
+		return err
+	}
+	name := s.reader(args.Token)
+	hide := s.blockedBy(name)
+	s.mu.RUnlock()
+
+	messages, last := r.history.tail(args.LastIndex)
+	reply.Messages, reply.LastIndex = without(messages, hide), last
+	s.receipts.deliver(name, reply.Messages)
+
+	return nil
+}
+
+// WaitForMessages blocks until messages newer than args.LastIndex are
+// available and returns only those messages. An empty reply means the wait
+// timed out and the client should simply call again.
+func (c *chatConn) WaitForMessages(args *chat.SinceArgs, reply *chat.MessagesReply) error {
+	return c.waitFor(args.LastIndex, reply, func() (*messageLog, string, error) {
+		r, err := c.readableRoom(roomName(args.Room), args.Token)
+		if err != nil {
+			return nil, "", err
+		}
+		return &r.history, c.reader(args.Token), nil
+	})
+}
+
+// waitFor blocks until the history returned by lookup grows past since, the
+// wait times out or the client disconnects. lookup is called with c.mu held
+// for reading and also returns who is reading, whose blocked users are
+// filtered out. The history itself is read after letting go of c.mu.
+func (c *chatConn) waitFor(since int, reply *chat.MessagesReply, lookup func() (*messageLog, string, error)) error {
+	timer := time.NewTimer(waitTimeout)
+	defer timer.Stop()
+
+	for {
+		// Taken first, so that a message added after the history is read
+		// still wakes the wait up
+		updated := c.updates()
+		c.mu.RLock()
+		history, name, err := lookup()
+		if err != nil {
+			c.mu.RUnlock()
+			return err
+		}
+		hide := c.blockedBy(name)
+		c.mu.RUnlock()
+
+		messages, last := history.tail(since)
+		// A position past the end means the server restarted; answer right
+		// away so the client can resync from reply.LastIndex. Answer too if
+		// every new message was filtered out, so the client moves past them.
+		if last != since {
+			reply.Messages, reply.LastIndex = without(messages, hide), last
+			c.receipts.deliver(name, reply.Messages)
+			return nil
+		}
+		reply.LastIndex = last
+
+		// Wait for the next message or give up after the timeout
+		select {
+		case <-updated:
+		case <-timer.C:
+			return nil
+		case <-c.closed:
+			return nil
+		}
+	}
+}
+
+// SendDirectMessage delivers a private message to a single recipient. It is
+// stored in the private history of both sender and recipient only.
+func (c *chatConn) SendDirectMessage(args *chat.DirectMessageArgs, _ *struct{}) error {
+	to := strings.TrimSpace(args.To)
+	if to == "" {
+		return errors.New("recipient is required")
+	}
+	if err := c.checkLength(args.Message); err != nil {
+		return err
+	}
+
+	return c.posting(c.mu.RLocker(), func() (*storeJob, error) {
+		from, err := c.sender(args.Token, args.Name)
+		if err != nil {
+			return nil, err
+		}
+		if id, ok := c.sentBefore(from, args.ClientID); ok {
+			slog.Debug("Ignored direct message sent again", "id", id, "from", from)
+			return nil, nil
+		}
+		if err := c.checkRate(); err != nil {
+			return nil, err
+		}
+		shadow, err := c.checkMuted(from)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.checkSpam(from, args.Message); err != nil {
+			return nil, err
+		}
+		text, err := c.filterText(args.Message)
+		if err != nil {
+			return nil, err
+		}
+		msg := c.newMessage("", from, to, text)
+		msg.Mentions = c.mentions(text)
+		msg.ClientID = args.ClientID
+		if shadow {
+			c.echo(&msg)
+			return nil, nil
+		}
+		job, err := c.post(&msg)
+		if err != nil {
+			return nil, err
+		}
+
+		slog.Debug("Received direct message", "id", msg.ID, "from", from, "to", to)
+
+		return job, nil
+	})
+}
+
+// EditMessage replaces the body of one of the caller's own messages, as
+// long as it was sent less than editWindow ago. The edit is posted as a
+// chat.KindEdit message so that clients following the room see it.
+func (c *chatConn) EditMessage(args *chat.EditArgs, _ *struct{}) error {
+	if err := c.checkLength(args.Message); err != nil {
+		return err
+	}
+
+	return c.posting(c.mu.RLocker(), func() (*storeJob, error) {
+		from, err := c.sender(args.Token, args.Name)
+		if err != nil {
+			return nil, err
+		}
+		target, ok := c.findMessage(args.ID)
+		if !ok || !target.IsChat() || !target.Deleted.IsZero() {
+			return nil, fmt.Errorf("message %d not found", args.ID)
+		}
+		if target.Sender != from {
+			return nil, errors.New("you can only edit your own messages")
+		}
+		if target.Kind == chat.KindPoll || target.Kind == chat.KindFile {
+			return nil, errors.New("only text messages can be edited")
+		}
+		if c.editWindow > 0 && time.Since(target.Timestamp) > c.editWindow {
+			return nil, fmt.Errorf("messages can only be edited for %v after sending", c.editWindow)
+		}
+		if err := c.checkRate(); err != nil {
+			return nil, err
+		}
+		shadow, err := c.checkMuted(from)
+		if err != nil {
+			return nil, err
+		}
+		text, err := c.filterText(args.Message)
+		if err != nil {
+			return nil, err
+		}
+
+		edit := c.newMessage(target.Room, from, target.To, text)
+		edit.Kind = chat.KindEdit
+		edit.Ref = target.ID
+		edit.Mentions = c.mentions(text)
+		if shadow {
+			c.echo(&edit)
+			return nil, nil
+		}
+		job, err := c.post(&edit)
+		if err != nil {
+			return nil, err
+		}
+		slog.Debug("Edited message", "id", target.ID, "by", from)
+
+		return job, nil
+	})
+}
+
+// DeleteMessage replaces a message with a tombstone. Users can delete their
+// own messages, and moderators and above those of users below them. Like an
+// edit, the deletion is posted as a chat.KindDelete message so that clients
+// following the room see it, and muted users may still delete what they
+// wrote.
+func (c *chatConn) DeleteMessage(args *chat.DeleteArgs, _ *struct{}) error {
+	return c.posting(c.mu.RLocker(), func() (*storeJob, error) {
+		from, err := c.sender(args.Token, args.Name)
+		if err != nil {
+			return nil, err
+		}
+		target, ok := c.findMessage(args.ID)
+		if !ok || !target.IsChat() || !target.Deleted.IsZero() {
+			return nil, fmt.Errorf("message %d not found", args.ID)
+		}
+		moderating := target.Sender != from
+		if moderating {
+			if !c.can(from, permDelete) {
+				return nil, errors.New("you can only delete your own messages")
+			}
+			if err := c.outranks(from, target.Sender); err != nil {
+				return nil, err
+			}
+		}
+		if err := c.checkRate(); err != nil {
+			return nil, err
+		}
+
+		del := c.newMessage(target.Room, from, target.To, "")
+		del.Kind = chat.KindDelete
+		del.Ref = target.ID
+		if c.muted[from] && !moderating {
+			c.echo(&del)
+			return nil, nil
+		}
+		job, err := c.post(&del)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("Deleted message", "id", target.ID, "sender", target.Sender, "by", from)
+		if moderating {
+			c.record(from, auditDelete, target.Sender, fmt.Sprintf("message %d: %s", target.ID, target.Body), args.Reason)
+		}
+
+		return job, nil
+	})
+}
+
+// postDelete deletes target on behalf of by, posting a chat.KindDelete
+// message. The caller must hold s.mu.
+func (s *ChatServer) postDelete(by string, target chat.Message) (*storeJob, error) {
+	del := s.newMessage(target.Room, by, target.To, "")
+	del.Kind = chat.KindDelete
+	del.Ref = target.ID
+	return s.post(&del)
+}
+
+// ReactToMessage adds a reaction from the caller to a message. The reaction
+// is posted as a chat.KindReact message so that clients following the room see it.
+func (c *chatConn) ReactToMessage(args *chat.ReactionArgs, _ *struct{}) error {
+	return c.react(args, true)
+}
+
+// RemoveReaction takes back a reaction the caller added to a message
+func (c *chatConn) RemoveReaction(args *chat.ReactionArgs, _ *struct{}) error {
+	return c.react(args, false)
+}
+
+// react implements ReactToMessage and RemoveReaction
+func (c *chatConn) react(args *chat.ReactionArgs, add bool) error {
+	reaction := strings.TrimSpace(args.Reaction)
+	if reaction == "" || strings.ContainsAny(reaction, " \t\n") {
+		return errors.New("a reaction must be a single emoji or word")
+	}
+	if len(reaction) > maxReactionLength {
+		return fmt.Errorf("reactions can be at most %d bytes long", maxReactionLength)
+	}
+
+	return c.posting(c.mu.RLocker(), func() (*storeJob, error) {
+		from, err := c.sender(args.Token, args.Name)
+		if err != nil {
+			return nil, err
+		}
+		target, ok := c.findMessage(args.ID)
+		if !ok || !target.IsChat() || !target.Deleted.IsZero() {
+			return nil, fmt.Errorf("message %d not found", args.ID)
+		}
+		if target.To != "" && from != target.Sender && from != target.To {
+			return nil, fmt.Errorf("message %d not found", args.ID)
+		}
+		if add && reacted(target, from, reaction) {
+			return nil, fmt.Errorf("you already reacted %s to message %d", reaction, args.ID)
+		}
+		if !add && !reacted(target, from, reaction) {
+			return nil, fmt.Errorf("you did not react %s to message %d", reaction, args.ID)
+		}
+		if err := c.checkRate(); err != nil {
+			return nil, err
+		}
+		shadow, err := c.checkMuted(from)
+		if err != nil {
+			return nil, err
+		}
+
+		// Reactions to a direct message go to whoever the caller is talking to
+		to := target.To
+		if to == from {
+			to = target.Sender
+		}
+		event := c.newMessage(target.Room, from, to, reaction)
+		event.Kind = chat.KindUnreact
+		if add {
+			event.Kind = chat.KindReact
+		}
+		event.Ref = target.ID
+		if shadow {
+			c.echo(&event)
+			return nil, nil
+		}
+		return c.post(&event)
+	})
+}
+
+// GetDirectMessages returns the caller's private messages newer than
+// args.LastIndex
+func (c *chatConn) GetDirectMessages(args *chat.DirectSinceArgs, reply *chat.MessagesReply) error {
+	c.mu.RLock()
+	name, err := c.sender(args.Token, args.Name)
+	if err != nil {
+		c.mu.RUnlock()
+		return err
+	}
+	history, hide := c.directHistory(name), c.blockedBy(name)
+	c.mu.RUnlock()
+
+	messages, last := history.tail(args.LastIndex)
+	reply.Messages, reply.LastIndex = without(messages, hide), last
+	c.receipts.deliver(name, reply.Messages)
+
+	return nil
+}
+
+// WaitForDirectMessages is the private-message counterpart of WaitForMessages
+func (c *chatConn) WaitForDirectMessages(args *chat.DirectSinceArgs, reply *chat.MessagesReply) error {
+	return c.waitFor(args.LastIndex, reply, func() (*messageLog, string, error) {
+		name, err := c.sender(args.Token, args.Name)
+		if err != nil {
+			return nil, "", err
+		}
+		return c.directHistory(name), name, nil
+	})
+}
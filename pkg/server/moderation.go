@@ -0,0 +1,288 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// kickCooldown is how long a kicked user has to wait to log in again
+const kickCooldown = time.Minute
+
+// banned reports whether name, logging in from this connection, is banned.
+// Admins are exempt from address bans so they cannot lock themselves out
+// by banning someone on the same network. The caller must hold c.mu.
+func (c *chatConn) banned(name string) bool {
+	return c.bannedNames[name] || (c.bannedIPs[c.ip] && !c.isAdmin(name))
+}
+
+// kick ends a session, announces why and closes its connection. The user
+// cannot log in again for kickCooldown, so clients that reconnect by
+// themselves stay out for a while. The caller must hold s.mu.
+func (s *ChatServer) kick(sess *session, format string, args ...interface{}) {
+	s.endSession(sess, "kicked")
+	s.kicked[sess.name] = time.Now().Add(kickCooldown)
+	s.announce(format, args...)
+	sess.conn.conn.Close()
+}
+
+// KickUser disconnects a user. They may log in again after kickCooldown
+// unless they are also banned.
+func (c *chatConn) KickUser(args *chat.ModerationArgs, _ *struct{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	admin, err := c.authorize(args.Token, args.Name, permKick)
+	if err != nil {
+		return err
+	}
+	sess, ok := c.online[args.Target]
+	if !ok {
+		return fmt.Errorf("%s is not online", args.Target)
+	}
+	if err := c.outranks(admin, sess.name); err != nil {
+		return err
+	}
+
+	slog.Info("Kicked user", "name", sess.name, "by", admin)
+	c.record(admin, auditKick, sess.name, "", args.Reason)
+	c.kick(sess, "%s was kicked by %s", sess.name, admin)
+
+	return nil
+}
+
+// BanUser stops a user from logging in again and disconnects them. Banning
+// a name that is online also bans the address it is connected from;
+// banning an IP address disconnects everyone connected from it whose role
+// is below the caller's.
+func (c *chatConn) BanUser(args *chat.ModerationArgs, _ *struct{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	admin, err := c.authorize(args.Token, args.Name, permBan)
+	if err != nil {
+		return err
+	}
+	target := strings.TrimSpace(args.Target)
+	if target == "" {
+		return errors.New("a name or IP address to ban is required")
+	}
+
+	if ip := net.ParseIP(target); ip != nil {
+		target = ip.String()
+		c.bannedIPs[target] = true
+		slog.Info("Banned address", "ip", target, "by", admin)
+		c.record(admin, auditBan, target, "", args.Reason)
+		for _, sess := range c.online {
+			if sess.conn.ip == target && c.outranks(admin, sess.name) == nil {
+				c.kick(sess, "%s was banned by %s", sess.name, admin)
+			}
+		}
+		return nil
+	}
+
+	if err := c.outranks(admin, target); err != nil {
+		return err
+	}
+	c.bannedNames[target] = true
+	slog.Info("Banned user", "name", target, "by", admin)
+	c.record(admin, auditBan, target, "", args.Reason)
+	if sess, ok := c.online[target]; ok {
+		c.bannedIPs[sess.conn.ip] = true
+		slog.Info("Banned address", "ip", sess.conn.ip, "by", admin)
+		c.record(admin, auditBan, sess.conn.ip, "address of "+target, args.Reason)
+		c.kick(sess, "%s was banned by %s", sess.name, admin)
+	}
+
+	return nil
+}
+
+// UnbanUser lifts the ban on a name or IP address
+func (c *chatConn) UnbanUser(args *chat.ModerationArgs, _ *struct{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	admin, err := c.authorize(args.Token, args.Name, permBan)
+	if err != nil {
+		return err
+	}
+	target := strings.TrimSpace(args.Target)
+
+	bans := c.bannedNames
+	if ip := net.ParseIP(target); ip != nil {
+		target = ip.String()
+		bans = c.bannedIPs
+	}
+	if !bans[target] {
+		return fmt.Errorf("%s is not banned", target)
+	}
+	delete(bans, target)
+	slog.Info("Unbanned", "target", target, "by", admin)
+	c.record(admin, auditUnban, target, "", args.Reason)
+
+	return nil
+}
+
+// MuteUser stops a user from sending messages. A normal mute makes their
+// sends fail; a shadow mute lets them appear to succeed while nobody else
+// sees them.
+func (c *chatConn) MuteUser(args *chat.ModerationArgs, _ *struct{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	admin, err := c.authorize(args.Token, args.Name, permMute)
+	if err != nil {
+		return err
+	}
+	target := strings.TrimSpace(args.Target)
+	if target == "" {
+		return errors.New("a name to mute is required")
+	}
+	if err := c.outranks(admin, target); err != nil {
+		return err
+	}
+
+	c.muted[target] = args.Shadow
+	if args.Shadow {
+		slog.Info("Shadow-muted user", "name", target, "by", admin)
+		c.record(admin, auditShadowMute, target, "", args.Reason)
+	} else {
+		slog.Info("Muted user", "name", target, "by", admin)
+		c.record(admin, auditMute, target, "", args.Reason)
+	}
+
+	return nil
+}
+
+// UnmuteUser lets a muted user send messages again, also lifting an
+// automatic mute for spamming
+func (c *chatConn) UnmuteUser(args *chat.ModerationArgs, _ *struct{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	admin, err := c.authorize(args.Token, args.Name, permMute)
+	if err != nil {
+		return err
+	}
+	target := strings.TrimSpace(args.Target)
+	c.limitsMu.Lock()
+	defer c.limitsMu.Unlock()
+	_, muted := c.muted[target]
+	spam, ok := c.spam[target]
+	spamMuted := ok && time.Now().Before(spam.mutedUntil)
+	if !muted && !spamMuted {
+		return fmt.Errorf("%s is not muted", target)
+	}
+	delete(c.muted, target)
+	if spamMuted {
+		spam.mutedUntil = time.Time{}
+	}
+	slog.Info("Unmuted user", "name", target, "by", admin)
+	c.record(admin, auditUnmute, target, "", args.Reason)
+
+	return nil
+}
+
+// checkLength returns an error if body is longer than the server accepts
+func (s *ChatServer) checkLength(body string) error {
+	if len(body) > s.maxLength {
+		return fmt.Errorf("message is %d bytes long, the limit is %d", len(body), s.maxLength)
+	}
+	return nil
+}
+
+// truncateText cuts text to at most n bytes, between runes
+func truncateText(text string, n int) string {
+	for len(text) > n {
+		_, size := utf8.DecodeLastRuneInString(text)
+		text = text[:len(text)-size]
+	}
+	return text
+}
+
+// checkMuted returns an error if name is muted, and reports whether they
+// are shadow-muted instead. The caller must hold s.mu, for reading at
+// least.
+func (s *ChatServer) checkMuted(name string) (shadow bool, err error) {
+	shadow, ok := s.muted[name]
+	if ok && !shadow {
+		return false, errors.New("you have been muted by a moderator")
+	}
+	return shadow, nil
+}
+
+// echo stamps a shadow-muted message and shows it to its sender only,
+// through their private feed, without saving it. The caller must hold
+// s.mu, for reading at least.
+func (s *ChatServer) echo(msg *chat.Message) {
+	s.show(msg.Sender, msg)
+	s.remember(*msg)
+	slog.Debug("Dropped message from shadow-muted user", "id", msg.ID, "name", msg.Sender)
+}
+
+// tokenBucket allows bursts of messages while capping the average rate
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket for the time since it was last used and removes
+// n tokens, reporting false if there were not that many left
+func (b *tokenBucket) take(n int, rate float64, burst int, now time.Time) bool {
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// idle reports whether the bucket has refilled completely, so forgetting it
+// changes nothing
+func (b *tokenBucket) idle(rate float64, burst int, now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*rate >= float64(burst)
+}
+
+// checkRate returns an error if this client is sending faster than the rate
+// limit. Clients are counted by name once logged in, or by address.
+// The caller must hold c.mu, for reading at least.
+func (c *chatConn) checkRate() error {
+	return c.checkBatchRate(1)
+}
+
+// checkBatchRate is checkRate for n messages sent at once. A batch larger
+// than the burst the limit allows is always rejected.
+// The caller must hold c.mu, for reading at least.
+func (c *chatConn) checkBatchRate(n int) error {
+	if c.rateLimit <= 0 {
+		return nil
+	}
+	if n > c.rateBurst {
+		return fmt.Errorf("rate limit exceeded: at most %d messages can be sent at once", c.rateBurst)
+	}
+
+	key := "ip:" + c.ip
+	if sess, ok := c.session(); ok {
+		key = sess.name
+	}
+	c.limitsMu.Lock()
+	defer c.limitsMu.Unlock()
+	b, ok := c.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(c.rateBurst), last: time.Now()}
+		c.buckets[key] = b
+	}
+	if !b.take(n, c.rateLimit, c.rateBurst, time.Now()) {
+		slog.Warn("Rate limited", "key", key)
+		return fmt.Errorf("rate limit exceeded: at most %g messages per second, please slow down", c.rateLimit)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+func TestModeration(t *testing.T) {
+	s, tokens := team(t, Config{})
+	kick := func(c *chatConn, args *chat.ModerationArgs) error { return c.KickUser(args, nil) }
+	ban := func(c *chatConn, args *chat.ModerationArgs) error { return c.BanUser(args, nil) }
+	mute := func(c *chatConn, args *chat.ModerationArgs) error { return c.MuteUser(args, nil) }
+
+	// The target's state afterwards
+	type state struct{ online, muted, banned bool }
+	untouched := state{online: true}
+	tests := []struct {
+		name   string
+		action func(*chatConn, *chat.ModerationArgs) error
+		actor  string
+		target string
+		ok     bool
+		after  state
+	}{
+		{"moderator kicks a user", kick, "moderator", "user", true, state{}},
+		{"moderator kicks a guest", kick, "moderator", "guest", true, state{}},
+		{"moderator cannot kick a moderator", kick, "moderator", "moderator", false, untouched},
+		{"moderator cannot kick an admin", kick, "moderator", "admin", false, untouched},
+		{"user cannot kick", kick, "user", "guest", false, untouched},
+		{"kicking someone offline", kick, "moderator", "nobody", false, state{}},
+		{"moderator mutes a user", mute, "moderator", "user", true, state{online: true, muted: true}},
+		{"moderator cannot mute an admin", mute, "moderator", "admin", false, untouched},
+		{"user cannot mute", mute, "user", "guest", false, untouched},
+		{"admin bans a moderator", ban, "admin", "moderator", true, state{banned: true}},
+		{"admin bans someone offline", ban, "admin", "nobody", true, state{banned: true}},
+		{"admin cannot ban the owner", ban, "admin", "owner", false, untouched},
+		{"moderator cannot ban", ban, "moderator", "user", false, untouched},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.mu.Lock()
+			clear(s.kicked)
+			clear(s.bannedNames)
+			clear(s.bannedIPs)
+			clear(s.muted)
+			_, online := s.online[tt.target]
+			s.mu.Unlock()
+			if !online && tt.target != "nobody" {
+				tokens[tt.target] = logIn(t, s, tt.target)
+			}
+
+			c := &chatConn{ChatServer: s}
+			err := tt.action(c, &chat.ModerationArgs{Token: tokens[tt.actor], Target: tt.target, Reason: "testing"})
+			if (err == nil) != tt.ok {
+				t.Errorf("error = %v, want ok: %v", err, tt.ok)
+			}
+
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			var got state
+			_, got.online = s.online[tt.target]
+			_, got.muted = s.muted[tt.target]
+			got.banned = s.bannedNames[tt.target]
+			if got != tt.after {
+				t.Errorf("%s is %+v afterwards, want %+v", tt.target, got, tt.after)
+			}
+		})
+	}
+}
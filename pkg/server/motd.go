@@ -0,0 +1,79 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// readMOTD reads the message of the day from path, returning fallback, the
+// message given in the config, if the file does not exist
+func readMOTD(path, fallback string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fallback, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// saveMOTD rewrites the message of the day file, if there is one, the
+// same way saveAccounts does. The caller must hold s.mu.
+func (s *ChatServer) saveMOTD() error {
+	if s.motdPath == "" {
+		return nil
+	}
+	tmp := s.motdPath + ".tmp"
+	if err := os.WriteFile(tmp, []byte(s.motd+"\n"), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.motdPath)
+}
+
+// GetMOTD returns the message of the day, which clients show after
+// connecting. It is empty if there is none.
+func (s *ChatServer) GetMOTD(_ *struct{}, reply *chat.MOTDReply) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	reply.Text = s.motd
+	return nil
+}
+
+// SetMOTD lets an admin change the message of the day, or clear it with
+// an empty text. Users who are online are told about the change.
+func (c *chatConn) SetMOTD(args *chat.MOTDArgs, _ *struct{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	admin, err := c.authorize(args.Token, args.Name, permSetMOTD)
+	if err != nil {
+		return err
+	}
+	text := strings.TrimSpace(args.Text)
+	if err := c.checkLength(text); err != nil {
+		return err
+	}
+
+	old := c.motd
+	c.motd = text
+	if err := c.saveMOTD(); err != nil {
+		c.motd = old
+		slog.Error("Error saving the message of the day", "err", err)
+		return errors.New("could not save the message of the day")
+	}
+
+	slog.Info("Changed the message of the day", "by", admin)
+	c.record(admin, auditSetMOTD, "", text, "")
+	if text == "" {
+		c.announce("%s removed the message of the day", admin)
+	} else {
+		c.announce("%s changed the message of the day: %s", admin, text)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// PinMessage pins a message to its room, where GetPins lists it. Like an
+// edit, the pin is posted as a chat.KindPin message, so it is kept in the
+// store and everyone following the room sees it.
+func (c *chatConn) PinMessage(args *chat.PinArgs, _ *struct{}) error {
+	return c.pin(args, true)
+}
+
+// UnpinMessage takes a message off its room's pins
+func (c *chatConn) UnpinMessage(args *chat.PinArgs, _ *struct{}) error {
+	return c.pin(args, false)
+}
+
+// pin implements PinMessage and UnpinMessage
+func (c *chatConn) pin(args *chat.PinArgs, pin bool) error {
+	return c.posting(c.mu.RLocker(), func() (*storeJob, error) {
+		from, err := c.authorize(args.Token, args.Name, permPin)
+		if err != nil {
+			return nil, err
+		}
+		target, ok := c.findMessage(args.ID)
+		if !ok || !target.IsChat() || !target.Deleted.IsZero() {
+			return nil, fmt.Errorf("message %d not found", args.ID)
+		}
+		if target.To != "" {
+			return nil, fmt.Errorf("only messages in rooms can be pinned")
+		}
+		if pinned := !target.Pinned.IsZero(); pinned == pin {
+			if pin {
+				return nil, fmt.Errorf("message %d is already pinned", args.ID)
+			}
+			return nil, fmt.Errorf("message %d is not pinned", args.ID)
+		}
+
+		event := c.newMessage(target.Room, from, "", "")
+		event.Kind = chat.KindUnpin
+		if pin {
+			event.Kind = chat.KindPin
+		}
+		event.Ref = target.ID
+		job, err := c.post(&event)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("Changed pin", "id", target.ID, "room", target.Room, "pinned", pin, "by", from)
+
+		return job, nil
+	})
+}
+
+// GetPins returns the pinned messages of a room
+func (s *ChatServer) GetPins(args *chat.RoomArgs, reply *chat.PinsReply) error {
+	s.mu.RLock()
+	r, err := s.readableRoom(roomName(args.Room), args.Token)
+	if err != nil {
+		s.mu.RUnlock()
+		return err
+	}
+	history, hide := r.history.snapshot(), s.blockedBy(s.reader(args.Token))
+	s.mu.RUnlock()
+
+	for _, m := range history.buf {
+		if m.IsChat() && !m.Pinned.IsZero() && m.Deleted.IsZero() && (hide == nil || !hide(m)) {
+			reply.Messages = append(reply.Messages, m)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// maxPollOption limits the length of each option of a poll in bytes
+const maxPollOption = 100
+
+// CreatePoll starts a poll in a room the caller has joined. The poll is a
+// chat.KindPoll message, so it is kept in the store like any other, and
+// votes and its closing are events referring to it, so everyone following
+// the room sees the tally change as it happens.
+func (c *chatConn) CreatePoll(args *chat.PollArgs, reply *chat.PollReply) error {
+	question := strings.TrimSpace(args.Question)
+	if question == "" || strings.Contains(question, "\n") {
+		return errors.New("a poll needs a question on a single line")
+	}
+	if len(args.Options) < chat.MinPollOptions || len(args.Options) > chat.MaxPollOptions {
+		return fmt.Errorf("a poll needs %d to %d options", chat.MinPollOptions, chat.MaxPollOptions)
+	}
+	options := make([]string, len(args.Options))
+	for i, option := range args.Options {
+		option = strings.TrimSpace(option)
+		if option == "" || strings.Contains(option, "\n") {
+			return errors.New("poll options must be on a single line and not empty")
+		}
+		if len(option) > maxPollOption {
+			return fmt.Errorf("poll options can be at most %d bytes long", maxPollOption)
+		}
+		if slices.Contains(options[:i], option) {
+			return fmt.Errorf("option %q is given twice", option)
+		}
+		options[i] = option
+	}
+	body := chat.PollBody(question, options)
+	if err := c.checkLength(body); err != nil {
+		return err
+	}
+
+	return c.posting(c.mu.RLocker(), func() (*storeJob, error) {
+		from, err := c.sender(args.Token, args.Name)
+		if err != nil {
+			return nil, err
+		}
+		name := roomName(args.Room)
+		if _, err := c.joinedRoom(from, name); err != nil {
+			return nil, err
+		}
+		if err := c.checkRate(); err != nil {
+			return nil, err
+		}
+		shadow, err := c.checkMuted(from)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.checkSpam(from, body); err != nil {
+			return nil, err
+		}
+		if body, err = c.filterText(body); err != nil {
+			return nil, err
+		}
+
+		msg := c.newMessage(name, from, "", body)
+		msg.Kind = chat.KindPoll
+		msg.Poll = chat.NewPoll(body)
+		msg.Mentions = c.mentions(body)
+		if shadow {
+			c.echo(&msg)
+			reply.ID = msg.ID
+			return nil, nil
+		}
+		job, err := c.post(&msg)
+		if err != nil {
+			return nil, err
+		}
+		reply.ID = msg.ID
+		slog.Info("Started poll", "id", msg.ID, "room", name, "by", from)
+
+		return job, nil
+	})
+}
+
+// Vote votes for an option of a poll, replacing any earlier vote of the
+// caller's in it
+func (c *chatConn) Vote(args *chat.VoteArgs, _ *struct{}) error {
+	return c.posting(c.mu.RLocker(), func() (*storeJob, error) {
+		from, err := c.sender(args.Token, args.Name)
+		if err != nil {
+			return nil, err
+		}
+		target, err := c.findPoll(args.ID)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := c.joinedRoom(from, target.Room); err != nil {
+			return nil, err
+		}
+		if args.Option < 1 || args.Option > len(target.Poll.Options) {
+			return nil, fmt.Errorf("poll %d has no option %d", args.ID, args.Option)
+		}
+		if target.Poll.Votes[from] == args.Option {
+			return nil, fmt.Errorf("you already voted for option %d", args.Option)
+		}
+		if err := c.checkRate(); err != nil {
+			return nil, err
+		}
+		shadow, err := c.checkMuted(from)
+		if err != nil {
+			return nil, err
+		}
+
+		event := c.newMessage(target.Room, from, "", strconv.Itoa(args.Option))
+		event.Kind = chat.KindVote
+		event.Ref = target.ID
+		if shadow {
+			c.echo(&event)
+			return nil, nil
+		}
+		return c.post(&event)
+	})
+}
+
+// ClosePoll ends the voting in a poll. Whoever started it may close it,
+// and moderators may close anyone's.
+func (c *chatConn) ClosePoll(args *chat.VoteArgs, _ *struct{}) error {
+	return c.posting(c.mu.RLocker(), func() (*storeJob, error) {
+		from, err := c.sender(args.Token, args.Name)
+		if err != nil {
+			return nil, err
+		}
+		target, err := c.findPoll(args.ID)
+		if err != nil {
+			return nil, err
+		}
+		if target.Sender != from {
+			if !c.can(from, permDelete) {
+				return nil, errors.New("only whoever started a poll can close it")
+			}
+			if err := c.outranks(from, target.Sender); err != nil {
+				return nil, err
+			}
+		}
+		if err := c.checkRate(); err != nil {
+			return nil, err
+		}
+
+		event := c.newMessage(target.Room, from, "", "")
+		event.Kind = chat.KindClosePoll
+		event.Ref = target.ID
+		job, err := c.post(&event)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("Closed poll", "id", target.ID, "room", target.Room, "by", from)
+
+		return job, nil
+	})
+}
+
+// findPoll returns the poll with the given ID, as long as it is still
+// open. The caller must hold s.mu.
+func (s *ChatServer) findPoll(id int64) (chat.Message, error) {
+	target, ok := s.findMessage(id)
+	if !ok || target.Kind != chat.KindPoll || !target.Deleted.IsZero() || target.Poll == nil {
+		return target, fmt.Errorf("poll %d not found", id)
+	}
+	if !target.Poll.Closed.IsZero() {
+		return target, fmt.Errorf("poll %d is closed", id)
+	}
+	return target, nil
+}
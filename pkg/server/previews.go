@@ -0,0 +1,256 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+	"golang.org/x/net/html"
+)
+
+// Limits on fetching link previews, so that slow or huge pages cannot
+// hold up the server
+const (
+	previewTimeout   = 5 * time.Second // for the whole fetch
+	maxPreviewPage   = 512 << 10       // bytes of a page read looking for its title
+	maxPreviewText   = 200             // runes kept of the title and of the description
+	maxPreviewFetch  = 4               // fetches running at once; more links go without
+	maxPreviewRedirs = 3
+)
+
+// newPreviewClient returns the HTTP client link previews are fetched with.
+// It only follows redirects to hosts that are allowed too.
+func (s *ChatServer) newPreviewClient() *http.Client {
+	return &http.Client{
+		Timeout: previewTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxPreviewRedirs {
+				return errors.New("too many redirects")
+			}
+			if !s.previewAllowed(req.URL) {
+				return fmt.Errorf("redirected to %s, which is not allowed", req.URL.Host)
+			}
+			return nil
+		},
+	}
+}
+
+// previewAllowed reports whether pages at u may be fetched for previews:
+// it must be http or https, on one of the allowed hosts or a subdomain of
+// one
+func (s *ChatServer) previewAllowed(u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range s.previewHosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstLink returns the first link in body that previews may be fetched
+// for, or "" if there is none
+func (s *ChatServer) firstLink(body string) string {
+	for _, word := range strings.Fields(body) {
+		if !strings.HasPrefix(word, "http://") && !strings.HasPrefix(word, "https://") {
+			continue
+		}
+		link := strings.TrimRight(word, ".,:;!?)'\">")
+		if u, err := url.Parse(link); err == nil && s.previewAllowed(u) {
+			return link
+		}
+	}
+	return ""
+}
+
+// previewLinks starts fetching a preview of the first link in a message
+// that was just posted, or edited, unless previews are turned off or too
+// many fetches are running already. The preview is posted as a
+// chat.KindPreview event once it arrives. The caller must hold s.mu.
+func (s *ChatServer) previewLinks(msg chat.Message) {
+	if len(s.previewHosts) == 0 {
+		return
+	}
+	target := msg.ID
+	switch msg.Kind {
+	case chat.KindChat, chat.KindEmote:
+	case chat.KindEdit:
+		target = msg.Ref
+	default:
+		return
+	}
+	link := s.firstLink(msg.Body)
+	if link == "" {
+		return
+	}
+	select {
+	case s.previewSlots <- struct{}{}:
+	default:
+		slog.Debug("Skipped link preview, too many fetches running", "id", target, "url", link)
+		return
+	}
+	go func() {
+		defer func() { <-s.previewSlots }()
+		preview, err := s.fetchPreview(link)
+		if err != nil {
+			slog.Debug("Error fetching link preview", "id", target, "url", link, "err", err)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err := s.attachPreview(target, preview); err != nil {
+			slog.Error("Error attaching link preview", "id", target, "err", err)
+		}
+	}()
+}
+
+// attachPreview posts a link preview for message id, as long as the
+// message is still there and still links to the page. Pages with words the
+// filter rejects get no preview. The caller must hold s.mu.
+func (s *ChatServer) attachPreview(id int64, preview *chat.Preview) error {
+	if s.stopping {
+		return nil
+	}
+	target, ok := s.findMessage(id)
+	if !ok || !target.Deleted.IsZero() || !strings.Contains(target.Body, preview.URL) {
+		return nil
+	}
+	var err error
+	if preview.Title, err = s.filterText(preview.Title); err != nil {
+		return nil
+	}
+	if preview.Description, err = s.filterText(preview.Description); err != nil {
+		return nil
+	}
+	event := s.newMessage(target.Room, target.Sender, target.To, preview.Body())
+	event.Kind = chat.KindPreview
+	event.Ref = id
+	_, err = s.post(&event)
+	return err
+}
+
+// fetchPreview fetches the page at link and returns its title and
+// description. Only HTML pages with a title have a preview.
+func (s *ChatServer) fetchPreview(link string) (*chat.Preview, error) {
+	ctx, cancel := s.requestContext(previewTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "chatroom-link-preview/"+Version)
+	req.Header.Set("Accept", "text/html")
+	resp, err := s.previewClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	if kind, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); kind != "text/html" {
+		return nil, fmt.Errorf("not a web page but %q", kind)
+	}
+
+	preview := &chat.Preview{URL: link}
+	preview.Title, preview.Description = pageSummary(io.LimitReader(resp.Body, maxPreviewPage))
+	if preview.Title == "" {
+		return nil, errors.New("the page has no title")
+	}
+	return preview, nil
+}
+
+// pageSummary reads an HTML page as far as the end of its head and returns
+// its title and description, preferring the Open Graph ones, each on one
+// line and cut to maxPreviewText
+func pageSummary(r io.Reader) (title, description string) {
+	var ogTitle, ogDescription string
+	z := html.NewTokenizer(r)
+	inTitle := false
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return summaryText(ogTitle, title), summaryText(ogDescription, description)
+		case html.TextToken:
+			if inTitle {
+				title += string(z.Text())
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = false
+			case "head":
+				return summaryText(ogTitle, title), summaryText(ogDescription, description)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = true
+			case "body":
+				return summaryText(ogTitle, title), summaryText(ogDescription, description)
+			case "meta":
+				var key, content string
+				for hasAttr {
+					var k, v []byte
+					k, v, hasAttr = z.TagAttr()
+					switch string(k) {
+					case "name", "property":
+						key = strings.ToLower(string(v))
+					case "content":
+						content = string(v)
+					}
+				}
+				switch key {
+				case "og:title":
+					ogTitle = content
+				case "og:description":
+					ogDescription = content
+				case "description":
+					description = content
+				}
+			}
+		}
+	}
+}
+
+// summaryText returns preferred, or else fallback, with control characters
+// taken out, runs of white space collapsed and cut to maxPreviewText runes.
+// Pages are written by anyone, and clients print previews to terminals,
+// where an escape sequence in a title would be obeyed.
+func summaryText(preferred, fallback string) string {
+	text := oneLine(preferred)
+	if text == "" {
+		text = oneLine(fallback)
+	}
+	if utf8.RuneCountInString(text) > maxPreviewText {
+		text = string([]rune(text)[:maxPreviewText]) + "…"
+	}
+	return text
+}
+
+// oneLine returns s with control characters as spaces and runs of white
+// space collapsed
+func oneLine(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPageSummary(t *testing.T) {
+	tests := []struct {
+		name        string
+		page        string
+		title       string
+		description string
+	}{
+		{"title", `<html><head><title>Example</title></head></html>`, "Example", ""},
+		{"description", `<head><title>Example</title><meta name="description" content="An example"></head>`, "Example", "An example"},
+		{"Open Graph preferred", `<head><title>Example</title><meta property="og:title" content="OG"><meta name="description" content="plain"><meta property="og:description" content="OG text"></head>`, "OG", "OG text"},
+		{"white space", "<title>\n  Two\n\tlines  </title>", "Two lines", ""},
+		{"escape sequences", "<title>\x1b[2J\x1b]0;owned\x07Example</title><meta property=\"og:description\" content=\"red\x1b[31m\u009b1mtext\">", "[2J ]0;owned Example", "red [31m 1mtext"},
+		{"after the head", `<head></head><title>Too late</title>`, "", ""},
+		{"long", "<title>" + strings.Repeat("a", maxPreviewText+10) + "</title>", strings.Repeat("a", maxPreviewText) + "…", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			title, description := pageSummary(strings.NewReader(tt.page))
+			if title != tt.title || description != tt.description {
+				t.Errorf("pageSummary = %q, %q; want %q, %q", title, description, tt.title, tt.description)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// Hello agrees on the protocol version used on this connection: the
+// newest one both sides speak. Clients that never call it speak version
+// 1. A client that needs a newer version than the server speaks is told so
+// here, rather than failing to decode replies later on.
+func (c *chatConn) Hello(args *chat.HelloArgs, reply *chat.HelloReply) error {
+	if args.Protocol < chat.MinProtocolVersion {
+		return fmt.Errorf("unknown protocol version %d", args.Protocol)
+	}
+	version := min(args.Protocol, chat.ProtocolVersion)
+	if version < args.MinProtocol {
+		return fmt.Errorf("the server speaks protocol versions up to %d, but the client needs %d or later", chat.ProtocolVersion, args.MinProtocol)
+	}
+
+	c.mu.Lock()
+	c.protocol = version
+	c.mu.Unlock()
+	reply.Protocol = version
+
+	return nil
+}
+
+// GetHistory is ChatServer.GetHistory for RPC clients, which also get the
+// page as strings unless they said Hello
+func (c *chatConn) GetHistory(args *chat.HistoryArgs, reply *chat.HistoryPage) error {
+	if err := c.ChatServer.GetHistory(args, reply); err != nil {
+		return err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.protocol < 2 {
+		reply.History = formatMessages(reply.Messages)
+	}
+	return nil
+}
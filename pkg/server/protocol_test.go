@@ -0,0 +1,53 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+func TestHello(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    chat.HelloArgs
+		want    int // the version agreed on, 0 if Hello fails
+		strings bool
+	}{
+		{"current", chat.HelloArgs{Protocol: chat.ProtocolVersion, MinProtocol: chat.MinProtocolVersion}, chat.ProtocolVersion, false},
+		{"newer client", chat.HelloArgs{Protocol: chat.ProtocolVersion + 3, MinProtocol: 1}, chat.ProtocolVersion, false},
+		{"oldest", chat.HelloArgs{Protocol: 1, MinProtocol: 1}, 1, true},
+		{"no minimum", chat.HelloArgs{Protocol: 2}, 2, false},
+		{"client needs a newer server", chat.HelloArgs{Protocol: chat.ProtocolVersion + 3, MinProtocol: chat.ProtocolVersion + 1}, 0, true},
+		{"unknown version", chat.HelloArgs{Protocol: 0}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, Config{})
+			c := &chatConn{ChatServer: s, protocol: 1}
+			var reply chat.HelloReply
+			err := c.Hello(&tt.args, &reply)
+			if (err != nil) != (tt.want == 0) {
+				t.Fatalf("Hello() error = %v, want an error: %v", err, tt.want == 0)
+			}
+			if reply.Protocol != tt.want {
+				t.Errorf("agreed on version %d, want %d", reply.Protocol, tt.want)
+			}
+
+			// Clients below version 2 also get the history as strings
+			msg := c.newMessage(chat.DefaultRoom, "alice", "", "hello")
+			c.mu.Lock()
+			job, err := c.post(&msg)
+			c.mu.Unlock()
+			if err := job.wait(); err != nil {
+				t.Fatal(err)
+			}
+			var page chat.HistoryPage
+			if err := c.GetHistory(&chat.HistoryArgs{}, &page); err != nil {
+				t.Fatal(err)
+			}
+			if got := len(page.History) > 0; got != tt.strings {
+				t.Errorf("history as strings: %v, want %v", got, tt.strings)
+			}
+		})
+	}
+}
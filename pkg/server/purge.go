@@ -0,0 +1,210 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// erasedName replaces the name of a purged user in messages kept in
+// memory, which stay in place so that positions clients hold stay valid
+const erasedName = "[erased]"
+
+// authoredBy returns a function reporting whether a message is part of
+// name's data: written by them, a private message to them, or a system
+// message naming them, such as their joining
+func authoredBy(name string) func(chat.Message) bool {
+	named := regexp.MustCompile(`(^|\W)` + regexp.QuoteMeta(name) + `(\W|$)`)
+	return func(m chat.Message) bool {
+		if m.Kind == chat.KindSystem {
+			return m.To == name || named.MatchString(m.Body)
+		}
+		return m.Sender == name || m.To == name
+	}
+}
+
+// erase blanks a purged message kept in memory, keeping only what shows
+// where it was
+func erase(m *chat.Message, name string, at time.Time) {
+	if m.Sender == name {
+		m.Sender = erasedName
+	}
+	if m.To == name {
+		m.To = erasedName
+	}
+	m.Body = ""
+	m.Mentions = nil
+	m.Reactions = nil
+	m.Deleted = at
+}
+
+// forget removes name's reactions from a message kept in memory, as the
+// events that added them are removed from the store. What others wrote,
+// mentions of name included, is theirs and stays.
+func forget(m *chat.Message, name string) {
+	for reaction, names := range m.Reactions {
+		if slices.Contains(names, name) {
+			m.Reactions = react(m.Reactions, name, reaction, false)
+		}
+	}
+}
+
+// purgeMessages removes name's messages from the store, along with the
+// events that refer to them, and returns their IDs. Like allMessages, it
+// works on the store without holding s.mu, so the caller must not hold it.
+func (s *ChatServer) purgeMessages(name string) (map[int64]bool, error) {
+	messages, err := s.allMessages()
+	if err != nil {
+		return nil, err
+	}
+	theirs := authoredBy(name)
+	drop := make(map[int64]bool)
+	for _, m := range messages {
+		if theirs(m) || drop[m.Ref] {
+			drop[m.ID] = true
+		}
+	}
+	if store, ok := s.store.(prunableStore); ok && len(drop) > 0 {
+		if err := s.writer.do(func() error { return store.Delete(drop) }); err != nil {
+			return nil, err
+		}
+	}
+	return drop, nil
+}
+
+// eraseMessages erases name's messages, those in drop, from memory and
+// takes back their reactions to the rest. The caller must hold s.mu.
+func (s *ChatServer) eraseMessages(name string, drop map[int64]bool) {
+	now := time.Now()
+	update := func(m *chat.Message) {
+		if drop[m.ID] {
+			erase(m, name, now)
+		} else {
+			forget(m, name)
+		}
+	}
+	for _, r := range s.rooms {
+		r.history.update(update)
+		if drop[r.topic.ID] {
+			r.topic = chat.Message{}
+		}
+	}
+	for _, l := range s.dms {
+		l.update(update)
+	}
+	delete(s.dms, name)
+}
+
+// purgeAccount removes everything the server keeps about name besides
+// messages: their account, blocks, room roles, reports, scheduled messages,
+// read receipts and limits. Bans are kept so that a purged troll cannot simply come
+// back. The caller must hold s.mu.
+func (s *ChatServer) purgeAccount(name string) error {
+	delete(s.accounts, name)
+	delete(s.blocks, name)
+	for other, blocked := range s.blocks {
+		if blocked[name] {
+			delete(blocked, name)
+			if acct, ok := s.accounts[other]; ok {
+				acct.Blocked = sortedNames(blocked)
+			}
+		}
+	}
+	if err := s.saveAccounts(); err != nil {
+		return err
+	}
+
+	for _, r := range s.rooms {
+		delete(r.members, name)
+		delete(r.operators, name)
+	}
+	if err := s.saveRooms(); err != nil {
+		return err
+	}
+
+	s.reports = slices.DeleteFunc(s.reports, func(r chat.Report) bool {
+		return r.Reporter == name || r.Message.Sender == name || r.Message.To == name
+	})
+	if err := s.saveReports(); err != nil {
+		return err
+	}
+
+	s.scheduled = slices.DeleteFunc(s.scheduled, func(m chat.ScheduledMessage) bool {
+		return m.Sender == name
+	})
+	if err := s.saveScheduled(); err != nil {
+		return err
+	}
+
+	s.receipts.forget(name)
+	delete(s.muted, name)
+	delete(s.kicked, name)
+	s.limitsMu.Lock()
+	delete(s.buckets, name)
+	delete(s.spam, name)
+	s.limitsMu.Unlock()
+	s.statsMu.Lock()
+	delete(s.perUser, name)
+	s.statsMu.Unlock()
+	return nil
+}
+
+// PurgeUser erases a user on request, as data protection laws such as the
+// GDPR require: it disconnects them and removes their messages, private
+// conversations and account from memory and the store. The audit log
+// keeps the moderation actions concerning them.
+func (c *chatConn) PurgeUser(args *chat.ModerationArgs, reply *chat.PurgeReply) error {
+	c.mu.Lock()
+	admin, err := c.authorize(args.Token, args.Name, permPurge)
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	target := strings.TrimSpace(args.Target)
+	if target == "" {
+		c.mu.Unlock()
+		return errors.New("a name to purge is required")
+	}
+	if err := c.outranks(admin, target); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	_, registered := c.accounts[target]
+	if sess, ok := c.online[target]; ok {
+		c.endSession(sess, "purged")
+		sess.conn.conn.Close()
+	}
+	c.mu.Unlock()
+
+	// The store is purged without holding c.mu, so others can chat on
+	drop, err := c.purgeMessages(target)
+	if err != nil {
+		slog.Error("Error purging messages", "name", target, "err", err)
+		return fmt.Errorf("could not remove the messages of %s", target)
+	}
+	n := len(drop)
+	if n == 0 && !registered {
+		return fmt.Errorf("%s has no account or messages", target)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.eraseMessages(target, drop)
+	if err := c.purgeAccount(target); err != nil {
+		slog.Error("Error purging account", "name", target, "err", err)
+		return errors.New("could not save the changes, the account may be partly purged")
+	}
+	reply.Removed = n
+	c.notify()
+
+	slog.Info("Purged user", "name", target, "messages", n, "by", admin)
+	c.record(admin, auditPurge, target, fmt.Sprintf("%d messages", n), args.Reason)
+
+	return nil
+}
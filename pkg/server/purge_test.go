@@ -0,0 +1,83 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+func TestPurgeUser(t *testing.T) {
+	tests := []struct {
+		name    string
+		actor   string
+		target  string
+		ok      bool
+		removed int // with the announcement of their joining
+	}{
+		{"admin purges a user", "admin", "user", true, 3},
+		{"admin purges a guest", "admin", "guest", true, 3},
+		{"owner purges an admin", "owner", "admin", true, 1},
+		{"admin cannot purge an admin", "admin", "admin", false, 0},
+		{"admin cannot purge the owner", "admin", "owner", false, 0},
+		{"moderator cannot purge", "moderator", "user", false, 0},
+		{"nobody to purge", "admin", "nobody", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, tokens := team(t, Config{})
+			c := &chatConn{ChatServer: s}
+			c.mu.Lock()
+			for _, m := range []chat.Message{
+				c.newMessage(chat.DefaultRoom, "user", "", "hello"),
+				c.newMessage(chat.DefaultRoom, "guest", "", "hi user"),
+				c.newMessage("", "user", "guest", "psst"),
+			} {
+				if _, err := c.post(&m); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_, registered := c.accounts[tt.target]
+			c.mu.Unlock()
+			c.writer.flush()
+
+			var reply chat.PurgeReply
+			err := c.PurgeUser(&chat.ModerationArgs{Token: tokens[tt.actor], Target: tt.target}, &reply)
+			if (err == nil) != tt.ok {
+				t.Fatalf("PurgeUser() error = %v, want ok: %v", err, tt.ok)
+			}
+			if reply.Removed != tt.removed {
+				t.Errorf("removed %d messages, want %d", reply.Removed, tt.removed)
+			}
+
+			stored, err := c.allMessages()
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			_, online := c.online[tt.target]
+			_, kept := c.accounts[tt.target]
+			if tt.ok && (online || kept) {
+				t.Errorf("%s is still online: %v, or has an account: %v", tt.target, online, kept)
+			}
+			if !tt.ok && kept != registered {
+				t.Errorf("%s has an account: %v, want %v", tt.target, kept, registered)
+			}
+			theirs := authoredBy(tt.target)
+			left := 0
+			for _, m := range stored {
+				if theirs(m) {
+					left++
+				}
+			}
+			for _, m := range c.rooms[chat.DefaultRoom].history.since(0) {
+				if theirs(m) && m.Body != "" {
+					left++
+				}
+			}
+			if tt.ok && left > 0 {
+				t.Errorf("%d of %s's messages were kept", left, tt.target)
+			}
+		})
+	}
+}
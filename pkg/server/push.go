@@ -0,0 +1,223 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+const (
+	defaultPushWorkers = 8   // see Config.PushWorkers
+	defaultPushQueue   = 256 // see Config.PushQueue
+)
+
+// errFellBehind is why a subscriber whose queue filled up is dropped
+var errFellBehind = errors.New("you fell too far behind, please reconnect")
+
+// subscriber receives the messages posted to a room as they are posted,
+// such as a browser or a gRPC stream following the room
+type subscriber struct {
+	room string
+	name string // who is reading, whose blocked users are left out
+
+	// send writes a message to the client. Only one worker calls it at a
+	// time, in the order the messages were posted.
+	send func(chat.Message) error
+
+	done chan struct{} // closed once the subscriber is dropped
+	err  error         // why it was dropped, nil if unsubscribed; set before done is closed
+
+	// Guarded by pusher.mu
+	queue     []chat.Message // messages waiting to be sent, oldest first
+	scheduled bool           // in pusher.ready, or being sent by a worker
+	sending   bool           // being sent by a worker
+	dropped   bool
+}
+
+// pusher writes new messages to subscribers with a fixed pool of workers,
+// so that however many clients follow a room, posting only has to queue
+// the message for each of them. Each subscriber has a queue of its own:
+// a slow client only holds up the worker sending to it, and one that falls
+// queueSize messages behind is dropped rather than kept up with.
+type pusher struct {
+	mu        sync.Mutex
+	wake      *sync.Cond    // signalled when ready grows or the pool stops
+	idle      *sync.Cond    // broadcast when a worker finishes sending to a subscriber
+	ready     []*subscriber // subscribers with messages to send, oldest first
+	queueSize int
+	stopped   bool
+}
+
+// newPusher starts a pool of workers sending to subscribers
+func newPusher(workers, queueSize int) *pusher {
+	p := &pusher{queueSize: queueSize}
+	p.wake = sync.NewCond(&p.mu)
+	p.idle = sync.NewCond(&p.mu)
+	for range workers {
+		go p.work()
+	}
+	return p
+}
+
+// push queues msg for sub, dropping sub if its queue is full
+func (p *pusher) push(sub *subscriber, msg chat.Message) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if sub.dropped {
+		return
+	}
+	if len(sub.queue) >= p.queueSize {
+		p.end(sub, errFellBehind)
+		return
+	}
+	sub.queue = append(sub.queue, msg)
+	if !sub.scheduled {
+		sub.scheduled = true
+		p.ready = append(p.ready, sub)
+		p.wake.Signal()
+	}
+}
+
+// drop stops sending to sub for the reason err
+func (p *pusher) drop(sub *subscriber, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.end(sub, err)
+}
+
+// end is drop for callers that hold p.mu
+func (p *pusher) end(sub *subscriber, err error) {
+	if sub.dropped {
+		return
+	}
+	sub.dropped = true
+	sub.queue = nil
+	sub.err = err
+	close(sub.done)
+}
+
+// remove stops sending to sub and waits for any send in progress, after
+// which sub.send is no longer called
+func (p *pusher) remove(sub *subscriber) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.end(sub, nil)
+	for sub.sending {
+		p.idle.Wait()
+	}
+}
+
+// work sends queued messages until the pool is stopped. A worker takes
+// every message queued for a subscriber at once, then puts the subscriber
+// at the back of the line if more came in meanwhile, so busy rooms do not
+// starve quiet ones.
+func (p *pusher) work() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		for len(p.ready) == 0 && !p.stopped {
+			p.wake.Wait()
+		}
+		if p.stopped {
+			return
+		}
+		sub := p.ready[0]
+		p.ready = p.ready[1:]
+		batch := sub.queue
+		sub.queue = nil
+		sub.sending = true
+		p.mu.Unlock()
+
+		var err error
+		for _, m := range batch {
+			if err = sub.send(m); err != nil {
+				break
+			}
+		}
+
+		p.mu.Lock()
+		sub.sending = false
+		p.idle.Broadcast()
+		if err != nil {
+			p.end(sub, err)
+		}
+		if len(sub.queue) > 0 {
+			p.ready = append(p.ready, sub)
+		} else {
+			sub.scheduled = false
+		}
+	}
+}
+
+// stop ends the workers once they finish what they are sending
+func (p *pusher) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stopped = true
+	p.wake.Broadcast()
+}
+
+// subscribe starts pushing the messages of a room to send on behalf of
+// name, beginning with those after position since, like WaitForMessages.
+// The caller must hold s.mu and unsubscribe once done.
+func (s *ChatServer) subscribe(room, name string, since int, send func(chat.Message) error) (*subscriber, error) {
+	r, err := s.findRoom(room)
+	if err != nil {
+		return nil, err
+	}
+	if !s.canRead(r, name) {
+		return nil, fmt.Errorf("room %s is private, join it first", room)
+	}
+	// Whatever reaches the client counts as delivered
+	deliver := func(m chat.Message) error {
+		if err := send(m); err != nil {
+			return err
+		}
+		s.receipts.deliver(name, []chat.Message{m})
+		return nil
+	}
+	sub := &subscriber{room: room, name: name, send: deliver, done: make(chan struct{})}
+	for _, m := range s.visible(name, r.history.since(since)) {
+		s.push.push(sub, m)
+	}
+	s.subscribers[sub] = true
+	return sub, nil
+}
+
+// unsubscribe stops pushing to sub, waiting for any message being sent to
+// it. The caller must not hold s.mu, which sending does not need.
+func (s *ChatServer) unsubscribe(sub *subscriber) {
+	s.mu.Lock()
+	delete(s.subscribers, sub)
+	s.mu.Unlock()
+
+	s.push.remove(sub)
+}
+
+// fanOut queues a new room message for everyone following the room who
+// may read it. Subscribers who may no longer read a private room are
+// dropped. The caller must hold s.mu.
+func (s *ChatServer) fanOut(msg chat.Message) {
+	if msg.To != "" {
+		return
+	}
+	r, ok := s.rooms[msg.Room]
+	if !ok {
+		return
+	}
+	for sub := range s.subscribers {
+		switch {
+		case sub.room != msg.Room || s.blocks[sub.name][msg.Sender]:
+		case !s.canRead(r, sub.name):
+			s.push.drop(sub, fmt.Errorf("room %s is private, join it first", msg.Room))
+		default:
+			s.push.push(sub, msg)
+		}
+	}
+}
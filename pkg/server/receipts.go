@@ -0,0 +1,231 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// receiptKey names a room, or the private messages a user exchanges with
+// one other user
+type receiptKey struct {
+	room string
+	with string // the other user, for private messages
+}
+
+// receiptKeyOf returns the key of the conversation msg is part of for
+// reader
+func receiptKeyOf(reader string, msg chat.Message) receiptKey {
+	if msg.To == "" {
+		return receiptKey{room: msg.Room}
+	}
+	if msg.Sender == reader {
+		return receiptKey{with: msg.To}
+	}
+	return receiptKey{with: msg.Sender}
+}
+
+// marks are how far a user has got in a conversation. Message IDs only go
+// up, so every message up to delivered has reached them, and every message
+// up to read they have seen.
+type marks struct {
+	delivered int64
+	read      int64
+}
+
+// receiptBook keeps the marks of every user in each of their
+// conversations. It has a lock of its own because messages are delivered
+// by calls that only hold s.mu for reading.
+type receiptBook struct {
+	mu    sync.Mutex
+	marks map[string]map[receiptKey]marks // by reader
+}
+
+func newReceiptBook() *receiptBook {
+	return &receiptBook{marks: make(map[string]map[receiptKey]marks)}
+}
+
+// deliver notes that messages reached reader. Their own messages and
+// events do not count.
+func (b *receiptBook) deliver(reader string, messages []chat.Message) {
+	if reader == "" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, m := range messages {
+		if m.IsChat() && m.Sender != reader {
+			b.advance(reader, receiptKeyOf(reader, m), m.ID, false)
+		}
+	}
+}
+
+// markRead notes that reader has seen conv up to message id, which also
+// means it reached them
+func (b *receiptBook) markRead(reader string, conv receiptKey, id int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance(reader, conv, id, true)
+}
+
+// advance moves reader's marks in conv up to id. The caller must hold b.mu.
+func (b *receiptBook) advance(reader string, conv receiptKey, id int64, read bool) {
+	convs := b.marks[reader]
+	if convs == nil {
+		convs = make(map[receiptKey]marks)
+		b.marks[reader] = convs
+	}
+	m := convs[conv]
+	m.delivered = max(m.delivered, id)
+	if read {
+		m.read = max(m.read, id)
+	}
+	convs[conv] = m
+}
+
+// rename moves the marks of a user who changed their name to the new one,
+// unless it has marks of its own
+func (b *receiptBook) rename(old, name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if convs, ok := b.marks[old]; ok && b.marks[name] == nil {
+		b.marks[name] = convs
+	}
+	delete(b.marks, old)
+}
+
+// forget removes a user's marks, and everyone's marks in their private
+// conversations with them
+func (b *receiptBook) forget(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.marks, name)
+	for _, convs := range b.marks {
+		delete(convs, receiptKey{with: name})
+	}
+}
+
+// lastRead returns the newest message reader has read in the conversation
+// key names
+func (b *receiptBook) lastRead(reader string, key receiptKey) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.marks[reader][key].read
+}
+
+// receipt returns who msg has reached and who has read it, besides its
+// sender and anyone skip reports true for
+func (b *receiptBook) receipt(msg chat.Message, skip func(reader string) bool) chat.Receipt {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	receipt := chat.Receipt{ID: msg.ID}
+	for reader, convs := range b.marks {
+		if reader == msg.Sender || (msg.To != "" && reader != msg.To) || skip(reader) {
+			continue
+		}
+		m := convs[receiptKeyOf(reader, msg)]
+		if m.delivered >= msg.ID {
+			receipt.Delivered = append(receipt.Delivered, reader)
+		}
+		if m.read >= msg.ID {
+			receipt.Read = append(receipt.Read, reader)
+		}
+	}
+	slices.Sort(receipt.Delivered)
+	slices.Sort(receipt.Read)
+	return receipt
+}
+
+// MarkRead tells the server the caller has seen a room, or their private
+// messages with another user, up to message args.ID
+func (c *chatConn) MarkRead(args *chat.ReadArgs, _ *struct{}) error {
+	if args.ID <= 0 {
+		return errors.New("a message ID is required")
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	name, err := c.sender(args.Token, args.Name)
+	if err != nil {
+		return err
+	}
+	conv := receiptKey{with: strings.TrimSpace(args.With)}
+	if conv.with == "" {
+		conv.room = roomName(args.Room)
+		if _, err := c.readableRoom(conv.room, args.Token); err != nil {
+			return err
+		}
+	}
+	// Messages not posted yet cannot have been read
+	c.receipts.markRead(name, conv, min(args.ID, c.nextID.Load()))
+
+	return nil
+}
+
+// GetReceipts returns who has received and read each of the caller's own
+// messages in args.IDs
+func (c *chatConn) GetReceipts(args *chat.ReceiptArgs, reply *chat.ReceiptsReply) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	name, err := c.sender(args.Token, args.Name)
+	if err != nil {
+		return err
+	}
+	for _, id := range args.IDs {
+		msg, ok := c.findMessage(id)
+		if !ok || !msg.IsChat() {
+			return fmt.Errorf("message %d not found", id)
+		}
+		if msg.Sender != name {
+			return errors.New("you can only see receipts for your own messages")
+		}
+		// Users who blocked the sender never get the message
+		blocked := func(reader string) bool { return c.blocks[reader][name] }
+		reply.Receipts = append(reply.Receipts, c.receipts.receipt(msg, blocked))
+	}
+
+	return nil
+}
+
+// GetUnreadCounts returns how many messages from others the caller has not
+// read yet in each of their rooms, going by what they marked with MarkRead
+func (c *chatConn) GetUnreadCounts(args *chat.UserArgs, reply *chat.UnreadReply) error {
+	c.mu.RLock()
+	name, err := c.sender(args.Token, args.Name)
+	if err != nil {
+		c.mu.RUnlock()
+		return err
+	}
+	histories := make(map[string]logSnapshot)
+	for room, r := range c.rooms {
+		if room == chat.DefaultRoom || r.members[name] {
+			histories[room] = r.history.snapshot()
+		}
+	}
+	hide := c.blockedBy(name)
+	c.mu.RUnlock()
+
+	reply.Rooms = make(map[string]int)
+	for room, history := range histories {
+		read := c.receipts.lastRead(name, receiptKey{room: room})
+		n := 0
+		for _, m := range history.buf {
+			if m.ID > read && m.IsChat() && m.Sender != name && m.Deleted.IsZero() && (hide == nil || !hide(m)) {
+				n++
+			}
+		}
+		if n > 0 {
+			reply.Rooms[room] = n
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+func TestReceiptBookForgetAndRename(t *testing.T) {
+	general := receiptKey{room: chat.DefaultRoom}
+	sent := chat.Message{ID: 1, Room: chat.DefaultRoom, Sender: "carol", Body: "hi"}
+	private := chat.Message{ID: 2, Sender: "carol", To: "bob", Body: "hi"}
+
+	tests := []struct {
+		name      string
+		change    func(*receiptBook)
+		delivered []string // who sent reached afterwards
+		read      []string
+		bobMarks  map[receiptKey]marks
+		daveRead  int64 // what dave has read of general
+	}{
+		{
+			name:      "unchanged",
+			change:    func(*receiptBook) {},
+			delivered: []string{"alice", "bob"},
+			read:      []string{"bob"},
+			bobMarks:  map[receiptKey]marks{general: {1, 1}, {with: "carol"}: {2, 0}},
+		},
+		{
+			name:      "reader forgotten",
+			change:    func(b *receiptBook) { b.forget("bob") },
+			delivered: []string{"alice"},
+		},
+		{
+			name:      "sender forgotten",
+			change:    func(b *receiptBook) { b.forget("carol") },
+			delivered: []string{"alice", "bob"},
+			read:      []string{"bob"},
+			bobMarks:  map[receiptKey]marks{general: {1, 1}},
+		},
+		{
+			name:      "reader renamed",
+			change:    func(b *receiptBook) { b.rename("bob", "dave") },
+			delivered: []string{"alice", "dave"},
+			read:      []string{"dave"},
+			daveRead:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newReceiptBook()
+			b.deliver("alice", []chat.Message{sent})
+			b.deliver("bob", []chat.Message{sent, private})
+			b.markRead("bob", general, 1)
+			tt.change(b)
+
+			got := b.receipt(sent, func(string) bool { return false })
+			if !reflect.DeepEqual(got.Delivered, tt.delivered) || !reflect.DeepEqual(got.Read, tt.read) {
+				t.Errorf("delivered to %q and read by %q, want %q and %q", got.Delivered, got.Read, tt.delivered, tt.read)
+			}
+			if !reflect.DeepEqual(b.marks["bob"], tt.bobMarks) {
+				t.Errorf("bob's marks = %v, want %v", b.marks["bob"], tt.bobMarks)
+			}
+			if got := b.lastRead("dave", general); got != tt.daveRead {
+				t.Errorf("dave read up to %d, want %d", got, tt.daveRead)
+			}
+		})
+	}
+}
+
+func TestPurgeUserForgetsReceipts(t *testing.T) {
+	s := newTestServer(t, Config{})
+	s.receipts.deliver("bob", []chat.Message{{ID: 1, Room: chat.DefaultRoom, Sender: "alice", Body: "hi"}})
+	s.mu.Lock()
+	err := s.purgeAccount("bob")
+	s.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.receipts.marks["bob"]; ok {
+		t.Error("the receipts of a purged user were kept")
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// configure applies the settings that can change while the server runs,
+// loading the message of the day and word filter files they name. Nothing
+// changes if a file cannot be loaded. The caller must hold s.mu, unless
+// the server is not serving yet.
+func (s *ChatServer) configure(config Config) error {
+	switch config.FilterMode {
+	case "", FilterMask, FilterReject:
+	default:
+		return fmt.Errorf("unknown filter mode %q", config.FilterMode)
+	}
+
+	motd := strings.TrimSpace(config.MOTD)
+	if config.MOTDFile != "" {
+		var err error
+		if motd, err = readMOTD(config.MOTDFile, motd); err != nil {
+			return fmt.Errorf("loading the message of the day: %w", err)
+		}
+	}
+	var filter *wordFilter
+	if config.FilterFile != "" {
+		var err error
+		if filter, err = loadFilter(config.FilterFile); err != nil {
+			return fmt.Errorf("loading the word filter: %w", err)
+		}
+		slog.Info("Loaded word filter", "words", filter.words, "path", config.FilterFile)
+	}
+
+	s.motd, s.motdPath = motd, config.MOTDFile
+	s.filter, s.filterPath = filter, config.FilterFile
+	s.filterMode = FilterMask
+	if config.FilterMode != "" {
+		s.filterMode = config.FilterMode
+	}
+
+	s.rateLimit = config.RateLimit
+	s.rateBurst = max(config.RateBurst, 1)
+	s.botRate = config.BotRate
+	s.botBurst = max(config.BotBurst, 1)
+	s.spamRepeats = config.SpamRepeats
+	s.spamBurst = config.SpamBurst
+	s.spamWindow = defaultSpamWindow
+	if config.SpamWindow > 0 {
+		s.spamWindow = config.SpamWindow
+	}
+	s.spamMute = defaultSpamMute
+	if config.SpamMute > 0 {
+		s.spamMute = config.SpamMute
+	}
+	s.retentionAge = config.RetentionAge
+	s.retentionCount = config.RetentionCount
+	return nil
+}
+
+// Reload loads the settings again with Config.Reload and applies those that
+// can change while the server runs, without dropping connections. If the
+// new settings are invalid, the old ones stay.
+func (s *ChatServer) Reload() error {
+	if s.reload == nil {
+		return errors.New("the server has no settings to reload")
+	}
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+
+	config, err := s.reload()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.configure(config); err != nil {
+		return err
+	}
+
+	slog.Info("Reloaded the settings")
+	return nil
+}
+
+// ReloadConfig lets an admin reload the server's settings, as sending it
+// SIGHUP does
+func (c *chatConn) ReloadConfig(args *chat.UserArgs, _ *struct{}) error {
+	c.mu.Lock()
+	admin, err := c.authorize(args.Token, args.Name, permReloadConfig)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := c.Reload(); err != nil {
+		slog.Error("Error reloading the settings", "err", err, "by", admin)
+		return fmt.Errorf("could not reload the settings, keeping the old ones: %v", err)
+	}
+
+	c.mu.Lock()
+	c.record(admin, auditReloadConfig, "", "", "")
+	c.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// loadReports reads the open reports, if the file exists, and remembers
+// the path to save changes to
+func (s *ChatServer) loadReports(path string) error {
+	s.reportsPath = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &s.reports); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for _, r := range s.reports {
+		s.nextReportID = max(s.nextReportID, r.ID)
+	}
+	return nil
+}
+
+// saveReports rewrites the reports file the same way saveAccounts does.
+// The caller must hold s.mu.
+func (s *ChatServer) saveReports() error {
+	if s.reportsPath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.reports, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.reportsPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.reportsPath)
+}
+
+// ReportMessage flags a message someone else sent as abusive. The report
+// waits for a moderator to resolve it, and the moderators online are told.
+func (c *chatConn) ReportMessage(args *chat.ReportArgs, reply *chat.ReportReply) error {
+	reason := strings.TrimSpace(args.Reason)
+	if err := c.checkLength(reason); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	from, err := c.sender(args.Token, args.Name)
+	if err != nil {
+		return err
+	}
+	target, ok := c.findMessage(args.ID)
+	visible := ok && target.IsChat() && target.Deleted.IsZero()
+	if visible && target.To != "" {
+		visible = target.To == from
+	} else if visible {
+		visible = c.canRead(c.rooms[target.Room], from)
+	}
+	if !visible {
+		return fmt.Errorf("message %d not found", args.ID)
+	}
+	if target.Sender == from {
+		return errors.New("you cannot report your own messages")
+	}
+	for _, r := range c.reports {
+		if r.Message.ID == target.ID && r.Reporter == from {
+			return fmt.Errorf("you have already reported message %d", target.ID)
+		}
+	}
+	if err := c.checkRate(); err != nil {
+		return err
+	}
+
+	c.nextReportID++
+	report := chat.Report{
+		ID:       c.nextReportID,
+		Message:  target,
+		Reporter: from,
+		Reason:   reason,
+		Time:     time.Now(),
+	}
+	c.reports = append(c.reports, report)
+	if err := c.saveReports(); err != nil {
+		c.reports = c.reports[:len(c.reports)-1]
+		slog.Error("Error saving reports", "err", err)
+		return errors.New("could not save the report")
+	}
+	reply.ID = report.ID
+
+	slog.Info("Reported message", "report", report.ID, "id", target.ID, "sender", target.Sender, "by", from)
+	c.tellModerators(from, "%s reported message %d by %s, see /reports", from, target.ID, target.Sender)
+
+	return nil
+}
+
+// tellModerators shows a system message in the private feed of everyone
+// online who can resolve reports, except the user called except. It is not
+// saved. The caller must hold s.mu.
+func (s *ChatServer) tellModerators(except, format string, args ...any) {
+	for name := range s.online {
+		if name == except || !s.can(name, permReports) {
+			continue
+		}
+		msg := s.newMessage("", "", name, fmt.Sprintf(format, args...))
+		msg.Kind = chat.KindSystem
+		s.show(name, &msg)
+	}
+}
+
+// GetReports returns the reports waiting for a moderator, oldest first
+func (c *chatConn) GetReports(args *chat.UserArgs, reply *chat.ReportsReply) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if _, err := c.authorize(args.Token, args.Name, permReports); err != nil {
+		return err
+	}
+	reply.Reports = append([]chat.Report(nil), c.reports...)
+
+	return nil
+}
+
+// ResolveReport closes a report, either deleting the reported message or
+// dismissing the report. Either way every other report of the same
+// message is closed too.
+func (c *chatConn) ResolveReport(args *chat.ResolveArgs, _ *struct{}) error {
+	return c.posting(&c.mu, func() (*storeJob, error) {
+		mod, err := c.authorize(args.Token, args.Name, permReports)
+		if err != nil {
+			return nil, err
+		}
+		var report *chat.Report
+		for i := range c.reports {
+			if c.reports[i].ID == args.Report {
+				report = &c.reports[i]
+				break
+			}
+		}
+		if report == nil {
+			return nil, fmt.Errorf("report %d not found", args.Report)
+		}
+		target := report.Message
+
+		action := "dismissed"
+		var job *storeJob
+		if args.Delete {
+			action = "deleted the message"
+			if err := c.outranks(mod, target.Sender); err != nil {
+				return nil, err
+			}
+			// It may be gone already, which is what deleting it would achieve
+			if m, ok := c.findMessage(target.ID); ok && m.Deleted.IsZero() {
+				if job, err = c.postDelete(mod, m); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		open := c.reports[:0]
+		for _, r := range c.reports {
+			if r.Message.ID != target.ID {
+				open = append(open, r)
+			}
+		}
+		c.reports = open
+		if err := c.saveReports(); err != nil {
+			slog.Error("Error saving reports", "err", err)
+		}
+
+		slog.Info("Resolved report", "report", args.Report, "id", target.ID, "action", action, "by", mod)
+		c.record(mod, auditResolveReport, target.Sender, fmt.Sprintf("report %d of message %d: %s", args.Report, target.ID, action), args.Reason)
+
+		return job, nil
+	})
+}
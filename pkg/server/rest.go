@@ -0,0 +1,212 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// restPost is the body of POST /messages
+type restPost struct {
+	Room      string `json:"room"` // empty means chat.DefaultRoom
+	Text      string `json:"text"`
+	InReplyTo int64  `json:"inReplyTo"`
+	Emote     bool   `json:"emote"`    // post text as an action, like /me
+	ClientID  string `json:"clientId"` // as chat.MessageArgs.ClientID
+}
+
+// restMessages is the reply to GET /messages
+type restMessages struct {
+	Messages  []chat.Message `json:"messages"`
+	LastIndex int            `json:"lastIndex"` // pass as since to get only newer messages
+}
+
+// restBodyOverhead is what a request body may hold besides the text of its
+// message, in bytes
+const restBodyOverhead = 4 << 10
+
+// restError is the body of every failed request
+type restError struct {
+	Error string `json:"error"`
+}
+
+// RESTHandler serves a JSON API for scripts and simple integrations:
+//
+//	POST /messages                   post {"room", "text", "inReplyTo", "emote", "clientId"}
+//	GET  /messages?room=R&since=N    messages of room R after position N
+//	POST /hooks                      post {"text", "username", "emote"} as a bot
+//
+// Posting, and reading private rooms, needs a registered account, given
+// with HTTP basic auth. It does not log the account in, so it also works
+// while its owner is online. Bots, which admins add with AddBot, give
+// their token as a bearer token instead, or as ?token= for systems that
+// cannot set headers, and post to their room.
+func (s *ChatServer) RESTHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /messages", s.restPostMessage)
+	mux.HandleFunc("GET /messages", s.restGetMessages)
+	mux.HandleFunc("POST /hooks", s.restPostHook)
+	return mux
+}
+
+// restLogin checks the account given with HTTP basic auth and returns a
+// connection for it and its name. If that fails, it answers the request
+// itself and returns false.
+func (s *ChatServer) restLogin(w http.ResponseWriter, r *http.Request) (*chatConn, string, bool) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	c := &chatConn{ChatServer: s, ip: ip}
+
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chat"`)
+		writeJSON(w, http.StatusUnauthorized, restError{"log in with the name and password of a registered account"})
+		return nil, "", false
+	}
+	name = strings.TrimSpace(name)
+	if err := c.checkPassword(name, password); err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chat"`)
+		writeJSON(w, http.StatusUnauthorized, restError{err.Error()})
+		return nil, "", false
+	}
+	return c, name, true
+}
+
+func (s *ChatServer) restPostMessage(w http.ResponseWriter, r *http.Request) {
+	c, name, ok := s.restLogin(w, r)
+	if !ok {
+		return
+	}
+
+	var post restPost
+	if !s.readJSON(w, r, &post) {
+		return
+	}
+	if err := c.checkLength(post.Text); err != nil {
+		writeJSON(w, http.StatusBadRequest, restError{err.Error()})
+		return
+	}
+
+	kind := chat.KindChat
+	if post.Emote {
+		kind = chat.KindEmote
+	}
+	c.mu.RLock()
+	banned := c.banned(name)
+	var msg chat.Message
+	var job *storeJob
+	var err error
+	status := http.StatusCreated
+	if id, ok := c.sentBefore(name, post.ClientID); ok && !banned {
+		// Posted already; answer with the message as it was posted
+		status = http.StatusOK
+		msg = chat.Message{ID: id}
+		if room, err := c.findRoom(roomName(post.Room)); err == nil {
+			if m, ok := room.history.find(id); ok {
+				msg = m
+			}
+		}
+	} else if !banned {
+		_, msg, job, _, err = c.sendMessage(name, post.Room, post.Text, kind, post.InReplyTo, post.ClientID)
+	}
+	c.mu.RUnlock()
+
+	if banned {
+		writeJSON(w, http.StatusForbidden, restError{"you are banned from this server"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, restError{err.Error()})
+		return
+	}
+	if err := job.wait(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, restError{err.Error()})
+		return
+	}
+	writeJSON(w, status, msg)
+}
+
+func (s *ChatServer) restGetMessages(w http.ResponseWriter, r *http.Request) {
+	var since int
+	if v := r.URL.Query().Get("since"); v != "" {
+		var err error
+		if since, err = strconv.Atoi(v); err != nil || since < 0 {
+			writeJSON(w, http.StatusBadRequest, restError{"since must be a position returned as lastIndex"})
+			return
+		}
+	}
+
+	name := roomName(r.URL.Query().Get("room"))
+	s.mu.RLock()
+	room, err := s.findRoom(name)
+	private := err == nil && room.private()
+	s.mu.RUnlock()
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, restError{err.Error()})
+		return
+	}
+
+	// Private rooms can only be read by their members, who log in the
+	// same way as for posting
+	user := ""
+	if private {
+		var ok bool
+		if _, user, ok = s.restLogin(w, r); !ok {
+			return
+		}
+	}
+
+	s.mu.RLock()
+	readable := !private || s.canRead(room, user)
+	hide := s.blockedBy(user)
+	s.mu.RUnlock()
+
+	if !readable {
+		writeJSON(w, http.StatusForbidden, restError{fmt.Sprintf("room %s is private, join it first", name)})
+		return
+	}
+	messages, last := room.history.tail(since)
+	reply := restMessages{Messages: without(messages, hide), LastIndex: last}
+	if reply.Messages == nil {
+		reply.Messages = []chat.Message{}
+	}
+	writeJSON(w, http.StatusOK, reply)
+}
+
+// limitBody stops the body of r being read past what a message could
+// take: a few times the longest one, for the escapes JSON and forms may
+// need, and the other fields
+func (s *ChatServer) limitBody(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 6*int64(s.maxLength)+restBodyOverhead)
+}
+
+// readJSON decodes the body of r, up to limitBody, into v. If that fails,
+// it answers the request itself and returns false.
+func (s *ChatServer) readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	s.limitBody(w, r)
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err == nil {
+		return true
+	}
+	if tooLarge := new(http.MaxBytesError); errors.As(err, &tooLarge) {
+		writeJSON(w, http.StatusRequestEntityTooLarge, restError{"request body is too large"})
+		return false
+	}
+	writeJSON(w, http.StatusBadRequest, restError{"invalid JSON: " + err.Error()})
+	return false
+}
+
+// writeJSON sends v as the JSON body of a response with the given status
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// newTestServer returns a server keeping everything in memory, shut down
+// when the test ends
+func newTestServer(t *testing.T, config Config) *ChatServer {
+	t.Helper()
+	s, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		s.Shutdown(ctx)
+	})
+	return s
+}
+
+// register creates an account on s
+func register(t *testing.T, s *ChatServer, name, password string) {
+	t.Helper()
+	c := &chatConn{ChatServer: s}
+	if err := c.Register(&chat.AccountArgs{Name: name, Password: password}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRESTPostMessage(t *testing.T) {
+	s := newTestServer(t, Config{MaxLength: 100})
+	register(t, s, "alice", "password1")
+	handler := s.RESTHandler()
+
+	tests := []struct {
+		name     string
+		user     string // "" for no basic auth
+		password string
+		body     string
+		status   int
+	}{
+		{"posts", "alice", "password1", `{"text": "hello"}`, http.StatusCreated},
+		{"emote", "alice", "password1", `{"text": "waves", "emote": true}`, http.StatusCreated},
+		{"no account", "", "", `{"text": "hello"}`, http.StatusUnauthorized},
+		{"wrong password", "alice", "password2", `{"text": "hello"}`, http.StatusUnauthorized},
+		{"unknown account", "bob", "password1", `{"text": "hello"}`, http.StatusUnauthorized},
+		{"invalid JSON", "alice", "password1", `{"text": `, http.StatusBadRequest},
+		{"too long", "alice", "password1", `{"text": "` + strings.Repeat("a", 101) + `"}`, http.StatusBadRequest},
+		{"body too large", "alice", "password1", `{"text": "` + strings.Repeat("a", 1<<20) + `"}`, http.StatusRequestEntityTooLarge},
+		{"room not joined", "alice", "password1", `{"room": "nowhere", "text": "hello"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(tt.body))
+			if tt.user != "" {
+				r.SetBasicAuth(tt.user, tt.password)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d; body %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestRESTGetMessages(t *testing.T) {
+	s := newTestServer(t, Config{})
+	register(t, s, "alice", "password1")
+	handler := s.RESTHandler()
+
+	post := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"text": "hello"}`))
+	post.SetBasicAuth("alice", "password1")
+	handler.ServeHTTP(httptest.NewRecorder(), post)
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		want   string // in the body
+	}{
+		{"default room", "", http.StatusOK, `"hello"`},
+		{"since the end", "?since=1", http.StatusOK, `"messages":[]`},
+		{"bad since", "?since=x", http.StatusBadRequest, "lastIndex"},
+		{"unknown room", "?room=nowhere", http.StatusNotFound, "nowhere"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/messages"+tt.query, nil))
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("got %d %s, want %d containing %s", w.Code, w.Body, tt.status, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// pruneInterval is how often the retention policy is applied
+const pruneInterval = 10 * time.Minute
+
+// prunableStore is a MessageStore that can delete messages, which the
+// retention policy needs. Stores that cannot keep everything.
+type prunableStore interface {
+	MessageStore
+	// Delete removes the messages with the given IDs
+	Delete(ids map[int64]bool) error
+}
+
+// conversation returns a key for the conversation m belongs to: its room,
+// or the two people in a private conversation
+func conversation(m chat.Message) string {
+	if m.To == "" {
+		return "#" + m.Room
+	}
+	a, b := m.Sender, m.To
+	if b < a {
+		a, b = b, a
+	}
+	return a + "\x00" + b
+}
+
+// expired returns the IDs of the messages a retention policy removes:
+// those sent before cutoff, unless it is zero, and all but the newest keep
+// in each conversation, unless keep is 0. The newest topic of each room is
+// kept regardless, since it is the room's current topic, and events go
+// with the message they change. messages must be in the order they were
+// sent.
+func expired(messages []chat.Message, cutoff time.Time, keep int) map[int64]bool {
+	topics := make(map[string]int64)
+	for _, m := range messages {
+		if m.Kind == chat.KindTopic {
+			topics[m.Room] = m.ID
+		}
+	}
+
+	drop := make(map[int64]bool)
+	kept := make(map[int64]bool)
+	counted := make(map[string]int)
+	// Walk back from the newest so the count keeps the newest ones
+	for i := len(messages) - 1; i >= 0; i-- {
+		m := messages[i]
+		if m.IsEvent() || (m.Kind == chat.KindTopic && topics[m.Room] == m.ID) {
+			kept[m.ID] = true
+			continue
+		}
+		key := conversation(m)
+		counted[key]++
+		if (!cutoff.IsZero() && m.Timestamp.Before(cutoff)) || (keep > 0 && counted[key] > keep) {
+			drop[m.ID] = true
+		} else {
+			kept[m.ID] = true
+		}
+	}
+	for _, m := range messages {
+		if m.IsEvent() && !kept[m.Ref] {
+			drop[m.ID] = true
+		}
+	}
+	return drop
+}
+
+// keptMessages returns every message kept in memory, each once, in the
+// order they were sent. The caller must hold s.mu.
+func (s *ChatServer) keptMessages() []chat.Message {
+	seen := make(map[int64]bool)
+	var messages []chat.Message
+	logs := make([]*messageLog, 0, len(s.rooms)+len(s.dms))
+	for _, r := range s.rooms {
+		logs = append(logs, &r.history)
+	}
+	for _, l := range s.dms {
+		logs = append(logs, l)
+	}
+	for _, l := range logs {
+		for _, m := range l.since(0) {
+			if !seen[m.ID] {
+				seen[m.ID] = true
+				messages = append(messages, m)
+			}
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	return messages
+}
+
+// prune applies the retention policy to the store, if it can delete
+// messages, and to the history kept in memory. It returns how many
+// messages expired. The store is changed without holding s.mu, which is
+// only taken to trim the histories, so the caller must not hold it.
+func (s *ChatServer) prune() (int, error) {
+	s.mu.RLock()
+	age, keep := s.retentionAge, s.retentionCount
+	s.mu.RUnlock()
+	var cutoff time.Time
+	if age > 0 {
+		cutoff = time.Now().Add(-age)
+	}
+
+	messages, err := s.allMessages()
+	if err != nil {
+		return 0, err
+	}
+	drop := expired(messages, cutoff, keep)
+	if len(drop) == 0 {
+		return 0, nil
+	}
+	if store, ok := s.store.(prunableStore); ok {
+		if err := s.writer.do(func() error { return store.Delete(drop) }); err != nil {
+			return 0, err
+		}
+	}
+
+	// Messages still in memory are the newest stored ones, so this only
+	// has to drop the oldest of each history. A kept topic older than that
+	// stays in the store and as the room's topic. Events are left out, as a
+	// recent reaction to an expired message would take everything before
+	// it along; clients ignore events for messages they do not have.
+	expiredMessage := func(m chat.Message) bool { return drop[m.ID] && !m.IsEvent() }
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.rooms {
+		r.history.trim(expiredMessage)
+	}
+	for _, l := range s.dms {
+		l.trim(expiredMessage)
+	}
+	return len(drop), nil
+}
+
+// watchRetention applies the retention policy, if there is one, every
+// pruneInterval until the server shuts down
+func (s *ChatServer) watchRetention() {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.RLock()
+			policy := s.retentionAge > 0 || s.retentionCount > 0
+			s.mu.RUnlock()
+			if !policy {
+				continue
+			}
+			n, err := s.prune()
+			if err != nil {
+				slog.Error("Error pruning history", "err", err)
+			} else if n > 0 {
+				slog.Info("Pruned history", "messages", n)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// PruneHistory lets an admin apply the retention policy right away rather
+// than waiting for the next scheduled run
+func (c *chatConn) PruneHistory(args *chat.UserArgs, reply *chat.PruneReply) error {
+	c.mu.RLock()
+	admin, err := c.authorize(args.Token, args.Name, permPrune)
+	policy := c.retentionAge > 0 || c.retentionCount > 0
+	c.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if !policy {
+		return errors.New("the server keeps all messages, it has no retention policy")
+	}
+	n, err := c.prune()
+	if err != nil {
+		slog.Error("Error pruning history", "err", err)
+		return errors.New("could not prune the history")
+	}
+	reply.Removed = n
+
+	slog.Info("Pruned history", "messages", n, "by", admin)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record(admin, auditPrune, "", fmt.Sprintf("%d messages", n), "")
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// permission is something only some roles may do
+type permission int
+
+const (
+	permCreateRoom permission = iota
+	permSetTopic              // in any room, not just the ones the user runs
+	permInvite                // likewise, to private rooms
+	permDelete                // other users' messages
+	permReports               // read and resolve reported messages
+	permPin                   // pin messages to rooms
+	permKick
+	permMute
+	permBan
+	permSetMOTD
+	permReloadFilter
+	permViewAudit
+	permPrune
+	permExport
+	permPurge
+	permReloadConfig
+	permSetRole
+	permWebhooks
+	permBots
+)
+
+var permissionNames = map[permission]string{
+	permCreateRoom:   "create rooms",
+	permSetTopic:     "change the topic of rooms they did not create",
+	permInvite:       "invite users to rooms they did not create",
+	permDelete:       "delete other users' messages",
+	permReports:      "handle reported messages",
+	permPin:          "pin messages",
+	permKick:         "kick users",
+	permMute:         "mute users",
+	permBan:          "ban users",
+	permSetMOTD:      "change the message of the day",
+	permReloadFilter: "reload the word filter",
+	permViewAudit:    "read the audit log",
+	permPrune:        "prune the history",
+	permExport:       "export the history",
+	permPurge:        "purge users",
+	permReloadConfig: "reload the settings",
+	permSetRole:      "change roles",
+	permWebhooks:     "manage webhooks",
+	permBots:         "manage bots",
+}
+
+func (p permission) String() string {
+	return permissionNames[p]
+}
+
+// minRole is the permission matrix: the lowest role that has each
+// permission. Roles above it have it too.
+var minRole = map[permission]chat.Role{
+	permCreateRoom:   chat.RoleUser,
+	permSetTopic:     chat.RoleModerator,
+	permInvite:       chat.RoleAdmin,
+	permDelete:       chat.RoleModerator,
+	permReports:      chat.RoleModerator,
+	permPin:          chat.RoleModerator,
+	permKick:         chat.RoleModerator,
+	permMute:         chat.RoleModerator,
+	permBan:          chat.RoleAdmin,
+	permSetMOTD:      chat.RoleAdmin,
+	permReloadFilter: chat.RoleAdmin,
+	permViewAudit:    chat.RoleAdmin,
+	permPrune:        chat.RoleAdmin,
+	permExport:       chat.RoleAdmin,
+	permPurge:        chat.RoleAdmin,
+	permReloadConfig: chat.RoleAdmin,
+	permSetRole:      chat.RoleAdmin,
+	permWebhooks:     chat.RoleAdmin,
+	permBots:         chat.RoleAdmin,
+}
+
+// roleOf returns the role of the user called name. Only accounts can have
+// a role above guest, since anyone could claim an unregistered name.
+// The caller must hold s.mu.
+func (s *ChatServer) roleOf(name string) chat.Role {
+	acct, ok := s.accounts[name]
+	if !ok {
+		return chat.RoleGuest
+	}
+	role := max(acct.Role, chat.RoleUser)
+	if s.admins[name] {
+		role = max(role, chat.RoleAdmin)
+	}
+	return role
+}
+
+// isAdmin reports whether name has the admin role or above.
+// The caller must hold s.mu.
+func (s *ChatServer) isAdmin(name string) bool {
+	return s.roleOf(name) >= chat.RoleAdmin
+}
+
+// can reports whether the user called name has a permission.
+// The caller must hold s.mu.
+func (s *ChatServer) can(name string, perm permission) bool {
+	return s.roleOf(name) >= minRole[perm]
+}
+
+// authorize resolves the caller like sender does and checks they have
+// perm. Every RPC that needs a permission calls it before changing
+// anything. The caller must hold c.mu.
+func (c *chatConn) authorize(token, name string, perm permission) (string, error) {
+	name, err := c.sender(token, name)
+	if err != nil {
+		return "", err
+	}
+	if !c.can(name, perm) {
+		return "", deniedError(perm)
+	}
+	return name, nil
+}
+
+// deniedError explains who may do what perm allows
+func deniedError(perm permission) error {
+	switch role := minRole[perm]; role {
+	case chat.RoleUser:
+		return fmt.Errorf("only registered users can %s", perm)
+	case chat.RoleOwner:
+		return fmt.Errorf("only the owner can %s", perm)
+	default:
+		return fmt.Errorf("only %ss and above can %s", role, perm)
+	}
+}
+
+// outranks checks that actor's role is above target's, which it must be to
+// act against them, e.g. to kick them. The caller must hold s.mu.
+func (s *ChatServer) outranks(actor, target string) error {
+	if role := s.roleOf(target); role >= s.roleOf(actor) {
+		return fmt.Errorf("%s has the %s role, and you can only do that to users below yours", target, role)
+	}
+	return nil
+}
+
+// GetRole returns the role of a user, who need not be online
+func (s *ChatServer) GetRole(args *chat.RoleArgs, reply *chat.RoleReply) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	reply.Role = s.roleOf(strings.TrimSpace(args.Target))
+	return nil
+}
+
+// SetRole changes the role of a registered account. The caller must
+// outrank both the account's current role and the one they give it, so
+// admins can make moderators and only the owner can make admins.
+func (c *chatConn) SetRole(args *chat.RoleArgs, _ *struct{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	from, err := c.authorize(args.Token, args.Name, permSetRole)
+	if err != nil {
+		return err
+	}
+	target := strings.TrimSpace(args.Target)
+	acct, ok := c.accounts[target]
+	if !ok {
+		return fmt.Errorf("%s has no account; only registered users can be given a role", target)
+	}
+	if c.admins[target] {
+		return fmt.Errorf("%s is made an admin on the server's command line", target)
+	}
+	if err := c.outranks(from, target); err != nil {
+		return err
+	}
+	if highest := c.roleOf(from) - 1; args.Role < chat.RoleUser || args.Role > highest {
+		return fmt.Errorf("you can only give the roles %s to %s", chat.RoleUser, highest)
+	}
+
+	old := acct.Role
+	acct.Role = args.Role
+	if err := c.saveAccounts(); err != nil {
+		acct.Role = old
+		slog.Error("Error saving accounts", "err", err)
+		return errors.New("could not save the new role")
+	}
+	slog.Info("Changed role", "name", target, "role", args.Role, "by", from)
+	c.record(from, auditSetRole, target, args.Role.String(), "")
+	c.announce("%s gave %s the %s role", from, target, args.Role)
+
+	return nil
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// nopCloser stands in for the network connection of a test client
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// team is a server with one user of each role, named after it and logged
+// in, and the session tokens of those users
+func team(t *testing.T, config Config) (*ChatServer, map[string]string) {
+	t.Helper()
+	s := newTestServer(t, config)
+	roles := map[string]chat.Role{
+		"owner":     chat.RoleOwner,
+		"admin":     chat.RoleAdmin,
+		"moderator": chat.RoleModerator,
+		"user":      chat.RoleUser,
+	}
+	register(t, s, "owner", "password1") // the first account runs the server
+	for _, name := range []string{"admin", "moderator", "user"} {
+		register(t, s, name, "password1")
+	}
+	tokens := make(map[string]string)
+	for _, name := range []string{"owner", "admin", "moderator", "user", "guest"} {
+		if acct, ok := s.accounts[name]; ok {
+			acct.Role = roles[name]
+		}
+		tokens[name] = logIn(t, s, name)
+	}
+	return s, tokens
+}
+
+// logIn starts a session for name on a new connection to s and returns
+// its token
+func logIn(t *testing.T, s *ChatServer, name string) string {
+	t.Helper()
+	c := &chatConn{ChatServer: s, conn: nopCloser{}}
+	c.mu.Lock()
+	sess, err := c.login(name)
+	c.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sess.token
+}
+
+func TestPermissionMatrix(t *testing.T) {
+	for perm := permCreateRoom; perm <= permBots; perm++ {
+		if _, ok := minRole[perm]; !ok {
+			t.Errorf("permission %d has no role in the matrix", perm)
+		}
+		if perm.String() == "" {
+			t.Errorf("permission %d has no name", perm)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	s, tokens := team(t, Config{Admins: []string{"boss"}})
+	register(t, s, "boss", "password1")
+	tokens["boss"] = logIn(t, s, "boss")
+
+	tests := []struct {
+		user    string
+		perm    permission
+		allowed bool
+	}{
+		{"guest", permCreateRoom, false},
+		{"user", permCreateRoom, true},
+		{"user", permKick, false},
+		{"user", permDelete, false},
+		{"moderator", permKick, true},
+		{"moderator", permMute, true},
+		{"moderator", permDelete, true},
+		{"moderator", permBan, false},
+		{"moderator", permPurge, false},
+		{"moderator", permSetRole, false},
+		{"admin", permBan, true},
+		{"admin", permPurge, true},
+		{"admin", permSetRole, true},
+		{"owner", permBots, true},
+		{"boss", permBan, true}, // an admin from the command line
+	}
+	for _, tt := range tests {
+		t.Run(tt.user+" can "+tt.perm.String(), func(t *testing.T) {
+			c := &chatConn{ChatServer: s}
+			c.mu.RLock()
+			name, err := c.authorize(tokens[tt.user], "", tt.perm)
+			c.mu.RUnlock()
+			if (err == nil) != tt.allowed {
+				t.Fatalf("authorize() error = %v, want allowed: %v", err, tt.allowed)
+			}
+			if err == nil && name != tt.user {
+				t.Errorf("authorized as %q, want %q", name, tt.user)
+			}
+		})
+	}
+
+	c := &chatConn{ChatServer: s}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if _, err := c.authorize("not a token", "owner", permCreateRoom); err == nil {
+		t.Error("authorized an unknown session token")
+	}
+	if _, err := c.authorize("", "owner", permCreateRoom); err == nil {
+		t.Error("authorized a name without a session")
+	}
+}
+
+func TestOutranks(t *testing.T) {
+	s, _ := team(t, Config{})
+	tests := []struct {
+		actor, target string
+		ok            bool
+	}{
+		{"owner", "admin", true},
+		{"admin", "moderator", true},
+		{"moderator", "user", true},
+		{"user", "guest", true},
+		{"moderator", "nobody", true}, // no account, so a guest
+		{"admin", "admin", false},
+		{"moderator", "moderator", false},
+		{"admin", "owner", false},
+		{"moderator", "admin", false},
+		{"user", "moderator", false},
+		{"guest", "guest", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.actor+" over "+tt.target, func(t *testing.T) {
+			s.mu.RLock()
+			err := s.outranks(tt.actor, tt.target)
+			s.mu.RUnlock()
+			if (err == nil) != tt.ok {
+				t.Errorf("outranks() error = %v, want ok: %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSetRole(t *testing.T) {
+	s, tokens := team(t, Config{Admins: []string{"boss"}})
+	register(t, s, "boss", "password1")
+	original := make(map[string]chat.Role)
+	for name, acct := range s.accounts {
+		original[name] = acct.Role
+	}
+
+	tests := []struct {
+		name   string
+		from   string
+		target string
+		role   chat.Role
+		ok     bool
+	}{
+		{"admin makes a moderator", "admin", "user", chat.RoleModerator, true},
+		{"admin demotes a moderator", "admin", "moderator", chat.RoleUser, true},
+		{"admin cannot make an admin", "admin", "user", chat.RoleAdmin, false},
+		{"owner demotes an admin", "owner", "admin", chat.RoleUser, true},
+		{"admin cannot change an equal", "admin", "admin", chat.RoleUser, false},
+		{"admin cannot change the owner", "admin", "owner", chat.RoleUser, false},
+		{"owner makes an admin", "owner", "user", chat.RoleAdmin, true},
+		{"owner cannot make an owner", "owner", "user", chat.RoleOwner, false},
+		{"nobody makes guests", "owner", "user", chat.RoleGuest, false},
+		{"moderator cannot give roles", "moderator", "user", chat.RoleModerator, false},
+		{"user cannot give roles", "user", "user", chat.RoleModerator, false},
+		{"guests have no account", "owner", "guest", chat.RoleModerator, false},
+		{"admins from the command line stay", "owner", "boss", chat.RoleUser, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, role := range original {
+				s.accounts[name].Role = role
+			}
+			c := &chatConn{ChatServer: s}
+			err := c.SetRole(&chat.RoleArgs{Token: tokens[tt.from], Target: tt.target, Role: tt.role}, nil)
+			if (err == nil) != tt.ok {
+				t.Fatalf("SetRole() error = %v, want ok: %v", err, tt.ok)
+			}
+			want := original[tt.target]
+			if tt.ok {
+				want = tt.role
+			}
+			s.mu.RLock()
+			got := s.roleOf(tt.target)
+			s.mu.RUnlock()
+			if tt.target != "boss" && tt.target != "guest" && got != want {
+				t.Errorf("%s has the %s role, want %s", tt.target, got, want)
+			}
+		})
+	}
+}
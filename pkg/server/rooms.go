@@ -0,0 +1,477 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxRoomNameLength limits how long a room name may be
+const maxRoomNameLength = 32
+
+// roomName normalizes a client-supplied room name
+func roomName(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return chat.DefaultRoom
+	}
+	return name
+}
+
+// savedRoom is how a room's settings are kept in the rooms file. Its
+// messages are kept in the store instead.
+type savedRoom struct {
+	Name       string
+	Operators  []string `json:",omitempty"`
+	Password   []byte   `json:",omitempty"` // bcrypt hash
+	InviteOnly bool     `json:",omitempty"`
+	Invites    []string `json:",omitempty"`
+
+	// Members is only kept for private rooms, so that their members need
+	// not be let in again after a restart
+	Members []string `json:",omitempty"`
+}
+
+// loadRooms reads the rooms file, if it exists, creating the rooms it
+// names that the store had no messages for
+func (s *ChatServer) loadRooms(path string) error {
+	s.roomsPath = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var rooms []savedRoom
+	if err := json.Unmarshal(data, &rooms); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for _, saved := range rooms {
+		r, ok := s.rooms[saved.Name]
+		if !ok {
+			r = newRoom()
+			s.rooms[saved.Name] = r
+		}
+		r.password = saved.Password
+		r.inviteOnly = saved.InviteOnly
+		for _, name := range saved.Operators {
+			r.operators[name] = true
+		}
+		for _, invite := range saved.Invites {
+			r.invites[invite] = true
+		}
+		for _, name := range saved.Members {
+			r.members[name] = true
+		}
+	}
+	return nil
+}
+
+// saveRooms rewrites the rooms file the same way saveAccounts does.
+// Rooms with nothing to remember besides their messages are left out.
+// The caller must hold s.mu.
+func (s *ChatServer) saveRooms() error {
+	if s.roomsPath == "" {
+		return nil
+	}
+
+	rooms := []savedRoom{}
+	for name, r := range s.rooms {
+		if len(r.operators) == 0 && !r.private() {
+			continue
+		}
+		saved := savedRoom{
+			Name:       name,
+			Operators:  sortedNames(r.operators),
+			Password:   r.password,
+			InviteOnly: r.inviteOnly,
+			Invites:    sortedNames(r.invites),
+		}
+		if r.private() {
+			saved.Members = sortedNames(r.members)
+		}
+		rooms = append(rooms, saved)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].Name < rooms[j].Name })
+
+	data, err := json.MarshalIndent(rooms, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.roomsPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.roomsPath)
+}
+
+// sortedNames returns the keys of a set in order
+func sortedNames(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// findRoom looks up an existing room.
+// The caller must hold s.mu.
+func (s *ChatServer) findRoom(name string) (*room, error) {
+	r, ok := s.rooms[name]
+	if !ok {
+		return nil, fmt.Errorf("room %q does not exist", name)
+	}
+	return r, nil
+}
+
+// isOperator reports whether name may manage room r: its creator can, and
+// so can anyone allowed to change the topic of any room.
+// The caller must hold s.mu.
+func (s *ChatServer) isOperator(r *room, name string) bool {
+	return r.operators[name] || s.can(name, permSetTopic)
+}
+
+// canInvite reports whether name may invite users to room r, and so join
+// it without an invite: its creator can, and so can admins.
+// The caller must hold s.mu.
+func (s *ChatServer) canInvite(r *room, name string) bool {
+	return r.operators[name] || s.can(name, permInvite)
+}
+
+// canRead reports whether name may read room r. Anyone may read public
+// rooms, but private ones only those who have been let in.
+// The caller must hold s.mu.
+func (s *ChatServer) canRead(r *room, name string) bool {
+	return !r.private() || r.members[name] || s.canInvite(r, name)
+}
+
+// readableRoom looks up a room for the user with the session token to read.
+// The caller must hold s.mu.
+func (s *ChatServer) readableRoom(name, token string) (*room, error) {
+	r, err := s.findRoom(name)
+	if err != nil {
+		return nil, err
+	}
+	if r.private() {
+		sess, ok := s.tokens[token]
+		if !ok || !s.canRead(r, sess.name) {
+			return nil, fmt.Errorf("room %s is private, join it first", name)
+		}
+	}
+	return r, nil
+}
+
+// CreateRoom creates a new room and joins the caller to it, making them
+// its operator. Giving a password or InviteOnly makes the room private.
+func (c *chatConn) CreateRoom(args *chat.RoomArgs, _ *struct{}) error {
+	name := strings.TrimSpace(args.Room)
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf("invalid room name %q", args.Room)
+	}
+	if len(name) > maxRoomNameLength {
+		return fmt.Errorf("room name is longer than %d characters", maxRoomNameLength)
+	}
+	var hash []byte
+	if args.Password != "" {
+		// Hashing is deliberately slow, so do it before taking the lock
+		var err error
+		if hash, err = bcrypt.GenerateFromPassword([]byte(args.Password), bcrypt.DefaultCost); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	from, err := c.authorize(args.Token, args.Name, permCreateRoom)
+	if err != nil {
+		return err
+	}
+	if _, ok := c.rooms[name]; ok {
+		return fmt.Errorf("room %q already exists", name)
+	}
+	r := newRoom()
+	r.members[from] = true
+	r.operators[from] = true
+	r.password = hash
+	r.inviteOnly = args.InviteOnly
+	c.rooms[name] = r
+	if err := c.saveRooms(); err != nil {
+		delete(c.rooms, name)
+		slog.Error("Error saving rooms", "err", err)
+		return errors.New("could not save the room")
+	}
+
+	slog.Info("Created room", "room", name, "by", from, "private", r.private())
+
+	return nil
+}
+
+// JoinRoom adds the caller to an existing room. Joining a private room
+// takes its password or one of its invites, unless the caller may invite
+// others to it.
+func (c *chatConn) JoinRoom(args *chat.RoomArgs, _ *struct{}) error {
+	name := roomName(args.Room)
+
+	c.mu.Lock()
+	hash, err := c.joinPassword(args, name)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// Checking is deliberately slow, so do it without the lock
+	if hash != nil && bcrypt.CompareHashAndPassword(hash, []byte(args.Password)) != nil {
+		slog.Warn("Wrong room password", "room", name, "ip", c.ip)
+		return fmt.Errorf("wrong password for room %s", name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	from, err := c.sender(args.Token, args.Name)
+	if err != nil {
+		return err
+	}
+	r, err := c.findRoom(name)
+	if err != nil {
+		return err
+	}
+	if r.members[from] {
+		return nil
+	}
+	r.members[from] = true
+	if r.private() {
+		if err := c.saveRooms(); err != nil {
+			slog.Error("Error saving rooms", "err", err)
+		}
+	}
+	// Hooks heard of everyone joining the default room when they logged in
+	if name != chat.DefaultRoom {
+		c.userJoined(from, name)
+	}
+
+	return nil
+}
+
+// joinPassword checks that the caller may join room name and returns the
+// hash of the room's password if they still have to prove they know it.
+// The caller must hold c.mu.
+func (c *chatConn) joinPassword(args *chat.RoomArgs, name string) ([]byte, error) {
+	from, err := c.sender(args.Token, args.Name)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.findRoom(name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case r.members[from] || !r.private() || c.canInvite(r, from):
+		return nil, nil
+	case args.Invite != "" && r.invites[args.Invite]:
+		return nil, nil
+	case r.password != nil && args.Password != "":
+		return r.password, nil
+	case r.password != nil:
+		return nil, fmt.Errorf("room %s needs a password", name)
+	case args.Invite != "":
+		return nil, fmt.Errorf("that invite to %s is not valid", name)
+	default:
+		return nil, fmt.Errorf("room %s is invite-only", name)
+	}
+}
+
+// LeaveRoom removes the caller from a room
+func (c *chatConn) LeaveRoom(args *chat.RoomArgs, _ *struct{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	from, err := c.sender(args.Token, args.Name)
+	if err != nil {
+		return err
+	}
+	name := roomName(args.Room)
+	r, err := c.findRoom(name)
+	if err != nil {
+		return err
+	}
+	if !r.members[from] {
+		return nil
+	}
+	delete(r.members, from)
+	if r.private() {
+		if err := c.saveRooms(); err != nil {
+			slog.Error("Error saving rooms", "err", err)
+		}
+	}
+	if name != chat.DefaultRoom {
+		c.userLeft(from, name)
+	}
+
+	return nil
+}
+
+// GenerateInvite returns a new invite to a private room, which lets
+// whoever has it join until it is revoked. Only those who may join the
+// room without one can make invites.
+func (c *chatConn) GenerateInvite(args *chat.InviteArgs, reply *chat.InviteReply) error {
+	invite, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	from, r, name, err := c.inviter(args)
+	if err != nil {
+		return err
+	}
+	r.invites[invite] = true
+	if err := c.saveRooms(); err != nil {
+		delete(r.invites, invite)
+		slog.Error("Error saving rooms", "err", err)
+		return errors.New("could not save the invite")
+	}
+	reply.Invite = invite
+
+	slog.Info("Generated invite", "room", name, "by", from)
+
+	return nil
+}
+
+// RevokeInvite stops an invite to a private room from letting anyone else
+// join. Users who already joined with it stay members.
+func (c *chatConn) RevokeInvite(args *chat.InviteArgs, _ *struct{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	from, r, name, err := c.inviter(args)
+	if err != nil {
+		return err
+	}
+	if !r.invites[args.Invite] {
+		return fmt.Errorf("no such invite to %s", name)
+	}
+	delete(r.invites, args.Invite)
+	if err := c.saveRooms(); err != nil {
+		r.invites[args.Invite] = true
+		slog.Error("Error saving rooms", "err", err)
+		return errors.New("could not save the room")
+	}
+
+	slog.Info("Revoked invite", "room", name, "by", from)
+
+	return nil
+}
+
+// inviter resolves the caller of GenerateInvite or RevokeInvite and the
+// room, checking the caller may manage its invites.
+// The caller must hold c.mu.
+func (c *chatConn) inviter(args *chat.InviteArgs) (from string, r *room, name string, err error) {
+	from, err = c.sender(args.Token, args.Name)
+	if err != nil {
+		return "", nil, "", err
+	}
+	name = roomName(args.Room)
+	r, err = c.findRoom(name)
+	if err != nil {
+		return "", nil, "", err
+	}
+	if !r.private() {
+		return "", nil, "", fmt.Errorf("room %s is not private, anyone can join it", name)
+	}
+	if !c.canInvite(r, from) {
+		return "", nil, "", fmt.Errorf("only operators of %s and admins can manage its invites", name)
+	}
+	return from, r, name, nil
+}
+
+// ListRooms returns every room sorted by name
+func (s *ChatServer) ListRooms(_ *struct{}, reply *chat.RoomsReply) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	reply.Rooms = make([]chat.RoomInfo, 0, len(s.rooms))
+	for name, r := range s.rooms {
+		reply.Rooms = append(reply.Rooms, chat.RoomInfo{
+			Name:     name,
+			Members:  len(r.members),
+			Messages: r.history.len(),
+			Topic:    r.topic.Body,
+			Private:  r.private(),
+		})
+	}
+	sort.Slice(reply.Rooms, func(i, j int) bool {
+		return reply.Rooms[i].Name < reply.Rooms[j].Name
+	})
+
+	return nil
+}
+
+// GetTopic returns the topic of a room
+func (s *ChatServer) GetTopic(args *chat.RoomArgs, reply *chat.TopicReply) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, err := s.findRoom(roomName(args.Room))
+	if err != nil {
+		return err
+	}
+	reply.Topic = r.topic.Body
+	if reply.Topic != "" {
+		reply.SetBy = r.topic.Sender
+		reply.SetAt = r.topic.Timestamp
+	}
+
+	return nil
+}
+
+// SetTopic changes the topic of a room, or clears it with an empty topic.
+// Only the room's operators may. The change is posted to the room, which
+// is how it is kept in the store and reaches everyone following the room.
+func (c *chatConn) SetTopic(args *chat.TopicArgs, _ *struct{}) error {
+	return c.posting(c.mu.RLocker(), func() (*storeJob, error) {
+		from, err := c.sender(args.Token, args.Name)
+		if err != nil {
+			return nil, err
+		}
+		name := roomName(args.Room)
+		r, err := c.findRoom(name)
+		if err != nil {
+			return nil, err
+		}
+		if !c.isOperator(r, from) {
+			return nil, fmt.Errorf("only operators of %s can change its topic", name)
+		}
+		topic := strings.TrimSpace(args.Topic)
+		if err := c.checkLength(topic); err != nil {
+			return nil, err
+		}
+		if topic, err = c.filterText(topic); err != nil {
+			return nil, err
+		}
+
+		msg := c.newMessage(name, from, "", topic)
+		msg.Kind = chat.KindTopic
+		job, err := c.post(&msg)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("Changed topic", "room", name, "by", from, "topic", topic)
+
+		return job, nil
+	})
+}
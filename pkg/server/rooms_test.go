@@ -0,0 +1,75 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+func TestJoinPrivateRoom(t *testing.T) {
+	s, tokens := team(t, Config{})
+	c := &chatConn{ChatServer: s}
+	if err := c.CreateRoom(&chat.RoomArgs{Token: tokens["user"], Room: "secret", Password: "hunter22"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateRoom(&chat.RoomArgs{Token: tokens["user"], Room: "club", InviteOnly: true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var invite chat.InviteReply
+	if err := c.GenerateInvite(&chat.InviteArgs{Token: tokens["user"], Room: "club"}, &invite); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		joiner   string
+		room     string
+		password string
+		invite   string
+		ok       bool
+	}{
+		{"no password", "guest", "secret", "", "", false},
+		{"wrong password", "guest", "secret", "hunter23", "", false},
+		{"password", "guest", "secret", "hunter22", "", true},
+		{"invite to a room with a password", "guest", "secret", "", invite.Invite, false},
+		{"moderators need the password too", "moderator", "secret", "", "", false},
+		{"admins can invite, so need no password", "admin", "secret", "", "", true},
+		{"no invite", "guest", "club", "", "", false},
+		{"made-up invite", "guest", "club", "", "not an invite", false},
+		{"invite", "guest", "club", "", invite.Invite, true},
+		{"a password is no invite", "guest", "club", "hunter22", "", false},
+		{"owner needs no invite", "owner", "club", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.mu.Lock()
+			r := s.rooms[tt.room]
+			delete(r.members, tt.joiner)
+			s.mu.Unlock()
+
+			c := &chatConn{ChatServer: s}
+			err := c.JoinRoom(&chat.RoomArgs{Token: tokens[tt.joiner], Room: tt.room, Password: tt.password, Invite: tt.invite}, nil)
+			if (err == nil) != tt.ok {
+				t.Errorf("JoinRoom() error = %v, want ok: %v", err, tt.ok)
+			}
+			s.mu.RLock()
+			member := r.members[tt.joiner]
+			_, readErr := s.readableRoom(tt.room, tokens[tt.joiner])
+			s.mu.RUnlock()
+			if member != tt.ok {
+				t.Errorf("member afterwards: %v, want %v", member, tt.ok)
+			}
+			if (readErr == nil) != tt.ok {
+				t.Errorf("readableRoom() error = %v, want ok: %v", readErr, tt.ok)
+			}
+		})
+	}
+
+	// A revoked invite lets nobody else in
+	if err := c.RevokeInvite(&chat.InviteArgs{Token: tokens["user"], Room: "club", Invite: invite.Invite}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.JoinRoom(&chat.RoomArgs{Token: tokens["moderator"], Room: "club", Invite: invite.Invite}, nil); err == nil {
+		t.Error("joined with a revoked invite")
+	}
+}
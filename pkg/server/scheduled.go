@@ -0,0 +1,299 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// scheduleInterval is how often the server posts the scheduled messages
+// that are due
+const scheduleInterval = time.Second
+
+// Limits on scheduled messages, so that nobody can fill the server's
+// memory with them
+const (
+	maxScheduled     = 50                   // waiting per user
+	maxScheduleAhead = 365 * 24 * time.Hour // how far ahead they can be
+)
+
+// loadScheduled reads the scheduled messages, if the file exists, and
+// remembers the path to save changes to
+func (s *ChatServer) loadScheduled(path string) error {
+	s.scheduledPath = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &s.scheduled); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for _, m := range s.scheduled {
+		s.nextScheduledID = max(s.nextScheduledID, m.ID)
+	}
+	return nil
+}
+
+// saveScheduled rewrites the scheduled messages file the same way
+// saveAccounts does. The caller must hold s.mu.
+func (s *ChatServer) saveScheduled() error {
+	if s.scheduledPath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.scheduled, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.scheduledPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.scheduledPath)
+}
+
+// ScheduleMessage holds a message until args.At and then posts it to a
+// room the sender has joined. Whether the sender may still post there is
+// checked again when it is due.
+func (c *chatConn) ScheduleMessage(args *chat.ScheduleArgs, reply *chat.ScheduleReply) error {
+	m := chat.ScheduledMessage{Room: roomName(args.Room), Body: args.Message, At: args.At}
+	id, err := c.schedule(args.Token, args.Name, m)
+	reply.ID = id
+	return err
+}
+
+// SetReminder has the server send args.Message back to the caller as a
+// private system message at args.At
+func (c *chatConn) SetReminder(args *chat.ReminderArgs, reply *chat.ScheduleReply) error {
+	m := chat.ScheduledMessage{Reminder: true, Body: args.Message, At: args.At}
+	id, err := c.schedule(args.Token, args.Name, m)
+	reply.ID = id
+	return err
+}
+
+// schedule adds m, a message or reminder from the caller, to the scheduled
+// messages and returns its ID. It takes an account, since by the time m
+// is due a guest's name may belong to someone else.
+func (c *chatConn) schedule(token, name string, m chat.ScheduledMessage) (int64, error) {
+	if strings.TrimSpace(m.Body) == "" {
+		return 0, errors.New("message is required")
+	}
+	if err := c.checkLength(m.Body); err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	if !m.At.After(now) {
+		return 0, errors.New("the time to send at must be in the future")
+	}
+	if m.At.Sub(now) > maxScheduleAhead {
+		return 0, fmt.Errorf("messages can be scheduled at most %v ahead", maxScheduleAhead)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	from, err := c.sender(token, name)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := c.accounts[from]; !ok {
+		return 0, errors.New("scheduling messages requires a registered account")
+	}
+	if !m.Reminder {
+		if _, err := c.joinedRoom(from, m.Room); err != nil {
+			return 0, err
+		}
+		if _, err := c.checkMuted(from); err != nil {
+			return 0, err
+		}
+		if _, err := c.filterText(m.Body); err != nil {
+			return 0, err
+		}
+	}
+	waiting := 0
+	for _, other := range c.scheduled {
+		if other.Sender == from {
+			waiting++
+		}
+	}
+	if waiting >= maxScheduled {
+		return 0, fmt.Errorf("you already have %d scheduled messages, the limit", waiting)
+	}
+	if err := c.checkRate(); err != nil {
+		return 0, err
+	}
+
+	c.nextScheduledID++
+	m.ID = c.nextScheduledID
+	m.Sender = from
+	m.Created = now
+	c.scheduled = append(c.scheduled, m)
+	if err := c.saveScheduled(); err != nil {
+		c.scheduled = c.scheduled[:len(c.scheduled)-1]
+		slog.Error("Error saving scheduled messages", "err", err)
+		return 0, errors.New("could not save the scheduled message")
+	}
+
+	slog.Info("Scheduled message", "scheduled", m.ID, "from", from, "room", m.Room, "reminder", m.Reminder, "at", m.At)
+
+	return m.ID, nil
+}
+
+// ListScheduled returns the caller's scheduled messages and reminders that
+// are still waiting, soonest first
+func (c *chatConn) ListScheduled(args *chat.UserArgs, reply *chat.ScheduledReply) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	from, err := c.sender(args.Token, args.Name)
+	if err != nil {
+		return err
+	}
+	for _, m := range c.scheduled {
+		if m.Sender == from {
+			reply.Messages = append(reply.Messages, m)
+		}
+	}
+	slices.SortStableFunc(reply.Messages, func(a, b chat.ScheduledMessage) int {
+		return a.At.Compare(b.At)
+	})
+
+	return nil
+}
+
+// CancelScheduled drops one of the caller's scheduled messages or
+// reminders before it is sent
+func (c *chatConn) CancelScheduled(args *chat.CancelScheduledArgs, _ *struct{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	from, err := c.sender(args.Token, args.Name)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(c.scheduled, func(m chat.ScheduledMessage) bool {
+		return m.ID == args.ID && m.Sender == from
+	})
+	if i < 0 {
+		return fmt.Errorf("scheduled message %d not found", args.ID)
+	}
+	c.scheduled = slices.Delete(c.scheduled, i, i+1)
+	if err := c.saveScheduled(); err != nil {
+		slog.Error("Error saving scheduled messages", "err", err)
+	}
+
+	slog.Info("Cancelled scheduled message", "scheduled", args.ID, "by", from)
+
+	return nil
+}
+
+// watchScheduled posts scheduled messages once they are due, checking
+// every scheduleInterval until the server shuts down
+func (s *ChatServer) watchScheduled() {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.mu.Lock()
+			s.postDue(now)
+			s.mu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// postDue posts every scheduled message and sends every reminder due by
+// now. A message that can no longer be posted, say because its sender
+// left the room, is dropped and its sender told why. The caller must hold
+// s.mu.
+func (s *ChatServer) postDue(now time.Time) {
+	waiting := s.scheduled[:0]
+	posted := 0
+	for _, m := range s.scheduled {
+		if m.At.After(now) {
+			waiting = append(waiting, m)
+			continue
+		}
+		posted++
+		if m.Reminder {
+			if err := s.remind(m); err != nil {
+				slog.Error("Error sending reminder", "scheduled", m.ID, "to", m.Sender, "err", err)
+			}
+		} else if err := s.postScheduled(m); err != nil {
+			slog.Info("Dropped scheduled message", "scheduled", m.ID, "from", m.Sender, "room", m.Room, "err", err)
+			s.tellUser(m.Sender, "Your message scheduled for %s in %s was not sent: %v", m.At.Format(time.DateTime), m.Room, err)
+		}
+	}
+	if posted == 0 {
+		return
+	}
+	clear(s.scheduled[len(waiting):])
+	s.scheduled = waiting
+	if err := s.saveScheduled(); err != nil {
+		slog.Error("Error saving scheduled messages", "err", err)
+	}
+}
+
+// postScheduled posts a scheduled message to its room, checking the
+// sender may still post there as SendMessage would. The caller must hold
+// s.mu.
+func (s *ChatServer) postScheduled(m chat.ScheduledMessage) error {
+	if s.bannedNames[m.Sender] {
+		return errors.New("you are banned from this server")
+	}
+	if _, err := s.joinedRoom(m.Sender, m.Room); err != nil {
+		return err
+	}
+	shadow, err := s.checkMuted(m.Sender)
+	if err != nil {
+		return err
+	}
+	text, err := s.filterText(m.Body)
+	if err != nil {
+		return err
+	}
+
+	msg := s.newMessage(m.Room, m.Sender, "", text)
+	msg.Mentions = s.mentions(text)
+	if shadow {
+		s.echo(&msg)
+		return nil
+	}
+	if _, err := s.post(&msg); err != nil {
+		return err
+	}
+	slog.Debug("Posted scheduled message", "scheduled", m.ID, "id", msg.ID, "from", m.Sender, "room", m.Room)
+
+	return nil
+}
+
+// remind sends a reminder back to the user who set it. Unlike tellUser's
+// messages it is saved, so it is not lost if they are offline when it is
+// due. The caller must hold s.mu.
+func (s *ChatServer) remind(m chat.ScheduledMessage) error {
+	msg := s.newMessage("", m.Sender, m.Sender, "Reminder: "+m.Body)
+	msg.Kind = chat.KindSystem
+	_, err := s.post(&msg)
+	return err
+}
+
+// tellUser shows a system message in name's private feed. It is not
+// saved. The caller must hold s.mu.
+func (s *ChatServer) tellUser(name, format string, args ...any) {
+	msg := s.newMessage("", "", name, fmt.Sprintf(format, args...))
+	msg.Kind = chat.KindSystem
+	s.show(name, &msg)
+}
@@ -0,0 +1,923 @@
+// Package server implements the chat server, so that other Go programs can
+// embed a chatroom instead of running the standalone binary:
+//
+//	srv, err := server.New(server.Config{})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	l, err := net.Listen("tcp", ":1234")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	go srv.Serve(l)
+//	...
+//	srv.Shutdown(ctx)
+//
+// Clients talk to it with the types in package chat.
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+	"google.golang.org/grpc"
+)
+
+// ChatServer represents the RPC server
+type ChatServer struct {
+	rooms  map[string]*room
+	dms    map[string]*messageLog // private messages sent or received, per user
+	nextID atomic.Int64           // the last ID given to a message, see stamp
+
+	conns    map[*chatConn]bool // every open connection
+	connsWG  sync.WaitGroup     // counts running serveConn calls
+	stopping bool               // set once the server is shutting down
+
+	// New connections are turned away once there are maxClients in all or
+	// maxConnsPerIP from the same address; 0 means no limit
+	maxClients    int
+	maxConnsPerIP int
+
+	listeners   map[net.Listener]bool // listeners Serve is accepting on
+	grpcServers map[*grpc.Server]bool // servers ServeGRPC is running
+	done        chan struct{}         // closed by Shutdown to stop background work
+	ctx         context.Context       // cancelled by Shutdown along with done
+	cancel      context.CancelFunc
+
+	// historyLimit is how many messages each room and each user's private
+	// history keep in memory, 0 for no limit
+	historyLimit int
+	online       map[string]*session // every online user by name
+	tokens       map[string]*session // the same sessions by token
+
+	// The retention policy, see Config
+	retentionAge   time.Duration
+	retentionCount int
+
+	reload    func() (Config, error) // loads the settings again, nil if it cannot
+	reloading sync.Mutex             // held while Reload runs
+
+	accounts     map[string]*account // registered users by name
+	accountsPath string              // file accounts are saved to, empty for none
+	roomsPath    string              // file room settings are saved to, empty for none
+	admins       map[string]bool     // accounts made admins on the command line
+
+	reports      []chat.Report // open reports, oldest first
+	reportsPath  string        // file reports are saved to, empty for none
+	nextReportID int64
+
+	// Shared files are kept under their hash in filesDir, or in files if
+	// it is empty. Files larger than maxFileSize, and images larger than
+	// maxImageSize, are refused; no files are taken if maxFileSize is 0.
+	filesDir     string
+	files        map[string][]byte
+	uploads      map[string]*upload // uploads in progress by ID
+	maxFileSize  int64
+	maxImageSize int64
+
+	// Links to previewHosts, and their subdomains, get a preview; none do
+	// if it is empty
+	previewHosts  []string
+	previewClient *http.Client
+	previewSlots  chan struct{} // holds a value for each fetch running
+
+	webhooks      []chat.Webhook // URLs new room messages are posted to
+	webhooksPath  string         // file they are saved to, empty for none
+	nextWebhookID int64
+	webhookClient *http.Client
+	webhookQueue  chan webhookDelivery // payloads waiting for sendWebhooks
+
+	bots      []bot  // identities external systems post to rooms under
+	botsPath  string // file they are saved to, empty for none
+	nextBotID int64
+
+	reservedSuffixes []string // name endings kept for hooks, see ReserveSuffix
+
+	scheduled       []chat.ScheduledMessage // messages waiting to be posted
+	scheduledPath   string                  // file they are saved to, empty for none
+	nextScheduledID int64
+
+	audit     []chat.AuditEntry // every moderation action and admin change
+	auditPath string            // file the audit log is appended to, empty for none
+	auditMu   sync.Mutex        // guards audit and the file, as senders record too
+
+	motd     string // message of the day, empty for none
+	motdPath string // file the message of the day is saved to, empty for none
+
+	filter     *wordFilter // nil when messages are not filtered
+	filterPath string      // file filter is loaded from
+	filterMode string      // FilterMask or FilterReject
+
+	bannedNames map[string]bool
+	bannedIPs   map[string]bool
+	muted       map[string]bool      // muted users, true for shadow mutes
+	kicked      map[string]time.Time // when kicked users may log in again
+
+	blocks map[string]map[string]bool // the users each user has blocked
+
+	sent   map[string]sentMessage // recent messages sent with a ClientID, see sentKey
+	sentMu sync.Mutex             // guards sent
+
+	receipts *receiptBook // who received and read what, guarded by its own lock
+
+	// Sending is limited to rateLimit messages per second per client, with
+	// bursts of up to rateBurst. A rateLimit of 0 disables the limit.
+	rateLimit float64
+	rateBurst int
+	buckets   map[string]*tokenBucket
+
+	// Each bot may post botRate messages per second, with bursts of up
+	// to botBurst. A botRate of 0 disables the limit.
+	botRate    float64
+	botBurst   int
+	botBuckets map[int64]*tokenBucket
+
+	// The spam detector's settings, see Config, and what it knows of
+	// each user
+	spamRepeats int
+	spamBurst   int
+	spamWindow  time.Duration
+	spamMute    time.Duration
+	spam        map[string]*spamState
+
+	// limitsMu guards buckets, botBuckets and spam, which senders change
+	// while holding s.mu only for reading
+	limitsMu sync.Mutex
+
+	maxLength  int           // longest message accepted, in bytes
+	editWindow time.Duration // how long messages can be edited, 0 for ever
+	codec      string        // chat.CodecGob or chat.CodecJSON
+
+	// presenceTimeout is how long a session lasts without a heartbeat
+	presenceTimeout time.Duration
+	idleTimeout     time.Duration // RPC connections silent this long are closed, 0 for never
+
+	started  time.Time      // when the server was created, for uptime
+	messages int            // chat messages ever posted, including stored ones
+	perUser  map[string]int // the same by sender
+	received int            // chat messages posted since the server started
+	statsMu  sync.Mutex     // guards messages, perUser and received
+
+	rpcCalls  atomic.Int64 // RPC requests answered, for -metrics-addr
+	rpcErrors atomic.Int64 // the same that returned an error
+
+	// allowLegacy accepts calls without a session token from clients that
+	// predate Login, as long as nobody is logged in with the name they use
+	allowLegacy bool
+
+	// mu guards the state above, apart from what has a lock of its own.
+	// Calls that only read it take mu for reading, so they do not wait for
+	// each other, and so do senders, as each history has a lock of its own.
+	mu        sync.RWMutex
+	updated   chan struct{} // closed and replaced whenever history changes
+	updatedMu sync.Mutex    // guards updated
+	store     MessageStore
+	writer    *storeWriter // saves new messages to store without holding mu
+
+	hooks      []MessageHook // called on messages and joins, guarded by mu
+	sending    sync.Mutex    // held while the OnBeforeSend hooks run
+	hookCalls  *hookQueue    // calls OnAfterSend and OnUserJoin
+	assistant  *assistant    // the built-in assistant, nil if there is none
+	translator Translator    // translates messages for Translate, nil if none
+
+	push        *pusher              // sends new messages to subscribers
+	subscribers map[*subscriber]bool // everyone following a room, guarded by mu
+}
+
+// Config holds the settings of a ChatServer. The zero value gives a server
+// that keeps everything in memory and only limits the length of messages.
+type Config struct {
+	// Store persists messages; the saved history is loaded by New. Nil
+	// keeps messages in memory only. The caller closes it after Shutdown.
+	Store MessageStore
+
+	// AccountsFile is where registered accounts are saved and loaded
+	// from, empty to keep them in memory only
+	AccountsFile string
+
+	// RoomsFile is where the settings of rooms, such as their operators,
+	// passwords and invites, are saved and loaded from, empty to keep
+	// them in memory only
+	RoomsFile string
+
+	// ReportsFile is where reports of abusive messages waiting for a
+	// moderator are saved and loaded from, empty to keep them in memory
+	// only
+	ReportsFile string
+
+	// FilesDir is the directory files users share are saved in, empty to
+	// keep them in memory only. MaxFileSize is the largest file that can
+	// be shared in bytes, chat.DefaultMaxFileSize if 0; a negative limit
+	// turns file sharing off. Images, which clients show inline, are also
+	// limited to MaxImageSize, chat.DefaultMaxImageSize if 0.
+	FilesDir     string
+	MaxFileSize  int64
+	MaxImageSize int64
+
+	// PreviewHosts lists the sites the server fetches pages from to
+	// preview links in messages, with their title and description. A
+	// host also allows its subdomains. Empty turns previews off.
+	PreviewHosts []string
+
+	// WebhooksFile is where the webhooks admins register with AddWebhook
+	// are saved and loaded from, empty to keep them in memory only
+	WebhooksFile string
+
+	// BotsFile is where the bots admins add with AddBot are saved and
+	// loaded from, empty to keep them in memory only
+	BotsFile string
+
+	// ScheduledFile is where messages scheduled with ScheduleMessage are
+	// saved and loaded from, empty to keep them in memory only
+	ScheduledFile string
+
+	// AuditFile is where moderation actions and admin changes are
+	// appended and loaded from, empty to keep them in memory only
+	AuditFile string
+
+	// MOTD is the message of the day clients show after connecting.
+	// MOTDFile, if set, is read instead when it exists, and is where
+	// changes admins make with SetMOTD are saved.
+	MOTD     string
+	MOTDFile string
+
+	// FilterFile, if set, lists words that messages may not contain. With
+	// FilterMode FilterMask, the default, they are replaced by asterisks;
+	// with FilterReject, messages containing them are refused. Admins can
+	// reload the file with ReloadFilter.
+	FilterFile string
+	FilterMode string
+
+	// Admins are accounts that always have at least the admin role.
+	// Without any, the first account registered becomes the owner.
+	Admins []string
+
+	// ImportFile is a history file, written by ExportHistory or the -export
+	// flag, to load into the server and its store at startup. Messages keep
+	// their IDs and timestamps, so a server can move to a new host.
+	ImportFile string
+
+	// HistoryLimit is how many messages each room and each user's private
+	// history keep in memory, 0 for no limit
+	HistoryLimit int
+
+	// Messages older than RetentionAge, and all but the newest
+	// RetentionCount of each room and private conversation, are removed
+	// from memory and from the store every few minutes. Each room's
+	// current topic is kept. 0 keeps messages regardless of age or count.
+	RetentionAge   time.Duration
+	RetentionCount int
+
+	// Sending is limited to RateLimit messages per second per client, with
+	// bursts of up to RateBurst. A RateLimit of 0 disables the limit.
+	RateLimit float64
+	RateBurst int
+
+	// Each bot may post BotRate messages per second through POST /hooks,
+	// with bursts of up to BotBurst, however many systems share its
+	// token. A BotRate of 0 disables the limit.
+	BotRate  float64
+	BotBurst int
+
+	// Flooding earns an automatic mute of SpamMute, doubling with each
+	// offence up to an hour: sending the same message more than
+	// SpamRepeats times in a row, or more than SpamBurst messages, within
+	// SpamWindow. 0 disables either check. SpamWindow and SpamMute
+	// default to 10 and 30 seconds.
+	SpamRepeats int
+	SpamBurst   int
+	SpamWindow  time.Duration
+	SpamMute    time.Duration
+
+	MaxLength   int           // longest message accepted in bytes, 0 for chat.DefaultMaxLength
+	EditWindow  time.Duration // how long messages can be edited, 0 for ever
+	AllowLegacy bool          // accept calls from clients that do not log in
+	Codec       string        // chat.CodecGob (the default if empty) or chat.CodecJSON
+
+	// New connections are turned away with an error once there are
+	// MaxClients in all, or MaxConnsPerIP from the same address. Clients
+	// connected over a Unix socket all count as one address. 0 means no
+	// limit.
+	MaxClients    int
+	MaxConnsPerIP int
+
+	// PresenceTimeout is how long a session lasts without a heartbeat
+	// before its user is marked offline, 30 seconds if 0
+	PresenceTimeout time.Duration
+
+	// PushWorkers is how many goroutines write new messages to the
+	// browsers and gRPC streams following rooms, 8 if 0. A client that
+	// falls PushQueue messages behind, 256 if 0, is disconnected rather
+	// than holding up the rest.
+	PushWorkers int
+	PushQueue   int
+
+	// IdleTimeout closes RPC connections that send no requests, not even
+	// heartbeats, for that long, 0 for never. It frees what clients that
+	// vanished without closing their connection hold on to.
+	IdleTimeout time.Duration
+
+	// AssistantURL, if set, adds a built-in assistant that answers
+	// messages mentioning @AssistantName ("assistant" if empty), and
+	// direct messages to it, with a reply from an OpenAI-compatible chat
+	// completions API at that URL. AssistantKey is sent as its bearer
+	// token and AssistantModel picks the model. Each answer is limited to
+	// AssistantMaxTokens tokens, 512 if 0, and each user may ask
+	// AssistantRate questions a minute, 5 if 0.
+	AssistantURL       string
+	AssistantKey       string
+	AssistantModel     string
+	AssistantName      string
+	AssistantMaxTokens int
+	AssistantRate      float64
+
+	// Translator, if set, translates messages for the Translate call.
+	// LibreTranslate is one.
+	Translator Translator
+
+	// Hooks are called on the messages users write and when they join
+	// rooms, in this order; AddHook adds more
+	Hooks []MessageHook
+
+	// Reload, if set, loads the settings again for Reload, which admins
+	// call with ReloadConfig. Only the rate limits, the spam detector, the
+	// message of the day, the word filter and the retention policy change;
+	// the rest of the settings take effect on restart.
+	Reload func() (Config, error)
+}
+
+// New creates a chat server from config, loading the history saved in
+// config.Store, the accounts in config.AccountsFile and the rooms in
+// config.RoomsFile
+func New(config Config) (*ChatServer, error) {
+	switch config.Codec {
+	case "", chat.CodecGob, chat.CodecJSON:
+	default:
+		return nil, fmt.Errorf("unknown codec %q", config.Codec)
+	}
+	s := newChatServer(config.Store)
+	if config.Codec != "" {
+		s.codec = config.Codec
+	}
+	if config.Store == nil {
+		s.store = memoryStore{}
+	}
+	s.historyLimit = config.HistoryLimit
+	if err := s.configure(config); err != nil {
+		return nil, err
+	}
+	s.reload = config.Reload
+	if config.MaxLength > 0 {
+		s.maxLength = config.MaxLength
+	}
+	s.editWindow = config.EditWindow
+	if config.MaxFileSize != 0 {
+		s.maxFileSize = max(config.MaxFileSize, 0)
+	}
+	if config.MaxImageSize > 0 {
+		s.maxImageSize = config.MaxImageSize
+	}
+	if config.FilesDir != "" {
+		if err := os.MkdirAll(config.FilesDir, 0o700); err != nil {
+			return nil, fmt.Errorf("creating the files directory: %w", err)
+		}
+		s.filesDir = config.FilesDir
+	}
+	for _, host := range config.PreviewHosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			s.previewHosts = append(s.previewHosts, host)
+		}
+	}
+	s.previewClient = s.newPreviewClient()
+	s.maxClients = config.MaxClients
+	s.maxConnsPerIP = config.MaxConnsPerIP
+	s.idleTimeout = config.IdleTimeout
+	workers, queueSize := defaultPushWorkers, defaultPushQueue
+	if config.PushWorkers > 0 {
+		workers = config.PushWorkers
+	}
+	if config.PushQueue > 0 {
+		queueSize = config.PushQueue
+	}
+	s.push = newPusher(workers, queueSize)
+	if config.PresenceTimeout > 0 {
+		s.presenceTimeout = config.PresenceTimeout
+	}
+	s.allowLegacy = config.AllowLegacy
+	s.hooks = append(s.hooks, config.Hooks...)
+	if s.assistant = newAssistant(s, config); s.assistant != nil {
+		s.hooks = append(s.hooks, s.assistant)
+	}
+	s.translator = config.Translator
+	for _, name := range config.Admins {
+		if name = strings.TrimSpace(name); name != "" {
+			s.admins[name] = true
+		}
+	}
+
+	loaded, err := s.restore()
+	if err != nil {
+		return nil, fmt.Errorf("loading history: %w", err)
+	}
+	slog.Info("Loaded messages", "count", loaded)
+	if config.ImportFile != "" {
+		imported, err := s.importHistory(config.ImportFile)
+		if err != nil {
+			return nil, fmt.Errorf("importing history: %w", err)
+		}
+		slog.Info("Imported messages", "count", imported, "path", config.ImportFile)
+	}
+	if config.AccountsFile != "" {
+		if err := s.loadAccounts(config.AccountsFile); err != nil {
+			return nil, fmt.Errorf("loading accounts: %w", err)
+		}
+		slog.Info("Loaded accounts", "count", len(s.accounts), "path", config.AccountsFile)
+	}
+	if config.ReportsFile != "" {
+		if err := s.loadReports(config.ReportsFile); err != nil {
+			return nil, fmt.Errorf("loading reports: %w", err)
+		}
+	}
+	if config.WebhooksFile != "" {
+		if err := s.loadWebhooks(config.WebhooksFile); err != nil {
+			return nil, fmt.Errorf("loading webhooks: %w", err)
+		}
+	}
+	if config.BotsFile != "" {
+		if err := s.loadBots(config.BotsFile); err != nil {
+			return nil, fmt.Errorf("loading bots: %w", err)
+		}
+	}
+	if config.ScheduledFile != "" {
+		if err := s.loadScheduled(config.ScheduledFile); err != nil {
+			return nil, fmt.Errorf("loading scheduled messages: %w", err)
+		}
+	}
+	if config.AuditFile != "" {
+		if err := s.loadAudit(config.AuditFile); err != nil {
+			return nil, fmt.Errorf("loading the audit log: %w", err)
+		}
+	}
+	if config.RoomsFile != "" {
+		if err := s.loadRooms(config.RoomsFile); err != nil {
+			return nil, fmt.Errorf("loading rooms: %w", err)
+		}
+	}
+
+	go s.watchPresence()
+	go s.watchRetention()
+	go s.watchScheduled()
+	for range webhookWorkers {
+		go s.sendWebhooks()
+	}
+	return s, nil
+}
+
+// newChatServer creates a chat server containing only the default room,
+// saving messages to store
+func newChatServer(store MessageStore) *ChatServer {
+	s := &ChatServer{
+		rooms:  map[string]*room{chat.DefaultRoom: newRoom()},
+		dms:    make(map[string]*messageLog),
+		conns:  make(map[*chatConn]bool),
+		online: make(map[string]*session),
+		tokens: make(map[string]*session),
+
+		accounts: make(map[string]*account),
+		admins:   make(map[string]bool),
+
+		bannedNames: make(map[string]bool),
+		bannedIPs:   make(map[string]bool),
+		muted:       make(map[string]bool),
+		kicked:      make(map[string]time.Time),
+		buckets:     make(map[string]*tokenBucket),
+		botBuckets:  make(map[int64]*tokenBucket),
+		spam:        make(map[string]*spamState),
+
+		blocks: make(map[string]map[string]bool),
+		sent:   make(map[string]sentMessage),
+
+		files:        make(map[string][]byte),
+		uploads:      make(map[string]*upload),
+		maxFileSize:  chat.DefaultMaxFileSize,
+		maxImageSize: chat.DefaultMaxImageSize,
+
+		previewSlots: make(chan struct{}, maxPreviewFetch),
+
+		webhookClient: &http.Client{Timeout: webhookTimeout},
+		webhookQueue:  make(chan webhookDelivery, webhookQueue),
+
+		receipts: newReceiptBook(),
+
+		maxLength:       chat.DefaultMaxLength,
+		codec:           chat.CodecGob,
+		presenceTimeout: defaultPresenceTimeout,
+
+		started: time.Now(),
+		perUser: make(map[string]int),
+
+		listeners:   make(map[net.Listener]bool),
+		grpcServers: make(map[*grpc.Server]bool),
+		done:        make(chan struct{}),
+
+		updated: make(chan struct{}),
+		store:   store,
+
+		subscribers: make(map[*subscriber]bool),
+		hookCalls:   newHookQueue(),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.writer = newStoreWriter(func(messages []chat.Message) error {
+		return appendAll(s.store, messages)
+	}, s.stamp, s.publish)
+	return s
+}
+
+// requestContext returns a context for a request to another server, such
+// as a webhook, done after timeout or as soon as the server shuts down
+func (s *ChatServer) requestContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(s.ctx, timeout)
+}
+
+// notify wakes up every client blocked in WaitForMessages
+func (s *ChatServer) notify() {
+	s.updatedMu.Lock()
+	defer s.updatedMu.Unlock()
+	close(s.updated)
+	s.updated = make(chan struct{})
+}
+
+// updates returns a channel closed the next time history changes
+func (s *ChatServer) updates() <-chan struct{} {
+	s.updatedMu.Lock()
+	defer s.updatedMu.Unlock()
+	return s.updated
+}
+
+// newMessage returns a new message, which post gives its ID and time
+func (s *ChatServer) newMessage(room, sender, to, body string) chat.Message {
+	return chat.Message{Room: room, Sender: sender, To: to, Body: body}
+}
+
+// stamp gives msg the next ID and the current time. Messages that are
+// delivered are stamped by the writer as they are queued, so that every
+// history stays in the order of their IDs.
+func (s *ChatServer) stamp(msg *chat.Message) {
+	msg.ID = s.nextID.Add(1)
+	msg.Timestamp = time.Now()
+}
+
+// deliver stores msg in its room, or in the private histories of its sender
+// and recipient for a direct message. The caller must hold s.mu, for
+// reading at least if msg is one inRoom accepts.
+func (s *ChatServer) deliver(msg chat.Message) {
+	// The SQLite store only keeps the body of polls and shared files,
+	// which the rest comes from
+	if msg.Kind == chat.KindPoll && msg.Poll == nil {
+		msg.Poll = chat.NewPoll(msg.Body)
+	}
+	if msg.Kind == chat.KindFile && msg.File == nil {
+		msg.File = chat.NewFile(msg.Body)
+	}
+	if msg.Ref != 0 {
+		s.apply(msg)
+	}
+	if msg.IsChat() {
+		s.statsMu.Lock()
+		s.messages++
+		s.perUser[msg.Sender]++
+		s.statsMu.Unlock()
+	}
+	// The ClientID is only for telling repeats apart, and nobody else's
+	// business
+	s.remember(msg)
+	msg.ClientID = ""
+
+	if msg.To != "" {
+		s.directLog(msg.Sender).add(msg, s.historyLimit)
+		if msg.To != msg.Sender {
+			s.directLog(msg.To).add(msg, s.historyLimit)
+		}
+		return
+	}
+
+	r, ok := s.rooms[msg.Room]
+	if !ok {
+		r = newRoom()
+		s.rooms[msg.Room] = r
+	}
+	if msg.Kind == chat.KindTopic {
+		r.topic = msg
+	}
+	r.history.add(msg, s.historyLimit)
+}
+
+// findMessage looks up a message kept in any room or private history.
+// The caller must hold s.mu.
+func (s *ChatServer) findMessage(id int64) (chat.Message, bool) {
+	for _, r := range s.rooms {
+		if m, ok := r.history.find(id); ok {
+			return m, true
+		}
+	}
+	for _, l := range s.dms {
+		if m, ok := l.find(id); ok {
+			return m, true
+		}
+	}
+	return chat.Message{}, false
+}
+
+// updateMessage calls fn on every kept copy of the message with the given
+// ID, which a direct message has in both its sender's and its recipient's
+// history. The caller must hold s.mu.
+func (s *ChatServer) updateMessage(id int64, fn func(*chat.Message)) {
+	target, ok := s.findMessage(id)
+	if !ok {
+		return
+	}
+
+	logs := []*messageLog{s.directLog(target.Sender), s.directLog(target.To)}
+	if target.To == "" {
+		logs = []*messageLog{&s.rooms[target.Room].history}
+	}
+	for _, l := range logs {
+		l.change(id, fn)
+	}
+}
+
+// apply makes the change described by an event message, such as an edit,
+// to the message it refers to. The caller must hold s.mu.
+func (s *ChatServer) apply(event chat.Message) {
+	switch event.Kind {
+	case chat.KindEdit:
+		s.updateMessage(event.Ref, func(m *chat.Message) {
+			m.Body = event.Body
+			m.Mentions = event.Mentions
+			m.Edited = event.Timestamp
+			if m.Preview != nil && !strings.Contains(m.Body, m.Preview.URL) {
+				m.Preview = nil
+			}
+		})
+	case chat.KindDelete:
+		s.updateMessage(event.Ref, func(m *chat.Message) {
+			m.Body = ""
+			m.Mentions = nil
+			m.Deleted = event.Timestamp
+			m.Reactions = nil
+			m.Poll = nil
+			m.File = nil
+			m.Preview = nil
+		})
+	case chat.KindReact, chat.KindUnreact:
+		s.updateMessage(event.Ref, func(m *chat.Message) {
+			m.Reactions = react(m.Reactions, event.Sender, event.Body, event.Kind == chat.KindReact)
+		})
+	case chat.KindPin:
+		s.updateMessage(event.Ref, func(m *chat.Message) {
+			m.Pinned = event.Timestamp
+		})
+	case chat.KindUnpin:
+		s.updateMessage(event.Ref, func(m *chat.Message) {
+			m.Pinned = time.Time{}
+		})
+	case chat.KindVote, chat.KindClosePoll:
+		s.updateMessage(event.Ref, func(m *chat.Message) {
+			m.Poll = m.Poll.Apply(event)
+		})
+	case chat.KindPreview:
+		s.updateMessage(event.Ref, func(m *chat.Message) {
+			m.Preview = chat.NewPreview(event.Body)
+		})
+	}
+}
+
+// react returns a copy of reactions with name added to or removed from the
+// users who reacted with reaction
+func react(reactions map[string][]string, name, reaction string, add bool) map[string][]string {
+	updated := make(map[string][]string, len(reactions)+1)
+	for r, names := range reactions {
+		updated[r] = names
+	}
+
+	var names []string
+	for _, n := range reactions[reaction] {
+		if n != name {
+			names = append(names, n)
+		}
+	}
+	if add {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		delete(updated, reaction)
+	} else {
+		updated[reaction] = names
+	}
+	if len(updated) == 0 {
+		return nil
+	}
+	return updated
+}
+
+// reacted reports whether name reacted to m with reaction
+func reacted(m chat.Message, name, reaction string) bool {
+	for _, n := range m.Reactions[reaction] {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// mentions returns the users mentioned in body with @name, each once.
+// Only names of online users and accounts count, so that things like
+// e-mail addresses and @everyone are left alone. The caller must hold s.mu.
+func (s *ChatServer) mentions(body string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, word := range strings.Fields(body) {
+		if !strings.HasPrefix(word, "@") {
+			continue
+		}
+		name := strings.TrimRight(word[1:], ".,:;!?)'\"")
+		_, online := s.online[name]
+		_, registered := s.accounts[name]
+		if (online || registered) && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// mentioned reports whether m mentions name
+func mentioned(m chat.Message, name string) bool {
+	for _, n := range m.Mentions {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// directLog returns the private message history of a user, creating it if
+// needed. The caller must hold s.mu.
+func (s *ChatServer) directLog(name string) *messageLog {
+	l, ok := s.dms[name]
+	if !ok {
+		l = &messageLog{}
+		s.dms[name] = l
+	}
+	return l
+}
+
+// directHistory returns the private message history of a user, or an
+// empty one if they have none, without creating it as directLog does.
+// The caller must hold s.mu, for reading at least.
+func (s *ChatServer) directHistory(name string) *messageLog {
+	if l, ok := s.dms[name]; ok {
+		return l
+	}
+	return &messageLog{}
+}
+
+// post stamps a new message and queues it to be saved and then delivered
+// to everyone waiting for it, and returns the job doing that on the
+// writer's goroutine, so that s.mu is not held while the store works.
+// Calls answering a client wait for the job once they let go of s.mu, and
+// fail if the message could not be saved. The caller must hold s.mu, for
+// reading at least.
+func (s *ChatServer) post(msg *chat.Message) (*storeJob, error) {
+	messages := []chat.Message{*msg}
+	job, err := s.postAll(messages)
+	*msg = messages[0]
+	return job, err
+}
+
+// postAll is post for several new messages, which are saved all at once
+// or not at all if the store can, and only delivered once saved. The
+// caller must hold s.mu, for reading at least.
+func (s *ChatServer) postAll(messages []chat.Message) (*storeJob, error) {
+	for i := range messages {
+		if err := s.beforeSend(&messages[i]); err != nil {
+			return nil, err
+		}
+	}
+	return s.writer.add(messages), nil
+}
+
+// publish delivers messages the writer saved to everyone waiting for them.
+// Everything it does that could take a while, pushing to browsers and
+// streams, webhooks, previews and the OnAfterSend hooks, is only queued,
+// so that s.mu, which it takes, is not held for long. Messages that only
+// go into a room's history need it just for reading, so that they do not
+// hold up readers, as that history has a lock of its own.
+func (s *ChatServer) publish(messages []chat.Message) {
+	s.mu.RLock()
+	if !s.inRooms(messages) {
+		// Anything else may change more than a history
+		s.mu.RUnlock()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	} else {
+		defer s.mu.RUnlock()
+	}
+
+	for _, msg := range messages {
+		s.deliver(msg)
+		msg.ClientID = ""
+		s.fanOut(msg)
+		if msg.IsChat() {
+			s.statsMu.Lock()
+			s.received++
+			s.statsMu.Unlock()
+		}
+		s.previewLinks(msg)
+		s.callWebhooks(msg)
+		s.afterSend(msg)
+	}
+	s.notify()
+}
+
+// inRooms reports whether delivering messages only adds them to the
+// histories of rooms that exist, which is all that most chat does. Events,
+// topics and direct messages change more. The caller must hold s.mu, for
+// reading at least.
+func (s *ChatServer) inRooms(messages []chat.Message) bool {
+	for _, msg := range messages {
+		if _, ok := s.rooms[msg.Room]; !ok || msg.To != "" || msg.Ref != 0 || msg.Kind == chat.KindTopic {
+			return false
+		}
+	}
+	return true
+}
+
+// show stamps msg, which is not saved, and adds it to name's private feed
+// once the messages posted before it are delivered, so that the feed stays
+// in order. The caller must hold s.mu, for reading at least.
+func (s *ChatServer) show(name string, msg *chat.Message) {
+	messages := []chat.Message{*msg}
+	s.writer.show(messages, func(messages []chat.Message) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, m := range messages {
+			m.ClientID = ""
+			s.directLog(name).add(m, s.historyLimit)
+		}
+		s.notify()
+	})
+	*msg = messages[0]
+}
+
+// posting calls fn, which posts messages, holding lock, s.mu or s.mu for
+// reading, and then waits for them to be saved and delivered without it.
+// It returns fn's error, or why the messages could not be saved. Most
+// calls only need to read s.mu to post, so that senders do not wait for
+// each other.
+func (s *ChatServer) posting(lock sync.Locker, fn func() (*storeJob, error)) error {
+	lock.Lock()
+	job, err := fn()
+	lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return job.wait()
+}
+
+// announce posts a system message to the default room. Failures are only
+// logged since there is no client to report them to.
+// The caller must hold s.mu, for reading at least.
+func (s *ChatServer) announce(format string, args ...any) {
+	msg := s.newMessage(chat.DefaultRoom, "", "", fmt.Sprintf(format, args...))
+	msg.Kind = chat.KindSystem
+	if _, err := s.post(&msg); err != nil {
+		slog.Error("Error announcing", "body", msg.Body, "err", err)
+	}
+}
+
+// restore loads previously saved messages from the store, recreating the
+// rooms they were posted in. It must be called before the server starts
+// serving.
+func (s *ChatServer) restore() (int, error) {
+	messages, err := s.store.Load()
+	if err != nil {
+		return 0, err
+	}
+	for _, msg := range messages {
+		s.deliver(msg)
+		if msg.ID > s.nextID.Load() {
+			s.nextID.Store(msg.ID)
+		}
+	}
+	return len(messages), nil
+}
@@ -0,0 +1,335 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// defaultPresenceTimeout is how long a user stays online without a
+// heartbeat, unless Config.PresenceTimeout says otherwise
+const defaultPresenceTimeout = 30 * time.Second
+
+// session is a logged in user. It is identified by its token and lasts as
+// long as the connection that created it keeps sending heartbeats.
+type session struct {
+	name     string
+	token    string
+	conn     *chatConn
+	lastSeen time.Time
+}
+
+// newToken returns a random session token
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Login claims a name for this connection, marks the user as online and
+// returns the session token that identifies them in later calls. It fails
+// while another connection is logged in with the same name; names are
+// released when their connection closes or stops sending heartbeats.
+func (c *chatConn) Login(args *chat.UserArgs, reply *chat.LoginReply) error {
+	name := strings.TrimSpace(args.Name)
+	if name == "" {
+		name = c.cert
+	}
+	if name == "" {
+		return errors.New("name is required")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// A client certificate proves the name just as well as a password
+	if _, ok := c.accounts[name]; ok && name != c.cert {
+		return fmt.Errorf("name %q is registered, authenticate with its password", name)
+	}
+	sess, err := c.login(name)
+	if err != nil {
+		return err
+	}
+	reply.Token = sess.token
+
+	return nil
+}
+
+// ClaimName is Login for clients that do not use session tokens
+func (c *chatConn) ClaimName(args *chat.UserArgs, _ *struct{}) error {
+	return c.Login(args, &chat.LoginReply{})
+}
+
+// RegisterUser is the original name for ClaimName
+func (c *chatConn) RegisterUser(args *chat.UserArgs, reply *struct{}) error {
+	return c.ClaimName(args, reply)
+}
+
+// login starts a new session for name on this connection, ending any
+// session the connection had before. The caller must hold c.mu.
+func (c *chatConn) login(name string) (*session, error) {
+	if c.cert != "" && name != c.cert {
+		return nil, fmt.Errorf("your certificate identifies you as %q", c.cert)
+	}
+	if sess, ok := c.online[name]; ok && sess.conn != c {
+		return nil, fmt.Errorf("name %q is already taken", name)
+	}
+	if c.isBot(name) {
+		return nil, fmt.Errorf("name %q belongs to a bot", name)
+	}
+	if c.banned(name) {
+		slog.Warn("Rejected banned user", "name", name, "ip", c.ip)
+		return nil, errors.New("you are banned from this server")
+	}
+	if until, ok := c.kicked[name]; ok {
+		if wait := time.Until(until); wait > 0 {
+			return nil, fmt.Errorf("you were kicked, you may log in again in %v", wait.Round(time.Second))
+		}
+		delete(c.kicked, name)
+	}
+	if old, ok := c.session(); ok {
+		c.markOffline(old, "logged in again")
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	sess := &session{name: name, token: token, conn: c, lastSeen: time.Now()}
+	c.name = name
+	c.online[name] = sess
+	c.tokens[token] = sess
+
+	slog.Info("User is online", "name", name, "ip", c.ip)
+	c.announce("%s joined", name)
+	c.userJoined(name, chat.DefaultRoom)
+
+	return sess, nil
+}
+
+// RenameUser changes the caller's name, keeping their session, rooms,
+// private messages, read receipts and any mute. The new name must be free and may not
+// belong to an account, since the caller has not proven it is theirs.
+func (c *chatConn) RenameUser(args *chat.RenameArgs, _ *struct{}) error {
+	name := strings.TrimSpace(args.NewName)
+	if name == "" {
+		return errors.New("name is required")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sess, ok := c.tokens[args.Token]
+	if !ok {
+		return errors.New("invalid or expired session, please log in again")
+	}
+	old := sess.name
+	if name == old {
+		return fmt.Errorf("you are already called %q", name)
+	}
+	if sess.conn.cert != "" {
+		return fmt.Errorf("your certificate identifies you as %q", sess.conn.cert)
+	}
+	if _, ok := c.online[name]; ok {
+		return fmt.Errorf("name %q is already taken", name)
+	}
+	if _, ok := c.accounts[name]; ok {
+		return fmt.Errorf("name %q is registered, log in with its password", name)
+	}
+	if c.isBot(name) {
+		return fmt.Errorf("name %q belongs to a bot", name)
+	}
+	if c.bannedNames[name] {
+		return fmt.Errorf("name %q is banned", name)
+	}
+
+	delete(c.online, old)
+	sess.name = name
+	sess.conn.name = name
+	c.online[name] = sess
+	roomsChanged := false
+	for _, r := range c.rooms {
+		if r.members[old] {
+			delete(r.members, old)
+			r.members[name] = true
+			roomsChanged = roomsChanged || r.private()
+		}
+		if r.operators[old] {
+			delete(r.operators, old)
+			r.operators[name] = true
+			roomsChanged = true
+		}
+	}
+	if roomsChanged {
+		if err := c.saveRooms(); err != nil {
+			slog.Error("Error saving rooms", "err", err)
+		}
+	}
+	if _, ok := c.dms[name]; !ok {
+		if log, ok := c.dms[old]; ok {
+			delete(c.dms, old)
+			c.dms[name] = log
+		}
+	}
+	if shadow, ok := c.muted[old]; ok {
+		delete(c.muted, old)
+		c.muted[name] = shadow
+	}
+	if blocked, ok := c.blocks[old]; ok {
+		delete(c.blocks, old)
+		c.blocks[name] = blocked
+	}
+	c.receipts.rename(old, name)
+
+	slog.Info("User renamed", "from", old, "to", name)
+	c.announce("%s is now known as %s", old, name)
+
+	return nil
+}
+
+// markOffline ends a session, releasing its name, and announces it.
+// The caller must hold s.mu.
+func (s *ChatServer) markOffline(sess *session, reason string) {
+	s.endSession(sess, reason)
+	s.announce("%s left", sess.name)
+	s.userLeft(sess.name, chat.DefaultRoom)
+}
+
+// endSession releases a session's name and token without announcing it.
+// The caller must hold s.mu.
+func (s *ChatServer) endSession(sess *session, reason string) {
+	delete(s.online, sess.name)
+	delete(s.tokens, sess.token)
+	slog.Info("User is offline", "name", sess.name, "reason", reason)
+}
+
+// session returns the session this connection is logged in with.
+// The caller must hold c.mu.
+func (c *chatConn) session() (*session, bool) {
+	sess, ok := c.online[c.name]
+	if !ok || sess.conn != c {
+		return nil, false
+	}
+	return sess, true
+}
+
+// Heartbeat keeps the caller online. Sessions that stop sending heartbeats
+// are dropped after the presence timeout and lose their name.
+func (c *chatConn) Heartbeat(args *chat.UserArgs, _ *struct{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if sess, ok := c.session(); ok {
+		sess.lastSeen = time.Now()
+		return nil
+	}
+	if args.Token != "" {
+		return errors.New("invalid or expired session, please log in again")
+	}
+
+	// Clients without tokens simply claim their name again, which lets them
+	// recover from a missed heartbeat without an extra round trip
+	name := strings.TrimSpace(args.Name)
+	if _, ok := c.accounts[name]; ok && name != c.cert {
+		return fmt.Errorf("name %q is registered, authenticate with its password", name)
+	}
+	_, err := c.login(name)
+	return err
+}
+
+// Ping keeps the caller online like Heartbeat, and tells them the server's
+// time and how long sessions and connections last without a ping. Callers that have not
+// logged in get an answer too, so they can check the server is up.
+func (c *chatConn) Ping(args *chat.UserArgs, reply *chat.PingReply) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if sess, ok := c.session(); ok {
+		sess.lastSeen = time.Now()
+	} else if args.Token != "" {
+		return errors.New("invalid or expired session, please log in again")
+	}
+	reply.Time = time.Now()
+	reply.PresenceTimeout = c.presenceTimeout
+	reply.IdleTimeout = c.idleTimeout
+	return nil
+}
+
+// UnregisterUser ends the caller's session when it exits cleanly
+func (c *chatConn) UnregisterUser(_ *chat.UserArgs, _ *struct{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if sess, ok := c.session(); ok {
+		c.markOffline(sess, "exited")
+	}
+
+	return nil
+}
+
+// ListOnlineUsers returns the names of everyone currently online, sorted
+func (s *ChatServer) ListOnlineUsers(_ *struct{}, reply *chat.UsersReply) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	reply.Users = make([]string, 0, len(s.online))
+	for name := range s.online {
+		reply.Users = append(reply.Users, name)
+	}
+	sort.Strings(reply.Users)
+
+	return nil
+}
+
+// expireUsers drops users whose last heartbeat is older than the presence
+// timeout, announcing that they left
+func (s *ChatServer) expireUsers() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sess := range s.online {
+		if time.Since(sess.lastSeen) > s.presenceTimeout {
+			s.markOffline(sess, "timed out")
+		}
+	}
+
+	// Forget rate limits of clients that have stopped sending
+	now := time.Now()
+	s.limitsMu.Lock()
+	for key, b := range s.buckets {
+		if b.idle(s.rateLimit, s.rateBurst, now) {
+			delete(s.buckets, key)
+		}
+	}
+	for id, b := range s.botBuckets {
+		if b.idle(s.botRate, s.botBurst, now) {
+			delete(s.botBuckets, id)
+		}
+	}
+	s.limitsMu.Unlock()
+	s.forgetSent()
+}
+
+// watchPresence periodically expires users that stopped sending heartbeats
+func (s *ChatServer) watchPresence() {
+	ticker := time.NewTicker(s.presenceTimeout / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.expireUsers()
+		case <-s.done:
+			return
+		}
+	}
+}
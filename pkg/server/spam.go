@@ -0,0 +1,96 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Defaults for the spam detector's Config settings
+const (
+	defaultSpamWindow = 10 * time.Second
+	defaultSpamMute   = 30 * time.Second
+)
+
+// Automatic mutes double with each strike up to maxSpamMute, and strikes
+// are forgotten after spamForgetAfter without a new one
+const (
+	maxSpamMute     = time.Hour
+	spamForgetAfter = time.Hour
+)
+
+// spamState is what the spam detector remembers about a user
+type spamState struct {
+	last       string      // their last message, normalized
+	repeats    int         // how many times in a row they sent it
+	recent     []time.Time // when they sent their messages in the window
+	strikes    int         // automatic mutes so far
+	lastStrike time.Time
+	mutedUntil time.Time
+}
+
+// checkSpam records that name is sending text. It returns an error if they
+// are serving an automatic mute, or if this message makes them a spammer,
+// in which case it mutes them for longer with each strike and announces
+// it. Moderators are exempt. The caller must hold s.mu, for reading at
+// least.
+func (s *ChatServer) checkSpam(name, text string) error {
+	if (s.spamRepeats <= 0 && s.spamBurst <= 0) || s.can(name, permMute) {
+		return nil
+	}
+	s.limitsMu.Lock()
+	defer s.limitsMu.Unlock()
+	now := time.Now()
+	st, ok := s.spam[name]
+	if !ok {
+		st = &spamState{}
+		s.spam[name] = st
+	}
+	if now.Before(st.mutedUntil) {
+		return fmt.Errorf("you are muted for flooding for another %v", st.mutedUntil.Sub(now).Round(time.Second))
+	}
+	if st.strikes > 0 && now.Sub(st.lastStrike) > spamForgetAfter {
+		st.strikes = 0
+	}
+
+	// Only messages within the window count, in a row or in all
+	recent := st.recent[:0]
+	for _, t := range st.recent {
+		if now.Sub(t) < s.spamWindow {
+			recent = append(recent, t)
+		}
+	}
+	normalized := strings.ToLower(strings.Join(strings.Fields(text), " "))
+	if normalized == st.last && len(recent) > 0 {
+		st.repeats++
+	} else {
+		st.last, st.repeats = normalized, 1
+	}
+	st.recent = append(recent, now)
+
+	var reason string
+	switch {
+	case s.spamRepeats > 0 && st.repeats > s.spamRepeats:
+		reason = "repeating the same message"
+	case s.spamBurst > 0 && len(st.recent) > s.spamBurst:
+		reason = "sending too many messages at once"
+	default:
+		return nil
+	}
+
+	mute := s.spamMute
+	for i := 0; i < st.strikes && mute < maxSpamMute; i++ {
+		mute *= 2
+	}
+	mute = min(mute, maxSpamMute)
+	st.strikes++
+	st.lastStrike = now
+	st.mutedUntil = now.Add(mute)
+	st.last, st.repeats, st.recent = "", 0, nil
+
+	slog.Warn("Muted user for spamming", "name", name, "reason", reason, "for", mute, "strike", st.strikes)
+	s.record("", auditAutoMute, name, fmt.Sprintf("for %v", mute), reason)
+	s.announce("%s was muted for %v for %s", name, mute, reason)
+	return fmt.Errorf("you are muted for %v for %s", mute, reason)
+}
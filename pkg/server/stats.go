@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// GetStats reports how busy the server is and has been
+func (s *ChatServer) GetStats(_ *struct{}, reply *chat.StatsReply) error {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	reply.MemAlloc = mem.HeapAlloc
+	reply.MemSys = mem.Sys
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	s.statsMu.Lock()
+	reply.Messages = s.messages
+	reply.PerUser = make(map[string]int, len(s.perUser))
+	for name, n := range s.perUser {
+		reply.PerUser[name] = n
+	}
+	s.statsMu.Unlock()
+	reply.Clients = len(s.conns)
+	reply.Online = len(s.online)
+	reply.Rooms = len(s.rooms)
+	reply.Uptime = time.Since(s.started)
+
+	return nil
+}
+
+// MetricsHandler returns an HTTP handler publishing the server's metrics
+// in the Prometheus text format, to be mounted at e.g. /metrics
+func (s *ChatServer) MetricsHandler() http.Handler {
+	return http.HandlerFunc(s.serveMetrics)
+}
+
+// serveMetrics publishes counters and gauges in the Prometheus text format
+func (s *ChatServer) serveMetrics(w http.ResponseWriter, _ *http.Request) {
+	s.statsMu.Lock()
+	received := s.received
+	s.statsMu.Unlock()
+
+	s.mu.RLock()
+	clients := len(s.conns)
+	online := len(s.online)
+	rooms := len(s.rooms)
+	history := 0
+	for _, r := range s.rooms {
+		history += r.history.kept()
+	}
+	for _, l := range s.dms {
+		history += l.kept()
+	}
+	s.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metric := func(name, kind, help string, value any) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+	}
+	metric("chat_messages_received_total", "counter", "Chat messages posted since the server started.", received)
+	metric("chat_rpc_requests_total", "counter", "RPC requests answered.", s.rpcCalls.Load())
+	metric("chat_rpc_errors_total", "counter", "RPC requests answered with an error.", s.rpcErrors.Load())
+	metric("chat_connected_clients", "gauge", "Open client connections.", clients)
+	metric("chat_online_users", "gauge", "Logged in users.", online)
+	metric("chat_rooms", "gauge", "Rooms on the server.", rooms)
+	metric("chat_history_messages", "gauge", "Messages kept in memory across rooms and private histories.", history)
+	metric("chat_uptime_seconds", "gauge", "Seconds since the server started.", time.Since(s.started).Seconds())
+}
@@ -0,0 +1,522 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+	_ "modernc.org/sqlite"
+)
+
+// MessageStore persists chat messages so history survives restarts. The
+// server always serves history from memory; a store only has to keep a
+// durable copy of it. New messages are appended from one goroutine, in
+// order, shortly after they are posted.
+type MessageStore interface {
+	// Load returns every stored message in the order it was appended
+	Load() ([]chat.Message, error)
+	// Append stores a single new message
+	Append(m chat.Message) error
+	Close() error
+}
+
+// batchStore is a MessageStore that can store several messages at once,
+// all of them or none, which SendMessages relies on
+type batchStore interface {
+	MessageStore
+	// AppendAll stores new messages in order
+	AppendAll(messages []chat.Message) error
+}
+
+// appendAll stores messages in store, all at once if it is a batchStore
+func appendAll(store MessageStore, messages []chat.Message) error {
+	if bs, ok := store.(batchStore); ok {
+		return bs.AppendAll(messages)
+	}
+	for _, m := range messages {
+		if err := store.Append(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeWriter saves new messages to the store from a goroutine of its
+// own, in the order they were posted, and only then delivers them with
+// publish, so that posting does not wait for the disk or the database
+// while holding s.mu, and nobody sees a message that was not saved. A
+// batch of messages is saved all at once if the store can. Messages are
+// stamped with their IDs as they are queued, so that they are delivered
+// in the order of their IDs however many post at once. Other work on the
+// store or the histories goes through the same goroutine with queue and
+// do, as stores are not safe to use from several at once and the
+// histories must stay in that order.
+type storeWriter struct {
+	save    func([]chat.Message) error
+	stamp   func(*chat.Message)
+	publish func([]chat.Message)
+
+	mu      sync.Mutex
+	wake    *sync.Cond  // signalled when jobs grows, the writer goes idle or stops
+	jobs    []*storeJob // waiting to run, oldest first
+	busy    bool        // while a job is running
+	stopped bool
+	failed  error // of the last save, nil if it worked
+	done    chan struct{}
+}
+
+// errNotSaved is what posting reports when the store failed to save the
+// messages, the details of which are logged rather than shown to users
+var errNotSaved = errors.New("the message could not be saved, please try again")
+
+// storeJob is a batch of messages to deliver, and save first unless they
+// are only shown, or a function to run instead
+type storeJob struct {
+	messages []chat.Message
+	save     bool
+	deliver  func([]chat.Message)
+	fn       func() error
+	done     chan struct{} // closed once the job has run
+	err      error         // why it failed, set before done is closed
+}
+
+// wait waits for the job to run and returns why it failed, if it did.
+// A nil job has nothing to wait for.
+func (j *storeJob) wait() error {
+	if j == nil {
+		return nil
+	}
+	<-j.done
+	return j.err
+}
+
+// newStoreWriter starts a goroutine saving the messages queued with add
+// with save and then delivering them with publish, once stamp has given
+// them their IDs
+func newStoreWriter(save func([]chat.Message) error, stamp func(*chat.Message), publish func([]chat.Message)) *storeWriter {
+	w := &storeWriter{save: save, stamp: stamp, publish: publish, done: make(chan struct{})}
+	w.wake = sync.NewCond(&w.mu)
+	go w.run()
+	return w
+}
+
+// add stamps messages, in place, and queues them to be saved and
+// delivered after those queued before
+func (w *storeWriter) add(messages []chat.Message) *storeJob {
+	job := &storeJob{messages: messages, save: true, deliver: w.publish}
+	if !w.push(job) {
+		slog.Error("Dropped messages to save, the server has shut down", "from", messages[0].ID, "count", len(messages))
+	}
+	return job
+}
+
+// show is add for messages that are delivered with deliver without being
+// saved
+func (w *storeWriter) show(messages []chat.Message, deliver func([]chat.Message)) *storeJob {
+	job := &storeJob{messages: messages, deliver: deliver}
+	w.push(job)
+	return job
+}
+
+// queue queues fn to run after the jobs queued before
+func (w *storeWriter) queue(fn func() error) *storeJob {
+	job := &storeJob{fn: fn}
+	w.push(job)
+	return job
+}
+
+// do runs fn once the jobs queued so far have run, with nothing else using
+// the store meanwhile, and returns its error. It waits for fn, so callers
+// must not hold s.mu, which delivering messages takes.
+func (w *storeWriter) do(fn func() error) error {
+	return w.queue(fn).wait()
+}
+
+// push stamps the messages of job and queues it, or fails it and returns
+// false if the writer was stopped
+func (w *storeWriter) push(job *storeJob) bool {
+	job.done = make(chan struct{})
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i := range job.messages {
+		w.stamp(&job.messages[i])
+	}
+	// The caller keeps the stamped messages, the job a copy of its own
+	job.messages = slices.Clone(job.messages)
+	if w.stopped {
+		job.err = errors.New("the server has shut down")
+		close(job.done)
+		return false
+	}
+	w.jobs = append(w.jobs, job)
+	w.wake.Broadcast()
+	return true
+}
+
+// run works through the queued jobs until the writer is stopped and has
+// run them all. Failures to save are logged and reported by err until a
+// later save works.
+func (w *storeWriter) run() {
+	defer close(w.done)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for {
+		for len(w.jobs) == 0 && !w.stopped {
+			w.wake.Wait()
+		}
+		if len(w.jobs) == 0 {
+			return
+		}
+		job := w.jobs[0]
+		w.jobs[0] = nil
+		w.jobs = w.jobs[1:]
+		w.busy = true
+		w.mu.Unlock()
+
+		var err error
+		if job.save {
+			err = w.save(job.messages)
+		}
+		switch {
+		case job.fn != nil:
+			job.err = job.fn()
+		case err != nil:
+			slog.Error("Error saving messages", "from", job.messages[0].ID, "count", len(job.messages), "err", err)
+			job.err = errNotSaved
+		default:
+			job.deliver(job.messages)
+		}
+		close(job.done)
+
+		w.mu.Lock()
+		w.busy = false
+		if job.save {
+			w.failed = err
+		}
+		w.wake.Broadcast()
+	}
+}
+
+// flush waits until every job queued so far has run
+func (w *storeWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for len(w.jobs) > 0 || w.busy {
+		w.wake.Wait()
+	}
+}
+
+// stop runs the jobs still queued and waits for them, after which nothing
+// more is saved. The caller must not hold s.mu.
+func (w *storeWriter) stop() {
+	w.mu.Lock()
+	w.stopped = true
+	w.wake.Broadcast()
+	w.mu.Unlock()
+	<-w.done
+}
+
+// err returns why the last save failed, or nil if it worked
+func (w *storeWriter) err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.failed
+}
+
+// memoryStore keeps nothing beyond the server's in-memory history
+type memoryStore struct{}
+
+func (memoryStore) Load() ([]chat.Message, error) { return nil, nil }
+
+func (memoryStore) Append(chat.Message) error { return nil }
+
+func (memoryStore) Close() error { return nil }
+
+// fileStore is an append-only JSON Lines file holding one message per line
+type fileStore struct {
+	path string
+	f    *os.File
+	enc  *json.Encoder
+}
+
+// openFileStore opens the history file at path, creating it if needed
+func openFileStore(path string) (*fileStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileStore{path: path, f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Load reads every message in the file
+func (fs *fileStore) Load() ([]chat.Message, error) {
+	if _, err := fs.f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var messages []chat.Message
+	scanner := bufio.NewScanner(fs.f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var m chat.Message
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			// Most likely a write cut short by a crash; skip it
+			slog.Warn("Skipping corrupt history line", "line", line, "path", fs.path, "err", err)
+			continue
+		}
+		messages = append(messages, m)
+	}
+	return messages, scanner.Err()
+}
+
+// Append writes a single message to the end of the file
+func (fs *fileStore) Append(m chat.Message) error {
+	return fs.enc.Encode(m)
+}
+
+// AppendAll writes messages to the end of the file in a single write, so
+// that a crash cannot keep some of them and lose the rest short of cutting
+// the write short, which Load copes with
+func (fs *fileStore) AppendAll(messages []chat.Message) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, m := range messages {
+		if err := enc.Encode(m); err != nil {
+			return err
+		}
+	}
+	_, err := fs.f.Write(buf.Bytes())
+	return err
+}
+
+// Delete rewrites the file without the messages with the given IDs,
+// replacing it the way saveAccounts does
+func (fs *fileStore) Delete(ids map[int64]bool) error {
+	messages, err := fs.Load()
+	if err != nil {
+		return err
+	}
+
+	tmp := fs.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, m := range messages {
+		if ids[m.ID] {
+			continue
+		}
+		if err := enc.Encode(m); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, fs.path); err != nil {
+		return err
+	}
+
+	// Append to the new file from now on
+	f, err = os.OpenFile(fs.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	fs.f.Close()
+	fs.f, fs.enc = f, json.NewEncoder(f)
+	return nil
+}
+
+// Close flushes the underlying file to disk and closes it
+func (fs *fileStore) Close() error {
+	if err := fs.f.Sync(); err != nil {
+		fs.f.Close()
+		return err
+	}
+	return fs.f.Close()
+}
+
+// sqliteMigrations holds the schema changes for the SQLite store in order.
+// The number applied so far is tracked in PRAGMA user_version, so new
+// migrations must only ever be appended.
+var sqliteMigrations = []string{
+	`CREATE TABLE messages (
+		id        INTEGER PRIMARY KEY,
+		room      TEXT NOT NULL,
+		sender    TEXT NOT NULL,
+		recipient TEXT NOT NULL DEFAULT '',
+		body      TEXT NOT NULL,
+		sent_at   INTEGER NOT NULL
+	)`,
+	`ALTER TABLE messages ADD COLUMN kind INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE messages ADD COLUMN ref INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE messages ADD COLUMN in_reply_to INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE messages ADD COLUMN thread INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE messages ADD COLUMN mentions TEXT NOT NULL DEFAULT ''`,
+}
+
+// sqliteStore keeps messages in a SQLite database
+type sqliteStore struct {
+	db *sql.DB
+}
+
+// openSQLiteStore opens the database at path and brings its schema up to date
+func openSQLiteStore(path string) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; sharing one connection avoids busy errors
+	db.SetMaxOpenConns(1)
+
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating %s: %w", path, err)
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+// migrateSQLite applies every migration the database has not seen yet
+func migrateSQLite(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return err
+		}
+		// PRAGMA does not accept bound parameters
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		slog.Info("Applied SQLite migration", "version", i+1)
+	}
+	return nil
+}
+
+// Load reads every message ordered by ID
+func (ss *sqliteStore) Load() ([]chat.Message, error) {
+	rows, err := ss.db.Query("SELECT id, kind, room, sender, recipient, body, sent_at, ref, in_reply_to, thread, mentions FROM messages ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []chat.Message
+	for rows.Next() {
+		var m chat.Message
+		var sentAt int64
+		var mentions string
+		if err := rows.Scan(&m.ID, &m.Kind, &m.Room, &m.Sender, &m.To, &m.Body, &sentAt, &m.Ref, &m.InReplyTo, &m.Thread, &mentions); err != nil {
+			return nil, err
+		}
+		m.Timestamp = time.Unix(0, sentAt)
+		// Mentioned names never contain spaces, see ChatServer.mentions
+		m.Mentions = strings.Fields(mentions)
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// insertMessage is the statement Append and AppendAll store a message with
+const insertMessage = "INSERT INTO messages (id, kind, room, sender, recipient, body, sent_at, ref, in_reply_to, thread, mentions) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+// storeTimeout bounds each database call so a locked or stalled database
+// cannot hold up the writer, and everyone posting, for ever
+const storeTimeout = 5 * time.Second
+
+// Append inserts a single message
+func (ss *sqliteStore) Append(m chat.Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	_, err := ss.db.ExecContext(ctx, insertMessage,
+		m.ID, m.Kind, m.Room, m.Sender, m.To, m.Body, m.Timestamp.UnixNano(), m.Ref, m.InReplyTo, m.Thread, strings.Join(m.Mentions, " "))
+	return err
+}
+
+// AppendAll inserts messages in a single transaction
+func (ss *sqliteStore) AppendAll(messages []chat.Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, m := range messages {
+		if _, err := tx.ExecContext(ctx, insertMessage,
+			m.ID, m.Kind, m.Room, m.Sender, m.To, m.Body, m.Timestamp.UnixNano(), m.Ref, m.InReplyTo, m.Thread, strings.Join(m.Mentions, " ")); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Delete removes the messages with the given IDs
+func (ss *sqliteStore) Delete(ids map[int64]bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for id := range ids {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM messages WHERE id = ?", id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Close closes the database
+func (ss *sqliteStore) Close() error {
+	return ss.db.Close()
+}
+
+// OpenStore opens a MessageStore by kind: "memory", "file" for a JSON Lines
+// file at historyPath, or "sqlite" for a database at dbPath
+func OpenStore(kind, historyPath, dbPath string) (MessageStore, error) {
+	switch kind {
+	case "memory":
+		return memoryStore{}, nil
+	case "file":
+		return openFileStore(historyPath)
+	case "sqlite":
+		return openSQLiteStore(dbPath)
+	default:
+		return nil, fmt.Errorf("unknown store %q (want memory, file or sqlite)", kind)
+	}
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// recordingStore is a slow store that remembers the batches saved to it,
+// failing while fail is set
+type recordingStore struct {
+	memoryStore
+
+	mu      sync.Mutex
+	batches [][]int64
+	fail    bool
+}
+
+func (rs *recordingStore) Append(m chat.Message) error {
+	return rs.AppendAll([]chat.Message{m})
+}
+
+func (rs *recordingStore) AppendAll(messages []chat.Message) error {
+	time.Sleep(time.Millisecond)
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.fail {
+		return errors.New("disk full")
+	}
+	rs.batches = append(rs.batches, ids(messages))
+	return nil
+}
+
+func (rs *recordingStore) setFail(fail bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.fail = fail
+}
+
+func TestStoreWriter(t *testing.T) {
+	store := &recordingStore{}
+	var published []int64
+	var last int64
+	stamp := func(m *chat.Message) {
+		last++
+		m.ID = last
+	}
+	w := newStoreWriter(func(messages []chat.Message) error { return appendAll(store, messages) }, stamp, func(messages []chat.Message) {
+		published = append(published, ids(messages)...)
+	})
+
+	for range 20 {
+		w.add(make([]chat.Message, 1))
+	}
+	batch := make([]chat.Message, 3)
+	w.add(batch)
+	w.flush()
+	if batch[0].ID != 21 || batch[2].ID != 23 {
+		t.Errorf("stamped %v, want 21 to 23", ids(batch))
+	}
+
+	store.mu.Lock()
+	batches := store.batches
+	store.mu.Unlock()
+	if len(batches) != 21 {
+		t.Fatalf("saved %d batches, want 21", len(batches))
+	}
+	for i, batch := range batches[:20] {
+		if len(batch) != 1 || batch[0] != int64(i+1) {
+			t.Fatalf("batch %d is %v, want [%d]", i, batch, i+1)
+		}
+	}
+	if last := batches[20]; len(last) != 3 || last[0] != 21 || last[2] != 23 {
+		t.Errorf("last batch is %v, want [21 22 23] saved at once", last)
+	}
+
+	if len(published) != 23 || published[22] != 23 {
+		t.Errorf("published %v, want 1 to 23", published)
+	}
+
+	// Failures are reported to whoever posted, and by err until a save
+	// works, and what was not saved is not published
+	store.setFail(true)
+	if err := w.add(make([]chat.Message, 1)).wait(); err == nil {
+		t.Error("wait() = nil after a failed save")
+	}
+	if w.err() == nil {
+		t.Error("err() = nil after a failed save")
+	}
+	if last := published[len(published)-1]; last == 24 {
+		t.Error("published a message that was not saved")
+	}
+	store.setFail(false)
+	if err := w.add(make([]chat.Message, 1)).wait(); err != nil {
+		t.Errorf("wait() = %v after a save worked", err)
+	}
+	if err := w.err(); err != nil {
+		t.Errorf("err() = %v after a save worked", err)
+	}
+
+	// Other work waits for what was queued before it
+	w.add(make([]chat.Message, 1))
+	var saved []int64
+	err := w.do(func() error {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		saved = store.batches[len(store.batches)-1]
+		return errors.New("done")
+	})
+	if len(saved) != 1 || saved[0] != 26 || err == nil {
+		t.Errorf("do() saw %v and returned %v, want [26] and its error", saved, err)
+	}
+
+	// Messages that are only shown are not saved, but delivered in order
+	var shown []int64
+	w.show(make([]chat.Message, 1), func(messages []chat.Message) {
+		shown = append(shown, ids(messages)...)
+	})
+	if err := w.add(make([]chat.Message, 1)).wait(); err != nil {
+		t.Fatal(err)
+	}
+	if len(shown) != 1 || shown[0] != 27 || published[len(published)-1] != 28 {
+		t.Errorf("showed %v and published %v last, want [27] and 28", shown, published[len(published)-1])
+	}
+
+	// Stopping saves what is still waiting, and nothing after
+	w.add(make([]chat.Message, 1))
+	w.stop()
+	if err := w.add(make([]chat.Message, 1)).wait(); err == nil {
+		t.Error("wait() = nil for a message added after stopping")
+	}
+	if err := w.do(func() error { return nil }); err == nil {
+		t.Error("do() worked after stopping")
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if last := store.batches[len(store.batches)-1]; last[0] != 29 {
+		t.Errorf("last batch saved is %v, want [29]", last)
+	}
+}
+
+func TestPostSavesBeforeDelivering(t *testing.T) {
+	store := &recordingStore{}
+	s, err := New(Config{Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivered := func() int {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return s.rooms[chat.DefaultRoom].history.len()
+	}
+
+	s.mu.Lock()
+	var job *storeJob
+	for range 50 {
+		msg := s.newMessage(chat.DefaultRoom, "alice", "", "hello")
+		job, _ = s.post(&msg)
+	}
+	s.mu.Unlock()
+	// Each save takes a millisecond, which posting did not wait for
+	store.mu.Lock()
+	saved := len(store.batches)
+	store.mu.Unlock()
+	if saved == 50 {
+		t.Error("posting waited for the messages to be saved")
+	}
+	if err := job.wait(); err != nil {
+		t.Fatal(err)
+	}
+	if n := delivered(); n != 50 {
+		t.Fatalf("delivered %d messages, want 50", n)
+	}
+
+	store.setFail(true)
+	msg := s.newMessage(chat.DefaultRoom, "alice", "", "lost")
+	s.mu.Lock()
+	job, _ = s.post(&msg)
+	s.mu.Unlock()
+	if err := job.wait(); err == nil {
+		t.Error("posting a message that was not saved worked")
+	}
+	if n := delivered(); n != 50 {
+		t.Error("delivered a message that was not saved")
+	}
+
+	// Health reports the failure
+	var health chat.HealthReply
+	s.Health(nil, &health)
+	if health.Healthy {
+		t.Errorf("healthy after failing to save: %+v", health)
+	}
+	store.setFail(false)
+
+	// Shutdown returns once everything is saved
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.Shutdown(ctx)
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	saved = 0
+	for _, batch := range store.batches {
+		saved += len(batch)
+	}
+	// The 50 messages, and the server's announcement that it shuts down
+	if saved != 51 {
+		t.Errorf("saved %d messages, want 51", saved)
+	}
+}
+
+func TestConcurrentSendsStayInOrder(t *testing.T) {
+	s, tokens := team(t, Config{})
+	s.writer.flush()
+	before := s.rooms[chat.DefaultRoom].history.len()
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"admin", "moderator", "user", "guest"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := &chatConn{ChatServer: s, protocol: 2}
+			for i := range 25 {
+				args := chat.MessageArgs{Token: tokens[name], Message: fmt.Sprintf("message %d", i)}
+				if err := c.SendMessage(&args, &chat.HistoryReply{}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	history := s.rooms[chat.DefaultRoom].history.since(before)
+	if len(history) != 100 {
+		t.Fatalf("delivered %d messages, want 100", len(history))
+	}
+	for i := 1; i < len(history); i++ {
+		if history[i].ID <= history[i-1].ID {
+			t.Fatalf("message %d came after message %d", history[i].ID, history[i-1].ID)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// Limits on translating messages
+const (
+	translateTimeout     = 10 * time.Second // for each translation
+	maxTranslationAnswer = 64 << 10         // bytes of a provider's response read
+	maxLanguageLength    = 16               // e.g. "zh-Hant"
+)
+
+// Translator translates text for Translate. Programs that embed the
+// server may give their own in Config.Translator; LibreTranslate is the
+// one the chat server command uses.
+type Translator interface {
+	// Translate returns text in the language lang, a code such as "fr"
+	// or "pt-BR", working out which language text is in itself. It should
+	// give up once ctx is done.
+	Translate(ctx context.Context, text, lang string) (string, error)
+}
+
+// LibreTranslate is a Translator using the API of LibreTranslate
+// (https://libretranslate.com) or a server compatible with it
+type LibreTranslate struct {
+	URL    string       // of the translate endpoint, e.g. https://libretranslate.com/translate
+	Key    string       // API key, if the server needs one
+	Client *http.Client // http.DefaultClient if nil
+}
+
+// libreTranslateRequest is the body of a request to LibreTranslate
+type libreTranslateRequest struct {
+	Q      string `json:"q"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Format string `json:"format"`
+	APIKey string `json:"api_key,omitempty"`
+}
+
+// libreTranslateResponse is LibreTranslate's answer
+type libreTranslateResponse struct {
+	TranslatedText string `json:"translatedText"`
+	Error          string `json:"error"`
+}
+
+// Translate implements Translator
+func (t *LibreTranslate) Translate(ctx context.Context, text, lang string) (string, error) {
+	body, err := json.Marshal(libreTranslateRequest{Q: text, Source: "auto", Target: lang, Format: "text", APIKey: t.Key})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "chatroom-translate/"+Version)
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var reply libreTranslateResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxTranslationAnswer)).Decode(&reply); err != nil && resp.StatusCode == http.StatusOK {
+		return "", fmt.Errorf("decoding the translation: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if reply.Error != "" {
+			return "", fmt.Errorf("status %s: %s", resp.Status, reply.Error)
+		}
+		return "", fmt.Errorf("status %s", resp.Status)
+	}
+	return reply.TranslatedText, nil
+}
+
+// validLanguage reports whether lang looks like a language code: letters,
+// with parts after hyphens or underscores
+func validLanguage(lang string) bool {
+	if lang == "" || len(lang) > maxLanguageLength || strings.Trim(lang, "-_") != lang {
+		return false
+	}
+	for _, r := range lang {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// Translate translates a message in a room the caller has joined into
+// another language and posts the translation from them, as a reply to the
+// message. The translation costs the caller a message of their rate limit
+// on top of the one it is posted with.
+func (c *chatConn) Translate(args *chat.TranslateArgs, reply *chat.TranslateReply) error {
+	lang := strings.TrimSpace(args.Lang)
+	if !validLanguage(lang) {
+		return fmt.Errorf("%q is not a language code, such as fr or pt-BR", args.Lang)
+	}
+
+	c.mu.RLock()
+	if c.translator == nil {
+		c.mu.RUnlock()
+		return errors.New("the server cannot translate messages")
+	}
+	from, target, err := c.translatable(args)
+	if err == nil {
+		err = c.checkRate()
+	}
+	c.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	text, err := c.translate(target.Body, lang)
+	if err != nil {
+		slog.Error("Error translating", "id", target.ID, "lang", lang, "by", from, "err", err)
+		return errors.New("could not translate the message, please try again later")
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return errors.New("the translation is empty")
+	}
+	text = truncateText(fmt.Sprintf("[%s] %s", lang, text), c.maxLength)
+
+	return c.posting(c.mu.RLocker(), func() (*storeJob, error) {
+		// The caller may have logged out or left the room meanwhile
+		if from, target, err = c.translatable(args); err != nil {
+			return nil, err
+		}
+		_, msg, job, _, err := c.sendMessage(from, target.Room, text, chat.KindChat, target.ID, "")
+		if err != nil {
+			return nil, err
+		}
+		reply.ID = msg.ID
+		reply.Text = msg.Body
+
+		slog.Debug("Translated message", "id", target.ID, "lang", lang, "by", from, "translation", msg.ID)
+		return job, nil
+	})
+}
+
+// translatable returns who is asking for a translation and the message
+// they want translated, after checking they can see it and post replies
+// to it. The caller must hold c.mu.
+func (c *chatConn) translatable(args *chat.TranslateArgs) (string, chat.Message, error) {
+	from, err := c.sender(args.Token, args.Name)
+	if err != nil {
+		return "", chat.Message{}, err
+	}
+	target, ok := c.findMessage(args.ID)
+	hide := c.blockedBy(from)
+	if !ok || (target.Kind != chat.KindChat && target.Kind != chat.KindEmote) || !target.Deleted.IsZero() || target.To != "" || (hide != nil && hide(target)) {
+		return "", chat.Message{}, fmt.Errorf("message %d not found", args.ID)
+	}
+	if _, err := c.joinedRoom(from, target.Room); err != nil {
+		return "", chat.Message{}, err
+	}
+	return from, target, nil
+}
+
+// translate asks the server's translator for text in lang, giving up when
+// it takes too long or the server shuts down
+func (s *ChatServer) translate(text, lang string) (string, error) {
+	ctx, cancel := s.requestContext(translateTimeout)
+	defer cancel()
+	return s.translator.Translate(ctx, text, lang)
+}
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// Limits on posting to webhooks, so that slow or broken receivers cannot
+// hold up the server
+const (
+	webhookTimeout  = 10 * time.Second // for each attempt
+	webhookAttempts = 5                // tries before a payload is dropped
+	webhookBackoff  = time.Second      // wait before the first retry, doubling after each
+	webhookQueue    = 1024             // payloads waiting to be sent; more are dropped
+	webhookWorkers  = 4                // payloads being sent at once
+)
+
+// webhookDelivery is a payload on its way to a webhook
+type webhookDelivery struct {
+	hook    chat.Webhook
+	body    []byte
+	attempt int // failed attempts so far
+}
+
+// loadWebhooks reads the registered webhooks, if the file exists, and
+// remembers the path to save changes to
+func (s *ChatServer) loadWebhooks(path string) error {
+	s.webhooksPath = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &s.webhooks); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for _, h := range s.webhooks {
+		s.nextWebhookID = max(s.nextWebhookID, h.ID)
+	}
+	return nil
+}
+
+// saveWebhooks rewrites the webhooks file the same way saveAccounts does.
+// The caller must hold s.mu.
+func (s *ChatServer) saveWebhooks() error {
+	if s.webhooksPath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.webhooks, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.webhooksPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.webhooksPath)
+}
+
+// AddWebhook lets an admin register a URL that every new message in a
+// room, or in every public room, is posted to
+func (c *chatConn) AddWebhook(args *chat.WebhookArgs, reply *chat.WebhookReply) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	admin, err := c.authorize(args.Token, args.Name, permWebhooks)
+	if err != nil {
+		return err
+	}
+	link := strings.TrimSpace(args.URL)
+	if u, err := url.Parse(link); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http or https URL", link)
+	}
+	room := strings.TrimSpace(args.Room)
+	if _, ok := c.rooms[room]; room != "" && !ok {
+		return fmt.Errorf("room %s does not exist", room)
+	}
+
+	c.nextWebhookID++
+	hook := chat.Webhook{
+		ID:      c.nextWebhookID,
+		URL:     link,
+		Room:    room,
+		Secret:  args.Secret,
+		Creator: admin,
+		Created: time.Now(),
+	}
+	c.webhooks = append(c.webhooks, hook)
+	if err := c.saveWebhooks(); err != nil {
+		c.webhooks = c.webhooks[:len(c.webhooks)-1]
+		slog.Error("Error saving webhooks", "err", err)
+		return errors.New("could not save the webhook")
+	}
+	reply.ID = hook.ID
+
+	slog.Info("Added webhook", "webhook", hook.ID, "room", room, "by", admin)
+	c.record(admin, auditAddWebhook, "", webhookDetail(hook), "")
+	return nil
+}
+
+// RemoveWebhook lets an admin stop messages being posted to a webhook.
+// Payloads already on their way may still arrive.
+func (c *chatConn) RemoveWebhook(args *chat.WebhookArgs, _ *struct{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	admin, err := c.authorize(args.Token, args.Name, permWebhooks)
+	if err != nil {
+		return err
+	}
+	for i, hook := range c.webhooks {
+		if hook.ID != args.ID {
+			continue
+		}
+		old := c.webhooks
+		c.webhooks = append(c.webhooks[:i:i], c.webhooks[i+1:]...)
+		if err := c.saveWebhooks(); err != nil {
+			c.webhooks = old
+			slog.Error("Error saving webhooks", "err", err)
+			return errors.New("could not remove the webhook")
+		}
+		slog.Info("Removed webhook", "webhook", hook.ID, "by", admin)
+		c.record(admin, auditRemoveWebhook, "", webhookDetail(hook), "")
+		return nil
+	}
+	return fmt.Errorf("webhook %d not found", args.ID)
+}
+
+// ListWebhooks returns the registered webhooks, oldest first, without
+// their secrets
+func (c *chatConn) ListWebhooks(args *chat.UserArgs, reply *chat.WebhooksReply) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if _, err := c.authorize(args.Token, args.Name, permWebhooks); err != nil {
+		return err
+	}
+	for _, hook := range c.webhooks {
+		hook.Signed = hook.Secret != ""
+		hook.Secret = ""
+		reply.Webhooks = append(reply.Webhooks, hook)
+	}
+	return nil
+}
+
+// webhookDetail describes a webhook for the audit log
+func webhookDetail(hook chat.Webhook) string {
+	if hook.Room == "" {
+		return fmt.Sprintf("webhook %d to %s for every room", hook.ID, hook.URL)
+	}
+	return fmt.Sprintf("webhook %d to %s for %s", hook.ID, hook.URL, hook.Room)
+}
+
+// callWebhooks queues a message that was just posted for the webhooks of
+// its room. Only chat messages in rooms are sent, never direct messages,
+// and webhooks for every room leave out private ones. When the queue is
+// full the message is dropped rather than holding up the chat.
+// The caller must hold s.mu.
+func (s *ChatServer) callWebhooks(msg chat.Message) {
+	if len(s.webhooks) == 0 || !msg.IsChat() || msg.To != "" {
+		return
+	}
+	r, ok := s.rooms[msg.Room]
+	if !ok {
+		return
+	}
+	for _, hook := range s.webhooks {
+		if hook.Room != msg.Room && (hook.Room != "" || r.private()) {
+			continue
+		}
+		body, err := json.Marshal(chat.WebhookPayload{Webhook: hook.ID, Message: msg})
+		if err != nil {
+			slog.Error("Error encoding webhook payload", "webhook", hook.ID, "id", msg.ID, "err", err)
+			continue
+		}
+		select {
+		case s.webhookQueue <- webhookDelivery{hook: hook, body: body}:
+		default:
+			slog.Warn("Dropped webhook payload, too many waiting", "webhook", hook.ID, "id", msg.ID)
+		}
+	}
+}
+
+// sendWebhooks posts queued payloads to their webhooks until the server
+// shuts down
+func (s *ChatServer) sendWebhooks() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case d := <-s.webhookQueue:
+			s.sendWebhook(d)
+		}
+	}
+}
+
+// sendWebhook makes one attempt at posting a payload. Failures that may
+// pass, such as timeouts and server errors, are tried again after a
+// backoff, up to webhookAttempts in all, so retries can arrive after
+// newer messages.
+func (s *ChatServer) sendWebhook(d webhookDelivery) {
+	retry, err := s.postWebhook(d.hook, d.body)
+	if err == nil {
+		return
+	}
+	d.attempt++
+	if !retry || d.attempt >= webhookAttempts {
+		slog.Warn("Gave up on webhook payload", "webhook", d.hook.ID, "attempts", d.attempt, "err", err)
+		return
+	}
+	delay := webhookBackoff << (d.attempt - 1)
+	slog.Debug("Retrying webhook payload", "webhook", d.hook.ID, "in", delay, "err", err)
+	time.AfterFunc(delay, func() {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		select {
+		case s.webhookQueue <- d:
+		default:
+			slog.Warn("Dropped webhook payload, too many waiting", "webhook", d.hook.ID)
+		}
+	})
+}
+
+// postWebhook posts a payload to a webhook, signed if it has a secret. It
+// reports whether a failure is worth trying again: anything but a client
+// error from the receiver, which would only be made again.
+func (s *ChatServer) postWebhook(hook chat.Webhook, body []byte) (retry bool, err error) {
+	ctx, cancel := s.requestContext(webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "chatroom-webhook/"+Version)
+	if hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		mac.Write(body)
+		req.Header.Set(chat.WebhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := s.webhookClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return true, fmt.Errorf("status %s", resp.Status)
+	default:
+		return false, fmt.Errorf("status %s", resp.Status)
+	}
+}
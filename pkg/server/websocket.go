@@ -0,0 +1,229 @@
+package server
+
+import (
+	"embed"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+// wsHistorySize is how many messages a browser is sent when it joins a room
+const wsHistorySize = 20
+
+//go:embed web
+var webFiles embed.FS
+
+// wsFrame is a JSON message exchanged with a browser. Browsers send
+// "login" (Name, Password), "join" (Room, and Password for the password of
+// or an invite to a private room) and "send" (Room, Text, InReplyTo)
+// frames. The server answers with "welcome" (Name) after
+// logging in, "history" (Room, Messages) after joining a room, "message"
+// (Message) for every message posted to a joined room, and "error" (Error)
+// when a request fails.
+type wsFrame struct {
+	Type      string         `json:"type"`
+	Name      string         `json:"name,omitempty"`
+	Password  string         `json:"password,omitempty"`
+	Room      string         `json:"room,omitempty"`
+	Text      string         `json:"text,omitempty"`
+	InReplyTo int64          `json:"inReplyTo,omitempty"`
+	Emote     bool           `json:"emote,omitempty"`
+	Message   *chat.Message  `json:"message,omitempty"`
+	Messages  []chat.Message `json:"messages,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// wsClient is the gateway between one browser and the chat
+type wsClient struct {
+	*chatConn
+	ws      *websocket.Conn
+	writeMu sync.Mutex      // frames are written by several goroutines
+	token   string          // session token, once logged in
+	joined  map[string]bool // rooms being followed
+}
+
+// WebHandler serves a small browser client at / and the WebSocket gateway
+// it talks to at /ws. Browsers join the same rooms as RPC clients.
+func (s *ChatServer) WebHandler() http.Handler {
+	web, _ := fs.Sub(webFiles, "web") // cannot fail, web is a valid path
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServerFS(web))
+	// Any origin may connect: logging in takes a name and password, not
+	// cookies a foreign page could borrow
+	mux.Handle("/ws", websocket.Server{
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler:   s.serveWebSocket,
+	})
+	return mux
+}
+
+// serveWebSocket serves one browser connection and releases the name it
+// claimed once the browser disconnects
+func (s *ChatServer) serveWebSocket(ws *websocket.Conn) {
+	ip, _, err := net.SplitHostPort(ws.Request().RemoteAddr)
+	if err != nil {
+		ip = ws.Request().RemoteAddr
+	}
+	closed := make(chan struct{})
+	c := &chatConn{ChatServer: s, ip: ip, conn: ws, closed: closed}
+
+	s.mu.Lock()
+	if s.stopping {
+		s.mu.Unlock()
+		ws.Close()
+		return
+	}
+	if err := s.admit(ip); err != nil {
+		s.mu.Unlock()
+		slog.Warn("Turned away connection", "ip", ip, "err", err)
+		websocket.JSON.Send(ws, &wsFrame{Type: "error", Error: err.Error()})
+		ws.Close()
+		return
+	}
+	s.conns[c] = true
+	s.connsWG.Add(1)
+	s.mu.Unlock()
+	defer s.connsWG.Done()
+
+	client := &wsClient{chatConn: c, ws: ws, joined: make(map[string]bool)}
+	go client.keepAlive()
+	client.serve()
+	close(closed)
+	ws.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, c)
+	if sess, ok := c.session(); ok {
+		s.markOffline(sess, "disconnected")
+	}
+}
+
+// serve handles the frames the browser sends until it disconnects
+func (w *wsClient) serve() {
+	for {
+		var frame wsFrame
+		if err := websocket.JSON.Receive(w.ws, &frame); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				slog.Debug("WebSocket read failed", "ip", w.ip, "err", err)
+			}
+			return
+		}
+		if err := w.handle(&frame); err != nil {
+			w.send(&wsFrame{Type: "error", Error: err.Error()})
+		}
+	}
+}
+
+// handle carries out one request from the browser
+func (w *wsClient) handle(frame *wsFrame) error {
+	if frame.Type != "login" && w.token == "" {
+		return errors.New("login required")
+	}
+	switch frame.Type {
+	case "login":
+		var reply chat.LoginReply
+		var err error
+		if frame.Password != "" {
+			err = w.Authenticate(&chat.AccountArgs{Name: frame.Name, Password: frame.Password}, &reply)
+		} else {
+			err = w.Login(&chat.UserArgs{Name: frame.Name}, &reply)
+		}
+		if err != nil {
+			return err
+		}
+		w.token = reply.Token
+		w.send(&wsFrame{Type: "welcome", Name: frame.Name})
+		return w.join(chat.DefaultRoom, "")
+	case "join":
+		return w.join(roomName(frame.Room), frame.Password)
+	case "send":
+		args := &chat.MessageArgs{Token: w.token, Room: frame.Room, Message: frame.Text, InReplyTo: frame.InReplyTo, Emote: frame.Emote}
+		return w.SendMessage(args, &chat.HistoryReply{})
+	default:
+		return errors.New("unknown request " + frame.Type)
+	}
+}
+
+// join joins a room, sends its latest messages and follows it. key is the
+// password of or an invite to a private room.
+func (w *wsClient) join(room, key string) error {
+	if err := w.JoinRoom(&chat.RoomArgs{Token: w.token, Room: room, Password: key, Invite: key}, &struct{}{}); err != nil {
+		return err
+	}
+	if w.joined[room] {
+		return nil
+	}
+	var page chat.HistoryPage
+	if err := w.GetHistory(&chat.HistoryArgs{Token: w.token, Room: room, Limit: wsHistorySize}, &page); err != nil {
+		return err
+	}
+	w.send(&wsFrame{Type: "history", Room: room, Messages: page.Messages})
+
+	w.mu.Lock()
+	sub, err := w.subscribe(room, w.reader(w.token), page.LastIndex, func(m chat.Message) error {
+		return w.send(&wsFrame{Type: "message", Message: &m})
+	})
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	w.joined[room] = true
+	go w.follow(sub)
+	return nil
+}
+
+// follow waits for the browser to disconnect or for the server to stop
+// pushing the messages of a room to it, telling the browser why. A browser
+// that fell too far behind is disconnected, as it has missed messages.
+func (w *wsClient) follow(sub *subscriber) {
+	select {
+	case <-sub.done:
+		if sub.err != nil {
+			w.send(&wsFrame{Type: "error", Error: sub.err.Error()})
+		}
+		if sub.err == errFellBehind {
+			w.ws.Close()
+		}
+	case <-w.closed:
+	}
+	w.unsubscribe(sub)
+}
+
+// keepAlive keeps the user online for as long as the browser is connected,
+// so browsers need not send heartbeats
+func (w *wsClient) keepAlive() {
+	ticker := time.NewTicker(w.presenceTimeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			if sess, ok := w.session(); ok {
+				sess.lastSeen = time.Now()
+			}
+			w.mu.Unlock()
+		case <-w.closed:
+			return
+		}
+	}
+}
+
+// send writes a frame to the browser, giving up on browsers that stop
+// reading for writeTimeout. Errors are mostly left for the reading side to
+// notice.
+func (w *wsClient) send(frame *wsFrame) error {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	w.ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return websocket.JSON.Send(w.ws, frame)
+}
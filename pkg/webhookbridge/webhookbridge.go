@@ -0,0 +1,293 @@
+// Package webhookbridge mirrors chat rooms to Slack or Discord channels
+// through their incoming webhooks. A Bridge is a server.MessageHook of the
+// chat server: each message written in a bridged room is posted to the
+// room's webhook, in order, with who wrote it.
+//
+// Messages from the channels come back through the chat server's
+// POST /hooks, with the token of a bot an admin added with AddBot for the
+// room, e.g. from a Slack outgoing webhook or a Discord bot. Naming that
+// bot in the room's Target keeps the bridge from mirroring them back.
+//
+//	bridge, err := webhookbridge.New(webhookbridge.Config{
+//		Targets: map[string]webhookbridge.Target{
+//			"general": {URL: "https://hooks.slack.com/services/T0/B0/XXXX", Bot: "slack"},
+//		},
+//	})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	srv.AddHook(bridge)
+//	go bridge.Run()
+//	defer bridge.Close()
+package webhookbridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/server"
+)
+
+// Limits on posting to webhooks
+const (
+	postTimeout  = 10 * time.Second // for each attempt
+	attempts     = 5                // tries before a message is dropped
+	backoff      = time.Second      // wait before the first retry, doubling after each
+	maxRetryWait = time.Minute      // longest Retry-After heeded
+	queueSize    = 256              // messages waiting to be posted to each webhook; more are dropped
+
+	maxDiscordContent  = 2000 // characters in a Discord message
+	maxDiscordUsername = 80   // characters in the name a Discord message is posted under
+)
+
+// ErrClosed is returned by Run once the bridge is closed
+var ErrClosed = errors.New("webhookbridge: bridge closed")
+
+// Platform is the kind of webhook a Target posts to
+type Platform string
+
+// The platforms a bridge can post to
+const (
+	Slack   Platform = "slack"   // Slack's incoming webhooks, and those compatible with them
+	Discord Platform = "discord" // Discord's webhooks
+)
+
+// Target is where a room is mirrored to
+type Target struct {
+	URL      string   // of the channel's incoming webhook
+	Platform Platform // worked out from URL if empty: Discord for discord.com, Slack for anything else
+	Bot      string   // the bot posting the channel's messages to the room, if any
+}
+
+// Config holds the settings of a Bridge
+type Config struct {
+	Targets map[string]Target // by room
+	Client  *http.Client      // to post with, one with a timeout if nil
+}
+
+// Bridge mirrors rooms of a chat server to Slack and Discord channels
+type Bridge struct {
+	server.BaseHook
+
+	targets map[string]*target // by room
+	client  *http.Client
+	ctx     context.Context // cancelled by Close, ending the posts under way
+	cancel  context.CancelFunc
+}
+
+// target is a Target and the messages waiting to be posted to it
+type target struct {
+	Target
+	room  string
+	queue chan []byte // payloads, in the order of the messages
+}
+
+// slackPayload is the body of a post to a Slack webhook
+type slackPayload struct {
+	Text string `json:"text"`
+}
+
+// discordPayload is the body of a post to a Discord webhook
+type discordPayload struct {
+	Content         string          `json:"content"`
+	Username        string          `json:"username"`
+	AllowedMentions discordMentions `json:"allowed_mentions"`
+}
+
+// discordMentions says what a Discord message may notify; nothing, so
+// that "@everyone" from the chat does not
+type discordMentions struct {
+	Parse []string `json:"parse"`
+}
+
+// New returns a bridge mirroring rooms to the targets in config. It
+// starts posting once Run is called, and only sees the messages in the
+// rooms once added with srv.AddHook.
+func New(config Config) (*Bridge, error) {
+	if len(config.Targets) == 0 {
+		return nil, errors.New("webhookbridge: no rooms to bridge")
+	}
+	b := &Bridge{
+		targets: make(map[string]*target),
+		client:  config.Client,
+	}
+	if b.client == nil {
+		b.client = &http.Client{Timeout: postTimeout}
+	}
+	for room, t := range config.Targets {
+		if room == "" {
+			room = chat.DefaultRoom
+		}
+		u, err := url.Parse(t.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("webhookbridge: the webhook of room %q must be an http or https URL", room)
+		}
+		switch t.Platform {
+		case Slack, Discord:
+		case "":
+			t.Platform = Slack
+			if host := strings.ToLower(u.Hostname()); host == "discord.com" || strings.HasSuffix(host, ".discord.com") || host == "discordapp.com" {
+				t.Platform = Discord
+			}
+		default:
+			return nil, fmt.Errorf("webhookbridge: unknown platform %q", t.Platform)
+		}
+		b.targets[room] = &target{Target: t, room: room, queue: make(chan []byte, queueSize)}
+	}
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	return b, nil
+}
+
+// Run posts the messages to the webhooks until the bridge is closed. It
+// always returns ErrClosed.
+func (b *Bridge) Run() error {
+	var wg sync.WaitGroup
+	for _, t := range b.targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-b.ctx.Done():
+					return
+				case body := <-t.queue:
+					b.deliver(t, body)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return ErrClosed
+}
+
+// Close stops Run, dropping the messages still waiting
+func (b *Bridge) Close() error {
+	b.cancel()
+	return nil
+}
+
+// OnAfterSend queues the chat and actions written in the bridged rooms,
+// other than by the bots bringing the channels' messages in
+func (b *Bridge) OnAfterSend(msg chat.Message) {
+	t, ok := b.targets[msg.Room]
+	if !ok || msg.To != "" || (msg.Kind != chat.KindChat && msg.Kind != chat.KindEmote) {
+		return
+	}
+	if t.Bot != "" && (msg.Sender == t.Bot || strings.HasSuffix(msg.Sender, "["+t.Bot+"]")) {
+		return
+	}
+
+	var payload any
+	emote := msg.Kind == chat.KindEmote
+	switch t.Platform {
+	case Discord:
+		content := msg.Body
+		if emote {
+			content = "_" + content + "_"
+		}
+		payload = discordPayload{
+			Content:         truncate(content, maxDiscordContent),
+			Username:        truncate(msg.Sender, maxDiscordUsername),
+			AllowedMentions: discordMentions{Parse: []string{}},
+		}
+	default:
+		// App webhooks post under the app's name, so the sender's goes in
+		// the text
+		text := "*" + slackEscape(msg.Sender) + "*: " + slackEscape(msg.Body)
+		if emote {
+			text = "_" + slackEscape(msg.Sender+" "+msg.Body) + "_"
+		}
+		payload = slackPayload{Text: text}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("Error encoding a message to mirror", "id", msg.ID, "room", msg.Room, "err", err)
+		return
+	}
+	select {
+	case t.queue <- body:
+	default:
+		slog.Warn("Dropped a message to mirror, too many waiting", "id", msg.ID, "room", msg.Room, "platform", t.Platform)
+	}
+}
+
+// deliver posts body to t, trying again when that might help
+func (b *Bridge) deliver(t *target, body []byte) {
+	wait := backoff
+	for attempt := 1; ; attempt++ {
+		retryAfter, err := b.post(t, body)
+		if err == nil {
+			return
+		}
+		if retryAfter < 0 || attempt == attempts {
+			slog.Warn("Gave up mirroring a message", "room", t.room, "platform", t.Platform, "attempts", attempt, "err", err)
+			return
+		}
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-time.After(max(wait, retryAfter)):
+		}
+		wait *= 2
+	}
+}
+
+// post makes one attempt at posting body to t. When it fails, it returns
+// how long the webhook asked to wait before trying again, 0 if it did
+// not say, or -1 if trying again would not help.
+func (b *Bridge) post(t *target, body []byte) (retryAfter time.Duration, err error) {
+	ctx, cancel := context.WithTimeout(b.ctx, postTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "chatroom-webhook-bridge/"+server.Version)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		seconds, _ := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64)
+		retryAfter = time.Duration(seconds * float64(time.Second))
+		return min(max(retryAfter, 0), maxRetryWait), fmt.Errorf("status %s", resp.Status)
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode >= 500:
+		return 0, fmt.Errorf("status %s", resp.Status)
+	default:
+		return -1, fmt.Errorf("status %s", resp.Status)
+	}
+}
+
+// slackEscape escapes the characters Slack gives a meaning in text
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// truncate cuts text to at most n characters
+func truncate(text string, n int) string {
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	return string([]rune(text)[:n])
+}
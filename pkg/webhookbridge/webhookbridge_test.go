@@ -0,0 +1,162 @@
+package webhookbridge
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mahmoud375/Assignment2_Simple_Chatroom/pkg/chat"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   Target
+		platform Platform // "" when New fails
+	}{
+		{"Slack", Target{URL: "https://hooks.slack.com/services/T0/B0/X"}, Slack},
+		{"Discord", Target{URL: "https://discord.com/api/webhooks/1/x"}, Discord},
+		{"Discord subdomain", Target{URL: "https://canary.discord.com/api/webhooks/1/x"}, Discord},
+		{"old Discord domain", Target{URL: "https://discordapp.com/api/webhooks/1/x"}, Discord},
+		{"look-alike domain", Target{URL: "https://notdiscord.com/api/webhooks/1/x"}, Slack},
+		{"anything else", Target{URL: "http://chat.example.com/hooks"}, Slack},
+		{"platform given", Target{URL: "https://example.com/hook", Platform: Discord}, Discord},
+		{"unknown platform", Target{URL: "https://example.com/hook", Platform: "teams"}, ""},
+		{"not http", Target{URL: "ftp://example.com/hook"}, ""},
+		{"no host", Target{URL: "https:///hook"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := New(Config{Targets: map[string]Target{"": tt.target}})
+			if tt.platform == "" {
+				if err == nil {
+					t.Fatal("New succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer b.Close()
+			if got := b.targets[chat.DefaultRoom].Platform; got != tt.platform {
+				t.Errorf("platform = %q, want %q", got, tt.platform)
+			}
+		})
+	}
+	if _, err := New(Config{}); err == nil {
+		t.Error("New without targets succeeded, want an error")
+	}
+}
+
+func TestOnAfterSend(t *testing.T) {
+	b, err := New(Config{Targets: map[string]Target{
+		"slack":   {URL: "https://hooks.slack.com/services/T0/B0/X", Bot: "slack"},
+		"discord": {URL: "https://discord.com/api/webhooks/1/x", Bot: "discord"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	tests := []struct {
+		name string
+		msg  chat.Message
+		want string // payload queued, "" for none
+	}{
+		{"Slack chat", chat.Message{Room: "slack", Sender: "alice", Body: "a <b> & c"},
+			`{"text":"*alice*: a \u0026lt;b\u0026gt; \u0026amp; c"}`},
+		{"Slack emote", chat.Message{Room: "slack", Sender: "alice", Body: "waves", Kind: chat.KindEmote},
+			`{"text":"_alice waves_"}`},
+		{"Discord chat", chat.Message{Room: "discord", Sender: "alice", Body: "hi @everyone"},
+			`{"content":"hi @everyone","username":"alice","allowed_mentions":{"parse":[]}}`},
+		{"Discord emote", chat.Message{Room: "discord", Sender: "alice", Body: "waves", Kind: chat.KindEmote},
+			`{"content":"_waves_","username":"alice","allowed_mentions":{"parse":[]}}`},
+		{"from the bot", chat.Message{Room: "slack", Sender: "slack", Body: "hi"}, ""},
+		{"from the bot for a user", chat.Message{Room: "slack", Sender: "bob[slack]", Body: "hi"}, ""},
+		{"from the other bot", chat.Message{Room: "slack", Sender: "bob[discord]", Body: "hi"},
+			`{"text":"*bob[discord]*: hi"}`},
+		{"private", chat.Message{Room: "slack", Sender: "alice", To: "bob", Body: "hi"}, ""},
+		{"not bridged", chat.Message{Room: "general", Sender: "alice", Body: "hi"}, ""},
+		{"topic", chat.Message{Room: "slack", Sender: "alice", Body: "news", Kind: chat.KindTopic}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b.OnAfterSend(tt.msg)
+			got := ""
+			for _, target := range b.targets {
+				select {
+				case body := <-target.queue:
+					got = string(body)
+				default:
+				}
+			}
+			if got != tt.want {
+				t.Errorf("queued %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPost(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		wantErr    bool
+		wantWait   time.Duration
+	}{
+		{"ok", http.StatusOK, "", false, 0},
+		{"no content", http.StatusNoContent, "", false, 0},
+		{"rate limited", http.StatusTooManyRequests, "2.5", true, 2500 * time.Millisecond},
+		{"rate limited for long", http.StatusTooManyRequests, "3600", true, maxRetryWait},
+		{"server error", http.StatusBadGateway, "", true, 0},
+		{"gone", http.StatusNotFound, "", true, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			b, err := New(Config{Targets: map[string]Target{"": {URL: srv.URL}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer b.Close()
+			wait, err := b.post(b.targets[chat.DefaultRoom], []byte(`{"text":"hi"}`))
+			if (err != nil) != tt.wantErr || wait != tt.wantWait {
+				t.Errorf("post = %v, %v; want %v and an error: %v", wait, err, tt.wantWait, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCloseStopsPosting(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	b, err := New(Config{Targets: map[string]Target{"": {URL: srv.URL}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(50*time.Millisecond, func() { b.Close() })
+	start := time.Now()
+	if _, err := b.post(b.targets[chat.DefaultRoom], []byte(`{"text":"hi"}`)); err == nil {
+		t.Error("post succeeded after Close")
+	}
+	if elapsed := time.Since(start); elapsed > postTimeout/2 {
+		t.Errorf("post took %v after Close", elapsed)
+	}
+}